@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const anyTargetConfig = `
+branches = ["stable"]
+dispatch_retries = 0
+any_target_succeeds = true
+
+[[targets]]
+github_repo = "example/rejects"
+github_token = "token"
+
+[[targets]]
+github_repo = "example/first"
+github_token = "token"
+
+[[targets]]
+github_repo = "example/second"
+github_token = "token"
+`
+
+// serveInterchangeable serves the stable listing, rejects dispatches to example/rejects
+// and returns the repos dispatched to in order
+func serveInterchangeable(t *testing.T, checker *DipaChecker) func() []string {
+	var mu sync.Mutex
+	var repos []string
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stable/" {
+			w.Write(listingJSON("Discord_228.0.ipa"))
+			return
+		}
+
+		repo := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/repos/"), "/dispatches")
+		mu.Lock()
+		repos = append(repos, repo)
+		mu.Unlock()
+		if repo == "example/rejects" {
+			http.Error(w, `{"message":"Unprocessable"}`, http.StatusUnprocessableEntity)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, repos...)
+	}
+}
+
+func TestAnyTargetSucceedsStopsAfterFirstSuccess(t *testing.T) {
+	checker := newTestChecker(t, anyTargetConfig)
+	dispatched := serveInterchangeable(t, checker)
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"example/rejects", "example/first"}
+	if got := dispatched(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("dispatched to %v, want %v", got, want)
+	}
+	if got := reopen(t, checker).BranchData.Branches["stable"].Winner; got != "example/first" {
+		t.Errorf("recorded winner %q, want example/first", got)
+	}
+
+	// The change is complete, so the remaining target is not dispatched later
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if got := dispatched(); !reflect.DeepEqual(got, want) {
+		t.Errorf("dispatched to %v after the change completed, want %v", got, want)
+	}
+}
+
+func TestAllTargetsWithoutAnyTargetSucceeds(t *testing.T) {
+	checker := newTestChecker(t, strings.Replace(anyTargetConfig, "any_target_succeeds = true", "", 1))
+	dispatched := serveInterchangeable(t, checker)
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"example/rejects", "example/first", "example/second"}
+	if got := dispatched(); !reflect.DeepEqual(got, want) {
+		t.Errorf("dispatched to %v, want every target %v", got, want)
+	}
+	if got := reopen(t, checker).BranchData.Branches["stable"].Winner; got != "" {
+		t.Errorf("recorded winner %q without any_target_succeeds", got)
+	}
+}
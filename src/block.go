@@ -0,0 +1,86 @@
+package main
+
+// isBlockedVersion reports whether a file was blocked with the block subcommand
+func (c *DipaChecker) isBlockedVersion(name string) bool {
+	c.blockMu.RLock()
+	defer c.blockMu.RUnlock()
+
+	return containsString(c.BranchData.BlockedVersions, name)
+}
+
+// selectVersion returns the file to dispatch from a listing: the latest one, or with
+// blocked_fallback set the newest file that is not blocked
+func (c *DipaChecker) selectVersion(files []IPAFile) *IPAFile {
+	latest := c.GetLatestVersion(files)
+	if latest == nil || !c.Config.BlockedFallback || !c.isBlockedVersion(latest.Name) {
+		return latest
+	}
+
+	allowed := []IPAFile{}
+	for _, file := range files {
+		if !c.isBlockedVersion(file.Name) {
+			allowed = append(allowed, file)
+		}
+	}
+	if len(allowed) == 0 {
+		return latest
+	}
+	return c.GetLatestVersion(allowed)
+}
+
+// isBlocked reports whether a listing must not be dispatched, either because its hash
+// or the selected file is blocked, or because the fallback was already dispatched
+func (c *DipaChecker) isBlocked(hash string, files []IPAFile, branchData BranchData) bool {
+	c.blockMu.RLock()
+	blockedHash := containsString(c.BranchData.BlockedHashes, hash)
+	c.blockMu.RUnlock()
+	if blockedHash {
+		return true
+	}
+
+	selected := c.selectVersion(files)
+	if selected == nil {
+		return false
+	}
+	if c.isBlockedVersion(selected.Name) {
+		return true
+	}
+
+	fellBack := selected.Name != c.GetLatestVersion(files).Name
+	return fellBack && selected.Name == branchData.Latest
+}
+
+// SetBlocked adds a version or listing hash to the blocklist, or removes it, and persists it
+func (c *DipaChecker) SetBlocked(version, hash string, blocked bool) error {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	c.mergeStoredState()
+	c.blockMu.Lock()
+	if version != "" {
+		c.BranchData.BlockedVersions = updateList(c.BranchData.BlockedVersions, version, blocked)
+	}
+	if hash != "" {
+		c.BranchData.BlockedHashes = updateList(c.BranchData.BlockedHashes, hash, blocked)
+	}
+	c.blockMu.Unlock()
+
+	if c.Config.SplitStateFiles {
+		return c.writeSplitState()
+	}
+	return c.writeHashes()
+}
+
+// updateList adds or removes a value from a list without duplicates
+func updateList(list []string, value string, add bool) []string {
+	updated := []string{}
+	for _, existing := range list {
+		if existing != value {
+			updated = append(updated, existing)
+		}
+	}
+	if add {
+		updated = append(updated, value)
+	}
+	return updated
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// dispatchedURL returns the ipa_url last dispatched to example/app
+func dispatchedURL(recorder *dispatchRecorder) string {
+	url, _ := recorder.payload("example/app")["ipa_url"].(string)
+	return url
+}
+
+func TestBlockedVersionSkipped(t *testing.T) {
+	checker := newTestChecker(t, `branches = ["stable"]`)
+	if err := checker.SetBlocked("Discord_229.0.ipa", "", true); err != nil {
+		t.Fatal(err)
+	}
+	recorder := serveDispatches(t, checker, listingJSON("Discord_228.0.ipa", "Discord_229.0.ipa"))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if got := recorder.dispatched(); len(got) != 0 {
+		t.Errorf("dispatched %v with the latest version blocked", got)
+	}
+	if storedHash(t, checker, "stable") == "" {
+		t.Error("blocked listing was not tracked")
+	}
+
+	recorder.setListing(listingJSON("Discord_228.0.ipa", "Discord_229.0.ipa", "Discord_230.0.ipa"))
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(dispatchedURL(recorder), "Discord_230.0.ipa") {
+		t.Errorf("dispatched %q, want the next unblocked version", dispatchedURL(recorder))
+	}
+}
+
+func TestBlockedFallback(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+blocked_fallback = true
+`)
+	if err := checker.SetBlocked("Discord_229.0.ipa", "", true); err != nil {
+		t.Fatal(err)
+	}
+	recorder := serveDispatches(t, checker, listingJSON("Discord_228.0.ipa", "Discord_229.0.ipa"))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(dispatchedURL(recorder), "Discord_228.0.ipa") {
+		t.Fatalf("dispatched %q, want the newest unblocked version", dispatchedURL(recorder))
+	}
+
+	// A listing change that still falls back to the same file isn't dispatched again
+	recorder.setListing(listingJSON("Discord_227.0.ipa", "Discord_228.0.ipa", "Discord_229.0.ipa"))
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if got := recorder.dispatched(); len(got) != 1 {
+		t.Errorf("dispatched %v, want the fallback only once", got)
+	}
+
+	// Every file blocked leaves nothing to fall back to
+	for _, version := range []string{"Discord_227.0.ipa", "Discord_228.0.ipa"} {
+		if err := checker.SetBlocked(version, "", true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recorder.setListing(listingJSON("Discord_229.0.ipa", "Discord_228.0.ipa", "Discord_227.0.ipa"))
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if got := recorder.dispatched(); len(got) != 1 {
+		t.Errorf("dispatched %v with every version blocked", got)
+	}
+}
+
+func TestBlockedHash(t *testing.T) {
+	checker := newTestChecker(t, `branches = ["stable"]`)
+	recorder := serveDispatches(t, checker, listingJSON("Discord_228.0.ipa"))
+
+	_, hash, err := checker.FetchIPAList("stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checker.SetBlocked("", hash, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if got := recorder.dispatched(); len(got) != 0 {
+		t.Errorf("dispatched %v for a blocked listing hash", got)
+	}
+}
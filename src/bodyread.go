@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// defaultRequestTimeout is used when request_timeout is not set
+const defaultRequestTimeout = 30 * time.Second
+
+// readBody reads a response body, cancelling the request through cancel when
+// body_read_timeout elapses so a stalled body fails before the request timeout
+func (c *DipaChecker) readBody(resp *http.Response, cancel context.CancelFunc) ([]byte, error) {
+	if c.bodyReadTimeout == 0 {
+		return io.ReadAll(resp.Body)
+	}
+
+	timer := time.AfterFunc(c.bodyReadTimeout, cancel)
+	body, err := io.ReadAll(resp.Body)
+	if !timer.Stop() && err != nil {
+		return nil, fmt.Errorf("reading response body timed out after %s", c.bodyReadTimeout)
+	}
+	return body, err
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// trickle serves body one byte every interval, stopping when the client goes away
+func trickle(body string, interval time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		for i := 0; i < len(body); i++ {
+			if _, err := w.Write([]byte{body[i]}); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}
+}
+
+func TestBodyReadTimeout(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+max_retries = 0
+body_read_timeout = "100ms"
+`)
+	serveAll(t, checker, trickle(string(listingJSON("Discord_228.0.ipa")), 50*time.Millisecond))
+
+	start := time.Now()
+	_, _, err := checker.FetchIPAList("stable")
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Fatalf("error %v, want the body read to time out", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("stalled body took %s to fail, want about body_read_timeout", elapsed)
+	}
+}
+
+func TestBodyReadWithinTimeout(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+max_retries = 0
+body_read_timeout = "5s"
+`)
+	serveAll(t, checker, trickle(string(listingJSON("Discord_228.0.ipa")), time.Millisecond))
+
+	files, _, err := checker.FetchIPAList("stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("read %d files, want 1", len(files))
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultCanaryTimeout is used when canary_timeout is not set
+const defaultCanaryTimeout = 30 * time.Minute
+
+// workflowRuns is the subset of the GitHub workflow runs response used for canaries
+type workflowRuns struct {
+	WorkflowRuns []workflowRun `json:"workflow_runs"`
+}
+
+// workflowRun is a single run of a workflow runs response
+type workflowRun struct {
+	Status     string `json:"status"`
+	Conclusion string `json:"conclusion"`
+	HTMLURL    string `json:"html_url"`
+	// DisplayTitle is the run-name of the run, which carries the dispatch_id
+	DisplayTitle string `json:"display_title"`
+}
+
+// CanaryRollout is a rollout waiting for the workflow runs of its canaries
+type CanaryRollout struct {
+	// DispatchID names the workflow runs of the canary dispatch
+	DispatchID string   `json:"dispatch_id"`
+	Canaries   []string `json:"canaries"`
+	// Targets are promoted to once every canary run succeeded
+	Targets  []string  `json:"targets"`
+	Since    time.Time `json:"since"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// dispatchWithCanary dispatches to the canary targets first and only promotes the
+// update to the remaining targets once every canary workflow run has succeeded.
+// The remaining targets are left pending meanwhile and the canary runs are checked on
+// later checks, so no check waits for them. A failed canary aborts the rollout with an alert.
+//
+// Canaries are sent a unique dispatch_id, which their workflow must put in its run-name
+// (e.g. run-name: "IPA ${{ github.event.client_payload.dispatch_id }}") so that only
+// the run of this dispatch is awaited.
+func (c *DipaChecker) dispatchWithCanary(event DispatchEvent) (DispatchResult, error) {
+	key := dispatchKey(event)
+	if rollout, ok := c.canaryRollout(event.Branch, key); ok {
+		return c.promoteCanary(event, key, rollout)
+	}
+
+	canaries, rest := []string{}, []string{}
+	dispatched := c.dispatchedTo(event.Branch, key)
+	for _, target := range c.Config.Targets {
+		if target.Disabled || containsString(dispatched, target.Name()) {
+			continue
+		}
+		if target.Canary {
+			canaries = append(canaries, target.Name())
+		} else if target.receivesBranch(event.Branch) {
+			rest = append(rest, target.Name())
+		}
+	}
+	if len(canaries) == 0 || len(event.OnlyTargets) > 0 {
+		return c.DispatchGitHubWorkflow(event)
+	}
+
+	since := time.Now().Add(-time.Minute)
+	canaryEvent := event
+	canaryEvent.OnlyTargets = canaries
+	canaryEvent.DispatchID = c.newDispatchID()
+	canaryResult, err := c.DispatchGitHubWorkflow(canaryEvent)
+	if err != nil {
+		return canaryResult, err
+	}
+
+	// A canary that skipped the update, e.g. for its version_constraint, cannot vouch for it
+	if len(canaryResult.Failed) > 0 || len(canaryResult.Pending) > 0 {
+		c.abortCanary(event, fmt.Errorf("canary dispatch did not go through for %v", append(canaryResult.Failed, canaryResult.Pending...)))
+		return canaryResult, nil
+	}
+	if len(canaryResult.Successful) == 0 {
+		c.abortCanary(event, fmt.Errorf("no canary took the update"))
+		return canaryResult, nil
+	}
+
+	if c.Config.DryRun {
+		restEvent := event
+		restEvent.OnlyTargets = rest
+		restResult, err := c.DispatchGitHubWorkflow(restEvent)
+		if err != nil {
+			return canaryResult, err
+		}
+		restResult.Successful = append(canaryResult.Successful, restResult.Successful...)
+		return restResult, nil
+	}
+
+	rollout := CanaryRollout{
+		DispatchID: canaryEvent.DispatchID,
+		Canaries:   canaryResult.Successful,
+		Targets:    rest,
+		Since:      since,
+		Deadline:   time.Now().Add(c.canaryTimeout),
+	}
+	err = c.updateBranch(event.Branch, func(branchData *BranchData) {
+		setCanaryRollout(branchData, key, &rollout)
+	})
+	if err != nil {
+		return canaryResult, fmt.Errorf("error saving canary rollout: %w", err)
+	}
+
+	log.Printf("Dispatched %s to canaries %v, promoting to %d targets once their workflow runs succeed",
+		event.Version, canaryResult.Successful, len(rest))
+	return DispatchResult{Successful: canaryResult.Successful, Pending: rest}, nil
+}
+
+// promoteCanary checks the workflow runs of a waiting rollout once, dispatching the update
+// to the remaining targets once every canary run succeeded. Until then they stay pending.
+func (c *DipaChecker) promoteCanary(event DispatchEvent, key string, rollout CanaryRollout) (DispatchResult, error) {
+	targets := event.OnlyTargets
+	if len(targets) == 0 {
+		targets = rollout.Targets
+	}
+
+	done, err := c.canaryOutcome(rollout)
+	if !done {
+		log.Printf("Canary runs of %s for %s have not completed yet", event.Version, event.Branch)
+		return DispatchResult{Pending: targets}, nil
+	}
+
+	clearErr := c.updateBranch(event.Branch, func(branchData *BranchData) {
+		setCanaryRollout(branchData, key, nil)
+	})
+	if clearErr != nil {
+		return DispatchResult{Pending: targets}, fmt.Errorf("error saving canary rollout: %w", clearErr)
+	}
+	if err != nil {
+		c.abortCanary(event, err)
+		return DispatchResult{}, nil
+	}
+
+	log.Printf("Canary succeeded for %s, promoting to the remaining targets", event.Branch)
+	event.OnlyTargets = targets
+	return c.DispatchGitHubWorkflow(event)
+}
+
+// abortCanary alerts that a canary failed and the update is not promoted
+func (c *DipaChecker) abortCanary(event DispatchEvent, err error) {
+	c.alert(event.Branch, "Canary failed, rollout aborted",
+		fmt.Sprintf("%s was not dispatched to the remaining targets: %v", event.Version, err))
+}
+
+// canaryRollout returns the waiting rollout of a dispatch key, if there is one
+func (c *DipaChecker) canaryRollout(branch, key string) (CanaryRollout, bool) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	rollout, ok := c.BranchData.Branches[branch].Canaries[key]
+	return rollout, ok
+}
+
+// setCanaryRollout records the waiting rollout of a dispatch key, or clears it when
+// rollout is nil. The map is replaced rather than changed, since snapshots share it.
+func setCanaryRollout(branchData *BranchData, key string, rollout *CanaryRollout) {
+	canaries := make(map[string]CanaryRollout, len(branchData.Canaries)+1)
+	for k, r := range branchData.Canaries {
+		if k != key {
+			canaries[k] = r
+		}
+	}
+	if rollout != nil {
+		canaries[key] = *rollout
+	}
+	if len(canaries) == 0 {
+		canaries = nil
+	}
+	branchData.Canaries = canaries
+}
+
+// dropStaleCanaries clears the waiting rollouts of superseded hashes and variant files
+func dropStaleCanaries(branchData *BranchData) {
+	for key := range branchData.Canaries {
+		if !branchData.tracksKey(key) {
+			setCanaryRollout(branchData, key, nil)
+		}
+	}
+}
+
+// newDispatchID returns a random identifier for a canary dispatch
+func (c *DipaChecker) newDispatchID() string {
+	c.rngMu.Lock()
+	defer c.rngMu.Unlock()
+
+	return fmt.Sprintf("%016x", c.rng.Uint64())
+}
+
+// canaryOutcome checks the workflow runs of a rollout's canaries once. It reports done
+// once every run succeeded, or with an error once one failed or the deadline passed.
+func (c *DipaChecker) canaryOutcome(rollout CanaryRollout) (bool, error) {
+	for _, target := range c.Config.Targets {
+		if !containsString(rollout.Canaries, target.Name()) {
+			continue
+		}
+		completed, err := c.checkWorkflowRun(target, rollout.Since, rollout.DispatchID)
+		if err != nil {
+			return true, fmt.Errorf("%s: %w", target.Name(), err)
+		}
+		if completed {
+			continue
+		}
+		if time.Now().After(rollout.Deadline) {
+			return true, fmt.Errorf("%s: no completed workflow run named with dispatch_id %s by %s",
+				target.Name(), rollout.DispatchID, rollout.Deadline.Format(time.RFC1123))
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// checkWorkflowRun looks up a repo's workflow runs created since the dispatch for the run
+// named with dispatchID, reporting whether it completed and failing unless it concluded
+// successfully. Runs of other dispatches, pushes or schedules are ignored.
+func (c *DipaChecker) checkWorkflowRun(target Target, since time.Time, dispatchID string) (bool, error) {
+	query := url.Values{}
+	query.Set("event", "repository_dispatch")
+	if target.DispatchMode == dispatchModeWorkflow {
+		query.Set("event", "workflow_dispatch")
+	}
+	query.Set("created", ">="+since.UTC().Format(time.RFC3339))
+	runsURL := fmt.Sprintf("%s/repos/%s/actions/runs?%s", githubAPIURL, target.GitHubRepo, query.Encode())
+
+	runs, err := c.fetchWorkflowRuns(target, runsURL)
+	if err != nil {
+		log.Printf("Error checking canary runs of %s: %v", target.Name(), err)
+		return false, nil
+	}
+	for _, run := range runs.WorkflowRuns {
+		if !strings.Contains(run.DisplayTitle, dispatchID) || run.Status != "completed" {
+			continue
+		}
+		if run.Conclusion != "success" {
+			return true, fmt.Errorf("workflow run concluded %q: %s", run.Conclusion, run.HTMLURL)
+		}
+		log.Printf("Canary run of %s succeeded: %s", target.Name(), run.HTMLURL)
+		return true, nil
+	}
+	return false, nil
+}
+
+// fetchWorkflowRuns lists workflow runs of a target
+func (c *DipaChecker) fetchWorkflowRuns(target Target, runsURL string) (workflowRuns, error) {
+	var runs workflowRuns
+
+	req, err := http.NewRequest("GET", runsURL, nil)
+	if err != nil {
+		return runs, err
+	}
+
+	token, err := c.githubToken(target)
+	if err != nil {
+		return runs, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return runs, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return runs, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return runs, json.NewDecoder(resp.Body).Decode(&runs)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// canaryRun is a workflow run returned by the canary test server
+type canaryRun struct {
+	title      string
+	conclusion string
+}
+
+// serveCanary serves a listing, accepts dispatches and lists the workflow runs returned
+// by runs for the dispatch_id sent to the canary. It returns the dispatched repos in order.
+func serveCanary(t *testing.T, checker *DipaChecker, runs func(dispatchID string) []canaryRun) func() []string {
+	t.Helper()
+
+	var mu sync.Mutex
+	dispatched := []string{}
+	dispatchID := ""
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.URL.Path {
+		case "/stable/":
+			w.Write(listingJSON("Discord_228.0.ipa"))
+		case "/repos/example/canary/dispatches", "/repos/example/app/dispatches":
+			var body struct {
+				ClientPayload map[string]interface{} `json:"client_payload"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			repo := r.URL.Path[len("/repos/") : len(r.URL.Path)-len("/dispatches")]
+			if id, ok := body.ClientPayload["dispatch_id"].(string); ok {
+				if repo != "example/canary" {
+					t.Errorf("dispatch_id sent to %s, which is not a canary", repo)
+				}
+				dispatchID = id
+			}
+			dispatched = append(dispatched, repo)
+			w.WriteHeader(http.StatusNoContent)
+		case "/repos/example/canary/actions/runs":
+			list := workflowRuns{}
+			for _, run := range runs(dispatchID) {
+				list.WorkflowRuns = append(list.WorkflowRuns,
+					workflowRun{Status: "completed", Conclusion: run.conclusion, DisplayTitle: run.title})
+			}
+			json.NewEncoder(w).Encode(list)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, dispatched...)
+	}
+}
+
+const canaryConfig = `
+branches = ["stable"]
+canary_timeout = "50ms"
+
+[[targets]]
+github_repo = "example/canary"
+github_token = "token"
+canary = true
+
+[[targets]]
+github_repo = "example/app"
+github_token = "token"
+`
+
+func newCanaryChecker(t *testing.T, config string) (*DipaChecker, *recordingNotifier) {
+	checker := newTestChecker(t, config)
+	notifier := &recordingNotifier{}
+	checker.Notifiers = []Notifier{notifier}
+	return checker, notifier
+}
+
+// checkTimes checks the stable branch n times
+func checkTimes(t *testing.T, checker *DipaChecker, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		if err := checker.CheckBranch("stable"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestCanarySuccessPromotes dispatches to the canary, leaving the rest pending, and
+// promotes the update on the next check once the canary run succeeded
+func TestCanarySuccessPromotes(t *testing.T) {
+	checker, notifier := newCanaryChecker(t, canaryConfig)
+	dispatched := serveCanary(t, checker, func(dispatchID string) []canaryRun {
+		// A failed run of something else must not abort the rollout
+		return []canaryRun{
+			{title: "Nightly build", conclusion: "failure"},
+			{title: "IPA " + dispatchID, conclusion: "success"},
+		}
+	})
+
+	checkTimes(t, checker, 1)
+	if got := dispatched(); len(got) != 1 || got[0] != "example/canary" {
+		t.Fatalf("dispatched %v, want only the canary before its run is checked", got)
+	}
+	if pending := checker.branchState("stable").Pending; len(pending) != 1 || pending[0] != "example/app" {
+		t.Errorf("pending %v, want example/app waiting for the canary", pending)
+	}
+
+	checkTimes(t, checker, 2)
+	got := dispatched()
+	if len(got) != 2 || got[0] != "example/canary" || got[1] != "example/app" {
+		t.Errorf("dispatched %v, want the canary then example/app", got)
+	}
+	if state := checker.branchState("stable"); len(state.Pending) != 0 || len(state.Canaries) != 0 {
+		t.Errorf("rollout still waiting after the promotion: %+v", state)
+	}
+	if alerts := notifier.titled("Canary failed"); len(alerts) != 0 {
+		t.Errorf("unexpected canary alert: %+v", alerts)
+	}
+}
+
+// TestCanaryDoesNotBlock returns from each check while the canary run is still in
+// progress, keeping the rollout across restarts
+func TestCanaryDoesNotBlock(t *testing.T) {
+	checker, notifier := newCanaryChecker(t, strings.Replace(canaryConfig, `"50ms"`, `"1h"`, 1))
+	dispatched := serveCanary(t, checker, func(dispatchID string) []canaryRun {
+		return nil
+	})
+
+	start := time.Now()
+	checkTimes(t, checker, 3)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("checks took %s while the canary run was in progress", elapsed)
+	}
+
+	if got := dispatched(); len(got) != 1 {
+		t.Errorf("dispatched %v, want only the canary", got)
+	}
+	if alerts := notifier.titled("Canary failed"); len(alerts) != 0 {
+		t.Errorf("unexpected canary alert: %+v", alerts)
+	}
+	restarted := reopen(t, checker)
+	if state := restarted.BranchData.Branches["stable"]; len(state.Canaries) != 1 || len(state.Pending) != 1 {
+		t.Errorf("stored state %+v, want the waiting rollout with example/app pending", state)
+	}
+}
+
+func TestCanaryFailureAborts(t *testing.T) {
+	checker, notifier := newCanaryChecker(t, canaryConfig)
+	dispatched := serveCanary(t, checker, func(dispatchID string) []canaryRun {
+		// A successful run of something else must not promote the update
+		return []canaryRun{
+			{title: "Nightly build", conclusion: "success"},
+			{title: "IPA " + dispatchID, conclusion: "failure"},
+		}
+	})
+
+	checkTimes(t, checker, 3)
+
+	if got := dispatched(); len(got) != 1 || got[0] != "example/canary" {
+		t.Errorf("dispatched %v, want only the canary", got)
+	}
+	if alerts := notifier.titled("Canary failed"); len(alerts) != 1 {
+		t.Errorf("got %d canary alerts, want 1", len(alerts))
+	}
+	if pending := checker.branchState("stable").Pending; len(pending) != 0 {
+		t.Errorf("aborted rollout left %v pending", pending)
+	}
+}
+
+func TestCanaryIgnoresUnrelatedRuns(t *testing.T) {
+	checker, notifier := newCanaryChecker(t, canaryConfig)
+	dispatched := serveCanary(t, checker, func(dispatchID string) []canaryRun {
+		return []canaryRun{
+			{title: "Nightly build", conclusion: "success"},
+			{title: "IPA 0000000000000000", conclusion: "success"},
+		}
+	})
+
+	checkTimes(t, checker, 1)
+	time.Sleep(60 * time.Millisecond)
+	checkTimes(t, checker, 1)
+
+	if got := dispatched(); len(got) != 1 {
+		t.Errorf("dispatched %v, want only the canary", got)
+	}
+	if alerts := notifier.titled("Canary failed"); len(alerts) != 1 {
+		t.Errorf("got %d canary alerts after the timeout, want 1", len(alerts))
+	}
+}
+
+// TestCanarySkippedAborts treats a canary that does not take the update as a failed one
+func TestCanarySkippedAborts(t *testing.T) {
+	config := strings.Replace(canaryConfig, "canary = true\n", "canary = true\nversion_constraint = \"<100.0.0\"\n", 1)
+	checker, notifier := newCanaryChecker(t, config)
+	dispatched := serveCanary(t, checker, func(dispatchID string) []canaryRun {
+		return nil
+	})
+
+	checkTimes(t, checker, 2)
+
+	if got := dispatched(); len(got) != 0 {
+		t.Errorf("dispatched %v without a canary taking the update", got)
+	}
+	if alerts := notifier.titled("Canary failed"); len(alerts) != 1 {
+		t.Errorf("got %d canary alerts, want 1", len(alerts))
+	}
+}
+
+func TestCanaryBranchFilterValidation(t *testing.T) {
+	for _, filter := range []string{`branches = ["stable"]`, `exclude_branches = ["stable"]`} {
+		config := strings.Replace(canaryConfig, "canary = true\n", "canary = true\n"+filter+"\n", 1)
+		if _, err := loadTestConfig(t, config); err == nil || !strings.Contains(err.Error(), "must receive every branch") {
+			t.Errorf("%s: error %v, want the canary to receive every branch", filter, err)
+		}
+	}
+}
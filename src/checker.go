@@ -2,35 +2,104 @@ package main
 
 import (
 	"bytes"
-	"crypto/sha256"
+	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
+	"math/rand"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
+// githubAPIURL is the base URL of the GitHub REST API
+const githubAPIURL = "https://api.github.com"
+
+// PayloadVersion is the schema version of the dispatch client_payload.
+// Increment it whenever fields are added, removed or change meaning.
+const PayloadVersion = 1
+
+// defaultBranches are the branches checked on the IPA server unless branches is set
+var defaultBranches = []string{"stable", "testflight"}
+
 // IPAFile represents an IPA file in the directory listing
 type IPAFile struct {
 	Name    string    `json:"name"`
 	ModTime time.Time `json:"mod_time"`
+	// ContentHash and Version are only read in content_addressed mode
+	ContentHash string `json:"content_hash,omitempty"`
+	Version     string `json:"version,omitempty"`
 }
 
 // BranchHashes represents the hash data for each branch
 type BranchHashes struct {
 	// Map of branch names to branch data
 	Branches map[string]BranchData `json:"branches"`
+	// Paused disables dispatching until resumed
+	Paused bool `json:"paused"`
+	// LastPayloads holds the last successfully dispatched request body per repo
+	LastPayloads map[string]string `json:"last_payloads,omitempty"`
+	// LastDispatched holds the time of the last successful dispatch per repo
+	LastDispatched map[string]time.Time `json:"last_dispatched,omitempty"`
+	// LastRun is the time of the last fully successful check cycle, kept when resume_schedule is set
+	LastRun *time.Time `json:"last_run,omitempty"`
+	// BlockedVersions are file names that are never dispatched, set with the block subcommand
+	BlockedVersions []string `json:"blocked_versions,omitempty"`
+	// BlockedHashes are listing hashes that are never dispatched, set with the block subcommand
+	BlockedHashes []string `json:"blocked_hashes,omitempty"`
 }
 
 // BranchData represents the hash and dispatch data for a branch
 type BranchData struct {
-	Hash      string              `json:"hash"`
-	Dispatches map[string][]string `json:"dispatches"`
+	Hash        string              `json:"hash"`
+	Dispatches  map[string][]string `json:"dispatches"`
+	LastChanged time.Time           `json:"last_changed"`
+	// Latest is the newest file at the stored hash, used to detect rollbacks
+	Latest        string    `json:"latest,omitempty"`
+	LatestModTime time.Time `json:"latest_mod_time"`
+	// LatestVersion is the listed version of Latest in content_addressed mode
+	LatestVersion string `json:"latest_version,omitempty"`
+	// Pending lists targets still waiting for a dispatch of the stored hash
+	Pending []string `json:"pending,omitempty"`
+	// Files is the listing at the stored hash, kept when log_listing_diff is set
+	Files []IPAFile `json:"files,omitempty"`
+	// FileHashes are the per-file hashes at the stored hash, kept when incremental_hash is set
+	FileHashes map[string]string `json:"file_hashes,omitempty"`
+	// Winner is the target that took the last change when any_target_succeeds is set
+	Winner string `json:"winner,omitempty"`
+	// DeadLettered is the dispatch key last sent to dead_letter_repo, so each is sent once
+	DeadLettered string `json:"dead_lettered,omitempty"`
+	// Variants maps each variant to its latest file at the stored hash when variant_regex is set
+	Variants map[string]string `json:"variants,omitempty"`
+	// Canaries holds the rollouts waiting for their canary workflow runs by dispatch key
+	Canaries map[string]CanaryRollout `json:"canaries,omitempty"`
+}
+
+// DispatchEvent describes an IPA update to dispatch to the targets
+type DispatchEvent struct {
+	Branch string
+	Hash   string
+	IPAURL string
+	Files  []IPAFile
+	// Version is the file name of the dispatched build, checked against version constraints
+	Version string
+	// Rollback is set when the latest file is older than the previously dispatched one
+	Rollback bool
+	// OnlyTargets limits the dispatch to these repos when set
+	OnlyTargets []string
+	// Force dispatches to targets that were already dispatched for the hash
+	Force bool
+	// Variant is the variant of the dispatched file when variant_regex is set
+	Variant string
+	// DispatchID is sent as dispatch_id so the workflow run of a canary can be identified
+	DispatchID string
 }
 
 // DipaChecker is the main checker for IPA updates
@@ -39,22 +108,302 @@ type DipaChecker struct {
 	HashFile   string
 	BranchData BranchHashes
 	Client     *http.Client
+	Notifiers  []Notifier
+	Statsd     *StatsdClient
+	// Metrics collects Prometheus metrics when metrics_port is set
+	Metrics *Metrics
+	// OnEvent is called synchronously for lifecycle events when set.
+	// Calls are serialized, so it needs no locking of its own.
+	OnEvent func(Event)
+	eventMu sync.Mutex
+
+	// notifyLimiter throttles notifications when notify_rate is set
+	notifyLimiter        *tokenBucket
+	droppedNotifications int
+	notifyMu             sync.Mutex
+
+	// TargetHealth tracks the reachability of each target, keyed by target name
+	TargetHealth map[string]TargetHealth
+	healthMu     sync.Mutex
+
+	// BranchStatus tracks the in-memory status of each branch
+	BranchStatus map[string]*BranchStatus
+	statusMu     sync.Mutex
+
+	staleAfter              time.Duration
+	actionsDisabledCooldown time.Duration
+	listingLocation         *time.Location
+
+	// recentHashes tracks recently observed hashes per branch for flap detection
+	recentHashes map[string][]hashSighting
+	flapWindow   time.Duration
+	flapMu       sync.Mutex
+
+	// lastFetch caches listings for min_fetch_interval
+	lastFetch        map[string]cachedListing
+	minFetchInterval time.Duration
+	fetchMu          sync.Mutex
+
+	// bodyReadTimeout bounds reading a response body, 0 when unset
+	bodyReadTimeout time.Duration
+
+	// minVersion is the parsed min_version, nil when unset
+	minVersion *semver
+
+	rng   *rand.Rand
+	rngMu sync.Mutex
+
+	// appTokens caches GitHub App installation tokens by installation ID
+	appTokens      map[string]appToken
+	appTokenMargin time.Duration
+	appTokenMu     sync.Mutex
+
+	// fetchRetryBase is the base delay of the listing fetch backoff
+	fetchRetryBase time.Duration
+	// dispatchRetryBase is the base delay of the dispatch backoff
+	dispatchRetryBase time.Duration
+	// stageDelay is the pause between dispatch stages
+	stageDelay time.Duration
+	// canaryTimeout bounds the wait for canary workflow runs
+	canaryTimeout time.Duration
+	// initialRetryDelay is the first delay between initial check retries
+	initialRetryDelay time.Duration
+	// includePattern and excludePattern filter listing files by name when set
+	includePattern *regexp.Regexp
+	excludePattern *regexp.Regexp
+	// nameVersionRegex extracts versions from file names when version_regex is set
+	nameVersionRegex *regexp.Regexp
+	// variantRegex groups listing files into variants when variant_regex is set
+	variantRegex *regexp.Regexp
+	// http3 fetches listings over QUIC when listing_http3 is set and HTTP/3 support is built in
+	http3 http.RoundTripper
+
+	// vaultSecrets caches tokens read from Vault by path and key
+	vaultSecrets  map[string]vaultSecret
+	vaultCacheTTL time.Duration
+	vaultMu       sync.Mutex
+
+	// Throttled saves when save_interval is set
+	saveInterval  time.Duration
+	dirtyBranches []string
+	lastSave      time.Time
+	saveTimer     *time.Timer
+	saveMu        sync.Mutex
+
+	// retriesLeft is the remaining retry budget of the current check cycle
+	retriesLeft int
+	retryMu     sync.Mutex
+
+	// cycleMu serializes check cycles from the scheduler and the initial check
+	cycleMu             sync.Mutex
+	initialCheckTimeout time.Duration
+
+	// stateMu guards BranchData and the hash file. It is only held to read or update
+	// the state, never across fetches, dispatches or waits, so branches are checked concurrently.
+	stateMu sync.Mutex
+	// blockMu guards the blocklists, which are also read while dispatching.
+	// Writers hold stateMu as well.
+	blockMu sync.RWMutex
+
+	// refAlerts holds the default branch each workflow target was last alerted about
+	refAlerts map[string]string
+	refMu     sync.Mutex
 }
 
 // NewChecker creates a new DipaChecker
 func NewChecker(cfg *Config) (*DipaChecker, error) {
-	hashDir := "/var/lib/dipa-auto"
-	if err := os.MkdirAll(hashDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create hash directory: %w", err)
+	if err := os.MkdirAll(cfg.HashDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create hash directory %s: %w", cfg.HashDir, err)
 	}
 
 	checker := &DipaChecker{
 		Config:   cfg,
-		HashFile: filepath.Join(hashDir, "branch_hashes.json"),
-		Client:   &http.Client{Timeout: 30 * time.Second},
+		HashFile: filepath.Join(cfg.HashDir, "branch_hashes.json"),
+		Client:   &http.Client{Timeout: defaultRequestTimeout},
 		BranchData: BranchHashes{
 			Branches: make(map[string]BranchData),
 		},
+		TargetHealth: make(map[string]TargetHealth),
+		BranchStatus: make(map[string]*BranchStatus),
+		recentHashes: make(map[string][]hashSighting),
+		rng:          rand.New(rand.NewSource(time.Now().UnixNano())),
+		retriesLeft:  cfg.RetryBudget,
+	}
+
+	if cfg.SaveInterval != "" {
+		interval, err := time.ParseDuration(cfg.SaveInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid save_interval: %w", err)
+		}
+		checker.saveInterval = interval
+	}
+
+	checker.dispatchRetryBase = defaultDispatchRetryBase
+	checker.fetchRetryBase = defaultFetchRetryBase
+	if cfg.RetryBackoff != "" {
+		backoff, err := time.ParseDuration(cfg.RetryBackoff)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry_backoff: %w", err)
+		}
+		checker.fetchRetryBase = backoff
+	}
+
+	if cfg.MinFetchInterval != "" {
+		interval, err := time.ParseDuration(cfg.MinFetchInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid min_fetch_interval: %w", err)
+		}
+		checker.minFetchInterval = interval
+	}
+
+	if cfg.StageDelay != "" {
+		delay, err := time.ParseDuration(cfg.StageDelay)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stage_delay: %w", err)
+		}
+		checker.stageDelay = delay
+	}
+
+	include, exclude, err := compileFilePatterns(cfg.IncludePattern, cfg.ExcludePattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file pattern: %w", err)
+	}
+	checker.includePattern, checker.excludePattern = include, exclude
+
+	if cfg.VersionRegex != "" {
+		re, err := compileVersionRegex(cfg.VersionRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version_regex: %w", err)
+		}
+		checker.nameVersionRegex = re
+	}
+
+	if cfg.VariantRegex != "" {
+		re, err := compileVariantRegex(cfg.VariantRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid variant_regex: %w", err)
+		}
+		checker.variantRegex = re
+	}
+
+	checker.canaryTimeout = defaultCanaryTimeout
+	if cfg.CanaryTimeout != "" {
+		timeout, err := time.ParseDuration(cfg.CanaryTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid canary_timeout: %w", err)
+		}
+		checker.canaryTimeout = timeout
+	}
+
+	checker.vaultCacheTTL = defaultVaultCacheTTL
+	if cfg.VaultCacheTTL != "" {
+		ttl, err := time.ParseDuration(cfg.VaultCacheTTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vault_cache_ttl: %w", err)
+		}
+		checker.vaultCacheTTL = ttl
+	}
+
+	checker.appTokenMargin = defaultAppTokenMargin
+	if cfg.GitHubAppTokenMargin != "" {
+		margin, err := time.ParseDuration(cfg.GitHubAppTokenMargin)
+		if err != nil {
+			return nil, fmt.Errorf("invalid github_app_token_margin: %w", err)
+		}
+		checker.appTokenMargin = margin
+	}
+
+	transport, err := newTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	checker.Client.Transport = transport
+
+	if cfg.ListingHTTP3 {
+		if newHTTP3Transport == nil {
+			log.Printf("listing_http3 is set, but this build has no HTTP/3 support (build with -tags http3), fetching listings over HTTP/2")
+		} else {
+			checker.http3 = newHTTP3Transport(transport.TLSClientConfig)
+		}
+	}
+
+	if cfg.RequestTimeout != "" {
+		timeout, err := time.ParseDuration(cfg.RequestTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid request_timeout: %w", err)
+		}
+		checker.Client.Timeout = timeout
+	}
+
+	if cfg.BodyReadTimeout != "" {
+		timeout, err := time.ParseDuration(cfg.BodyReadTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body_read_timeout: %w", err)
+		}
+		checker.bodyReadTimeout = timeout
+	}
+
+	if cfg.StaleBranchAfter != "" {
+		staleAfter, err := time.ParseDuration(cfg.StaleBranchAfter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stale_branch_after: %w", err)
+		}
+		checker.staleAfter = staleAfter
+	}
+
+	if cfg.MinVersion != "" {
+		minVersion, ok := parseVersionSetting(cfg.MinVersion)
+		if !ok {
+			return nil, fmt.Errorf("invalid min_version: %s", cfg.MinVersion)
+		}
+		checker.minVersion = &minVersion
+	}
+
+	checker.listingLocation = time.UTC
+	if cfg.ListingTimezone != "" {
+		loc, err := time.LoadLocation(cfg.ListingTimezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listing_timezone: %w", err)
+		}
+		checker.listingLocation = loc
+	}
+
+	checker.initialRetryDelay = defaultInitialRetryDelay
+	if cfg.InitialCheckTimeout != "" {
+		timeout, err := time.ParseDuration(cfg.InitialCheckTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid initial_check_timeout: %w", err)
+		}
+		checker.initialCheckTimeout = timeout
+	}
+
+	checker.actionsDisabledCooldown = defaultActionsDisabledCooldown
+	if cfg.ActionsDisabledCooldown != "" {
+		cooldown, err := time.ParseDuration(cfg.ActionsDisabledCooldown)
+		if err != nil {
+			return nil, fmt.Errorf("invalid actions_disabled_cooldown: %w", err)
+		}
+		checker.actionsDisabledCooldown = cooldown
+	}
+
+	if cfg.FlapWindow != "" {
+		flapWindow, err := time.ParseDuration(cfg.FlapWindow)
+		if err != nil {
+			return nil, fmt.Errorf("invalid flap_window: %w", err)
+		}
+		checker.flapWindow = flapWindow
+	}
+	checker.Notifiers = newNotifiers(cfg, checker.Client)
+	statsd, err := newStatsdClient(cfg.StatsdAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid statsd_addr: %w", err)
+	}
+	checker.Statsd = statsd
+	if cfg.MetricsPort > 0 {
+		checker.Metrics = NewMetrics(cfg.MetricsLabels)
+	}
+	if cfg.NotifyRate > 0 {
+		checker.notifyLimiter = newTokenBucket(cfg.NotifyRate, cfg.NotifyBurst)
 	}
 
 	// Initialize the hash file (either load it or create it)
@@ -65,19 +414,31 @@ func NewChecker(cfg *Config) (*DipaChecker, error) {
 	return checker, nil
 }
 
+// BranchOrder returns the order in which branches are checked this cycle,
+// shuffled when randomize_branch_order is set so branches share rate limits fairly
+func (c *DipaChecker) BranchOrder(branches []string) []string {
+	order := append([]string(nil), branches...)
+	if c.Config.RandomizeBranchOrder {
+		c.rngMu.Lock()
+		c.rng.Shuffle(len(order), func(i, j int) {
+			order[i], order[j] = order[j], order[i]
+		})
+		c.rngMu.Unlock()
+	}
+	return order
+}
+
 // InitHashFile initializes the hash file - either loads existing one or creates new
 func (c *DipaChecker) InitHashFile() error {
 	// Check if the file exists
 	if _, err := os.Stat(c.HashFile); os.IsNotExist(err) {
 		// File doesn't exist, create a new one
 		log.Printf("Hash file not found, creating new one at %s", c.HashFile)
-		c.BranchData.Branches["stable"] = BranchData{
-			Hash:      "",
-			Dispatches: make(map[string][]string),
-		}
-		c.BranchData.Branches["testflight"] = BranchData{
-			Hash:      "",
-			Dispatches: make(map[string][]string),
+		for _, branch := range c.Config.Branches {
+			c.BranchData.Branches[branch] = BranchData{
+				Hash:      "",
+				Dispatches: make(map[string][]string),
+			}
 		}
 		
 		return c.SaveHashes()
@@ -89,18 +450,13 @@ func (c *DipaChecker) InitHashFile() error {
 		return fmt.Errorf("failed to load hash file: %w", err)
 	}
 	
-	// Make sure both branches exist
-	if _, ok := c.BranchData.Branches["stable"]; !ok {
-		c.BranchData.Branches["stable"] = BranchData{
-			Hash:      "",
-			Dispatches: make(map[string][]string),
-		}
-	}
-	
-	if _, ok := c.BranchData.Branches["testflight"]; !ok {
-		c.BranchData.Branches["testflight"] = BranchData{
-			Hash:      "",
-			Dispatches: make(map[string][]string),
+	// Make sure every configured branch exists
+	for _, branch := range c.Config.Branches {
+		if _, ok := c.BranchData.Branches[branch]; !ok {
+			c.BranchData.Branches[branch] = BranchData{
+				Hash:      "",
+				Dispatches: make(map[string][]string),
+			}
 		}
 	}
 	
@@ -109,71 +465,352 @@ func (c *DipaChecker) InitHashFile() error {
 
 // LoadHashes loads the branch hashes from the hash file
 func (c *DipaChecker) LoadHashes() error {
-	file, err := os.Open(c.HashFile)
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	data, err := c.readStateFile(c.HashFile)
+	if err != nil {
+		return err
+	}
+
+	c.blockMu.Lock()
+	err = decodeHashes(data, &c.BranchData)
+	c.blockMu.Unlock()
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	return json.NewDecoder(file).Decode(&c.BranchData)
+	if c.Config.SplitStateFiles {
+		return c.loadBranchFiles()
+	}
+	return nil
 }
 
 // SaveHashes saves the branch hashes to the hash file
 func (c *DipaChecker) SaveHashes() error {
-	file, err := os.Create(c.HashFile)
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	return c.saveHashesLocked()
+}
+
+// saveHashesLocked saves the branch hashes, the caller must hold stateMu.
+// Operator changes made through the subcommands since the last save are merged in first.
+func (c *DipaChecker) saveHashesLocked() error {
+	c.mergeStoredState()
+	return c.writeHashes()
+}
+
+// writeHashes writes the in-memory state as it is, the caller must hold stateMu
+func (c *DipaChecker) writeHashes() error {
+	if c.Config.SplitStateFiles {
+		return c.writeSplitState(c.branchNames()...)
+	}
+
+	data, err := encodeHashes(&c.BranchData, c.Config.CompactHashFile)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	return encoder.Encode(&c.BranchData)
+	if err := c.writeStateFile(c.HashFile, data); err != nil {
+		return err
+	}
+
+	// The save succeeded, so a failed snapshot is only logged
+	if err := c.snapshotState(); err != nil {
+		log.Printf("Failed to snapshot state: %v", err)
+	}
+	return nil
+}
+
+// saveBranch persists the state after a change to a single branch.
+// In split mode only that branch's file and the shared state are rewritten.
+// With save_interval set, saves are coalesced and flushed at most once per interval.
+// The caller must hold stateMu.
+func (c *DipaChecker) saveBranch(branch string) error {
+	if c.saveInterval > 0 {
+		return c.queueSave(branch)
+	}
+	if c.Config.SplitStateFiles {
+		return c.saveSplitState(branch)
+	}
+	return c.saveHashesLocked()
+}
+
+// branchState returns the stored state of a branch, the caller must hold stateMu
+func (c *DipaChecker) branchState(branch string) BranchData {
+	branchData, ok := c.BranchData.Branches[branch]
+	if !ok {
+		branchData = BranchData{Dispatches: make(map[string][]string)}
+	}
+	return branchData
+}
+
+// updateBranch applies update to the stored state of a branch and saves it,
+// holding stateMu only for the update and the save
+func (c *DipaChecker) updateBranch(branch string, update func(branchData *BranchData)) error {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	branchData := c.branchState(branch)
+	update(&branchData)
+	c.BranchData.Branches[branch] = branchData
+	return c.saveBranch(branch)
 }
 
-// FetchIPAList fetches the IPA list for a branch and calculates its hash
-func (c *DipaChecker) FetchIPAList(branch string) ([]IPAFile, string, error) {
-	url := fmt.Sprintf("%s/%s/", c.Config.IPABaseURL, branch)
+// readStateFile reads a state file, verifying its checksum when enabled
+func (c *DipaChecker) readStateFile(path string) ([]byte, error) {
+	if c.Config.VerifyHashChecksum {
+		return readVerifiedHashFile(path)
+	}
+	return os.ReadFile(path)
+}
+
+// writeStateFile writes a state file atomically, with a checksum and backup when enabled
+func (c *DipaChecker) writeStateFile(path string, data []byte) error {
+	// A dry run leaves the hash file alone so a real run afterwards still dispatches
+	if c.Config.DryRun {
+		return nil
+	}
+	if c.Config.VerifyHashChecksum {
+		return writeVerifiedHashFile(path, data)
+	}
+	return writeFileAtomic(path, data, 0644)
+}
+
+// StatusError indicates the IPA host answered a listing request with a status other than 200
+type StatusError struct {
+	StatusCode int
+	// RetryAfter is the Retry-After header of the response, if any
+	RetryAfter string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+}
+
+// BadBodyError indicates the IPA host answered 200 with a body that is not a JSON listing
+type BadBodyError struct {
+	Err     error
+	Snippet string
+	// Attempts is the number of fetches made before giving up
+	Attempts int
+}
+
+func (e *BadBodyError) Error() string {
+	return fmt.Sprintf("IPA host returned non-JSON body: %v (body: %q)", e.Err, e.Snippet)
+}
+
+func (e *BadBodyError) Unwrap() error {
+	return e.Err
+}
+
+// fetchAndHash fetches the IPA list for a branch and calculates its hash
+func (c *DipaChecker) fetchAndHash(branch string) ([]IPAFile, string, error) {
+	var files []IPAFile
+	var err error
+	attempts := 0
+
+	// Network errors, 5xx and 429 responses and non-JSON bodies (maintenance pages,
+	// proxies) are usually transient, so retry them
+	for attempt := 0; attempt <= c.Config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			if !c.takeRetry() {
+				log.Printf("Retry budget exhausted, not retrying %s listing fetch", branch)
+				break
+			}
+			delay := c.backoff(c.fetchRetryBase, attempt-1)
+			if retryAfter, ok := retryAfterDelay(err); ok {
+				delay = retryAfter
+			}
+			log.Printf("Retrying %s listing fetch in %s (attempt %d/%d): %v",
+				branch, delay.Round(time.Millisecond), attempt, c.Config.MaxRetries, err)
+			time.Sleep(delay)
+		}
+
+		start := time.Now()
+		files, err = c.fetchListing(branch)
+		attempts++
+		c.Metrics.ObserveFetch(branch, time.Since(start))
+		if !isRetryableFetchError(err) {
+			break
+		}
+	}
+
+	if err != nil {
+		// The retry budget may have cut retries short, so report what actually happened
+		var badBody *BadBodyError
+		if errors.As(err, &badBody) {
+			badBody.Attempts = attempts
+		}
+		return nil, "", err
+	}
+	
+	// Unrelated files (checksums, .DS_Store) neither change the hash nor get dispatched
+	files = c.filterFiles(files)
+	
+	// Only the newest entries take part in the hash when hash_top_n is set
+	hashed := newestFiles(files, c.Config.HashTopN)
 	
-	req, err := http.NewRequest("GET", url, nil)
+	// Per-file hashes let changes be localized to individual entries
+	if c.Config.IncrementalHash {
+		return files, merkleRoot(fileLeafHashes(hashed)), nil
+	}
+	
+	// Sort the data to ensure consistent hashing
+	sortedData, err := sortAndMarshal(hashed)
 	if err != nil {
 		return nil, "", err
 	}
 	
+	// Calculate hash
+	hasher := c.newListingHasher()
+	hasher.Write(sortedData)
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	
+	return files, hash, nil
+}
+
+// fetchListing performs a single request for the IPA list of a branch, or one per
+// backend when listing_quorum is set
+func (c *DipaChecker) fetchListing(branch string) ([]IPAFile, error) {
+	if c.Config.ListingQuorum > 1 {
+		return c.fetchListingQuorum(branch)
+	}
+	return c.fetchListingWith(c.listingClient(), branch)
+}
+
+// setIPAAuth authenticates a request to the IPA server, preferring the bearer token
+func (c *DipaChecker) setIPAAuth(req *http.Request) {
+	if c.Config.IPAAuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Config.IPAAuthToken)
+	} else if c.Config.IPAAuthUser != "" {
+		req.SetBasicAuth(c.Config.IPAAuthUser, c.Config.IPAAuthPassword)
+	}
+}
+
+// fetchListingWith requests the IPA list of a branch using the given client
+func (c *DipaChecker) fetchListingWith(client *http.Client, branch string) ([]IPAFile, error) {
+	url := listingURL(c.Config.IPABaseURL, branch)
+	
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	
 	req.Header.Set("Accept", "application/json")
+	c.setIPAAuth(req)
 	
-	resp, err := c.Client.Do(req)
+	// Custom headers come last so they can replace the defaults
+	for name, value := range c.Config.IPAHeaders {
+		req.Header.Set(name, value)
+	}
+	
+	resp, err := client.Do(req)
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 	
 	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, &StatusError{StatusCode: resp.StatusCode, RetryAfter: resp.Header.Get("Retry-After")}
 	}
 	
-	body, err := io.ReadAll(resp.Body)
+	body, err := c.readBody(resp, cancel)
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
 	
-	var files []IPAFile
-	if err := json.Unmarshal(body, &files); err != nil {
-		return nil, "", err
+	listing, err := extractListing(body, c.Config.ListingRootKey)
+	if err != nil {
+		return nil, &BadBodyError{Err: err, Snippet: trimString(string(body), 200)}
 	}
 	
-	// Sort the data to ensure consistent hashing
-	sortedData, err := sortAndMarshal(files)
-	if err != nil {
-		return nil, "", err
+	var entries []listingEntry
+	if err := json.Unmarshal(listing, &entries); err != nil {
+		return nil, &BadBodyError{Err: err, Snippet: trimString(string(body), 200)}
 	}
 	
-	// Calculate hash
-	hasher := sha256.New()
-	hasher.Write(sortedData)
-	hash := hex.EncodeToString(hasher.Sum(nil))
+	files := make([]IPAFile, 0, len(entries))
+	for _, entry := range entries {
+		// Content addressed listings don't depend on timestamps at all
+		if c.Config.ContentAddressed {
+			files = append(files, IPAFile{Name: entry.Name})
+			continue
+		}
+		modTime, err := parseModTime(entry.ModTime, c.listingLocation)
+		if err != nil {
+			return nil, &BadBodyError{Err: err, Snippet: trimString(string(body), 200)}
+		}
+		files = append(files, IPAFile{Name: entry.Name, ModTime: modTime})
+	}
 	
-	return files, hash, nil
+	if c.Config.ContentAddressed {
+		if err := c.decodeContentFields(listing, files); err != nil {
+			return nil, &BadBodyError{Err: err, Snippet: trimString(string(body), 200)}
+		}
+	}
+
+	return files, nil
+}
+
+// listingEntry is an IPA file as it appears in the listing, before timestamps are interpreted
+type listingEntry struct {
+	Name    string `json:"name"`
+	ModTime string `json:"mod_time"`
+}
+
+// naiveTimeLayouts are accepted for timestamps without a UTC offset
+var naiveTimeLayouts = []string{
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+}
+
+// parseModTime parses a listing timestamp. Timestamps with an offset are used as is,
+// naive timestamps are interpreted in loc.
+func parseModTime(value string, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	
+	for _, layout := range naiveTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	
+	return time.Time{}, fmt.Errorf("unrecognized mod_time %q", value)
+}
+
+// extractListing returns the file array nested under a dot separated key path,
+// or the whole body when no key is configured
+func extractListing(body []byte, rootKey string) ([]byte, error) {
+	if rootKey == "" {
+		return body, nil
+	}
+	
+	current := json.RawMessage(body)
+	for _, key := range strings.Split(rootKey, ".") {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(current, &object); err != nil {
+			return nil, fmt.Errorf("listing is not an object at %q: %w", key, err)
+		}
+		
+		next, ok := object[key]
+		if !ok {
+			return nil, fmt.Errorf("listing has no %q key", key)
+		}
+		current = next
+	}
+	
+	return current, nil
 }
 
 // sortAndMarshal sorts the IPA files and marshals them to JSON
@@ -186,6 +823,23 @@ func sortAndMarshal(files []IPAFile) ([]byte, error) {
 	return json.Marshal(files)
 }
 
+// newestFiles returns the n most recently modified files, or all files when n is not positive
+func newestFiles(files []IPAFile, n int) []IPAFile {
+	if n <= 0 || len(files) <= n {
+		return files
+	}
+	
+	newest := append([]IPAFile(nil), files...)
+	sort.Slice(newest, func(i, j int) bool {
+		if newest[i].ModTime.Equal(newest[j].ModTime) {
+			return newest[i].Name < newest[j].Name
+		}
+		return newest[i].ModTime.After(newest[j].ModTime)
+	})
+	
+	return newest[:n]
+}
+
 // GetLatestVersion returns the latest version from the IPA list
 func (c *DipaChecker) GetLatestVersion(files []IPAFile) *IPAFile {
 	if len(files) == 0 {
@@ -194,7 +848,7 @@ func (c *DipaChecker) GetLatestVersion(files []IPAFile) *IPAFile {
 	
 	latest := files[0]
 	for _, file := range files[1:] {
-		if file.ModTime.After(latest.ModTime) {
+		if c.newerThan(file, latest) {
 			latest = file
 		}
 	}
@@ -202,28 +856,54 @@ func (c *DipaChecker) GetLatestVersion(files []IPAFile) *IPAFile {
 	return &latest
 }
 
+// DispatchResult summarizes the outcome of dispatching an event to the targets
+type DispatchResult struct {
+	Successful []string
+	Failed     []string
+	// Pending targets were not ready and should be dispatched on a later check
+	Pending []string
+	// Winner is the target that took the change when any_target_succeeds is set
+	Winner string
+	// Variants holds the result of each variant by its dispatchKey when variant_regex is set
+	Variants map[string]DispatchResult
+}
+
 // DispatchGitHubWorkflow dispatches a GitHub workflow for an IPA update
-func (c *DipaChecker) DispatchGitHubWorkflow(ipaURL, branch, currentHash string) ([]string, []string, error) {
+func (c *DipaChecker) DispatchGitHubWorkflow(event DispatchEvent) (DispatchResult, error) {
+	ipaURL, branch, currentHash := event.IPAURL, event.Branch, event.Hash
 	successfulDispatches := []string{}
 	failedDispatches := []string{}
-	
-	// Get branch data
-	branchData, ok := c.BranchData.Branches[branch]
-	if !ok {
-		branchData = BranchData{
-			Hash:      "",
-			Dispatches: make(map[string][]string),
-		}
-	}
+	pendingDispatches := []string{}
+	winner := ""
 	
 	// Get dispatches for current hash
-	dispatches, ok := branchData.Dispatches[currentHash]
-	if !ok {
-		dispatches = []string{}
+	// Variants are tracked by their file so each is only dispatched when it changes
+	dispatches := []string{}
+	if !event.Force {
+		dispatches = c.dispatchedTo(branch, dispatchKey(event))
 	}
 	
-	for _, target := range c.Config.Targets {
-		repo := target.GitHubRepo
+	// Built lazily since most targets don't want the full listing
+	var payloadFiles []PayloadFile
+	
+	// Targets are dispatched in stages, waiting stage_delay after each stage that dispatched
+	targets := c.stagedTargets()
+	stageDispatched := false
+	
+	for i, target := range targets {
+		repo := target.Name()
+		
+		if i > 0 && target.Stage != targets[i-1].Stage && stageDispatched {
+			if c.stageDelay > 0 {
+				log.Printf("Waiting %s before dispatching %s stage %d", c.stageDelay, branch, target.Stage)
+				time.Sleep(c.stageDelay)
+			}
+			stageDispatched = false
+		}
+		
+		if target.Disabled || (len(event.OnlyTargets) > 0 && !containsString(event.OnlyTargets, repo)) {
+			continue
+		}
 		
 		// Skip if already successfully dispatched for this hash
 		alreadyDispatched := false
@@ -237,64 +917,201 @@ func (c *DipaChecker) DispatchGitHubWorkflow(ipaURL, branch, currentHash string)
 		if alreadyDispatched {
 			log.Printf("Skipping %s for %s - already dispatched for current version", repo, branch)
 			successfulDispatches = append(successfulDispatches, repo)
+			
+			// The change is already complete when any target may take it
+			if c.Config.AnyTargetSucceeds {
+				pendingDispatches = []string{}
+				break
+			}
+			continue
+		}
+
+		if !target.receivesBranch(branch) {
+			log.Printf("Skipping %s for %s - branch is excluded for this target", repo, branch)
+			continue
+		}
+
+		// Rollbacks only go to targets that opted in
+		if event.Rollback && !target.AcceptRollbacks {
+			log.Printf("Skipping %s for %s - target does not accept rollbacks", repo, branch)
 			continue
 		}
+
+		if target.VersionConstraint != "" {
+			ok, err := c.satisfiesConstraint(findFile(event.Files, event.Version), target.VersionConstraint)
+			if err != nil || !ok {
+				log.Printf("Skipping %s for %s - %s does not satisfy version constraint %q",
+					repo, branch, event.Version, target.VersionConstraint)
+				continue
+			}
+		}
+
+		// Targets with Actions disabled are only retried after their cooldown
+		if until, ok := c.actionsDisabledUntil(repo); ok {
+			log.Printf("Skipping %s for %s - GitHub Actions disabled, retrying after %s",
+				repo, branch, until.Format(time.RFC1123))
+			continue
+		}
+		
+		// Defer targets still cooling down from their last successful dispatch
+		if until, ok := c.successCooldownUntil(target); ok {
+			log.Printf("Deferring %s for %s - cooling down until %s", repo, branch, until.Format(time.RFC1123))
+			pendingDispatches = append(pendingDispatches, repo)
+			continue
+		}
+		
+		// Defer targets whose infrastructure isn't ready to a later check
+		if target.ReadinessURL != "" {
+			if err := c.checkReadiness(target); err != nil {
+				log.Printf("Deferring %s for %s - not ready: %v", repo, branch, err)
+				pendingDispatches = append(pendingDispatches, repo)
+				continue
+			}
+		}
 		
 		log.Printf("Dispatching workflow for %s update %s to %s", branch, ipaURL, repo)
 		
 		// Prepare dispatch payload
-		payload := map[string]interface{}{
-			"event_type": "ipa-update",
-			"client_payload": map[string]interface{}{
-				"ipa_url":      ipaURL,
-				"is_testflight": branch == "testflight",
-			},
+		clientPayload := map[string]interface{}{
+			"ipa_url":         ipaURL,
+			"is_testflight":   branch == "testflight",
+			"payload_version": PayloadVersion,
+			"branch":          branch,
+		}
+		if modTime, ok := fileModTime(event.Files, event.Version); ok {
+			clientPayload["mod_time"] = modTime
+		}
+		if c.Config.InstanceID != "" {
+			clientPayload["instance_id"] = c.Config.InstanceID
+		}
+		if event.Variant != "" {
+			clientPayload["variant"] = event.Variant
+		}
+		if event.DispatchID != "" {
+			clientPayload["dispatch_id"] = event.DispatchID
+		}
+
+		if c.Config.IncludeFiles || target.IncludeFiles {
+			if payloadFiles == nil {
+				payloadFiles = buildPayloadFiles(c.Config.IPABaseURL, branch, event.Files)
+			}
+			clientPayload["files"] = payloadFiles
+		}
+		
+		// Branch overrides win over the target, which wins over the global config.
+		// payload_fields replace branch and mod_time but never the reserved fields,
+		// which validateConfig rejects.
+		target = c.resolveTarget(target, branch)
+		target = c.resolveRef(target, branch)
+		for key, value := range target.PayloadFields {
+			clientPayload[key] = value
 		}
 		
-		payloadBytes, err := json.Marshal(payload)
+		payloadBytes, err := buildDispatchBody(target, target.EventType, clientPayload)
 		if err != nil {
 			log.Printf("Error marshaling payload for %s: %v", repo, err)
 			failedDispatches = append(failedDispatches, repo)
 			continue
 		}
 		
-		// Create request
-		url := fmt.Sprintf("https://api.github.com/repos/%s/dispatches", repo)
-		req, err := http.NewRequest("POST", url, bytes.NewBuffer(payloadBytes))
-		if err != nil {
-			log.Printf("Error creating request for %s: %v", repo, err)
-			failedDispatches = append(failedDispatches, repo)
+		// Only show what would be sent, counting it as a success for the logs
+		if c.Config.DryRun {
+			log.Printf("Dry run: would dispatch %s to %s (event_type %s): %s", branch, repo, target.EventType, payloadBytes)
+			successfulDispatches = append(successfulDispatches, repo)
+			if c.Config.AnyTargetSucceeds {
+				break
+			}
 			continue
 		}
 		
-		// Set headers
-		req.Header.Set("Accept", "application/vnd.github+json")
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", target.GitHubToken))
-		req.Header.Set("Content-Type", "application/json")
-		
-		// Send request
-		resp, err := c.Client.Do(req)
-		if err != nil {
-			log.Printf("Error sending request to %s: %v", repo, err)
+		if err := c.sendDispatchWithRetry(target, payloadBytes); err != nil {
+			log.Printf("Failed to dispatch %s workflow to %s: %v", branch, repo, err)
+			if isActionsDisabledError(err) {
+				c.markActionsDisabled(repo)
+			}
+			c.Statsd.Incr("dispatch.failures", "branch:"+branch, "repo:"+repo)
+			c.Metrics.ObserveDispatch(repo, "failure")
 			failedDispatches = append(failedDispatches, repo)
 			continue
 		}
 		
-		// Check response
-		if resp.StatusCode != http.StatusNoContent {
-			body, _ := io.ReadAll(resp.Body)
-			log.Printf("Failed to dispatch %s workflow to %s: Status %d, Details: %s", 
-				branch, repo, resp.StatusCode, trimString(string(body), 200))
-			failedDispatches = append(failedDispatches, repo)
-		} else {
-			log.Printf("Successfully dispatched %s workflow to %s", branch, repo)
-			successfulDispatches = append(successfulDispatches, repo)
-		}
+		log.Printf("Successfully dispatched %s workflow to %s", branch, repo)
+		stageDispatched = true
+		c.Statsd.Incr("dispatches", "branch:"+branch, "repo:"+repo)
+		c.Metrics.ObserveDispatch(repo, "success")
+		successfulDispatches = append(successfulDispatches, repo)
+		
+		// Keep the exact payload so it can be replayed verbatim
+		c.recordPayload(repo, payloadBytes)
 		
-		resp.Body.Close()
+		// Interchangeable targets only need one of them to take the change
+		if c.Config.AnyTargetSucceeds {
+			log.Printf("%s took the %s update, not dispatching to the remaining targets", repo, branch)
+			winner = repo
+			pendingDispatches = []string{}
+			break
+		}
 	}
 	
-	return successfulDispatches, failedDispatches, nil
+	result := DispatchResult{
+		Successful: successfulDispatches,
+		Failed:     failedDispatches,
+		Pending:    pendingDispatches,
+		Winner:     winner,
+	}
+	c.emit(Event{Type: EventDispatchResult, Branch: branch, Hash: currentHash, Version: event.Version, Result: &result})
+	c.exportDispatch(event, result)
+	
+	return result, nil
+}
+
+// DispatchError represents a dispatch rejected by GitHub
+type DispatchError struct {
+	StatusCode int
+	Body       string
+	// RetryAfter is the wait requested by rate limit headers, if any
+	RetryAfter time.Duration
+}
+
+func (e *DispatchError) Error() string {
+	return fmt.Sprintf("Status %d, Details: %s", e.StatusCode, trimString(e.Body, 200))
+}
+
+// postDispatch sends a repository_dispatch or workflow_dispatch request to a target
+func (c *DipaChecker) postDispatch(target Target, payloadBytes []byte) error {
+	url := target.dispatchURL()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	
+	// Set headers
+	token, err := c.githubToken(target)
+	if err != nil {
+		return fmt.Errorf("error getting token: %w", err)
+	}
+	
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("Content-Type", target.contentType())
+	
+	// Send request
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+	
+	// Check response
+	if !target.acceptsStatus(resp.StatusCode, http.StatusNoContent) {
+		body, _ := c.readBody(resp, cancel)
+		return &DispatchError{StatusCode: resp.StatusCode, Body: string(body), RetryAfter: rateLimitDelay(resp.Header)}
+	}
+	
+	return nil
 }
 
 // trimString trims a string to the specified length
@@ -308,69 +1125,157 @@ func trimString(s string, maxLen int) string {
 // CheckBranch checks a branch for updates
 func (c *DipaChecker) CheckBranch(branch string) error {
 	log.Printf("Checking %s branch...", branch)
+	c.emit(Event{Type: EventCheckStarted, Branch: branch})
+	c.Metrics.ObserveCheck(branch)
+	c.updateBranchStatus(branch, func(status *BranchStatus) {
+		status.LastCheck = time.Now()
+		status.Changed = false
+	})
 	
 	files, currentHash, err := c.FetchIPAList(branch)
 	if err != nil {
+		var badBody *BadBodyError
+		isBadBody := errors.As(err, &badBody)
+		alerted := false
+		c.updateBranchStatus(branch, func(status *BranchStatus) {
+			status.LastError = err.Error()
+			alerted = status.NonJSON
+			status.NonJSON = status.NonJSON || isBadBody
+		})
+
+		// Alert once per outage rather than on every check
+		if isBadBody && !alerted {
+			c.alert(branch, "IPA host returning non-JSON",
+				fmt.Sprintf("Skipping %s for this check after %d attempts: %v", branch, badBody.Attempts, err))
+		}
 		return fmt.Errorf("error fetching IPA list: %w", err)
 	}
-	
-	// Ensure branch exists in hash structure
-	branchData, ok := c.BranchData.Branches[branch]
-	if !ok {
-		branchData = BranchData{
-			Hash:      "",
-			Dispatches: make(map[string][]string),
+	c.updateBranchStatus(branch, func(status *BranchStatus) {
+		if status.NonJSON {
+			log.Printf("IPA host is returning a JSON listing for %s again", branch)
 		}
-	}
+		status.NonJSON = false
+	})
+	
+	// Work from a snapshot of the branch state. The state is locked again only to
+	// record the outcome, so dispatches and waits don't hold up other branches.
+	c.stateMu.Lock()
+	branchData := c.branchState(branch)
+
+	// Pick up pause/resume from the subcommands before anything is saved
+	paused := c.refreshPaused()
+	c.stateMu.Unlock()
 	
 	storedHash := branchData.Hash
+
+	flapping := c.recordHash(branch, currentHash)
+
+	// Builds older than min_version are tracked like paused ones, but never dispatched
+	belowFloor := currentHash != storedHash && c.belowMinVersion(c.selectVersion(files))
+
+	// So are blocked versions and hashes
+	blocked := currentHash != storedHash && c.isBlocked(currentHash, files, branchData)
 	
-	if currentHash != storedHash {
-		latestVersion := c.GetLatestVersion(files)
+	if currentHash != storedHash && flapping {
+		log.Printf("%s hash is flapping, skipping dispatch", branch)
+	} else if currentHash != storedHash && (paused || belowFloor || blocked) {
+		// Keep tracking the listing while paused, but never dispatch
+		if paused {
+			log.Printf("dipa-auto is paused, recording new %s hash without dispatching", branch)
+		} else if blocked {
+			log.Printf("Latest %s build is blocked, recording new hash without dispatching", branch)
+		} else {
+			log.Printf("Latest %s build is below min_version %s, recording new hash without dispatching",
+				branch, c.Config.MinVersion)
+		}
+		err := c.updateBranch(branch, func(branchData *BranchData) {
+			branchData.Hash = currentHash
+			branchData.LastChanged = time.Now()
+			if latest := c.GetLatestVersion(files); latest != nil {
+				recordLatest(branchData, *latest)
+			}
+			c.recordListing(branchData, files)
+		})
+		if err != nil {
+			return fmt.Errorf("error saving hashes: %w", err)
+		}
+	} else if currentHash != storedHash {
+		c.Statsd.Incr("changes", "branch:"+branch)
+		latestVersion := c.selectVersion(files)
 		if latestVersion != nil {
-			finalURL := fmt.Sprintf("%s/%s/%s", c.Config.IPABaseURL, branch, latestVersion.Name)
-			log.Printf("New version found in %s: %s", branch, finalURL)
+			c.emit(Event{Type: EventChangeDetected, Branch: branch, Hash: currentHash, Version: latestVersion.Name})
+			finalURL := ipaURL(c.Config.IPABaseURL, branch, latestVersion.Name)
+			rollback := c.isRollback(*latestVersion, branchData)
+			if rollback {
+				log.Printf("Rollback detected in %s: %s is older than %s", branch, latestVersion.Name, branchData.Latest)
+			} else {
+				log.Printf("New version found in %s: %s", branch, finalURL)
+			}
+
+			diff, hasDiff := c.listingDiff(branchData, files)
+			if hasDiff {
+				log.Printf("%s hash changed from %s to %s: %s", branch,
+					shortHash(storedHash), shortHash(currentHash), diff)
+			}
+			
+			// Defer the dispatch to the next check rather than sending a broken URL downstream
+			if c.Config.VerifyIPA {
+				if err := c.verifyIPA(finalURL); err != nil {
+					c.alert(branch, "IPA failed verification",
+						fmt.Sprintf("Deferring dispatch of %s: %v", finalURL, err))
+					return fmt.Errorf("error verifying IPA: %w", err)
+				}
+			}
 			
-			successful, failed, err := c.DispatchGitHubWorkflow(finalURL, branch, currentHash)
+			event := DispatchEvent{
+				Branch:   branch,
+				Hash:     currentHash,
+				IPAURL:   finalURL,
+				Files:    files,
+				Version:  latestVersion.Name,
+				Rollback: rollback,
+			}
+			result, err := c.dispatchVariants(event, c.dispatchWithCanary)
 			if err != nil {
 				return fmt.Errorf("error dispatching workflow: %w", err)
 			}
+			successful, failed := result.Successful, result.Failed
+			c.updateBranchStatus(branch, func(status *BranchStatus) {
+				status.Changed = true
+				status.Version = latestVersion.Name
+				status.Dispatched = successful
+				status.Failed = failed
+				status.Pending = result.Pending
+			})
 			
-			// Update hash and dispatched repositories if there are successful dispatches
-			if len(successful) > 0 {
-				branchData.Hash = currentHash
-				
-				// Initialize dispatches map if needed
-				if branchData.Dispatches == nil {
-					branchData.Dispatches = make(map[string][]string)
-				}
-				
-				// Initialize array for current hash if needed
-				if _, ok := branchData.Dispatches[currentHash]; !ok {
-					branchData.Dispatches[currentHash] = []string{}
+			// Hand updates every target rejected to the dead-letter repo, once per hash
+			if len(successful) == 0 && len(result.Pending) == 0 && len(failed) > 0 {
+				c.dispatchDeadLetter(event, failed)
+			}
+			
+			// Update hash and dispatched repositories if there are successful dispatches.
+			// A change with nothing left to dispatch, like a rollback no target accepts or
+			// variants that were all dispatched already, is recorded too so it is only handled once.
+			// So is a change every target deferred, which is then queued instead of detected again.
+			if len(successful) > 0 || len(result.Pending) > 0 || len(failed) == 0 {
+				pending := result.Pending
+				if len(successful) == 0 {
+					// Nothing accepted the change yet, so failed targets are retried with the queued ones
+					pending = appendMissing(append([]string{}, result.Pending...), failed...)
 				}
-				
-				// Add successful dispatches to the list
-				existingDispatches := branchData.Dispatches[currentHash]
-				for _, repo := range successful {
-					// Check if repo is already in the list
-					found := false
-					for _, existing := range existingDispatches {
-						if existing == repo {
-							found = true
-							break
-						}
-					}
+				err := c.updateBranch(branch, func(branchData *BranchData) {
+					branchData.Hash = currentHash
+					branchData.LastChanged = time.Now()
+					recordLatest(branchData, *latestVersion)
+					c.recordListing(branchData, files)
 					
-					if !found {
-						existingDispatches = append(existingDispatches, repo)
+					branchData.Pending = pending
+					if result.Winner != "" {
+						branchData.Winner = result.Winner
 					}
-				}
-				
-				branchData.Dispatches[currentHash] = existingDispatches
-				c.BranchData.Branches[branch] = branchData
-				
-				if err := c.SaveHashes(); err != nil {
+					recordResult(branchData, currentHash, result)
+				})
+				if err != nil {
 					return fmt.Errorf("error saving hashes: %w", err)
 				}
 				
@@ -382,10 +1287,52 @@ func (c *DipaChecker) CheckBranch(branch string) error {
 				log.Printf("Failed to dispatch %s to %d repositories: %v", 
 					branch, len(failed), failed)
 			}
+
+			if len(result.Pending) > 0 {
+				log.Printf("Deferred %s dispatch to %d repositories: %v",
+					branch, len(result.Pending), result.Pending)
+			}
+
+			// Unaccepted updates are retried every check, so only notify once a target took one
+			if len(successful) > 0 {
+				title := fmt.Sprintf("New %s version", branch)
+				if rollback {
+					title = fmt.Sprintf("%s rolled back", branch)
+				}
+				message := ""
+				if c.Config.NotifyChangelog && hasDiff {
+					message = diff.Changelog(latestVersion.Name)
+				}
+				c.notify(Notification{
+					Title:      title,
+					Message:    message,
+					Branch:     branch,
+					Version:    latestVersion.Name,
+					URL:        finalURL,
+					Successful: successful,
+					Failed:     failed,
+				})
+			}
+		}
+	} else if len(branchData.Pending) > 0 && !paused && !flapping {
+		if err := c.dispatchPending(branch, branchData, files); err != nil {
+			return err
 		}
 	} else {
 		log.Printf("No changes detected in %s", branch)
 	}
+
+	c.checkStale(branch)
+
+	c.stateMu.Lock()
+	trackedHashes := len(c.BranchData.Branches[branch].Dispatches)
+	c.stateMu.Unlock()
+	c.updateBranchStatus(branch, func(status *BranchStatus) {
+		status.LastSuccess = time.Now()
+		status.LastError = ""
+		status.Hash = currentHash
+		status.TrackedHashes = trackedHashes
+	})
 	
 	return nil
 }
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBadBodyRetryThenAlert(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+max_retries = 2
+`)
+	notifier := &recordingNotifier{}
+	checker.Notifiers = []Notifier{notifier}
+
+	var requests int32
+	var healthy int32
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&healthy) == 1 {
+			w.Write(listingJSON("App_1.0.ipa"))
+			return
+		}
+		w.Write([]byte("<html>Down for maintenance</html>"))
+	}))
+
+	for i := 0; i < 2; i++ {
+		if err := checker.CheckBranch("stable"); err == nil {
+			t.Fatalf("check %d: expected an error for a non-JSON listing", i)
+		}
+	}
+	if got := atomic.LoadInt32(&requests); got != 6 {
+		t.Errorf("expected 3 fetches per check, got %d in total", got)
+	}
+
+	alerts := notifier.titled("IPA host returning non-JSON")
+	if len(alerts) != 1 {
+		t.Fatalf("expected one alert for the outage, got %d", len(alerts))
+	}
+	if !strings.Contains(alerts[0].Message, "after 3 attempts") {
+		t.Errorf("alert should report the attempts made: %q", alerts[0].Message)
+	}
+
+	// Recovering and failing again is a new outage
+	atomic.StoreInt32(&healthy, 1)
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatalf("check after recovery: %v", err)
+	}
+	atomic.StoreInt32(&healthy, 0)
+	checker.CheckBranch("stable")
+
+	if alerts := notifier.titled("IPA host returning non-JSON"); len(alerts) != 2 {
+		t.Errorf("expected a second alert after recovery, got %d", len(alerts))
+	}
+}
+
+func TestBadBodyAttemptsWithinRetryBudget(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+max_retries = 3
+retry_budget = 1
+`)
+	notifier := &recordingNotifier{}
+	checker.Notifiers = []Notifier{notifier}
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>Bad gateway</html>"))
+	}))
+
+	checker.resetRetryBudget()
+	checker.CheckBranch("stable")
+
+	alerts := notifier.titled("IPA host returning non-JSON")
+	if len(alerts) != 1 {
+		t.Fatalf("expected one alert, got %d", len(alerts))
+	}
+	if !strings.Contains(alerts[0].Message, "after 2 attempts") {
+		t.Errorf("alert should report the attempts the budget allowed: %q", alerts[0].Message)
+	}
+}
+
+func TestListingRootKey(t *testing.T) {
+	cases := []struct {
+		name, rootKey, body string
+	}{
+		{"flat", "", `[{"name": "Discord_228.0.ipa", "mod_time": "2024-01-01T00:00:00Z"}]`},
+		{"wrapped", "files", `{"files": [{"name": "Discord_228.0.ipa", "mod_time": "2024-01-01T00:00:00Z"}], "generated_at": "2024-01-01T00:00:00Z"}`},
+		{"nested", "data.files", `{"data": {"files": [{"name": "Discord_228.0.ipa", "mod_time": "2024-01-01T00:00:00Z"}]}}`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			checker := newTestChecker(t, "branches = [\"stable\"]\nlisting_root_key = "+strconvQuote(tc.rootKey))
+			serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tc.body))
+			}))
+
+			files, _, err := checker.FetchIPAList("stable")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 1 || files[0].Name != "Discord_228.0.ipa" {
+				t.Errorf("files = %v, want Discord_228.0.ipa", files)
+			}
+		})
+	}
+}
+
+func TestListingRootKeyMissing(t *testing.T) {
+	for _, body := range []string{`{"items": []}`, `[{"name": "Discord_228.0.ipa"}]`} {
+		if _, err := extractListing([]byte(body), "files"); err == nil {
+			t.Errorf("extractListing(%s) succeeded without a files key", body)
+		}
+	}
+}
+
+func TestListingTimezone(t *testing.T) {
+	// The naive timestamp is 15:00 UTC in New York but 10:00 UTC when read as UTC,
+	// so the newest file depends on the configured timezone
+	listing := `[
+		{"name": "Discord_naive.ipa", "mod_time": "2024-01-01T10:00:00"},
+		{"name": "Discord_offset.ipa", "mod_time": "2024-01-01T12:00:00Z"}
+	]`
+
+	cases := []struct {
+		timezone, latest string
+	}{
+		{"", "Discord_offset.ipa"},
+		{"UTC", "Discord_offset.ipa"},
+		{"America/New_York", "Discord_naive.ipa"},
+		{"Asia/Tokyo", "Discord_offset.ipa"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.timezone, func(t *testing.T) {
+			checker := newTestChecker(t, "branches = [\"stable\"]\nlisting_timezone = "+strconvQuote(tc.timezone))
+			serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(listing))
+			}))
+
+			files, _, err := checker.FetchIPAList("stable")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if latest := checker.GetLatestVersion(files); latest == nil || latest.Name != tc.latest {
+				t.Errorf("latest = %v, want %s", latest, tc.latest)
+			}
+		})
+	}
+}
+
+func TestParseModTime(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	cases := []struct {
+		value string
+		want  time.Time
+	}{
+		{"2024-01-01T09:00:00", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"2024-01-01 09:00:00.5", time.Date(2024, 1, 1, 0, 0, 0, 5e8, time.UTC)},
+		// An explicit offset wins over the listing timezone
+		{"2024-01-01T09:00:00Z", time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)},
+		{"", time.Time{}},
+	}
+
+	for _, tc := range cases {
+		got, err := parseModTime(tc.value, tokyo)
+		if err != nil {
+			t.Errorf("parseModTime(%q): %v", tc.value, err)
+			continue
+		}
+		if !got.Equal(tc.want) {
+			t.Errorf("parseModTime(%q) = %s, want %s", tc.value, got.UTC(), tc.want)
+		}
+	}
+
+	if _, err := parseModTime("yesterday", tokyo); err == nil {
+		t.Error("parseModTime accepted an unrecognized timestamp")
+	}
+}
+
+func TestHashTopN(t *testing.T) {
+	checker := newTestChecker(t, "branches = [\"stable\"]\nhash_top_n = 2\n")
+
+	var listing atomic.Value
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(listing.Load().(string)))
+	}))
+	hash := func(entries ...string) string {
+		t.Helper()
+		listing.Store("[" + strings.Join(entries, ",") + "]")
+		_, hash, err := checker.FetchIPAList("stable")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	entry := func(name, modTime string) string {
+		return `{"name": "` + name + `", "mod_time": "2024-01-01T` + modTime + `Z"}`
+	}
+
+	base := hash(entry("Discord_226.0.ipa", "00:00:00"), entry("Discord_227.0.ipa", "00:01:00"), entry("Discord_228.0.ipa", "00:02:00"))
+
+	// Churn below the newest two doesn't change the hash
+	if got := hash(entry("Discord_226.0.ipa", "00:00:30"), entry("Discord_227.0.ipa", "00:01:00"), entry("Discord_228.0.ipa", "00:02:00")); got != base {
+		t.Error("touching an old file changed the hash")
+	}
+	if got := hash(entry("Discord_227.0.ipa", "00:01:00"), entry("Discord_228.0.ipa", "00:02:00")); got != base {
+		t.Error("removing an old file changed the hash")
+	}
+
+	// The newest two do take part
+	if got := hash(entry("Discord_226.0.ipa", "00:00:00"), entry("Discord_227.0.ipa", "00:01:30"), entry("Discord_228.0.ipa", "00:02:00")); got == base {
+		t.Error("touching the second newest file kept the hash")
+	}
+	if got := hash(entry("Discord_226.0.ipa", "00:00:00"), entry("Discord_227.0.ipa", "00:01:00"), entry("Discord_228.0.ipa", "00:02:00"), entry("Discord_229.0.ipa", "00:03:00")); got == base {
+		t.Error("adding a new file kept the hash")
+	}
+}
+
+// TestDryRunCheckBranch logs the dispatch a new version would send without sending it
+// or saving its hash, so a real run afterwards still dispatches
+func TestDryRunCheckBranch(t *testing.T) {
+	checker := newTestChecker(t, `branches = ["stable"]`)
+	checker.Config.DryRun = true
+	recorder := serveDispatches(t, checker, listingJSON("Discord_228.0.ipa"))
+	logs := captureLog(t)
+
+	before, err := os.ReadFile(checker.HashFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if got := recorder.dispatched(); len(got) != 0 {
+		t.Errorf("dry run dispatched to %v", got)
+	}
+	if !strings.Contains(logs.String(), "Dry run: would dispatch stable to example/app (event_type ") ||
+		!strings.Contains(logs.String(), "Discord_228.0.ipa") {
+		t.Errorf("dry run did not log the intended dispatch:\n%s", logs)
+	}
+	after, err := os.ReadFile(checker.HashFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Error("dry run changed the hash file")
+	}
+
+	checker.Config.DryRun = false
+	restarted := reopen(t, checker)
+	restarted.Client = checker.Client
+	if err := restarted.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if got := recorder.dispatched(); len(got) != 1 || got[0] != "example/app" {
+		t.Errorf("real run after the dry run dispatched to %v, want [example/app]", got)
+	}
+}
+
+func TestTargetBranchesDispatch(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable", "testflight"]
+
+[[targets]]
+github_repo = "example/stable-only"
+github_token = "token"
+branches = ["stable"]
+
+[[targets]]
+github_repo = "example/app"
+github_token = "token"
+`)
+	recorder := serveDispatches(t, checker, listingJSON("Discord_228.0.ipa"))
+
+	if err := checker.CheckBranch("testflight"); err != nil {
+		t.Fatal(err)
+	}
+	if got := recorder.dispatched(); len(got) != 1 || got[0] != "example/app" {
+		t.Errorf("testflight dispatched to %v, want only example/app", got)
+	}
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if got := recorder.dispatched(); len(got) != 3 || got[1] != "example/stable-only" {
+		t.Errorf("dispatched to %v, want stable to reach both targets", got)
+	}
+}
+
+func TestIPAAuth(t *testing.T) {
+	tests := []struct {
+		name, config, want string
+	}{
+		{"none", "", ""},
+		{"basic", "ipa_auth_user = \"dipa\"\nipa_auth_password = \"secret\"\n", "Basic ZGlwYTpzZWNyZXQ="},
+		{"bearer", "ipa_auth_token = \"ipa-token\"\n", "Bearer ipa-token"},
+		{"both", "ipa_auth_user = \"dipa\"\nipa_auth_password = \"secret\"\nipa_auth_token = \"ipa-token\"\n", "Bearer ipa-token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := newTestChecker(t, "branches = [\"stable\"]\n"+tt.config)
+
+			var mu sync.Mutex
+			auth := map[string]string{}
+			serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				auth[r.URL.Path] = r.Header.Get("Authorization")
+				mu.Unlock()
+				if r.URL.Path == "/stable/" {
+					w.Write(listingJSON("Discord_228.0.ipa"))
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+			}))
+
+			if err := checker.CheckBranch("stable"); err != nil {
+				t.Fatal(err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if got := auth["/stable/"]; got != tt.want {
+				t.Errorf("listing request authorization %q, want %q", got, tt.want)
+			}
+			// The IPA credentials never reach GitHub
+			if got := auth["/repos/example/app/dispatches"]; got == "" || (tt.want != "" && got == tt.want) {
+				t.Errorf("dispatch authorization %q, want the GitHub token", got)
+			}
+		})
+	}
+}
+
+func TestIPAAuthValidation(t *testing.T) {
+	if _, err := loadTestConfig(t, "ipa_auth_password = \"secret\"\n"); err == nil || !strings.Contains(err.Error(), "ipa_auth_password requires ipa_auth_user") {
+		t.Errorf("error %v, want a password without a user rejected", err)
+	}
+}
+
+func TestIPAHeaders(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+
+[ipa_headers]
+Accept = "application/vnd.ipa+json"
+X-Api-Key = "key"
+`)
+
+	var mu sync.Mutex
+	headers := map[string]http.Header{}
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		headers[r.URL.Path] = r.Header.Clone()
+		mu.Unlock()
+		if r.URL.Path == "/stable/" {
+			w.Write(listingJSON("Discord_228.0.ipa"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	listing := headers["/stable/"]
+	if listing.Get("Accept") != "application/vnd.ipa+json" || listing.Get("X-Api-Key") != "key" {
+		t.Errorf("listing request headers %v, want the custom Accept and X-Api-Key", listing)
+	}
+	if key := headers["/repos/example/app/dispatches"].Get("X-Api-Key"); key != "" {
+		t.Errorf("dispatch sent the IPA header X-Api-Key %q", key)
+	}
+}
+
+func TestIPAHeadersValidation(t *testing.T) {
+	if _, err := loadTestConfig(t, "[ipa_headers]\n\"X Api Key\" = \"key\"\n"); err == nil || !strings.Contains(err.Error(), "invalid header name") {
+		t.Errorf("error %v, want the invalid header name rejected", err)
+	}
+}
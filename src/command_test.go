@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestHelperDispatchCommand is not a real test: it is the command run by the command
+// provider tests, recording its arguments and stdin to DIPA_HELPER_OUT
+func TestHelperDispatchCommand(t *testing.T) {
+	out := os.Getenv("DIPA_HELPER_OUT")
+	if out == "" {
+		return
+	}
+
+	args := os.Args
+	for i, arg := range args {
+		if arg == "--" {
+			args = args[i+1:]
+			break
+		}
+	}
+	stdin, _ := io.ReadAll(os.Stdin)
+	data, _ := json.Marshal(map[string]interface{}{"args": args, "stdin": string(stdin)})
+	os.WriteFile(out, data, 0644)
+
+	if os.Getenv("DIPA_HELPER_FAIL") != "" {
+		os.Stderr.WriteString("build host unreachable")
+		os.Exit(3)
+	}
+	os.Exit(0)
+}
+
+// helperCommandConfig configures a command target running TestHelperDispatchCommand
+func helperCommandConfig(t *testing.T) (string, string) {
+	out := filepath.Join(t.TempDir(), "invocation.json")
+	t.Setenv("DIPA_HELPER_OUT", out)
+
+	command, _ := json.Marshal([]string{os.Args[0], "-test.run=TestHelperDispatchCommand", "--", "build-host", "trigger; rm -rf /"})
+	return `
+branches = ["stable"]
+dispatch_retries = 0
+
+[[targets]]
+provider = "command"
+command = ` + string(command) + `
+`, out
+}
+
+func TestDispatchCommand(t *testing.T) {
+	config, out := helperCommandConfig(t)
+	checker := newTestChecker(t, config)
+	serveDispatches(t, checker, listingJSON("Discord_228.0.ipa"))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("command was not run: %v", err)
+	}
+	var invocation struct {
+		Args  []string `json:"args"`
+		Stdin string   `json:"stdin"`
+	}
+	if err := json.Unmarshal(data, &invocation); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a shell the metacharacters reach the command as a literal argument
+	if len(invocation.Args) != 3 || invocation.Args[0] != "build-host" || invocation.Args[1] != "trigger; rm -rf /" ||
+		!strings.HasSuffix(invocation.Args[2], "/stable/Discord_228.0.ipa") {
+		t.Errorf("command arguments %q, want the configured ones followed by the IPA URL", invocation.Args)
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal([]byte(invocation.Stdin), &payload); err != nil {
+		t.Fatalf("stdin is not the JSON payload: %v", err)
+	}
+	if payload["branch"] != "stable" || payload["ipa_url"] != invocation.Args[2] {
+		t.Errorf("stdin payload %v", payload)
+	}
+
+	if status := checker.BranchStatusSnapshot()["stable"]; len(status.Pending) != 0 {
+		t.Errorf("pending %v after a zero exit code", status.Pending)
+	}
+}
+
+func TestDispatchCommandFailure(t *testing.T) {
+	config, _ := helperCommandConfig(t)
+	t.Setenv("DIPA_HELPER_FAIL", "1")
+	checker := newTestChecker(t, config)
+
+	err := checker.sendDispatch(checker.Config.Targets[0], []byte(`{"ipa_url": "https://ipa.example.com/stable/Discord_228.0.ipa"}`))
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") || !strings.Contains(err.Error(), "build host unreachable") {
+		t.Errorf("error %v, want the exit status and output", err)
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// runCommand runs a subcommand and reports whether one was handled
+func runCommand(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	switch args[0] {
+	case "debug":
+		return true, runDebug(args[1:])
+	case "status":
+		return true, runStatus()
+	case "pause":
+		return true, runSetPaused(true)
+	case "resume":
+		return true, runSetPaused(false)
+	case "force":
+		return true, runForce(args[1:])
+	case "replay":
+		return true, runReplay(args[1:])
+	case "once":
+		return true, runOnce()
+	case "schema":
+		return true, runSchema()
+	case "block":
+		return true, runBlock(args[1:])
+	case "prune":
+		return true, runPrune(args[1:])
+	default:
+		return false, nil
+	}
+}
+
+// Exit codes of the once subcommand. Errors while checking exit with 1.
+const (
+	exitNoChange       = 0
+	exitDispatched     = 10
+	exitDispatchFailed = 11
+)
+
+// ExitCodeError makes main exit with a specific code
+type ExitCodeError struct {
+	Code    int
+	Message string
+}
+
+func (e *ExitCodeError) Error() string {
+	return e.Message
+}
+
+// loadChecker loads the config and hash file for a subcommand
+func loadChecker() (*DipaChecker, error) {
+	cfg, err := LoadConfig("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	return NewChecker(cfg)
+}
+
+// runDebug prints the stored hash data, optionally limited to one branch
+func runDebug(args []string) error {
+	fs := flag.NewFlagSet("debug", flag.ExitOnError)
+	branch := fs.String("branch", "", "only show data for this branch")
+	fs.Parse(args)
+
+	checker, err := loadChecker()
+	if err != nil {
+		return err
+	}
+
+	data, err := filterBranch(checker.BranchData, *branch)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
+
+// filterBranch limits hash data to one branch, keeping every branch when branch is empty
+func filterBranch(data BranchHashes, branch string) (BranchHashes, error) {
+	if branch == "" {
+		return data, nil
+	}
+
+	branchData, ok := data.Branches[branch]
+	if !ok {
+		return data, fmt.Errorf("branch %q not found in hash file", branch)
+	}
+	data.Branches = map[string]BranchData{branch: branchData}
+	return data, nil
+}
+
+// runStatus prints per branch which targets were dispatched for the stored hash and which are outstanding
+func runStatus() error {
+	checker, err := loadChecker()
+	if err != nil {
+		return err
+	}
+
+	printStatus(os.Stdout, checker)
+	return nil
+}
+
+// printStatus prints the dispatched and outstanding targets of each branch
+func printStatus(w io.Writer, checker *DipaChecker) {
+	if checker.BranchData.Paused {
+		fmt.Fprintln(w, "dipa-auto is paused")
+	}
+	for _, branch := range checker.Config.Branches {
+		branchData := checker.BranchData.Branches[branch]
+
+		targets := []string{}
+		for _, target := range checker.Config.Targets {
+			if !target.Disabled && target.receivesBranch(branch) {
+				targets = append(targets, target.Name())
+			}
+		}
+
+		// Variants are tracked separately, each under the key of its current file
+		keys := []string{branchData.Hash}
+		if checker.Config.VariantRegex != "" {
+			keys = []string{}
+			for variant, file := range branchData.Variants {
+				keys = append(keys, variantKey(variant, file))
+			}
+			sort.Strings(keys)
+		}
+
+		fmt.Fprintf(w, "%s: hash %s, %d targets\n", branch, shortHash(branchData.Hash), len(targets))
+		if branchData.Latest != "" {
+			fmt.Fprintf(w, "  latest:      %s\n", branchData.Latest)
+		}
+		for _, key := range keys {
+			done, outstanding := []string{}, []string{}
+			for _, target := range targets {
+				if containsString(branchData.Dispatches[key], target) {
+					done = append(done, target)
+				} else {
+					outstanding = append(outstanding, target)
+				}
+			}
+
+			if variant, file, ok := strings.Cut(key, variantSeparator); ok {
+				fmt.Fprintf(w, "  variant %s: %s\n", variant, file)
+			}
+			fmt.Fprintf(w, "  dispatched:  %d %v\n", len(done), done)
+			fmt.Fprintf(w, "  outstanding: %d %v\n", len(outstanding), outstanding)
+		}
+		if len(branchData.Pending) > 0 {
+			fmt.Fprintf(w, "  pending:     %d %v\n", len(branchData.Pending), branchData.Pending)
+		}
+	}
+
+	// Target health lives in the running instance, so check the targets now
+	if checker.Config.TargetHealthInterval != "" {
+		checker.CheckTargets()
+		printTargetHealth(w, checker.TargetHealthSnapshot())
+	}
+}
+
+// printTargetHealth prints the health of each target, sorted by repo
+func printTargetHealth(w io.Writer, health map[string]TargetHealth) {
+	repos := make([]string, 0, len(health))
+	for repo := range health {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+
+	fmt.Fprintln(w, "targets:")
+	for _, repo := range repos {
+		state := "healthy"
+		if !health[repo].Healthy {
+			state = "UNHEALTHY"
+		}
+		fmt.Fprintf(w, "  %s: %s (%s)\n", repo, state, health[repo].Status)
+	}
+}
+
+// runSetPaused pauses or resumes dispatching
+func runSetPaused(paused bool) error {
+	checker, err := loadChecker()
+	if err != nil {
+		return err
+	}
+
+	if err := checker.SetPaused(paused); err != nil {
+		return fmt.Errorf("failed to save paused state: %w", err)
+	}
+
+	if paused {
+		fmt.Println("dipa-auto paused, no workflows will be dispatched until resumed")
+	} else {
+		fmt.Println("dipa-auto resumed")
+	}
+	return nil
+}
+
+// runReplay re-sends the last payload dispatched to a target, byte for byte
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	repo := fs.String("repo", "", "target repo to replay the last dispatch to (owner/repo)")
+	fs.Parse(args)
+
+	if *repo == "" {
+		return fmt.Errorf("-repo is required")
+	}
+
+	checker, err := loadChecker()
+	if err != nil {
+		return err
+	}
+
+	return checker.Replay(*repo)
+}
+
+// runForce dispatches the current version of a branch again, even to targets that already received it
+func runForce(args []string) error {
+	fs := flag.NewFlagSet("force", flag.ExitOnError)
+	branch := fs.String("branch", "", "branch to dispatch the current version of")
+	fs.Parse(args)
+
+	if *branch == "" {
+		return fmt.Errorf("-branch is required")
+	}
+
+	checker, err := loadChecker()
+	if err != nil {
+		return err
+	}
+	if !containsString(checker.Config.Branches, *branch) {
+		return fmt.Errorf("unknown branch %q", *branch)
+	}
+
+	result, err := checker.ForceDispatch(*branch)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("dispatched to %d targets: %v\n", len(result.Successful), result.Successful)
+	if len(result.Pending) > 0 {
+		fmt.Printf("deferred %d targets: %v\n", len(result.Pending), result.Pending)
+	}
+	if len(result.Failed) > 0 {
+		return fmt.Errorf("failed to dispatch to %d targets: %v", len(result.Failed), result.Failed)
+	}
+	return nil
+}
+
+// runBlock adds a version or listing hash to the blocklist, or removes it with -remove
+func runBlock(args []string) error {
+	fs := flag.NewFlagSet("block", flag.ExitOnError)
+	version := fs.String("version", "", "IPA file name to block, e.g. Discord-124.0.ipa")
+	hash := fs.String("hash", "", "listing hash to block")
+	remove := fs.Bool("remove", false, "unblock instead")
+	fs.Parse(args)
+
+	if *version == "" && *hash == "" {
+		return fmt.Errorf("-version or -hash is required")
+	}
+
+	checker, err := loadChecker()
+	if err != nil {
+		return err
+	}
+
+	if err := checker.SetBlocked(*version, *hash, !*remove); err != nil {
+		return fmt.Errorf("failed to save blocklist: %w", err)
+	}
+
+	if *remove {
+		fmt.Println("unblocked, matching builds will be dispatched again")
+	} else {
+		fmt.Println("blocked, matching builds will not be dispatched")
+	}
+	return nil
+}
+
+// runPrune removes superseded hash file entries, or with --dry-run only lists them
+func runPrune(args []string) error {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	preview := fs.Bool("dry-run", false, "only report what would be removed")
+	fs.Parse(args)
+
+	checker, err := loadChecker()
+	if err != nil {
+		return err
+	}
+
+	dryRun := *preview || checker.Config.DryRun
+	set, before, after, err := checker.Prune(dryRun)
+	if err != nil {
+		return fmt.Errorf("failed to prune hash file: %w", err)
+	}
+
+	if set.Empty() {
+		fmt.Println("nothing to prune")
+		return nil
+	}
+
+	verb, shrink := "removed", "shrunk"
+	if dryRun {
+		verb, shrink = "would remove", "would shrink"
+	}
+	for _, branch := range checker.branchNames() {
+		if hashes := set.Hashes[branch]; len(hashes) > 0 {
+			fmt.Printf("%s: %s %d superseded hashes: %v\n", branch, verb, len(hashes), hashes)
+		}
+	}
+	if len(set.Repos) > 0 {
+		fmt.Printf("%s stored payloads of %d removed targets: %v\n", verb, len(set.Repos), set.Repos)
+	}
+	fmt.Printf("hash file %s from %d to %d bytes\n", shrink, before, after)
+	return nil
+}
+
+// runOnce checks every branch a single time and reports the outcome through the exit code
+func runOnce() error {
+	checker, err := loadChecker()
+	if err != nil {
+		return err
+	}
+
+	if checker.Config.LockHashFile {
+		if err := checker.AcquireLock(); err != nil {
+			return fmt.Errorf("failed to lock hash file: %w", err)
+		}
+		defer checker.ReleaseLock()
+	}
+
+	return checkOnce(checker)
+}
+
+// checkOnce checks every branch and returns the ExitCodeError for the outcome,
+// or an error when a check failed
+func checkOnce(checker *DipaChecker) error {
+	changed, failed := false, false
+	checker.OnEvent = func(e Event) {
+		// Changes no target took, e.g. while every target is pending, dispatched nothing
+		if e.Type != EventDispatchResult {
+			return
+		}
+		if len(e.Result.Successful) > 0 {
+			changed = true
+		}
+		if len(e.Result.Failed) > 0 {
+			failed = true
+		}
+	}
+
+	ok := checker.CheckAll(checker.Config.Branches)
+	if err := checker.Flush(); err != nil {
+		return fmt.Errorf("failed to save hashes: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("check failed, see the log for details")
+	}
+
+	switch {
+	case failed:
+		return &ExitCodeError{Code: exitDispatchFailed, Message: "some dispatches failed"}
+	case changed:
+		return &ExitCodeError{Code: exitDispatched, Message: "changes dispatched"}
+	default:
+		return &ExitCodeError{Code: exitNoChange}
+	}
+}
+
+// runSchema prints the JSON Schema of the config file
+func runSchema() error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(ConfigSchema())
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFilterBranch(t *testing.T) {
+	data := BranchHashes{
+		Branches: map[string]BranchData{
+			"stable":     {Hash: "s1"},
+			"testflight": {Hash: "t1"},
+		},
+		Paused: true,
+	}
+
+	all, err := filterBranch(data, "")
+	if err != nil || len(all.Branches) != 2 {
+		t.Errorf("without a branch got %d branches (%v), want both", len(all.Branches), err)
+	}
+
+	stable, err := filterBranch(data, "stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stable.Branches) != 1 || stable.Branches["stable"].Hash != "s1" {
+		t.Errorf("filtering stable shows %+v", stable.Branches)
+	}
+	if !stable.Paused {
+		t.Error("filtering dropped the shared state")
+	}
+	if len(data.Branches) != 2 {
+		t.Error("filtering modified the hash data")
+	}
+
+	if _, err := filterBranch(data, "beta"); err == nil {
+		t.Error("expected an error for an unknown branch")
+	}
+}
+
+func TestCheckOnceExitCodes(t *testing.T) {
+	tests := []struct {
+		name      string
+		listing   []byte
+		rejecting bool
+		cooldown  bool
+		wantCode  int
+	}{
+		{"no change", listingJSON("Discord_228.0.ipa"), false, false, exitNoChange},
+		{"dispatched", listingJSON("Discord_228.0.ipa", "Discord_229.0.ipa"), false, false, exitDispatched},
+		{"dispatch failed", listingJSON("Discord_228.0.ipa", "Discord_229.0.ipa"), true, false, exitDispatchFailed},
+		{"every target pending", listingJSON("Discord_228.0.ipa", "Discord_229.0.ipa"), false, true, exitNoChange},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := `
+branches = ["stable"]
+dispatch_retries = 0
+
+[[targets]]
+github_repo = "example/ok"
+github_token = "token"
+
+[[targets]]
+github_repo = "example/picky"
+github_token = "token"
+`
+			if tt.cooldown {
+				config = strings.ReplaceAll(config, "github_token = \"token\"\n", "github_token = \"token\"\nsuccess_cooldown = \"1h\"\n")
+			}
+			checker := newTestChecker(t, config)
+			listing := listingJSON("Discord_228.0.ipa")
+			serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.Path == "/stable/":
+					w.Write(listing)
+				case r.URL.Path == "/repos/example/picky/dispatches" && tt.rejecting:
+					w.WriteHeader(http.StatusUnprocessableEntity)
+				default:
+					w.WriteHeader(http.StatusNoContent)
+				}
+			}))
+			if err := checker.CheckBranch("stable"); err != nil {
+				t.Fatal(err)
+			}
+			listing = tt.listing
+
+			var exitErr *ExitCodeError
+			if err := checkOnce(checker); !errors.As(err, &exitErr) {
+				t.Fatalf("error %v, want an exit code", err)
+			}
+			if exitErr.Code != tt.wantCode {
+				t.Errorf("exit code %d, want %d", exitErr.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestCheckOnceFailedCheck(t *testing.T) {
+	checker := newTestChecker(t, "branches = [\"stable\"]\nmax_retries = 0\n")
+	serveAll(t, checker, http.NotFoundHandler())
+
+	var exitErr *ExitCodeError
+	if err := checkOnce(checker); err == nil || errors.As(err, &exitErr) {
+		t.Errorf("error %v, want a plain error so main exits with 1", err)
+	}
+}
+
+func TestPrintStatus(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable", "testflight"]
+dispatch_retries = 0
+
+[[targets]]
+github_repo = "example/app"
+github_token = "token"
+
+[[targets]]
+github_repo = "example/broken"
+github_token = "token"
+
+[[targets]]
+github_repo = "example/off"
+github_token = "token"
+disabled = true
+`)
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stable/":
+			w.Write(listingJSON("Discord_228.0.ipa"))
+		case "/repos/example/app/dispatches":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	printStatus(&out, checker)
+
+	want := "stable: hash " + shortHash(storedHash(t, checker, "stable")) + ", 2 targets\n" +
+		"  latest:      Discord_228.0.ipa\n" +
+		"  dispatched:  1 [example/app]\n" +
+		"  outstanding: 1 [example/broken]\n" +
+		"testflight: hash (none), 2 targets\n" +
+		"  dispatched:  0 []\n" +
+		"  outstanding: 2 [example/app example/broken]\n"
+	if out.String() != want {
+		t.Errorf("status is\n%s\nwant\n%s", out.String(), want)
+	}
+
+	if err := checker.SetPaused(true); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	printStatus(&out, checker)
+	if !strings.HasPrefix(out.String(), "dipa-auto is paused\n") {
+		t.Errorf("status of a paused instance is\n%s", out.String())
+	}
+}
@@ -2,9 +2,16 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"log"
+	"mime"
 	"os"
+	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/robfig/cron/v3"
@@ -12,15 +19,267 @@ import (
 
 // Config represents the application configuration
 type Config struct {
-	IPABaseURL      string   `toml:"ipa_base_url"`
-	RefreshSchedule string   `toml:"refresh_schedule"`
-	Targets         []Target `toml:"targets"`
+	IPABaseURL string `toml:"ipa_base_url"`
+	// IPA server authentication, either basic auth or a bearer token, which wins when both are set
+	IPAAuthUser     string `toml:"ipa_auth_user"`
+	IPAAuthPassword string `toml:"ipa_auth_password"`
+	IPAAuthToken    string `toml:"ipa_auth_token"`
+	// IPAHeaders are set on listing requests, replacing defaults such as Accept
+	IPAHeaders map[string]string `toml:"ipa_headers"`
+	// MinTLSVersion is the lowest TLS version negotiated for outbound connections, "1.2" (default) or "1.3"
+	MinTLSVersion string `toml:"min_tls_version"`
+	// IPAHostPinnedSHA256 is the expected SHA-256 fingerprint of the IPA host's leaf certificate
+	IPAHostPinnedSHA256 string `toml:"ipa_host_pinned_sha256"`
+	RefreshSchedule     string `toml:"refresh_schedule"`
+	// ListingRootKey is the dot separated key holding the file array in wrapped listings (e.g. "files")
+	ListingRootKey string `toml:"listing_root_key"`
+	// ListingTimezone is used for listing timestamps without a UTC offset (default "UTC")
+	ListingTimezone string `toml:"listing_timezone"`
+	// CronWithSeconds accepts an optional leading seconds field in refresh_schedule
+	CronWithSeconds bool `toml:"cron_with_seconds"`
+	MaxRetries      int  `toml:"max_retries"`
+	// RetryBackoff is the base delay of the exponential listing fetch backoff (default "1s")
+	RetryBackoff string `toml:"retry_backoff"`
+	// Branches are the branches checked on the IPA server, stable and testflight by default
+	Branches []string `toml:"branches"`
+	// HashDir holds the hash file (default "/var/lib/dipa-auto"), overridden by HASH_DIR
+	HashDir string `toml:"hash_dir"`
+	// DryRun logs dispatches instead of sending them and never writes the hash file.
+	// It is enabled with the --dry-run flag or the DRY_RUN environment variable.
+	DryRun bool `toml:"-"`
+	// Strict verifies the config against the environment at load: ${NAME} references, token
+	// paths and writable state directories. Also enabled with --strict or STRICT_CONFIG.
+	Strict bool `toml:"strict"`
+	// InstanceID identifies this instance in payloads, logs and notifications, the hostname by default
+	InstanceID string `toml:"instance_id"`
+	// DispatchRetries is how often a dispatch failing with a 5xx response or a rate limit is retried
+	DispatchRetries int `toml:"dispatch_retries"`
+	// RequestTimeout bounds every outbound HTTP request including its body, 30s by default
+	RequestTimeout string `toml:"request_timeout"`
+	// BodyReadTimeout bounds reading a listing or dispatch response body, failing stalled bodies early
+	BodyReadTimeout string `toml:"body_read_timeout"`
+	// JitterStrategy randomizes retry backoff: "none", "full" or "equal" (default)
+	JitterStrategy string `toml:"jitter_strategy"`
+	// RetryBudget caps the total fetch and dispatch retries of a check cycle, unlimited when unset
+	RetryBudget int `toml:"retry_budget"`
+	// InitialCheckTimeout retries the startup check with backoff until it succeeds, for at most this long
+	InitialCheckTimeout string `toml:"initial_check_timeout"`
+	// ResumeSchedule skips the startup check when refresh_schedule has not come due since the last run
+	ResumeSchedule bool `toml:"resume_schedule"`
+	// RandomizeBranchOrder shuffles the branch check order every cycle
+	RandomizeBranchOrder bool `toml:"randomize_branch_order"`
+	// TargetHealthInterval enables periodic target reachability checks (e.g. "6h")
+	TargetHealthInterval string `toml:"target_health_interval"`
+	// VerifyHashChecksum keeps a checksum and backup of the hash file and verifies it on load
+	VerifyHashChecksum bool `toml:"verify_hash_checksum"`
+	// CompactHashFile stores dispatched repo names once and references them by index
+	CompactHashFile bool `toml:"compact_hash_file"`
+	// StateSnapshots is the number of timestamped full state snapshots kept after saves
+	// for point-in-time recovery (default 5, 0 disables them)
+	StateSnapshots int `toml:"state_snapshots"`
+	// SaveInterval coalesces hash file saves, writing at most once per interval and on shutdown
+	SaveInterval string `toml:"save_interval"`
+	// LockHashFile holds a lock file next to the hash file so only one instance runs against it
+	LockHashFile bool `toml:"lock_hash_file"`
+	// SplitStateFiles stores every branch in its own file next to the hash file (e.g. stable.json)
+	SplitStateFiles bool `toml:"split_state_files"`
+	// VerifyIPA checks that the latest IPA starts with the zip signature before dispatching it
+	VerifyIPA bool `toml:"verify_ipa"`
+	// MinVersion ignores builds whose version is lower, e.g. "228.0". The version is read
+	// with version_regex when set, or from the listing in content_addressed mode
+	MinVersion string `toml:"min_version"`
+	// MinFetchInterval is the shortest time between listing fetches of a branch, checks
+	// arriving sooner reuse the last listing (e.g. "1m")
+	MinFetchInterval string `toml:"min_fetch_interval"`
+	// ListingQuorum fetches the listing from every resolved backend of the IPA host and
+	// only keeps entries present in at least this many of them
+	ListingQuorum int `toml:"listing_quorum"`
+	// ListingBackendSamples limits how many resolved backends are fetched, all by default
+	ListingBackendSamples int `toml:"listing_backend_samples"`
+	// ListingHTTP3 fetches listings over HTTP/3, falling back to HTTP/2 when a request fails.
+	// It needs a build with -tags http3 and does not apply to listing_quorum fetches.
+	ListingHTTP3 bool `toml:"listing_http3"`
+	// BlockedFallback dispatches the newest file that is not blocked when the latest one is
+	BlockedFallback bool `toml:"blocked_fallback"`
+	// IncludePattern and ExcludePattern filter listing files by name before hashing,
+	// e.g. \.ipa$ to ignore checksums and .DS_Store
+	IncludePattern string `toml:"include_pattern"`
+	ExcludePattern string `toml:"exclude_pattern"`
+	// VersionRegex captures a semantic version in file names, e.g. _(\d+\.\d+\.\d+(?:-[\w.]+)?)\.ipa$,
+	// ordering files by it instead of mod_time when both have one
+	VersionRegex string `toml:"version_regex"`
+	// VariantRegex groups files into variants by a captured key, e.g. -(arm64|x86)\.ipa$,
+	// and dispatches the latest file of each variant with the key as client_payload.variant
+	VariantRegex string `toml:"variant_regex"`
+	// HashTopN only hashes the N most recently modified files of a listing when set
+	HashTopN int `toml:"hash_top_n"`
+	// StaleBranchAfter alerts when a branch has not changed for this long (e.g. "336h")
+	StaleBranchAfter string `toml:"stale_branch_after"`
+	// FlapThreshold suspends dispatching when the hash toggles more than this many times within FlapWindow
+	FlapThreshold int    `toml:"flap_threshold"`
+	FlapWindow    string `toml:"flap_window"`
+	// ActionsDisabledCooldown is how long to skip targets with GitHub Actions disabled (default "24h")
+	ActionsDisabledCooldown string `toml:"actions_disabled_cooldown"`
+	// LogListingDiff logs which files changed when a branch hash changes (persists the last listing)
+	LogListingDiff bool `toml:"log_listing_diff"`
+	// NotifyChangelog adds a summary of the listing changes to update notifications (persists the last listing)
+	NotifyChangelog bool `toml:"notify_changelog"`
+	// ContentAddressed detects changes by the per-file content hashes of the listing and
+	// orders builds by their listed version, ignoring modification times entirely
+	ContentAddressed bool `toml:"content_addressed"`
+	// ContentHashField and VersionField name the listing fields read in content_addressed
+	// mode, "sha256" and "version" by default
+	ContentHashField string `toml:"content_hash_field"`
+	VersionField     string `toml:"version_field"`
+	// HashAlgorithm is the digest of the listing hash: "md5", "sha1", "sha256" (default) or "sha512"
+	HashAlgorithm string `toml:"hash_algorithm"`
+	// IncrementalHash hashes every file separately and folds them into a Merkle root,
+	// so changes can be localized. Toggling it changes every branch hash once.
+	IncrementalHash bool `toml:"incremental_hash"`
+	// IncludeFiles adds the full IPA listing to every dispatch payload
+	IncludeFiles bool `toml:"include_files"`
+	// HealthPort serves /healthz on this port when set, HEALTH_PORT overrides it
+	HealthPort int `toml:"health_port"`
+	// MetricsPort serves Prometheus metrics on /metrics on this port when set
+	MetricsPort int `toml:"metrics_port"`
+	// MetricsLabels are static labels attached to every metric, e.g. { environment = "prod" }
+	MetricsLabels map[string]string `toml:"metrics_labels"`
+	// StatusFile receives a JSON summary of the branch status after every check cycle
+	StatusFile string `toml:"status_file"`
+	// HeartbeatURL is pinged after every check cycle, HeartbeatFailureURL after cycles with errors
+	HeartbeatURL        string `toml:"heartbeat_url"`
+	HeartbeatFailureURL string `toml:"heartbeat_failure_url"`
+	// NotifyRate limits notifications per minute, allowing bursts of NotifyBurst
+	NotifyRate  float64 `toml:"notify_rate"`
+	NotifyBurst int     `toml:"notify_burst"`
+	// NotifySummarizeDropped sends a "N more updates" notification for throttled ones
+	NotifySummarizeDropped bool `toml:"notify_summarize_dropped"`
+	// NotifyShutdownSummary sends the unresolved issues logged at shutdown to the notifiers
+	NotifyShutdownSummary bool `toml:"notify_shutdown_summary"`
+	// StatsdAddr enables DogStatsD metrics sent over UDP to host:port
+	StatsdAddr string `toml:"statsd_addr"`
+	// SlackWebhookURL enables Slack notifications when set
+	SlackWebhookURL string `toml:"slack_webhook_url"`
+	// DiscordWebhookURL enables Discord notifications when set
+	DiscordWebhookURL string `toml:"discord_webhook_url"`
+	// KafkaRESTURL publishes dispatch events through a Kafka REST proxy when set
+	KafkaRESTURL string `toml:"kafka_rest_url"`
+	KafkaTopic   string `toml:"kafka_topic"`
+	// GitHubAppTokenCache persists GitHub App installation tokens across runs when set
+	GitHubAppTokenCache string `toml:"github_app_token_cache"`
+	// GitHubAppTokenMargin renews cached installation tokens this long before they expire, 5m by default
+	GitHubAppTokenMargin string `toml:"github_app_token_margin"`
+	// DeadLetterRepo receives an "ipa-update-failed" repository_dispatch when every target fails
+	DeadLetterRepo  string `toml:"dead_letter_repo"`
+	DeadLetterToken string `toml:"dead_letter_token"`
+	// VaultCacheTTL is how long tokens read from Vault are reused, 5m by default
+	VaultCacheTTL string `toml:"vault_cache_ttl"`
+	// SelfTestRepo receives a "self-test" repository_dispatch on startup to verify tokens end to end
+	SelfTestRepo string `toml:"self_test_repo"`
+	// SelfTestToken is used for the self-test instead of every configured target token
+	SelfTestToken         string `toml:"self_test_token"`
+	SelfTestExitOnFailure bool   `toml:"self_test_exit_on_failure"`
+	// StageDelay is the pause between dispatch stages of targets with different stage values
+	StageDelay string `toml:"stage_delay"`
+	// AnyTargetSucceeds stops dispatching a change after the first target that accepts it
+	AnyTargetSucceeds bool `toml:"any_target_succeeds"`
+	// CanaryTimeout is how long to wait for canary workflow runs, 30m by default
+	CanaryTimeout string `toml:"canary_timeout"`
+	// EventType is the repository_dispatch event type, "ipa-update" by default
+	EventType string `toml:"event_type"`
+	// SuccessStatuses are the dispatch response codes treated as success, the provider's default when unset
+	SuccessStatuses []int `toml:"success_statuses"`
+	// PayloadFields are extra fields added to every client_payload, including nested tables
+	PayloadFields map[string]interface{} `toml:"payload_fields"`
+	// ZeroEnabledTargets is the startup policy when every target is disabled: "warn" (default) or "fail"
+	ZeroEnabledTargets string   `toml:"zero_enabled_targets"`
+	Targets            []Target `toml:"targets"`
+	// TargetGroups are expanded into Targets at load time
+	TargetGroups []TargetGroup `toml:"target_groups"`
 }
 
-// Target represents a GitHub repository target
+// Retry defaults used when the options are not set in the config
+const (
+	defaultMaxRetries      = 3
+	defaultDispatchRetries = 2
+)
+
+// defaultHashDir holds the hash file when hash_dir is not set
+const defaultHashDir = "/var/lib/dipa-auto"
+
+// Target represents a dispatch target, a GitHub repository by default
 type Target struct {
+	// Provider selects how the target is triggered: "github" (default), "azuredevops", "command" or "jenkins"
+	Provider    string `toml:"provider"`
 	GitHubRepo  string `toml:"github_repo"`
 	GitHubToken string `toml:"github_token"`
+	// GitHub App authentication, used instead of github_token when github_app_id is set
+	GitHubAppID             int64  `toml:"github_app_id"`
+	GitHubAppInstallationID int64  `toml:"github_app_installation_id"`
+	GitHubAppPrivateKeyPath string `toml:"github_app_private_key_path"`
+	// VaultPath reads the GitHub token from this Vault secret instead, e.g. "secret/data/dipa-auto/repo"
+	VaultPath string `toml:"vault_path"`
+	// VaultKey is the key of the token within the secret, "token" by default
+	VaultKey string `toml:"vault_key"`
+	// Azure DevOps pipeline settings, used by the "azuredevops" provider
+	AzureOrganization string `toml:"azure_organization"`
+	AzureProject      string `toml:"azure_project"`
+	AzurePipelineID   int    `toml:"azure_pipeline_id"`
+	AzureToken        string `toml:"azure_token"`
+	// Jenkins job settings, used by the "jenkins" provider to trigger buildWithParameters
+	JenkinsJobURL string `toml:"jenkins_job_url"`
+	JenkinsUser   string `toml:"jenkins_user"`
+	JenkinsToken  string `toml:"jenkins_token"`
+	// DispatchMode is "repository" (default) for repository_dispatch, or "workflow" to run
+	// WorkflowFile on Ref through workflow_dispatch with ipa_url and is_testflight inputs
+	DispatchMode string `toml:"dispatch_mode"`
+	WorkflowFile string `toml:"workflow_file"`
+	Ref          string `toml:"ref"`
+	// DefaultBranchPolicy looks up the repo's default branch before workflow dispatches when
+	// ref is expected to be it. Once it changed, "update" dispatches to the new default branch
+	// and "alert" keeps ref and alerts.
+	DefaultBranchPolicy string `toml:"default_branch_policy"`
+	// Command is run without a shell by the "command" provider, e.g. ["ssh", "build-host", "trigger-build"]
+	Command []string `toml:"command"`
+	// Branches limits the target to these branches, it receives every branch when empty
+	Branches []string `toml:"branches"`
+	// ExcludeBranches lists branches this target never receives, it cannot be combined with Branches
+	ExcludeBranches []string `toml:"exclude_branches"`
+	// Disabled keeps the target configured without dispatching to it
+	Disabled bool `toml:"disabled"`
+	// IncludeFiles adds the full IPA listing to this target's payload
+	IncludeFiles bool `toml:"include_files"`
+	// AcceptRollbacks also dispatches to this target when the latest IPA goes back to an older file
+	AcceptRollbacks bool `toml:"accept_rollbacks"`
+	// ReadinessURL must answer 200 before this target is dispatched to, otherwise the dispatch is deferred
+	ReadinessURL string `toml:"readiness_url"`
+	// EventType, SuccessStatuses and PayloadFields override the global dispatch settings
+	EventType       string                 `toml:"event_type"`
+	SuccessStatuses []int                  `toml:"success_statuses"`
+	PayloadFields   map[string]interface{} `toml:"payload_fields"`
+	// BranchOverrides override the dispatch settings per branch, taking precedence over the target
+	BranchOverrides map[string]BranchOverride `toml:"branch_overrides"`
+	// Canary targets are dispatched first, the rest only after their workflow runs succeed
+	Canary bool `toml:"canary"`
+	// Stage orders dispatches in waves, lower stages first with stage_delay in between
+	Stage int `toml:"stage"`
+	// ContentType overrides the dispatch request's Content-Type, application/json by default
+	ContentType string `toml:"content_type"`
+	// SuccessCooldown defers dispatches to this target for a while after a successful one, e.g. "1h"
+	SuccessCooldown string `toml:"success_cooldown"`
+	// RepoTemplate expands into one GitHub repo per branch, e.g. "myorg/app-{{.Branch}}"
+	RepoTemplate string `toml:"repo_template"`
+	// VersionConstraint limits dispatches to matching versions, e.g. "<2.0.0" or "~1.4",
+	// read like min_version
+	VersionConstraint string `toml:"version_constraint"`
+}
+
+// TargetGroup defines shared target settings for a list of repos.
+// Fields set on an override replace the group's value for that repo.
+type TargetGroup struct {
+	Target
+	Repos     []string `toml:"repos"`
+	Overrides []Target `toml:"overrides"`
 }
 
 // LoadConfig loads the configuration from the specified path
@@ -34,53 +293,685 @@ func LoadConfig(path string) (*Config, error) {
 	}
 
 	var config Config
-	if _, err := toml.DecodeFile(path, &config); err != nil {
+	meta, err := toml.DecodeFile(path, &config)
+	if err != nil {
 		return nil, err
 	}
+	unsetEnv := expandEnv(&config)
 
-	if err := validateConfig(&config); err != nil {
+	if !meta.IsDefined("max_retries") {
+		config.MaxRetries = defaultMaxRetries
+	}
+	if dryRun, err := strconv.ParseBool(os.Getenv("DRY_RUN")); err == nil {
+		config.DryRun = dryRun
+	}
+	if strict, err := strconv.ParseBool(os.Getenv("STRICT_CONFIG")); err == nil && strict {
+		config.Strict = true
+	}
+	if envDir := os.Getenv("HASH_DIR"); envDir != "" {
+		config.HashDir = envDir
+	}
+	if config.HashDir == "" {
+		config.HashDir = defaultHashDir
+	}
+	if envPort := os.Getenv("HEALTH_PORT"); envPort != "" {
+		port, err := strconv.Atoi(envPort)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HEALTH_PORT: %w", err)
+		}
+		config.HealthPort = port
+	}
+	if !meta.IsDefined("branches") {
+		config.Branches = append([]string{}, defaultBranches...)
+	}
+	if !meta.IsDefined("state_snapshots") {
+		config.StateSnapshots = defaultStateSnapshots
+	}
+	if !meta.IsDefined("dispatch_retries") {
+		config.DispatchRetries = defaultDispatchRetries
+	}
+	if config.InstanceID == "" {
+		if hostname, err := os.Hostname(); err == nil {
+			config.InstanceID = hostname
+		}
+	}
+
+	if err := expandTargetGroups(&config); err != nil {
+		return nil, err
+	}
+	// Expanded repo templates get their own branch filters, so check the configured ones first
+	if err := validateBranchFilters(&config); err != nil {
 		return nil, err
 	}
+	if err := expandRepoTemplates(&config); err != nil {
+		return nil, err
+	}
+
+	for i := range config.Targets {
+		if config.Targets[i].Provider == "" {
+			config.Targets[i].Provider = providerGitHub
+		}
+	}
+
+	if config.Strict {
+		var problems []string
+		for _, name := range unsetEnv {
+			problems = append(problems, fmt.Sprintf("${%s} is referenced but not set", name))
+		}
+		problems = append(problems, configProblems(&config)...)
+		problems = append(problems, environmentProblems(&config)...)
+		if len(problems) > 0 {
+			return nil, &StrictError{Problems: problems}
+		}
+	} else {
+		if err := validateConfig(&config); err != nil {
+			return nil, err
+		}
+		for _, name := range unsetEnv {
+			log.Printf("Warning: ${%s} is referenced in the config but not set", name)
+		}
+	}
+
+	if enabledTargets(&config) == 0 {
+		log.Println("Warning: all targets are disabled, no workflows will be dispatched")
+	}
 
 	return &config, nil
 }
 
-// validateConfig validates the configuration
+// expandTargetGroups appends a Target for every repo in every target group
+func expandTargetGroups(config *Config) error {
+	for i, group := range config.TargetGroups {
+		if len(group.Repos) == 0 {
+			return fmt.Errorf("target group %d has no repos", i+1)
+		}
+
+		overrides := make(map[string]Target, len(group.Overrides))
+		for _, override := range group.Overrides {
+			if !containsString(group.Repos, override.GitHubRepo) {
+				return fmt.Errorf("target group %d override for %q does not match any of its repos", i+1, override.GitHubRepo)
+			}
+			overrides[override.GitHubRepo] = override
+		}
+
+		for _, repo := range group.Repos {
+			target := group.Target
+			target.GitHubRepo = repo
+			if override, ok := overrides[repo]; ok {
+				target = mergeTarget(target, override)
+			}
+			config.Targets = append(config.Targets, target)
+		}
+	}
+
+	return nil
+}
+
+// validateBranchFilters checks the branches and exclude_branches of every target
+func validateBranchFilters(config *Config) error {
+	for _, target := range config.Targets {
+		name := target.Name()
+		if target.RepoTemplate != "" {
+			name = target.RepoTemplate
+		}
+		if len(target.Branches) > 0 && len(target.ExcludeBranches) > 0 {
+			return fmt.Errorf("%s cannot set both branches and exclude_branches", name)
+		}
+		for _, branch := range target.Branches {
+			if !containsString(config.Branches, branch) {
+				return fmt.Errorf("branches for %s contains unknown branch %q", name, branch)
+			}
+		}
+		for _, branch := range target.ExcludeBranches {
+			if !containsString(config.Branches, branch) {
+				return fmt.Errorf("exclude_branches for %s contains unknown branch %q", name, branch)
+			}
+		}
+	}
+	return nil
+}
+
+// expandRepoTemplates replaces every target with a repo_template by one target per
+// branch, each only dispatched for its own branch
+func expandRepoTemplates(config *Config) error {
+	targets := make([]Target, 0, len(config.Targets))
+	for _, target := range config.Targets {
+		if target.RepoTemplate == "" {
+			targets = append(targets, target)
+			continue
+		}
+		if target.GitHubRepo != "" {
+			return fmt.Errorf("repo_template %q cannot be combined with github_repo", target.RepoTemplate)
+		}
+
+		tmpl, err := template.New("repo").Option("missingkey=error").Parse(target.RepoTemplate)
+		if err != nil {
+			return fmt.Errorf("invalid repo_template %q: %w", target.RepoTemplate, err)
+		}
+
+		for _, branch := range config.Branches {
+			if !target.receivesBranch(branch) {
+				continue
+			}
+
+			var repo strings.Builder
+			if err := tmpl.Execute(&repo, struct{ Branch string }{branch}); err != nil {
+				return fmt.Errorf("failed to expand repo_template %q: %w", target.RepoTemplate, err)
+			}
+			if !repoRegex.MatchString(repo.String()) {
+				return fmt.Errorf("repo_template %q expands to %q for %s, which is not in the format 'owner/repo'",
+					target.RepoTemplate, repo.String(), branch)
+			}
+
+			expanded := target
+			expanded.RepoTemplate = ""
+			expanded.GitHubRepo = repo.String()
+			expanded.Branches = nil
+			expanded.ExcludeBranches = []string{}
+			for _, other := range config.Branches {
+				if other != branch {
+					expanded.ExcludeBranches = append(expanded.ExcludeBranches, other)
+				}
+			}
+			targets = append(targets, expanded)
+		}
+	}
+
+	config.Targets = targets
+	return nil
+}
+
+// mergeTarget returns base with every non-zero field of override applied on top
+func mergeTarget(base, override Target) Target {
+	merged := base
+	mergedValue := reflect.ValueOf(&merged).Elem()
+	overrideValue := reflect.ValueOf(override)
+
+	for i := 0; i < overrideValue.NumField(); i++ {
+		if !overrideValue.Field(i).IsZero() {
+			mergedValue.Field(i).Set(overrideValue.Field(i))
+		}
+	}
+
+	return merged
+}
+
+// enabledTargets counts the targets that are not disabled
+func enabledTargets(config *Config) int {
+	count := 0
+	for _, target := range config.Targets {
+		if !target.Disabled {
+			count++
+		}
+	}
+	return count
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// cronParser returns the cron parser used for both validation and scheduling
+func cronParser(config *Config) cron.Parser {
+	fields := cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor
+	if config.CronWithSeconds {
+		fields |= cron.SecondOptional
+	}
+	return cron.NewParser(fields)
+}
+
+// repoRegex matches GitHub repositories in the format owner/repo
+var repoRegex = regexp.MustCompile(`^[a-zA-Z0-9-]+/[a-zA-Z0-9-]+$`)
+
+// headerNameRegex matches valid HTTP header names
+var headerNameRegex = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// fingerprintRegex matches a normalized hex encoded SHA-256 fingerprint
+var fingerprintRegex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// validateConfig validates the configuration, reporting the first problem found
 func validateConfig(config *Config) error {
+	if problems := configProblems(config); len(problems) > 0 {
+		return errors.New(problems[0])
+	}
+	return nil
+}
+
+// configProblems lists every problem with the configuration. Checks only rely on
+// earlier ones passing within a setting, so each problem is reported once.
+func configProblems(config *Config) []string {
+	var problems []string
+
 	// Validate IPA Base URL
 	if config.IPABaseURL == "" {
-		return errors.New("ipa_base_url is required")
+		problems = append(problems, "ipa_base_url is required")
+	} else if !strings.HasPrefix(config.IPABaseURL, "http://") && !strings.HasPrefix(config.IPABaseURL, "https://") {
+		problems = append(problems, "ipa_base_url must be a valid URL")
 	}
-	if !strings.HasPrefix(config.IPABaseURL, "http://") && !strings.HasPrefix(config.IPABaseURL, "https://") {
-		return errors.New("ipa_base_url must be a valid URL")
+
+	// Validate branches
+	if len(config.Branches) == 0 {
+		problems = append(problems, "branches must not be empty")
+	}
+	for i, branch := range config.Branches {
+		if branch == "" {
+			problems = append(problems, "branches must not contain an empty name")
+			continue
+		}
+		// Branch names end up in state file names with split_state_files
+		if strings.ContainsAny(branch, `/\`) || strings.Contains(branch, "..") || branch == "." {
+			problems = append(problems, fmt.Sprintf("branch name %q must not be a path", branch))
+		}
+		if branch == "branch_hashes" {
+			problems = append(problems, "branch name \"branch_hashes\" clashes with the hash file")
+		}
+		if containsString(config.Branches[:i], branch) {
+			problems = append(problems, fmt.Sprintf("branches contains %q more than once", branch))
+		}
+	}
+
+	// Validate IPA server authentication
+	if config.IPAAuthPassword != "" && config.IPAAuthUser == "" {
+		problems = append(problems, "ipa_auth_password requires ipa_auth_user")
+	}
+
+	for name := range config.IPAHeaders {
+		if !headerNameRegex.MatchString(name) {
+			problems = append(problems, fmt.Sprintf("ipa_headers contains invalid header name %q", name))
+		}
+	}
+
+	// Validate TLS settings
+	if config.MinTLSVersion != "" {
+		if _, ok := tlsVersions[config.MinTLSVersion]; !ok {
+			problems = append(problems, "min_tls_version must be '1.2' or '1.3'")
+		}
+	}
+	if config.IPAHostPinnedSHA256 != "" {
+		if !strings.HasPrefix(config.IPABaseURL, "https://") {
+			problems = append(problems, "ipa_host_pinned_sha256 requires an https ipa_base_url")
+		}
+		if !fingerprintRegex.MatchString(normalizeFingerprint(config.IPAHostPinnedSHA256)) {
+			problems = append(problems, "ipa_host_pinned_sha256 must be a hex encoded SHA-256 fingerprint")
+		}
+	}
+
+	// Validate listing timezone
+	if config.ListingTimezone != "" {
+		if _, err := time.LoadLocation(config.ListingTimezone); err != nil {
+			problems = append(problems, "invalid listing_timezone: "+err.Error())
+		}
 	}
 
 	// Validate cron schedule
 	if config.RefreshSchedule == "" {
-		return errors.New("refresh_schedule is required")
+		problems = append(problems, "refresh_schedule is required")
+	} else if _, err := cronParser(config).Parse(config.RefreshSchedule); err != nil {
+		problems = append(problems, "invalid cron expression: "+err.Error())
 	}
-	_, err := cron.ParseStandard(config.RefreshSchedule)
-	if err != nil {
-		return errors.New("invalid cron expression: " + err.Error())
+
+	// Validate retries
+	if config.MaxRetries < 0 {
+		problems = append(problems, "max_retries must not be negative")
+	}
+	if config.RetryBackoff != "" {
+		if _, err := time.ParseDuration(config.RetryBackoff); err != nil {
+			problems = append(problems, "invalid retry_backoff: "+err.Error())
+		}
+	}
+	if config.DispatchRetries < 0 {
+		problems = append(problems, "dispatch_retries must not be negative")
+	}
+	if config.RetryBudget < 0 {
+		problems = append(problems, "retry_budget must not be negative")
+	}
+	switch config.JitterStrategy {
+	case "", jitterNone, jitterFull, jitterEqual:
+	default:
+		problems = append(problems, "jitter_strategy must be 'none', 'full' or 'equal'")
+	}
+
+	// Validate HTTP timeouts
+	if config.RequestTimeout != "" {
+		if _, err := time.ParseDuration(config.RequestTimeout); err != nil {
+			problems = append(problems, "invalid request_timeout: "+err.Error())
+		}
+	}
+	if config.BodyReadTimeout != "" {
+		if _, err := time.ParseDuration(config.BodyReadTimeout); err != nil {
+			problems = append(problems, "invalid body_read_timeout: "+err.Error())
+		}
+	}
+
+	// Validate initial check timeout
+	if config.InitialCheckTimeout != "" {
+		if _, err := time.ParseDuration(config.InitialCheckTimeout); err != nil {
+			problems = append(problems, "invalid initial_check_timeout: "+err.Error())
+		}
+	}
+
+	// Validate target health interval
+	if config.TargetHealthInterval != "" {
+		interval, err := time.ParseDuration(config.TargetHealthInterval)
+		if err != nil {
+			problems = append(problems, "invalid target_health_interval: "+err.Error())
+		}
+		if interval < time.Minute {
+			problems = append(problems, "target_health_interval must be at least 1m")
+		}
+	}
+
+	// Validate fetch floor
+	if config.MinFetchInterval != "" {
+		if _, err := time.ParseDuration(config.MinFetchInterval); err != nil {
+			problems = append(problems, "invalid min_fetch_interval: "+err.Error())
+		}
+	}
+
+	// Validate listing quorum
+	if config.ListingQuorum < 0 {
+		problems = append(problems, "listing_quorum must not be negative")
+	}
+	if config.ListingBackendSamples < 0 {
+		problems = append(problems, "listing_backend_samples must not be negative")
+	}
+	if config.ListingBackendSamples > 0 && config.ListingQuorum > config.ListingBackendSamples {
+		problems = append(problems, "listing_quorum must not exceed listing_backend_samples")
+	}
+
+	// Validate hash algorithm
+	if config.HashAlgorithm != "" {
+		if _, ok := hashAlgorithms[config.HashAlgorithm]; !ok {
+			problems = append(problems, "hash_algorithm must be 'md5', 'sha1', 'sha256' or 'sha512'")
+		}
+		if config.IncrementalHash && config.HashAlgorithm != defaultHashAlgorithm {
+			problems = append(problems, "hash_algorithm cannot be combined with incremental_hash")
+		}
+	}
+
+	// Validate hashed file count
+	if config.HashTopN < 0 {
+		problems = append(problems, "hash_top_n must not be negative")
+	}
+	if config.ContentAddressed && config.HashTopN > 0 {
+		problems = append(problems, "hash_top_n relies on modification times and cannot be combined with content_addressed")
+	}
+
+	// Validate file filters
+	if _, _, err := compileFilePatterns(config.IncludePattern, config.ExcludePattern); err != nil {
+		problems = append(problems, "invalid include_pattern or exclude_pattern: "+err.Error())
+	}
+
+	// Validate version ordering
+	if config.VersionRegex != "" {
+		if config.ContentAddressed {
+			problems = append(problems, "version_regex cannot be combined with content_addressed, which orders by version_field")
+		}
+		if _, err := compileVersionRegex(config.VersionRegex); err != nil {
+			problems = append(problems, "invalid version_regex: "+err.Error())
+		}
+	}
+
+	// Validate variants
+	if config.VariantRegex != "" {
+		if _, err := compileVariantRegex(config.VariantRegex); err != nil {
+			problems = append(problems, "invalid variant_regex: "+err.Error())
+		}
+	}
+
+	// Validate version floor
+	if config.MinVersion != "" {
+		if _, ok := parseVersionSetting(config.MinVersion); !ok {
+			problems = append(problems, "invalid min_version: "+config.MinVersion)
+		}
+	}
+
+	if config.StateSnapshots < 0 {
+		problems = append(problems, "state_snapshots cannot be negative")
+	}
+
+	// Validate save interval
+	if config.SaveInterval != "" {
+		if _, err := time.ParseDuration(config.SaveInterval); err != nil {
+			problems = append(problems, "invalid save_interval: "+err.Error())
+		}
+	}
+
+	// Validate staleness window
+	if config.StaleBranchAfter != "" {
+		if _, err := time.ParseDuration(config.StaleBranchAfter); err != nil {
+			problems = append(problems, "invalid stale_branch_after: "+err.Error())
+		}
+	}
+
+	// Validate flap detection
+	if config.FlapThreshold < 0 {
+		problems = append(problems, "flap_threshold must not be negative")
+	}
+	if config.FlapThreshold > 0 {
+		if config.FlapWindow == "" {
+			problems = append(problems, "flap_window is required when flap_threshold is set")
+		} else if _, err := time.ParseDuration(config.FlapWindow); err != nil {
+			problems = append(problems, "invalid flap_window: "+err.Error())
+		}
+	}
+
+	// Validate Actions-disabled cooldown
+	if config.ActionsDisabledCooldown != "" {
+		if _, err := time.ParseDuration(config.ActionsDisabledCooldown); err != nil {
+			problems = append(problems, "invalid actions_disabled_cooldown: "+err.Error())
+		}
+	}
+
+	// Validate heartbeat URLs
+	for _, url := range []string{config.HeartbeatURL, config.HeartbeatFailureURL} {
+		if url != "" && !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			problems = append(problems, "heartbeat URLs must be valid URLs")
+			break
+		}
+	}
+
+	// Validate notifiers
+	if config.NotifyRate < 0 {
+		problems = append(problems, "notify_rate must not be negative")
+	}
+	if config.NotifyRate > 0 && config.NotifyBurst < 1 {
+		problems = append(problems, "notify_burst must be at least 1 when notify_rate is set")
+	}
+	if config.SlackWebhookURL != "" && !strings.HasPrefix(config.SlackWebhookURL, "https://") {
+		problems = append(problems, "slack_webhook_url must be an https URL")
+	}
+	if config.HealthPort < 0 || config.HealthPort > 65535 {
+		problems = append(problems, "health_port must be between 1 and 65535")
+	}
+	if config.MetricsPort < 0 || config.MetricsPort > 65535 {
+		problems = append(problems, "metrics_port must be between 1 and 65535")
+	}
+	if err := validateMetricLabels(config.MetricsLabels); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if config.HealthPort > 0 && config.MetricsPort == config.HealthPort {
+		problems = append(problems, "metrics_port and health_port must differ")
+	}
+	if config.DiscordWebhookURL != "" && !strings.HasPrefix(config.DiscordWebhookURL, "https://") {
+		problems = append(problems, "discord_webhook_url must be an https URL")
+	}
+	if config.KafkaRESTURL != "" {
+		if !strings.HasPrefix(config.KafkaRESTURL, "http://") && !strings.HasPrefix(config.KafkaRESTURL, "https://") {
+			problems = append(problems, "kafka_rest_url must be a valid URL")
+		}
+		if config.KafkaTopic == "" {
+			problems = append(problems, "kafka_topic is required when kafka_rest_url is set")
+		}
+	}
+
+	// Validate GitHub App token cache
+	if config.GitHubAppTokenMargin != "" {
+		if _, err := time.ParseDuration(config.GitHubAppTokenMargin); err != nil {
+			problems = append(problems, "invalid github_app_token_margin: "+err.Error())
+		}
+	}
+
+	// Validate dead-letter repo
+	if config.DeadLetterRepo != "" {
+		if !repoRegex.MatchString(config.DeadLetterRepo) {
+			problems = append(problems, "dead_letter_repo must be in the format 'owner/repo'")
+		}
+		if config.DeadLetterToken == "" {
+			problems = append(problems, "dead_letter_token is required when dead_letter_repo is set")
+		}
+	}
+
+	// Validate Vault cache
+	if config.VaultCacheTTL != "" {
+		if _, err := time.ParseDuration(config.VaultCacheTTL); err != nil {
+			problems = append(problems, "invalid vault_cache_ttl: "+err.Error())
+		}
+	}
+
+	// Validate self-test
+	if config.SelfTestRepo != "" && !repoRegex.MatchString(config.SelfTestRepo) {
+		problems = append(problems, "self_test_repo must be in the format 'owner/repo'")
+	}
+
+	// Validate dispatch settings
+	if config.StageDelay != "" {
+		if _, err := time.ParseDuration(config.StageDelay); err != nil {
+			problems = append(problems, "invalid stage_delay: "+err.Error())
+		}
+	}
+	if config.CanaryTimeout != "" {
+		if _, err := time.ParseDuration(config.CanaryTimeout); err != nil {
+			problems = append(problems, "invalid canary_timeout: "+err.Error())
+		}
+	}
+	if err := validateDispatchSettings("the config", config.EventType, config.SuccessStatuses, config.PayloadFields); err != nil {
+		problems = append(problems, err.Error())
 	}
 
 	// Validate targets
 	if len(config.Targets) == 0 {
-		return errors.New("at least one target is required")
+		problems = append(problems, "at least one target is required")
+	}
+	switch config.ZeroEnabledTargets {
+	case "", "warn":
+	case "fail":
+		if enabledTargets(config) == 0 {
+			problems = append(problems, "all targets are disabled")
+		}
+	default:
+		problems = append(problems, "zero_enabled_targets must be 'warn' or 'fail'")
 	}
 
-	repoRegex := regexp.MustCompile(`^[a-zA-Z0-9-]+/[a-zA-Z0-9-]+$`)
 	for _, target := range config.Targets {
-		if target.GitHubRepo == "" {
-			return errors.New("github_repo is required for all targets")
+		switch target.Provider {
+		case providerGitHub:
+			if target.GitHubRepo == "" {
+				problems = append(problems, "github_repo is required for all targets")
+			} else if !repoRegex.MatchString(target.GitHubRepo) {
+				problems = append(problems, "github_repo must be in the format 'owner/repo'")
+			}
+			sources := 0
+			for _, set := range []bool{target.GitHubToken != "", target.GitHubAppID != 0, target.VaultPath != ""} {
+				if set {
+					sources++
+				}
+			}
+			if sources == 0 {
+				problems = append(problems, "github_token is required for all targets")
+			}
+			if sources > 1 {
+				problems = append(problems, fmt.Sprintf("%s must use exactly one of github_token, github_app_id and vault_path", target.GitHubRepo))
+			}
+			if target.GitHubAppID != 0 && (target.GitHubAppInstallationID == 0 || target.GitHubAppPrivateKeyPath == "") {
+				problems = append(problems, "github_app_installation_id and github_app_private_key_path are required with github_app_id")
+			}
+			switch target.DispatchMode {
+			case "", dispatchModeRepository:
+			case dispatchModeWorkflow:
+				if target.WorkflowFile == "" || target.Ref == "" {
+					problems = append(problems, fmt.Sprintf("workflow_file and ref are required for workflow dispatches to %s", target.GitHubRepo))
+				}
+			default:
+				problems = append(problems, fmt.Sprintf("dispatch_mode for %s must be 'repository' or 'workflow'", target.GitHubRepo))
+			}
+			switch target.DefaultBranchPolicy {
+			case "", defaultBranchUpdate, defaultBranchAlert:
+				if target.DefaultBranchPolicy != "" && target.DispatchMode != dispatchModeWorkflow {
+					problems = append(problems, fmt.Sprintf("default_branch_policy requires dispatch_mode 'workflow' for %s", target.GitHubRepo))
+				}
+			default:
+				problems = append(problems, fmt.Sprintf("default_branch_policy for %s must be 'update' or 'alert'", target.GitHubRepo))
+			}
+		case providerAzureDevOps:
+			if target.AzureOrganization == "" || target.AzureProject == "" {
+				problems = append(problems, "azure_organization and azure_project are required for azuredevops targets")
+			}
+			if target.AzurePipelineID <= 0 {
+				problems = append(problems, "azure_pipeline_id is required for azuredevops targets")
+			}
+			if target.AzureToken == "" {
+				problems = append(problems, "azure_token is required for azuredevops targets")
+			}
+		case providerCommand:
+			if len(target.Command) == 0 || target.Command[0] == "" {
+				problems = append(problems, "command is required for command targets")
+			}
+		case providerJenkins:
+			if !strings.HasPrefix(target.JenkinsJobURL, "http://") && !strings.HasPrefix(target.JenkinsJobURL, "https://") {
+				problems = append(problems, "jenkins_job_url must be a valid URL for jenkins targets")
+			} else if !strings.Contains(target.JenkinsJobURL, "/job/") {
+				problems = append(problems, fmt.Sprintf("jenkins_job_url %q must point to a job, e.g. https://ci.example.com/job/app-build", target.JenkinsJobURL))
+			}
+			if target.JenkinsUser == "" || target.JenkinsToken == "" {
+				problems = append(problems, "jenkins_user and jenkins_token are required for jenkins targets")
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("unknown target provider %q", target.Provider))
+		}
+		if target.ReadinessURL != "" && !strings.HasPrefix(target.ReadinessURL, "http://") && !strings.HasPrefix(target.ReadinessURL, "https://") {
+			problems = append(problems, "readiness_url must be a valid URL")
 		}
-		if !repoRegex.MatchString(target.GitHubRepo) {
-			return errors.New("github_repo must be in the format 'owner/repo'")
+		if err := validateDispatchSettings(target.Name(), target.EventType, target.SuccessStatuses, target.PayloadFields); err != nil {
+			problems = append(problems, err.Error())
 		}
-		if target.GitHubToken == "" {
-			return errors.New("github_token is required for all targets")
+		for branch, override := range target.BranchOverrides {
+			if !containsString(config.Branches, branch) {
+				problems = append(problems, fmt.Sprintf("branch_overrides for %s contains unknown branch %q", target.Name(), branch))
+			}
+			if err := validateDispatchSettings(target.Name()+" on "+branch, override.EventType, override.SuccessStatuses, override.PayloadFields); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+		if target.Canary && target.Provider != providerGitHub {
+			problems = append(problems, fmt.Sprintf("canary is only supported for github targets, not %s", target.Name()))
+		}
+		// A canary skipping a branch would leave its updates without a canary to vouch for them
+		if target.Canary && (len(target.Branches) > 0 || len(target.ExcludeBranches) > 0) {
+			problems = append(problems, fmt.Sprintf("canary target %s must receive every branch", target.Name()))
+		}
+		if target.ContentType != "" {
+			if _, _, err := mime.ParseMediaType(target.ContentType); err != nil || !strings.Contains(target.ContentType, "/") {
+				problems = append(problems, fmt.Sprintf("content_type for %s must be a media type like application/json", target.Name()))
+			}
+		}
+		if target.SuccessCooldown != "" {
+			if _, err := time.ParseDuration(target.SuccessCooldown); err != nil {
+				problems = append(problems, fmt.Sprintf("invalid success_cooldown for %s: %v", target.Name(), err))
+			}
+		}
+		if target.VersionConstraint != "" {
+			if _, err := parseConstraint(target.VersionConstraint); err != nil {
+				problems = append(problems, fmt.Sprintf("invalid version_constraint for %s: %v", target.Name(), err))
+			}
 		}
 	}
 
-	return nil
+	return problems
 }
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateBranchNames(t *testing.T) {
+	tests := []struct {
+		branches string
+		wantErr  string
+	}{
+		{`["stable", "testflight"]`, ""},
+		{`["release-1.2"]`, ""},
+		{`["feature/new-ui"]`, "must not be a path"},
+		{`["..\\stable"]`, "must not be a path"},
+		{`[".."]`, "must not be a path"},
+		{`["."]`, "must not be a path"},
+		{`["branch_hashes"]`, "clashes with the hash file"},
+		{`["stable", "stable"]`, "more than once"},
+	}
+
+	for _, tt := range tests {
+		_, err := loadTestConfig(t, "branches = "+tt.branches+"\n")
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("branches = %s: unexpected error %v", tt.branches, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("branches = %s: error %v, want %q", tt.branches, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateBranchFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		wantErr string
+	}{
+		{"only", `branches = ["stable"]`, ""},
+		{"exclude", `exclude_branches = ["testflight"]`, ""},
+		{"both", "branches = [\"stable\"]\nexclude_branches = [\"testflight\"]", "cannot set both"},
+		{"unknown only", `branches = ["beta"]`, "unknown branch \"beta\""},
+		{"unknown exclude", `exclude_branches = ["beta"]`, "unknown branch \"beta\""},
+	}
+
+	for _, tt := range tests {
+		for _, repo := range []string{`github_repo = "example/app"`, `repo_template = "example/app-{{.Branch}}"`} {
+			_, err := loadTestConfig(t, "[[targets]]\n"+repo+"\ngithub_token = \"token\"\n"+tt.target+"\n")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("%s with %s: unexpected error %v", tt.name, repo, err)
+				}
+				continue
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s with %s: error %v, want %q", tt.name, repo, err, tt.wantErr)
+			}
+		}
+	}
+}
+
+func TestTargetBranches(t *testing.T) {
+	cfg, err := loadTestConfig(t, `
+[[targets]]
+github_repo = "example/app"
+github_token = "token"
+branches = ["stable"]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := cfg.Targets[0]
+	if !target.receivesBranch("stable") {
+		t.Error("target does not receive its only branch")
+	}
+	if target.receivesBranch("testflight") {
+		t.Error("target receives a branch outside branches")
+	}
+}
+
+func TestCronWithSeconds(t *testing.T) {
+	tests := []struct {
+		schedule    string
+		withSeconds bool
+		wantErr     bool
+	}{
+		{"*/15 * * * *", false, false},
+		{"0 */15 * * * *", false, true},
+		{"*/15 * * * *", true, false},
+		{"0 */15 * * * *", true, false},
+		{"@hourly", true, false},
+		{"0 0 */15 * * * *", true, true},
+	}
+
+	for _, tt := range tests {
+		config := "refresh_schedule = " + strconvQuote(tt.schedule) + "\n"
+		if tt.withSeconds {
+			config += "cron_with_seconds = true\n"
+		}
+		_, err := loadTestConfig(t, config)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q with cron_with_seconds %v: error %v, want error %v", tt.schedule, tt.withSeconds, err, tt.wantErr)
+		}
+	}
+
+	// The 5 and 6 field forms of the same schedule fire at the same times
+	cfg := &Config{CronWithSeconds: true}
+	fiveField, err := cronParser(cfg).Parse("*/15 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sixField, err := cronParser(cfg).Parse("0 */15 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 1, 1, 10, 7, 30, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		a, b := fiveField.Next(at), sixField.Next(at)
+		if !a.Equal(b) {
+			t.Fatalf("next runs after %s differ: %s and %s", at, a, b)
+		}
+		at = a
+	}
+	if want := time.Date(2024, 1, 1, 11, 15, 0, 0, time.UTC); !at.Equal(want) {
+		t.Errorf("fifth run at %s, want %s", at, want)
+	}
+}
+
+func TestTargetGroups(t *testing.T) {
+	config, err := loadTestConfig(t, `
+event_type = "global-event"
+
+[[targets]]
+github_repo = "example/app"
+github_token = "token"
+
+[[target_groups]]
+repos = ["example/one", "example/two", "example/three"]
+github_token = "group-token"
+event_type = "group-event"
+payload_fields = { channel = "prod" }
+
+[[target_groups.overrides]]
+github_repo = "example/two"
+event_type = "two-event"
+github_token = "two-token"
+
+[[target_groups.overrides]]
+github_repo = "example/three"
+disabled = true
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, target := range config.Targets {
+		names = append(names, target.Name())
+	}
+	if want := []string{"example/app", "example/one", "example/two", "example/three"}; strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("targets %v, want %v", names, want)
+	}
+
+	one, two, three := config.Targets[1], config.Targets[2], config.Targets[3]
+	if one.GitHubToken != "group-token" || one.EventType != "group-event" || one.PayloadFields["channel"] != "prod" {
+		t.Errorf("example/one did not inherit the group settings: %+v", one)
+	}
+	// Overrides replace only the fields they set
+	if two.GitHubToken != "two-token" || two.EventType != "two-event" || two.PayloadFields["channel"] != "prod" {
+		t.Errorf("example/two override was not applied over the group: %+v", two)
+	}
+	if !three.Disabled || three.EventType != "group-event" {
+		t.Errorf("example/three override was not applied over the group: %+v", three)
+	}
+	if config.Targets[0].EventType != "" {
+		t.Errorf("group settings leaked into example/app: %+v", config.Targets[0])
+	}
+}
+
+func TestTargetGroupErrors(t *testing.T) {
+	tests := []struct {
+		config  string
+		wantErr string
+	}{
+		{"[[target_groups]]\ngithub_token = \"token\"\n", "has no repos"},
+		{"[[target_groups]]\nrepos = [\"example/one\"]\ngithub_token = \"token\"\n" +
+			"[[target_groups.overrides]]\ngithub_repo = \"example/two\"\n", "does not match any of its repos"},
+	}
+
+	for _, tt := range tests {
+		_, err := loadTestConfig(t, tt.config)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("error %v, want %q", err, tt.wantErr)
+		}
+	}
+}
+
+func TestZeroEnabledTargets(t *testing.T) {
+	disabled := "[[targets]]\ngithub_repo = \"example/app\"\ngithub_token = \"token\"\ndisabled = true\n"
+
+	tests := []struct {
+		name, config, wantErr string
+		wantWarning           bool
+	}{
+		{"default warns", disabled, "", true},
+		{"warn", "zero_enabled_targets = \"warn\"\n" + disabled, "", true},
+		{"fail", "zero_enabled_targets = \"fail\"\n" + disabled, "all targets are disabled", false},
+		{"fail with an enabled target", "zero_enabled_targets = \"fail\"\n" + disabled +
+			"[[targets]]\ngithub_repo = \"example/other\"\ngithub_token = \"token\"\n", "", false},
+		{"unknown policy", "zero_enabled_targets = \"ignore\"\n" + disabled, "must be 'warn' or 'fail'", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLog(t)
+			_, err := loadTestConfig(t, tt.config)
+			if tt.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error %v, want %q", err, tt.wantErr)
+			}
+			if warned := strings.Contains(logs.String(), "all targets are disabled"); warned != tt.wantWarning {
+				t.Errorf("warning logged %v, want %v:\n%s", warned, tt.wantWarning, logs)
+			}
+		})
+	}
+}
+
+func TestRepoTemplate(t *testing.T) {
+	cfg, err := loadTestConfig(t, `
+branches = ["stable", "testflight"]
+
+[[targets]]
+repo_template = "myorg/app-{{.Branch}}"
+github_token = "token"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cfg.Targets) != 2 {
+		t.Fatalf("expanded into %d targets, want one per branch", len(cfg.Targets))
+	}
+	for i, branch := range []string{"stable", "testflight"} {
+		target := cfg.Targets[i]
+		if target.GitHubRepo != "myorg/app-"+branch || target.GitHubToken != "token" {
+			t.Errorf("target for %s is %s, want myorg/app-%s with the template's token", branch, target.GitHubRepo, branch)
+		}
+		for _, other := range []string{"stable", "testflight"} {
+			if target.receivesBranch(other) != (other == branch) {
+				t.Errorf("%s receives %s: %v", target.GitHubRepo, other, target.receivesBranch(other))
+			}
+		}
+	}
+}
+
+func TestRepoTemplateErrors(t *testing.T) {
+	tests := []struct {
+		target, wantErr string
+	}{
+		{"repo_template = \"myorg/app {{.Branch}}\"", "not in the format 'owner/repo'"},
+		{"repo_template = \"app-{{.Branch}}\"", "not in the format 'owner/repo'"},
+		{"repo_template = \"myorg/{{.Branch\"", "invalid repo_template"},
+		{"repo_template = \"myorg/{{.Channel}}\"", "failed to expand repo_template"},
+		{"repo_template = \"myorg/app-{{.Branch}}\"\ngithub_repo = \"myorg/app\"", "cannot be combined with github_repo"},
+	}
+
+	for _, tt := range tests {
+		_, err := loadTestConfig(t, "[[targets]]\ngithub_token = \"token\"\n"+tt.target+"\n")
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error %v, want %q", tt.target, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDryRunEnv(t *testing.T) {
+	for value, want := range map[string]bool{"true": true, "1": true, "false": false, "maybe": false} {
+		t.Setenv("DRY_RUN", value)
+		cfg, err := loadTestConfig(t, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.DryRun != want {
+			t.Errorf("DRY_RUN=%s: dry run %v, want %v", value, cfg.DryRun, want)
+		}
+	}
+}
+
+func TestHealthPortEnv(t *testing.T) {
+	t.Setenv("HEALTH_PORT", "9000")
+	cfg, err := loadTestConfig(t, "health_port = 8080\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.HealthPort != 9000 {
+		t.Errorf("health port %d, want HEALTH_PORT to override it with 9000", cfg.HealthPort)
+	}
+
+	t.Setenv("HEALTH_PORT", "healthz")
+	if _, err := loadTestConfig(t, ""); err == nil || !strings.Contains(err.Error(), "invalid HEALTH_PORT") {
+		t.Errorf("error %v, want an invalid HEALTH_PORT error", err)
+	}
+}
+
+func TestHashDirEnv(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	t.Setenv("HASH_DIR", dir)
+	checker := newTestChecker(t, "")
+
+	if checker.Config.HashDir != dir {
+		t.Errorf("hash dir %s, want HASH_DIR %s", checker.Config.HashDir, dir)
+	}
+	if want := filepath.Join(dir, "branch_hashes.json"); checker.HashFile != want {
+		t.Errorf("hash file %s, want %s", checker.HashFile, want)
+	}
+	if _, err := os.Stat(checker.HashFile); err != nil {
+		t.Errorf("hash file was not created in HASH_DIR: %v", err)
+	}
+}
+
+func TestHashDirNotCreatable(t *testing.T) {
+	blocker := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadTestConfig(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.HashDir = filepath.Join(blocker, "state")
+	_, err = NewChecker(cfg)
+	if err == nil || !strings.Contains(err.Error(), "failed to create hash directory "+cfg.HashDir) {
+		t.Errorf("error %v, want the resolved hash directory named", err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Listing fields read in content_addressed mode unless configured otherwise
+const (
+	defaultContentHashField = "sha256"
+	defaultVersionField     = "version"
+)
+
+// decodeContentFields reads the content hash and version of every listing entry
+// for content_addressed mode. Entries without a content hash are rejected, while
+// a missing version falls back to the version in the file name.
+func (c *DipaChecker) decodeContentFields(listing []byte, files []IPAFile) error {
+	hashField, versionField := c.Config.ContentHashField, c.Config.VersionField
+	if hashField == "" {
+		hashField = defaultContentHashField
+	}
+	if versionField == "" {
+		versionField = defaultVersionField
+	}
+
+	var entries []map[string]json.RawMessage
+	if err := json.Unmarshal(listing, &entries); err != nil {
+		return err
+	}
+
+	for i, entry := range entries {
+		if err := json.Unmarshal(entry[hashField], &files[i].ContentHash); err != nil || files[i].ContentHash == "" {
+			return fmt.Errorf("%s has no %q content hash", files[i].Name, hashField)
+		}
+		if raw, ok := entry[versionField]; ok {
+			if err := json.Unmarshal(raw, &files[i].Version); err != nil {
+				return fmt.Errorf("%s has an invalid %q version: %w", files[i].Name, versionField, err)
+			}
+		}
+	}
+	return nil
+}
+
+// newerThan reports whether a is a newer build than b: by version in
+// content_addressed mode, by the version_regex version of the file names
+// when both have one, otherwise by modification time
+func (c *DipaChecker) newerThan(a, b IPAFile) bool {
+	if !c.Config.ContentAddressed {
+		if cmp, ok := c.compareNameVersions(a.Name, b.Name); ok && cmp != 0 {
+			return cmp > 0
+		}
+		return a.ModTime.After(b.ModTime)
+	}
+	return compareVersions(fileVersion(a.Version, a.Name), fileVersion(b.Version, b.Name)) > 0
+}
+
+// isRollback reports whether file is older than the latest file at the stored hash
+func (c *DipaChecker) isRollback(file IPAFile, branchData BranchData) bool {
+	if branchData.Latest == "" {
+		return false
+	}
+	if !c.Config.ContentAddressed {
+		if cmp, ok := c.compareNameVersions(file.Name, branchData.Latest); ok && cmp != 0 {
+			return cmp < 0
+		}
+		return file.ModTime.Before(branchData.LatestModTime)
+	}
+	stored := fileVersion(branchData.LatestVersion, branchData.Latest)
+	return compareVersions(fileVersion(file.Version, file.Name), stored) < 0
+}
+
+// recordLatest stores the latest file of the recorded listing
+func recordLatest(branchData *BranchData, file IPAFile) {
+	branchData.Latest = file.Name
+	branchData.LatestModTime = file.ModTime
+	branchData.LatestVersion = file.Version
+}
+
+// fileVersion parses an explicit version, or the version in the file name without one
+func fileVersion(version, name string) []int {
+	if version != "" {
+		if parsed, ok := parseVersion(version); ok {
+			return parsed
+		}
+	}
+	parsed, _ := parseVersion(name)
+	return parsed
+}
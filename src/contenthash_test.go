@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const contentConfig = `
+branches = ["stable"]
+content_addressed = true
+`
+
+// contentListing encodes listing entries with content hashes and versions, all
+// sharing one modification time
+func contentListing(entries ...map[string]string) []byte {
+	for _, entry := range entries {
+		entry["mod_time"] = "2024-01-01T00:00:00Z"
+	}
+	data, _ := json.Marshal(entries)
+	return data
+}
+
+func TestContentHashChangeDetected(t *testing.T) {
+	checker := newTestChecker(t, contentConfig)
+	recorder := serveDispatches(t, checker, contentListing(
+		map[string]string{"name": "Discord.ipa", "sha256": "aaa", "version": "228.0"},
+	))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	first := storedHash(t, checker, "stable")
+
+	// A rebuilt file under the same name and time is only told apart by its content
+	recorder.setListing(contentListing(
+		map[string]string{"name": "Discord.ipa", "sha256": "bbb", "version": "228.0"},
+	))
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if storedHash(t, checker, "stable") == first {
+		t.Error("content hash change was not detected")
+	}
+	if got := recorder.dispatched(); len(got) != 2 {
+		t.Errorf("dispatched to %v, want one dispatch per content", got)
+	}
+}
+
+func TestContentAddressedIgnoresModTime(t *testing.T) {
+	checker := newTestChecker(t, contentConfig)
+	entry := map[string]string{"name": "Discord.ipa", "sha256": "aaa", "version": "228.0"}
+	recorder := serveDispatches(t, checker, contentListing(entry))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	first := storedHash(t, checker, "stable")
+
+	touched, _ := json.Marshal([]map[string]string{{"name": "Discord.ipa", "sha256": "aaa", "version": "228.0", "mod_time": "2024-06-01T00:00:00Z"}})
+	recorder.setListing(touched)
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if storedHash(t, checker, "stable") != first {
+		t.Error("a new modification time changed the content-addressed hash")
+	}
+	if got := recorder.dispatched(); len(got) != 1 {
+		t.Errorf("dispatched to %v, want no dispatch for a touched file", got)
+	}
+}
+
+// TestContentAddressedVersionField picks the latest build by its version field rather
+// than the file name or modification time
+func TestContentAddressedVersionField(t *testing.T) {
+	checker := newTestChecker(t, contentConfig+"version_field = \"build\"\n")
+	recorder := serveDispatches(t, checker, contentListing(
+		map[string]string{"name": "Discord_b.ipa", "sha256": "aaa", "build": "229.10"},
+		map[string]string{"name": "Discord_a.ipa", "sha256": "bbb", "build": "229.9"},
+	))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if url := dispatchedURL(recorder); !strings.HasSuffix(url, "/Discord_b.ipa") {
+		t.Errorf("dispatched %q, want the highest build version", url)
+	}
+}
+
+func TestContentAddressedMissingHash(t *testing.T) {
+	checker := newTestChecker(t, contentConfig)
+	recorder := serveDispatches(t, checker, contentListing(
+		map[string]string{"name": "Discord.ipa", "version": "228.0"},
+	))
+
+	err := checker.CheckBranch("stable")
+	if err == nil || !strings.Contains(err.Error(), `Discord.ipa has no "sha256" content hash`) {
+		t.Errorf("error %v, want the missing content hash reported", err)
+	}
+	if got := recorder.dispatched(); len(got) != 0 {
+		t.Errorf("dispatched to %v without content hashes", got)
+	}
+}
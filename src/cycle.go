@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// CheckAll checks every branch once, serialized against other cycles,
+// and reports whether all branch checks succeeded
+func (c *DipaChecker) CheckAll(branches []string) bool {
+	c.cycleMu.Lock()
+	defer c.cycleMu.Unlock()
+
+	c.resetRetryBudget()
+
+	// Check all branches concurrently. CheckBranch only locks the shared state to read
+	// and record it, so dispatches, stage delays and canary waits overlap across branches.
+	var wg sync.WaitGroup
+	var failedMu sync.Mutex
+	failed := false
+	for _, branch := range c.BranchOrder(branches) {
+		wg.Add(1)
+		go func(branch string) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := c.CheckBranch(branch)
+			c.Statsd.Incr("checks", "branch:"+branch)
+			c.Statsd.Timing("check.duration", time.Since(start), "branch:"+branch)
+			if err != nil {
+				log.Printf("Error checking %s branch: %v", branch, err)
+				c.Statsd.Incr("check.failures", "branch:"+branch)
+				failedMu.Lock()
+				failed = true
+				failedMu.Unlock()
+			}
+		}(branch)
+	}
+	wg.Wait()
+
+	if !failed && c.Config.ResumeSchedule {
+		c.recordLastRun(time.Now())
+	}
+
+	if err := c.writeStatusFile(!failed); err != nil {
+		log.Printf("Error writing status file: %v", err)
+	}
+
+	c.SendHeartbeat(failed)
+	return !failed
+}
+
+// CheckOnSignal checks every branch each time a signal arrives, serialized against
+// scheduled checks by CheckAll, until signals is closed
+func (c *DipaChecker) CheckOnSignal(signals <-chan os.Signal, branches []string) {
+	for range signals {
+		log.Println("Manual check triggered by SIGUSR1")
+		c.CheckAll(branches)
+	}
+}
+
+// defaultInitialRetryDelay is the first delay between initial check retries, doubled up to five minutes
+const defaultInitialRetryDelay = 5 * time.Second
+
+// RunInitialCheck checks every branch on startup. With initial_check_timeout set,
+// failed checks are retried with backoff until one succeeds or the timeout elapses.
+func (c *DipaChecker) RunInitialCheck(branches []string) {
+	if c.Config.ResumeSchedule && c.lastRunCurrent(time.Now()) {
+		last, _ := c.lastRun()
+		log.Printf("Last check at %s is still current, skipping initial check",
+			last.Format(time.RFC1123))
+		return
+	}
+
+	log.Println("Running initial check...")
+	if c.CheckAll(branches) || c.initialCheckTimeout == 0 {
+		return
+	}
+
+	deadline := time.Now().Add(c.initialCheckTimeout)
+	delay := c.initialRetryDelay
+	for time.Now().Add(delay).Before(deadline) {
+		log.Printf("Initial check failed, retrying in %s", delay)
+		time.Sleep(delay)
+
+		if c.CheckAll(branches) {
+			log.Println("Initial check succeeded, baseline established")
+			return
+		}
+
+		delay *= 2
+		if delay > 5*time.Minute {
+			delay = 5 * time.Minute
+		}
+	}
+
+	log.Printf("Initial check did not succeed within %s, relying on the schedule", c.initialCheckTimeout)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const twoTargets = `
+branches = ["stable", "testflight"]
+dispatch_retries = 0
+
+[[targets]]
+github_repo = "example/one"
+github_token = "token"
+
+[[targets]]
+github_repo = "example/two"
+github_token = "token"
+`
+
+// TestCheckAllConcurrentState checks both branches in parallel and confirms every dispatch
+// ends up in the shared state and the hash file. Run with -race to catch unguarded access.
+func TestCheckAllConcurrentState(t *testing.T) {
+	checker := newTestChecker(t, twoTargets)
+
+	var mu sync.Mutex
+	dispatched := map[string]int{}
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/stable/":
+			w.Write(listingJSON("Discord_228.0.ipa"))
+		case r.URL.Path == "/testflight/":
+			w.Write(listingJSON("Discord_229.0.ipa"))
+		case strings.HasSuffix(r.URL.Path, "/dispatches"):
+			mu.Lock()
+			dispatched[r.URL.Path]++
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	if !checker.CheckAll(checker.Config.Branches) {
+		t.Fatal("CheckAll reported a failed check")
+	}
+
+	for _, path := range []string{"/repos/example/one/dispatches", "/repos/example/two/dispatches"} {
+		if dispatched[path] != 2 {
+			t.Errorf("%s received %d dispatches, want one per branch", path, dispatched[path])
+		}
+	}
+
+	if err := checker.LoadHashes(); err != nil {
+		t.Fatal(err)
+	}
+	for _, branch := range checker.Config.Branches {
+		branchData := checker.BranchData.Branches[branch]
+		if branchData.Hash == "" {
+			t.Errorf("%s hash was not saved", branch)
+		}
+		if got := branchData.Dispatches[branchData.Hash]; len(got) != 2 {
+			t.Errorf("%s dispatches = %v, want both targets", branch, got)
+		}
+	}
+	if len(checker.BranchData.LastPayloads) != 2 {
+		t.Errorf("stored payloads for %d targets, want 2", len(checker.BranchData.LastPayloads))
+	}
+}
+
+// TestCheckAllDispatchesConcurrently holds every dispatch until both branches are
+// dispatching at the same time, which only happens when branch checks overlap
+func TestCheckAllDispatchesConcurrently(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable", "testflight"]
+dispatch_retries = 0
+
+[[targets]]
+github_repo = "example/app"
+github_token = "token"
+`)
+
+	var mu sync.Mutex
+	inFlight := 0
+	both := make(chan struct{})
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/stable/":
+			w.Write(listingJSON("Discord_228.0.ipa"))
+		case r.URL.Path == "/testflight/":
+			w.Write(listingJSON("Discord_229.0.ipa"))
+		case strings.HasSuffix(r.URL.Path, "/dispatches"):
+			mu.Lock()
+			inFlight++
+			if inFlight == 2 {
+				close(both)
+			}
+			mu.Unlock()
+
+			select {
+			case <-both:
+				w.WriteHeader(http.StatusNoContent)
+			case <-time.After(5 * time.Second):
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	checker.CheckAll(checker.Config.Branches)
+
+	for _, branch := range checker.Config.Branches {
+		status := checker.BranchStatus[branch]
+		if status == nil || len(status.Dispatched) != 1 {
+			t.Errorf("%s was not dispatched while the other branch was dispatching", branch)
+		}
+	}
+}
+
+func TestBranchOrder(t *testing.T) {
+	branches := []string{"stable", "testflight", "beta", "alpha"}
+
+	fixed := newTestChecker(t, "")
+	for i := 0; i < 10; i++ {
+		if got := fixed.BranchOrder(branches); strings.Join(got, ",") != strings.Join(branches, ",") {
+			t.Fatalf("order %v without randomize_branch_order, want %v", got, branches)
+		}
+	}
+
+	orders := func(seed int64) []string {
+		checker := newTestChecker(t, "randomize_branch_order = true")
+		checker.rng = rand.New(rand.NewSource(seed))
+
+		orders := []string{}
+		for i := 0; i < 20; i++ {
+			order := checker.BranchOrder(branches)
+			sorted := append([]string{}, order...)
+			sort.Strings(sorted)
+			if strings.Join(sorted, ",") != "alpha,beta,stable,testflight" {
+				t.Fatalf("order %v is not a permutation of %v", order, branches)
+			}
+			orders = append(orders, strings.Join(order, ","))
+		}
+		return orders
+	}
+
+	first := orders(7)
+	distinct := map[string]bool{}
+	firstBranches := map[string]bool{}
+	for _, order := range first {
+		distinct[order] = true
+		firstBranches[strings.Split(order, ",")[0]] = true
+	}
+	if len(distinct) < 2 {
+		t.Errorf("the order never varied across cycles: %v", first)
+	}
+	if len(firstBranches) < 2 {
+		t.Errorf("the same branch was always checked first: %v", first)
+	}
+	if strings.Join(orders(7), ";") != strings.Join(first, ";") {
+		t.Error("the same seed gave different orders")
+	}
+	if branches[0] != "stable" {
+		t.Error("BranchOrder shuffled the configured list in place")
+	}
+}
+
+// TestInitialCheckRetries fails the listing twice on startup and expects the
+// initial check to keep retrying until the baseline is stored
+func TestInitialCheckRetries(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+max_retries = 0
+initial_check_timeout = "1m"
+`)
+	checker.initialRetryDelay = time.Millisecond
+
+	var mu sync.Mutex
+	fetches := 0
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.URL.Path != "/stable/" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		fetches++
+		if fetches <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(listingJSON("Discord_228.0.ipa"))
+	}))
+
+	checker.RunInitialCheck(checker.Config.Branches)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fetches != 3 {
+		t.Errorf("fetched the listing %d times, want two failures and a success", fetches)
+	}
+	if storedHash(t, checker, "stable") == "" {
+		t.Error("no baseline hash stored after the initial check succeeded")
+	}
+}
+
+func TestInitialCheckWithoutTimeout(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+max_retries = 0
+`)
+	checker.initialRetryDelay = time.Millisecond
+
+	var fetches int32
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+
+	checker.RunInitialCheck(checker.Config.Branches)
+	if got := atomic.LoadInt32(&fetches); got != 1 {
+		t.Errorf("fetched the listing %d times, want a single attempt without initial_check_timeout", got)
+	}
+}
+
+// TestCheckOnSignal triggers a manual check while a scheduled one holds the cycle and
+// expects it to run once the scheduled check is done
+func TestCheckOnSignal(t *testing.T) {
+	checker := newTestChecker(t, `branches = ["stable", "testflight"]`)
+	recorder := serveDispatches(t, checker, listingJSON("Discord_228.0.ipa"))
+	logs := captureLog(t)
+
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	checker.cycleMu.Lock()
+	go func() {
+		checker.CheckOnSignal(signals, checker.Config.Branches)
+		close(done)
+	}()
+	signals <- syscall.SIGUSR1
+
+	time.Sleep(50 * time.Millisecond)
+	if got := recorder.dispatched(); len(got) != 0 {
+		t.Errorf("manual check dispatched to %v during a scheduled check", got)
+	}
+	checker.cycleMu.Unlock()
+
+	close(signals)
+	<-done
+	if got := recorder.dispatched(); len(got) != 2 {
+		t.Errorf("manual check dispatched to %v, want example/app for both branches", got)
+	}
+	if !strings.Contains(logs.String(), "Manual check triggered by SIGUSR1") {
+		t.Errorf("manual check was not logged:\n%s", logs)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log"
+)
+
+// dispatchDeadLetter records an update no target accepted to dead_letter_repo so
+// it can be handled by hand. It is attempted once per hash, failures are only logged.
+func (c *DipaChecker) dispatchDeadLetter(event DispatchEvent, failed []string) {
+	if c.Config.DeadLetterRepo == "" || c.Config.DryRun {
+		return
+	}
+
+	// Later checks keep retrying the targets without sending the update again
+	key := dispatchKey(event)
+	c.stateMu.Lock()
+	sent := c.branchState(event.Branch).DeadLettered == key
+	c.stateMu.Unlock()
+	if sent {
+		return
+	}
+
+	err := c.updateBranch(event.Branch, func(branchData *BranchData) {
+		branchData.DeadLettered = key
+	})
+	if err != nil {
+		log.Printf("Error saving hashes: %v", err)
+	}
+
+	target := Target{
+		Provider:    providerGitHub,
+		GitHubRepo:  c.Config.DeadLetterRepo,
+		GitHubToken: c.Config.DeadLetterToken,
+	}
+	body, err := buildDispatchBody(target, "ipa-update-failed", map[string]interface{}{
+		"ipa_url":          event.IPAURL,
+		"branch":           event.Branch,
+		"version":          event.Version,
+		"is_testflight":    event.Branch == "testflight",
+		"payload_version":  PayloadVersion,
+		"original_targets": failed,
+		"instance_id":      c.Config.InstanceID,
+	})
+	if err != nil {
+		log.Printf("Error marshaling dead-letter payload: %v", err)
+		return
+	}
+
+	if err := c.postDispatch(target, body); err != nil {
+		log.Printf("Failed to dispatch %s update to dead-letter repo %s: %v", event.Branch, c.Config.DeadLetterRepo, err)
+		return
+	}
+	log.Printf("Recorded failed %s update in dead-letter repo %s", event.Branch, c.Config.DeadLetterRepo)
+}
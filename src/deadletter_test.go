@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// TestDeadLetterOncePerHash fails every dispatch on two checks of the same listing
+// and expects a single dead letter
+func TestDeadLetterOncePerHash(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+dispatch_retries = 0
+dead_letter_repo = "example/dead-letters"
+dead_letter_token = "token"
+
+[[targets]]
+github_repo = "example/app"
+github_token = "token"
+`)
+
+	var mu sync.Mutex
+	deadLetters, dispatches := 0, 0
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.URL.Path {
+		case "/stable/":
+			w.Write(listingJSON("Discord_228.0.ipa"))
+		case "/repos/example/app/dispatches":
+			dispatches++
+			http.Error(w, `{"message":"Unprocessable"}`, http.StatusUnprocessableEntity)
+		case "/repos/example/dead-letters/dispatches":
+			deadLetters++
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	for i := 0; i < 2; i++ {
+		if err := checker.CheckBranch("stable"); err != nil {
+			t.Fatalf("check %d: %v", i+1, err)
+		}
+	}
+
+	if dispatches != 2 {
+		t.Errorf("target received %d dispatches, want one per check", dispatches)
+	}
+	if deadLetters != 1 {
+		t.Errorf("dead-letter repo received %d dispatches, want 1", deadLetters)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Policies of workflow targets whose ref is no longer the repo's default branch
+const (
+	defaultBranchUpdate = "update"
+	defaultBranchAlert  = "alert"
+)
+
+// repository is the part of a GitHub repository lookup dipa-auto uses
+type repository struct {
+	DefaultBranch string `json:"default_branch"`
+}
+
+// fetchDefaultBranch looks up the current default branch of a target's repo
+func (c *DipaChecker) fetchDefaultBranch(target Target) (string, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/repos/%s", githubAPIURL, target.GitHubRepo), nil)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := c.githubToken(target)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	var repo repository
+	if err := json.NewDecoder(resp.Body).Decode(&repo); err != nil {
+		return "", err
+	}
+	if repo.DefaultBranch == "" {
+		return "", fmt.Errorf("no default branch reported")
+	}
+	return repo.DefaultBranch, nil
+}
+
+// resolveRef applies default_branch_policy to a workflow target whose ref is meant to be
+// the repo's default branch. Once the default branch changed, "update" dispatches to the
+// new default branch and "alert" alerts once per change and keeps the configured ref.
+// The configured ref is kept when the lookup fails.
+func (c *DipaChecker) resolveRef(target Target, branch string) Target {
+	if target.DispatchMode != dispatchModeWorkflow || target.DefaultBranchPolicy == "" || c.Config.DryRun {
+		return target
+	}
+
+	defaultBranch, err := c.fetchDefaultBranch(target)
+	if err != nil {
+		log.Printf("Error looking up the default branch of %s, dispatching to %s: %v", target.GitHubRepo, target.Ref, err)
+		return target
+	}
+	if defaultBranch == target.Ref {
+		return target
+	}
+
+	if target.DefaultBranchPolicy == defaultBranchUpdate {
+		log.Printf("Default branch of %s changed from %s to %s, dispatching to %s",
+			target.GitHubRepo, target.Ref, defaultBranch, defaultBranch)
+		target.Ref = defaultBranch
+		return target
+	}
+
+	c.refMu.Lock()
+	if c.refAlerts == nil {
+		c.refAlerts = make(map[string]string)
+	}
+	alerted := c.refAlerts[target.Name()] == defaultBranch
+	c.refAlerts[target.Name()] = defaultBranch
+	c.refMu.Unlock()
+
+	if !alerted {
+		c.alert(branch, fmt.Sprintf("Default branch of %s changed", target.GitHubRepo),
+			fmt.Sprintf("%s now defaults to %s, but %s is dispatched on ref %s", target.GitHubRepo, defaultBranch, target.Name(), target.Ref))
+	}
+	return target
+}
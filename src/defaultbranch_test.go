@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// serveRenamedDefault serves a listing and a repo whose default branch is now trunk,
+// recording the ref of every workflow dispatch
+func serveRenamedDefault(t *testing.T, checker *DipaChecker) func() []string {
+	t.Helper()
+
+	var mu sync.Mutex
+	var refs []string
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stable/":
+			w.Write(listingJSON("Discord_228.0.ipa"))
+		case "/repos/example/app":
+			w.Write([]byte(`{"full_name":"example/app","default_branch":"trunk"}`))
+		case "/repos/example/app/actions/workflows/build.yml/dispatches":
+			var body struct {
+				Ref string `json:"ref"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			mu.Lock()
+			refs = append(refs, body.Ref)
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, refs...)
+	}
+}
+
+func defaultBranchConfig(policy string) string {
+	return fmt.Sprintf(`
+branches = ["stable"]
+
+[[targets]]
+github_repo = "example/app"
+github_token = "token"
+dispatch_mode = "workflow"
+workflow_file = "build.yml"
+ref = "main"
+default_branch_policy = %q
+`, policy)
+}
+
+func TestDefaultBranchUpdate(t *testing.T) {
+	checker := newTestChecker(t, defaultBranchConfig("update"))
+	refs := serveRenamedDefault(t, checker)
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if got := refs(); strings.Join(got, ",") != "trunk" {
+		t.Errorf("dispatched on refs %v, want the new default branch trunk", got)
+	}
+}
+
+// TestDefaultBranchAlert keeps the configured ref and alerts once per default branch change
+func TestDefaultBranchAlert(t *testing.T) {
+	checker := newTestChecker(t, defaultBranchConfig("alert"))
+	notifier := &recordingNotifier{}
+	checker.Notifiers = []Notifier{notifier}
+	refs := serveRenamedDefault(t, checker)
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checker.ForceDispatch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := refs(); strings.Join(got, ",") != "main,main" {
+		t.Errorf("dispatched on refs %v, want the configured ref main", got)
+	}
+	alerts := notifier.titled("Default branch of example/app changed")
+	if len(alerts) != 1 {
+		t.Fatalf("got %d alerts, want 1", len(alerts))
+	}
+	if !strings.Contains(alerts[0].Message, "trunk") {
+		t.Errorf("alert %q does not name the new default branch", alerts[0].Message)
+	}
+}
+
+func TestDefaultBranchPolicyValidation(t *testing.T) {
+	if _, err := loadTestConfig(t, defaultBranchConfig("follow")); err == nil || !strings.Contains(err.Error(), "default_branch_policy") {
+		t.Errorf("unknown policy accepted: %v", err)
+	}
+
+	_, err := loadTestConfig(t, `
+[[targets]]
+github_repo = "example/app"
+github_token = "token"
+default_branch_policy = "update"
+`)
+	if err == nil || !strings.Contains(err.Error(), "requires dispatch_mode 'workflow'") {
+		t.Errorf("policy without a workflow target accepted: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ListingDiff describes how a listing changed between two checks
+type ListingDiff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// diffListings compares two listings by file name, treating a different ModTime or content hash as a change
+func diffListings(previous, current []IPAFile) ListingDiff {
+	diff := ListingDiff{}
+
+	previousFiles := make(map[string]IPAFile, len(previous))
+	for _, file := range previous {
+		previousFiles[file.Name] = file
+	}
+
+	currentNames := make(map[string]bool, len(current))
+	for _, file := range current {
+		currentNames[file.Name] = true
+
+		old, ok := previousFiles[file.Name]
+		if !ok {
+			diff.Added = append(diff.Added, file.Name)
+		} else if !old.ModTime.Equal(file.ModTime) || old.ContentHash != file.ContentHash {
+			diff.Changed = append(diff.Changed, file.Name)
+		}
+	}
+
+	for _, file := range previous {
+		if !currentNames[file.Name] {
+			diff.Removed = append(diff.Removed, file.Name)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
+
+// Empty reports whether the diff contains no changes
+func (d ListingDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// String formats the diff as "+added, -removed, ~changed"
+func (d ListingDiff) String() string {
+	if d.Empty() {
+		return "no file changes"
+	}
+
+	parts := []string{}
+	for _, name := range d.Added {
+		parts = append(parts, "+"+name)
+	}
+	for _, name := range d.Removed {
+		parts = append(parts, "-"+name)
+	}
+	for _, name := range d.Changed {
+		parts = append(parts, "~"+name)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// maxChangelogEntries bounds the files listed in a changelog, e.g. on the first check
+const maxChangelogEntries = 5
+
+// Changelog summarizes the diff and the new latest file for notifications,
+// e.g. "+Discord-124.0.ipa, latest now 124.0"
+func (d ListingDiff) Changelog(latest string) string {
+	parts := []string{}
+	for _, name := range d.Added {
+		parts = append(parts, "+"+name)
+	}
+	for _, name := range d.Removed {
+		parts = append(parts, "-"+name)
+	}
+	for _, name := range d.Changed {
+		parts = append(parts, "~"+name)
+	}
+	if len(parts) > maxChangelogEntries {
+		parts = append(parts[:maxChangelogEntries], fmt.Sprintf("%d more", len(parts)-maxChangelogEntries))
+	}
+
+	if latest != "" {
+		if version, ok := parseVersion(latest); ok {
+			latest = formatVersion(version)
+		}
+		parts = append(parts, "latest now "+latest)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// shortHash abbreviates a hash for log output
+func shortHash(hash string) string {
+	if hash == "" {
+		return "(none)"
+	}
+	return trimString(hash, 12)
+}
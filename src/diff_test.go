@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiffListings(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	previous := []IPAFile{
+		{Name: "Discord_227.0.ipa", ModTime: base},
+		{Name: "Discord_228.0.ipa", ModTime: base},
+		{Name: "Discord_old.ipa", ModTime: base},
+	}
+	current := []IPAFile{
+		{Name: "Discord_229.0.ipa", ModTime: base},
+		{Name: "Discord_228.0.ipa", ModTime: base.Add(time.Minute)},
+		{Name: "Discord_227.0.ipa", ModTime: base},
+	}
+
+	diff := diffListings(previous, current)
+	if got, want := diff.String(), "+Discord_229.0.ipa, -Discord_old.ipa, ~Discord_228.0.ipa"; got != want {
+		t.Errorf("diff = %q, want %q", got, want)
+	}
+	if !diffListings(current, current).Empty() {
+		t.Error("identical listings produced a diff")
+	}
+}
+
+// TestDiffLogged adds a single file and expects the dispatch log to name it with both hashes
+func TestDiffLogged(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+log_listing_diff = true
+`)
+	recorder := serveDispatches(t, checker, listingJSON("Discord_227.0.ipa"))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	oldHash := storedHash(t, checker, "stable")
+
+	logs := captureLog(t)
+	recorder.setListing(listingJSON("Discord_227.0.ipa", "Discord_228.0.ipa"))
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	newHash := storedHash(t, checker, "stable")
+
+	want := "stable hash changed from " + shortHash(oldHash) + " to " + shortHash(newHash) + ": +Discord_228.0.ipa\n"
+	if !strings.Contains(logs.String(), want) {
+		t.Errorf("log does not contain %q:\n%s", want, logs)
+	}
+}
+
+func TestChangelog(t *testing.T) {
+	before := []IPAFile{listingFile("Discord-122.0.ipa", 0), listingFile("Discord-123.0.ipa", 1)}
+
+	tests := []struct {
+		name    string
+		after   []IPAFile
+		latest  string
+		summary string
+	}{
+		{"added", append(before, listingFile("Discord-124.0.ipa", 2)), "Discord-124.0.ipa",
+			"+Discord-124.0.ipa, latest now 124.0"},
+		{"replaced", []IPAFile{before[1], listingFile("Discord-124.0.ipa", 2)}, "Discord-124.0.ipa",
+			"+Discord-124.0.ipa, -Discord-122.0.ipa, latest now 124.0"},
+		{"reuploaded", []IPAFile{before[0], listingFile("Discord-123.0.ipa", 5)}, "Discord-123.0.ipa",
+			"~Discord-123.0.ipa, latest now 123.0"},
+		{"unversioned", append(before, listingFile("Discord-nightly.ipa", 2)), "Discord-nightly.ipa",
+			"+Discord-nightly.ipa, latest now Discord-nightly.ipa"},
+	}
+
+	for _, tt := range tests {
+		if got := diffListings(before, tt.after).Changelog(tt.latest); got != tt.summary {
+			t.Errorf("%s: changelog %q, want %q", tt.name, got, tt.summary)
+		}
+	}
+
+	// A first check lists every file, so the summary is capped
+	first := []IPAFile{}
+	for i := 0; i < maxChangelogEntries+3; i++ {
+		first = append(first, listingFile(fmt.Sprintf("Discord-%d.0.ipa", 120+i), i))
+	}
+	summary := diffListings(nil, first).Changelog("Discord-127.0.ipa")
+	if !strings.HasSuffix(summary, ", 3 more, latest now 127.0") || strings.Count(summary, "+") != maxChangelogEntries {
+		t.Errorf("first check changelog %q, want %d files and 3 more", summary, maxChangelogEntries)
+	}
+}
+
+func TestChangelogNotification(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+notify_changelog = true
+`)
+	notifier := &recordingNotifier{}
+	checker.Notifiers = []Notifier{notifier}
+	recorder := serveDispatches(t, checker, listingJSON("Discord_227.0.ipa"))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	recorder.setListing(listingJSON("Discord_227.0.ipa", "Discord_228.0.ipa"))
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := notifier.titled("New stable version")
+	if len(got) != 2 {
+		t.Fatalf("%d update notifications, want 2", len(got))
+	}
+	if got[1].Message != "+Discord_228.0.ipa, latest now 228.0" {
+		t.Errorf("notification message %q, want the changelog", got[1].Message)
+	}
+}
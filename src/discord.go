@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Embed colors of Discord notifications
+const (
+	discordColorSuccess = 0x57F287
+	discordColorFailure = 0xED4245
+	discordColorAlert   = 0xFEE75C
+)
+
+// DiscordNotifier posts embeds to a Discord webhook
+type DiscordNotifier struct {
+	WebhookURL string
+	Client     *http.Client
+}
+
+// discordField represents an embed field
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+// discordEmbed represents a Discord embed
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description,omitempty"`
+	URL         string         `json:"url,omitempty"`
+	Color       int            `json:"color"`
+	Fields      []discordField `json:"fields,omitempty"`
+}
+
+// discordMessage represents the webhook payload
+type discordMessage struct {
+	Embeds []discordEmbed `json:"embeds"`
+}
+
+// Notify sends the notification to Discord
+func (d *DiscordNotifier) Notify(n Notification) error {
+	payloadBytes, err := json.Marshal(discordMessage{Embeds: []discordEmbed{buildDiscordEmbed(n)}})
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.Client.Post(d.WebhookURL, "application/json", bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Discord answers 204 unless ?wait=true is set on the webhook URL
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("discord webhook returned status %d: %s", resp.StatusCode, trimString(string(body), 200))
+	}
+
+	return nil
+}
+
+// buildDiscordEmbed builds the embed for a notification
+func buildDiscordEmbed(n Notification) discordEmbed {
+	embed := discordEmbed{
+		Title:       n.Title,
+		Description: n.Message,
+		URL:         n.URL,
+		Color:       discordColorSuccess,
+	}
+
+	switch {
+	case n.Version == "":
+		embed.Color = discordColorAlert
+	case len(n.Failed) > 0:
+		embed.Color = discordColorFailure
+	}
+
+	if n.Branch != "" {
+		embed.Fields = append(embed.Fields, discordField{Name: "Branch", Value: n.Branch, Inline: true})
+	}
+	if n.Version != "" {
+		embed.Fields = append(embed.Fields, discordField{Name: "Version", Value: n.Version, Inline: true})
+	}
+	if n.Instance != "" {
+		embed.Fields = append(embed.Fields, discordField{Name: "Instance", Value: n.Instance, Inline: true})
+	}
+	if n.URL != "" {
+		embed.Fields = append(embed.Fields, discordField{Name: "URL", Value: n.URL})
+	}
+	if len(n.Successful) > 0 {
+		embed.Fields = append(embed.Fields, discordField{Name: "Dispatched", Value: strings.Join(n.Successful, "\n")})
+	}
+	if len(n.Failed) > 0 {
+		embed.Fields = append(embed.Fields, discordField{Name: "Failed", Value: strings.Join(n.Failed, "\n")})
+	}
+
+	return embed
+}
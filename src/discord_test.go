@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const discordConfig = `
+branches = ["stable"]
+dispatch_retries = 0
+discord_webhook_url = "https://discord.example.com/api/webhooks/1/token"
+
+[[targets]]
+github_repo = "example/app"
+github_token = "token"
+
+[[targets]]
+github_repo = "example/broken"
+github_token = "token"
+`
+
+// serveDiscord serves the stable listing, accepts dispatches to example/app, rejects those
+// to example/broken and records webhook messages, answering them with webhookStatus
+func serveDiscord(t *testing.T, checker *DipaChecker, webhookStatus int) func() []discordMessage {
+	var mu sync.Mutex
+	var messages []discordMessage
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stable/":
+			w.Write(listingJSON("Discord_228.0.ipa"))
+		case "/repos/example/app/dispatches":
+			w.WriteHeader(http.StatusNoContent)
+		case "/api/webhooks/1/token":
+			var message discordMessage
+			json.NewDecoder(r.Body).Decode(&message)
+			mu.Lock()
+			messages = append(messages, message)
+			mu.Unlock()
+			w.WriteHeader(webhookStatus)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	return func() []discordMessage {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]discordMessage{}, messages...)
+	}
+}
+
+func TestDiscordUpdateEmbed(t *testing.T) {
+	checker := newTestChecker(t, discordConfig)
+	messages := serveDiscord(t, checker, http.StatusNoContent)
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	var embed *discordEmbed
+	for _, message := range messages() {
+		if len(message.Embeds) == 1 && strings.HasPrefix(message.Embeds[0].Title, "New stable version") {
+			embed = &message.Embeds[0]
+		}
+	}
+	if embed == nil {
+		t.Fatalf("no update embed among %+v", messages())
+	}
+
+	fields := make(map[string]string)
+	for _, field := range embed.Fields {
+		fields[field.Name] = field.Value
+	}
+	want := map[string]string{
+		"Branch":     "stable",
+		"Version":    "Discord_228.0.ipa",
+		"Dispatched": "example/app",
+		"Failed":     "example/broken",
+	}
+	for name, value := range want {
+		if fields[name] != value {
+			t.Errorf("%s field is %q, want %q", name, fields[name], value)
+		}
+	}
+	if !strings.HasSuffix(fields["URL"], "/Discord_228.0.ipa") || embed.URL != fields["URL"] {
+		t.Errorf("embed links %q with URL field %q, want the IPA's final URL", embed.URL, fields["URL"])
+	}
+	if embed.Color != discordColorFailure {
+		t.Errorf("embed color %#x, want the failure color with a target failed", embed.Color)
+	}
+}
+
+// TestDiscordWebhookFailure expects a failing webhook to be logged without failing the
+// check, since the dispatch already went out
+func TestDiscordWebhookFailure(t *testing.T) {
+	checker := newTestChecker(t, discordConfig)
+	messages := serveDiscord(t, checker, http.StatusInternalServerError)
+	logs := captureLog(t)
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatalf("webhook failure failed the check: %v", err)
+	}
+	if len(messages()) == 0 {
+		t.Fatal("webhook was not called")
+	}
+	if !strings.Contains(logs.String(), "discord webhook returned status 500") {
+		t.Errorf("webhook failure was not logged:\n%s", logs)
+	}
+	if storedHash(t, checker, "stable") == "" {
+		t.Error("update was not recorded after the webhook failed")
+	}
+}
+
+func TestDiscordDisabled(t *testing.T) {
+	checker := newTestChecker(t, `branches = ["stable"]`)
+	for _, notifier := range checker.Notifiers {
+		if _, ok := notifier.(*DiscordNotifier); ok {
+			t.Fatal("Discord notifier enabled without a webhook URL")
+		}
+	}
+}
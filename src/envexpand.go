@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"regexp"
+)
+
+// envReferenceRegex matches ${NAME} environment variable references
+var envReferenceRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${NAME} references in the string values of the config, including
+// those nested in targets and tables, by the environment variables they name. Unset
+// variables expand to "" and are returned in the order they were first referenced.
+// Comments are never expanded and a bare $ is left alone, so regexes like \.ipa$ are kept.
+func expandEnv(config *Config) []string {
+	var unset []string
+	expand := func(s string) string {
+		return envReferenceRegex.ReplaceAllStringFunc(s, func(reference string) string {
+			name := envReferenceRegex.FindStringSubmatch(reference)[1]
+			value, ok := os.LookupEnv(name)
+			if !ok && !containsString(unset, name) {
+				unset = append(unset, name)
+			}
+			return value
+		})
+	}
+
+	expandValue(reflect.ValueOf(config).Elem(), expand)
+	return unset
+}
+
+// expandValue applies expand to every string reachable from v, which must be settable
+func expandValue(v reflect.Value, expand func(string) string) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(expand(v.String()))
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				expandValue(v.Field(i), expand)
+			}
+		}
+	case reflect.Ptr:
+		if !v.IsNil() {
+			expandValue(v.Elem(), expand)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			expandValue(v.Index(i), expand)
+		}
+	case reflect.Map:
+		// Map values are not addressable, so expand a copy and store it back
+		for _, key := range v.MapKeys() {
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.Set(v.MapIndex(key))
+			expandValue(value, expand)
+			v.SetMapIndex(key, value)
+		}
+	case reflect.Interface:
+		// Untyped TOML values such as payload_fields hold strings, tables and arrays
+		if !v.IsNil() {
+			value := reflect.New(v.Elem().Type()).Elem()
+			value.Set(v.Elem())
+			expandValue(value, expand)
+			v.Set(value)
+		}
+	}
+}
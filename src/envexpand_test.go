@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestExpandEnv expands references in nested values and leaves other dollar signs alone
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("DIPA_EXPAND_TOKEN", "secret")
+	t.Setenv("DIPA_EXPAND_CHANNEL", "beta")
+	cfg, err := loadTestConfig(t, `
+ipa_headers = { Authorization = "Bearer ${DIPA_EXPAND_TOKEN}" }
+include_pattern = '\.ipa$'
+
+[[targets]]
+github_repo = "example/app"
+github_token = "${DIPA_EXPAND_TOKEN}"
+
+[targets.payload_fields]
+channel = "${DIPA_EXPAND_CHANNEL}"
+tags = ["${DIPA_EXPAND_CHANNEL}", "fixed"]
+build = { note = "from ${DIPA_EXPAND_UNSET}" }
+`)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	if got := cfg.IPAHeaders["Authorization"]; got != "Bearer secret" {
+		t.Errorf("ipa_headers Authorization = %q", got)
+	}
+	if got := cfg.IncludePattern; got != `\.ipa$` {
+		t.Errorf("include_pattern = %q, want it unchanged", got)
+	}
+	if got := cfg.Targets[0].GitHubToken; got != "secret" {
+		t.Errorf("github_token = %q", got)
+	}
+	want := map[string]interface{}{
+		"channel": "beta",
+		"tags":    []interface{}{"beta", "fixed"},
+		"build":   map[string]interface{}{"note": "from "},
+	}
+	if got := cfg.Targets[0].PayloadFields; !reflect.DeepEqual(got, want) {
+		t.Errorf("payload_fields = %#v, want %#v", got, want)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import "time"
+
+// EventType identifies a checker lifecycle event
+type EventType string
+
+// Lifecycle events passed to DipaChecker.OnEvent
+const (
+	EventCheckStarted   EventType = "check_started"
+	EventChangeDetected EventType = "change_detected"
+	EventDispatchResult EventType = "dispatch_result"
+)
+
+// Event describes a checker lifecycle event for programmatic consumers
+type Event struct {
+	Type   EventType
+	Branch string
+	Time   time.Time
+
+	// Hash and Version are set for change_detected and dispatch_result events
+	Hash    string
+	Version string
+	// Result is set for dispatch_result events
+	Result *DispatchResult
+}
+
+// emit passes an event to the OnEvent callback when one is set
+func (c *DipaChecker) emit(e Event) {
+	if c.OnEvent == nil {
+		return
+	}
+
+	c.eventMu.Lock()
+	defer c.eventMu.Unlock()
+
+	e.Time = time.Now()
+	c.OnEvent(e)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOnEvent(t *testing.T) {
+	checker := newTestChecker(t, `branches = ["stable"]`)
+	recorder := serveDispatches(t, checker, listingJSON("Discord_228.0.ipa"))
+
+	events := []Event{}
+	checker.OnEvent = func(e Event) { events = append(events, e) }
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	types := []string{}
+	for _, e := range events {
+		types = append(types, string(e.Type))
+		if e.Branch != "stable" || e.Time.IsZero() {
+			t.Errorf("%s event for branch %q at %s", e.Type, e.Branch, e.Time)
+		}
+	}
+	if got := strings.Join(types, ","); got != "check_started,change_detected,dispatch_result" {
+		t.Fatalf("events %s, want a check, a change and its dispatch result", got)
+	}
+
+	change, result := events[1], events[2]
+	if change.Version != "Discord_228.0.ipa" || change.Hash == "" || result.Hash != change.Hash {
+		t.Errorf("change of %s to %s, result for %s", change.Version, change.Hash, result.Hash)
+	}
+	if result.Result == nil || strings.Join(result.Result.Successful, ",") != "example/app" {
+		t.Errorf("dispatch result %+v, want example/app successful", result.Result)
+	}
+
+	// Without a change only the check is reported
+	events = nil
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Type != EventCheckStarted {
+		t.Errorf("events %v for an unchanged listing, want only check_started", events)
+	}
+	if len(recorder.dispatched()) != 1 {
+		t.Errorf("dispatched %v, want one dispatch", recorder.dispatched())
+	}
+}
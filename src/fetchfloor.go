@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// cachedListing is the last fetched listing of a branch, reused within min_fetch_interval
+type cachedListing struct {
+	files     []IPAFile
+	hash      string
+	fetchedAt time.Time
+}
+
+// recentListing returns the cached listing of a branch if it was fetched less than
+// min_fetch_interval ago
+func (c *DipaChecker) recentListing(branch string) ([]IPAFile, string, bool) {
+	if c.minFetchInterval == 0 {
+		return nil, "", false
+	}
+
+	c.fetchMu.Lock()
+	defer c.fetchMu.Unlock()
+
+	cached, ok := c.lastFetch[branch]
+	if !ok || time.Since(cached.fetchedAt) >= c.minFetchInterval {
+		return nil, "", false
+	}
+	return append([]IPAFile(nil), cached.files...), cached.hash, true
+}
+
+// rememberListing caches a successfully fetched listing when min_fetch_interval is set
+func (c *DipaChecker) rememberListing(branch string, files []IPAFile, hash string) {
+	if c.minFetchInterval == 0 {
+		return
+	}
+
+	c.fetchMu.Lock()
+	defer c.fetchMu.Unlock()
+
+	if c.lastFetch == nil {
+		c.lastFetch = make(map[string]cachedListing)
+	}
+	c.lastFetch[branch] = cachedListing{
+		files:     append([]IPAFile(nil), files...),
+		hash:      hash,
+		fetchedAt: time.Now(),
+	}
+}
+
+// FetchIPAList fetches the IPA list for a branch and calculates its hash. Within
+// min_fetch_interval of the last fetch the previous result is returned instead.
+func (c *DipaChecker) FetchIPAList(branch string) ([]IPAFile, string, error) {
+	if files, hash, ok := c.recentListing(branch); ok {
+		log.Printf("%s listing was fetched less than %s ago, reusing it", branch, c.minFetchInterval)
+		return files, hash, nil
+	}
+
+	files, hash, err := c.fetchAndHash(branch)
+	if err != nil {
+		return nil, "", err
+	}
+
+	c.rememberListing(branch, files, hash)
+	return files, hash, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countFetches serves the listing for every branch, failing while fail is 1, and
+// counts the fetches per branch
+func countFetches(t *testing.T, checker *DipaChecker, fail *int32) func(branch string) int {
+	var mu sync.Mutex
+	fetches := make(map[string]int)
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		fetches[r.URL.Path]++
+		if atomic.LoadInt32(fail) == 1 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(listingJSON("Discord_228.0.ipa"))
+	}))
+
+	return func(branch string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return fetches["/"+branch+"/"]
+	}
+}
+
+func TestMinFetchIntervalSuppressesFetches(t *testing.T) {
+	checker := newTestChecker(t, "branches = [\"stable\", \"testflight\"]\nmin_fetch_interval = \"1h\"\n")
+	var fail int32
+	fetches := countFetches(t, checker, &fail)
+
+	_, first, err := checker.FetchIPAList("stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, second, err := checker.FetchIPAList("stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := fetches("stable"); n != 1 {
+		t.Errorf("stable was fetched %d times within the interval, want once", n)
+	}
+	if second != first {
+		t.Errorf("reused listing has hash %s, want the cached %s", second, first)
+	}
+
+	// The floor applies per branch
+	if _, _, err := checker.FetchIPAList("testflight"); err != nil {
+		t.Fatal(err)
+	}
+	if n := fetches("testflight"); n != 1 {
+		t.Errorf("testflight was fetched %d times, want once", n)
+	}
+}
+
+func TestMinFetchIntervalElapsed(t *testing.T) {
+	checker := newTestChecker(t, "branches = [\"stable\"]\nmin_fetch_interval = \"50ms\"\n")
+	var fail int32
+	fetches := countFetches(t, checker, &fail)
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := checker.FetchIPAList("stable"); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(60 * time.Millisecond)
+	}
+	if n := fetches("stable"); n != 2 {
+		t.Errorf("stable was fetched %d times, want again once the interval elapsed", n)
+	}
+}
+
+func TestMinFetchIntervalSkipsFailures(t *testing.T) {
+	checker := newTestChecker(t, "branches = [\"stable\"]\nmin_fetch_interval = \"1h\"\nmax_retries = 0\n")
+	fail := int32(1)
+	fetches := countFetches(t, checker, &fail)
+
+	if _, _, err := checker.FetchIPAList("stable"); err == nil {
+		t.Fatal("failed fetch returned no error")
+	}
+
+	// Failures are not cached, so the next check fetches again
+	atomic.StoreInt32(&fail, 0)
+	files, _, err := checker.FetchIPAList("stable")
+	if err != nil || len(files) != 1 {
+		t.Fatalf("fetched %d files (%v) after a failure, want the listing", len(files), err)
+	}
+	if n := fetches("stable"); n != 2 {
+		t.Errorf("stable was fetched %d times, want a refetch after the failure", n)
+	}
+}
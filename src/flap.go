@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// hashSighting records a branch hash observed by a check
+type hashSighting struct {
+	Hash string
+	Seen time.Time
+}
+
+// recordHash tracks the observed hash and reports whether the branch is flapping,
+// i.e. its hash returned to a recently seen value more than flap_threshold times within flap_window
+func (c *DipaChecker) recordHash(branch, hash string) bool {
+	if c.Config.FlapThreshold <= 0 {
+		return false
+	}
+
+	now := time.Now()
+
+	c.flapMu.Lock()
+	sightings := append(c.recentHashes[branch], hashSighting{Hash: hash, Seen: now})
+	cutoff := now.Add(-c.flapWindow)
+	for len(sightings) > 0 && sightings[0].Seen.Before(cutoff) {
+		sightings = sightings[1:]
+	}
+	c.recentHashes[branch] = sightings
+	c.flapMu.Unlock()
+
+	toggles := countToggles(sightings)
+	flapping := toggles > c.Config.FlapThreshold
+
+	wasFlapping := false
+	c.updateBranchStatus(branch, func(status *BranchStatus) {
+		wasFlapping = status.Flapping
+		status.Flapping = flapping
+	})
+
+	if flapping && !wasFlapping {
+		c.alert(branch, "Hash flapping",
+			fmt.Sprintf("%s listing hash toggled %d times within %s, dispatching is suspended until it settles",
+				branch, toggles, c.flapWindow))
+	}
+
+	return flapping
+}
+
+// countToggles counts changes back to a hash that was already seen earlier in the sightings
+func countToggles(sightings []hashSighting) int {
+	toggles := 0
+	seen := make(map[string]bool)
+	for i, sighting := range sightings {
+		if i > 0 && sighting.Hash != sightings[i-1].Hash && seen[sighting.Hash] {
+			toggles++
+		}
+		seen[sighting.Hash] = true
+	}
+	return toggles
+}
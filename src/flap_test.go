@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+func TestCountToggles(t *testing.T) {
+	tests := []struct {
+		hashes []string
+		want   int
+	}{
+		{[]string{}, 0},
+		{[]string{"a", "a", "a"}, 0},
+		{[]string{"a", "b", "c"}, 0},
+		{[]string{"a", "b", "a"}, 1},
+		{[]string{"a", "b", "a", "b"}, 2},
+		{[]string{"a", "a", "b", "b", "a"}, 1},
+	}
+
+	for _, tt := range tests {
+		sightings := []hashSighting{}
+		for _, hash := range tt.hashes {
+			sightings = append(sightings, hashSighting{Hash: hash})
+		}
+		if got := countToggles(sightings); got != tt.want {
+			t.Errorf("countToggles(%v) = %d, want %d", tt.hashes, got, tt.want)
+		}
+	}
+}
+
+func TestFlappingHash(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+flap_threshold = 1
+flap_window = "1h"
+`)
+	notifier := &recordingNotifier{}
+	checker.Notifiers = []Notifier{notifier}
+
+	first := listingJSON("Discord_228.0.ipa")
+	second := listingJSON("Discord_228.0.ipa", "Discord_229.0.ipa")
+	recorder := serveDispatches(t, checker, first)
+
+	storedHash := func() string {
+		checker.stateMu.Lock()
+		defer checker.stateMu.Unlock()
+		return checker.BranchData.Branches["stable"].Hash
+	}
+
+	// A mirror out of sync makes the listing alternate between two states
+	hashes := []string{}
+	for i, listing := range [][]byte{first, second, first, second} {
+		recorder.setListing(listing)
+		if err := checker.CheckBranch("stable"); err != nil {
+			t.Fatalf("check %d: %v", i, err)
+		}
+		hashes = append(hashes, storedHash())
+	}
+
+	// The first toggle back is tolerated, after that changes are no longer recorded
+	if hashes[2] != hashes[0] {
+		t.Error("the first toggle back was not recorded")
+	}
+	if hashes[3] != hashes[2] {
+		t.Error("a change was recorded while the hash was flapping")
+	}
+	if got := recorder.dispatched(); len(got) != 2 {
+		t.Errorf("dispatched %d times, want once per listing", len(got))
+	}
+	if alerts := notifier.titled("Hash flapping"); len(alerts) != 1 {
+		t.Errorf("got %d flapping alerts, want 1", len(alerts))
+	}
+	if !checker.BranchStatusSnapshot()["stable"].Flapping {
+		t.Error("stable is not marked flapping in its status")
+	}
+}
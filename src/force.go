@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// ForceDispatch fetches the current listing of a branch and dispatches its latest build to
+// every target, including those already dispatched for the hash, then records the result
+func (c *DipaChecker) ForceDispatch(branch string) (DispatchResult, error) {
+	files, hash, err := c.FetchIPAList(branch)
+	if err != nil {
+		return DispatchResult{}, fmt.Errorf("error fetching IPA list: %w", err)
+	}
+
+	latestVersion := c.selectVersion(files)
+	if latestVersion == nil {
+		return DispatchResult{}, fmt.Errorf("no IPA found for %s", branch)
+	}
+
+	finalURL := ipaURL(c.Config.IPABaseURL, branch, latestVersion.Name)
+	log.Printf("Forcing dispatch of %s: %s", branch, finalURL)
+
+	result, err := c.dispatchVariants(DispatchEvent{
+		Branch:  branch,
+		Hash:    hash,
+		IPAURL:  finalURL,
+		Files:   files,
+		Version: latestVersion.Name,
+		Force:   true,
+	}, c.DispatchGitHubWorkflow)
+	if err != nil {
+		return result, fmt.Errorf("error dispatching workflow: %w", err)
+	}
+	if len(result.Successful) == 0 {
+		return result, nil
+	}
+
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	branchData, ok := c.BranchData.Branches[branch]
+	if !ok {
+		branchData = BranchData{Dispatches: make(map[string][]string)}
+	}
+	if branchData.Hash != hash {
+		branchData.Hash = hash
+		branchData.LastChanged = time.Now()
+		recordLatest(&branchData, *latestVersion)
+		c.recordListing(&branchData, files)
+	}
+	branchData.Pending = result.Pending
+	if result.Winner != "" {
+		branchData.Winner = result.Winner
+	}
+	recordResult(&branchData, hash, result)
+	c.BranchData.Branches[branch] = branchData
+
+	if err := c.saveHashesLocked(); err != nil {
+		return result, fmt.Errorf("error saving hashes: %w", err)
+	}
+	return result, nil
+}
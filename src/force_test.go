@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestForceDispatchRedispatches(t *testing.T) {
+	checker := newTestChecker(t, `branches = ["stable"]`)
+	recorder := serveDispatches(t, checker, listingJSON("Discord_228.0.ipa"))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	hash := storedHash(t, checker, "stable")
+
+	// The target already has the current version, which force dispatches regardless
+	result, err := checker.ForceDispatch("stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Successful, []string{"example/app"}) {
+		t.Errorf("forced dispatch succeeded for %v, want [example/app]", result.Successful)
+	}
+	if got := recorder.dispatched(); len(got) != 2 {
+		t.Errorf("dispatched to %v, want the forced dispatch after the regular one", got)
+	}
+	if !strings.HasSuffix(dispatchedURL(recorder), "/stable/Discord_228.0.ipa") {
+		t.Errorf("forced dispatch sent %q, want the current version", dispatchedURL(recorder))
+	}
+	if storedHash(t, checker, "stable") != hash {
+		t.Error("forcing the current version changed the stored hash")
+	}
+}
+
+// TestForceDispatchRecordsHash forces a version nothing was dispatched for yet and
+// expects the next check not to dispatch it again
+func TestForceDispatchRecordsHash(t *testing.T) {
+	checker := newTestChecker(t, `branches = ["stable"]`)
+	recorder := serveDispatches(t, checker, listingJSON("Discord_228.0.ipa"))
+
+	if _, err := checker.ForceDispatch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if storedHash(t, checker, "stable") == "" {
+		t.Fatal("forced dispatch did not record the hash")
+	}
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if got := recorder.dispatched(); len(got) != 1 {
+		t.Errorf("dispatched to %v, want only the forced dispatch", got)
+	}
+}
+
+func TestForceDispatchEmptyListing(t *testing.T) {
+	checker := newTestChecker(t, `branches = ["stable"]`)
+	recorder := serveDispatches(t, checker, listingJSON())
+
+	if _, err := checker.ForceDispatch("stable"); err == nil || !strings.Contains(err.Error(), "no IPA found for stable") {
+		t.Errorf("error %v, want no IPA found", err)
+	}
+	if got := recorder.dispatched(); len(got) != 0 {
+		t.Errorf("dispatched to %v without an IPA", got)
+	}
+}
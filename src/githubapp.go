@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// defaultAppTokenMargin is used when github_app_token_margin is not set
+const defaultAppTokenMargin = 5 * time.Minute
+
+// appToken is a GitHub App installation token and its expiry
+type appToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// githubToken returns the token used to authenticate requests for a GitHub target,
+// minting a GitHub App installation token or reading Vault when the target uses them
+func (c *DipaChecker) githubToken(target Target) (string, error) {
+	switch {
+	case target.GitHubAppID != 0:
+		return c.installationToken(target)
+	case target.VaultPath != "":
+		return c.vaultToken(target)
+	default:
+		return target.GitHubToken, nil
+	}
+}
+
+// installationToken returns a cached installation token that is not about to expire,
+// or mints and caches a new one
+func (c *DipaChecker) installationToken(target Target) (string, error) {
+	c.appTokenMu.Lock()
+	defer c.appTokenMu.Unlock()
+
+	key := strconv.FormatInt(target.GitHubAppInstallationID, 10)
+	if c.appTokens == nil {
+		c.appTokens = c.loadAppTokenCache()
+	}
+
+	if cached, ok := c.appTokens[key]; ok && time.Now().Add(c.appTokenMargin).Before(cached.ExpiresAt) {
+		return cached.Token, nil
+	}
+
+	token, err := c.mintInstallationToken(target)
+	if err != nil {
+		return "", err
+	}
+
+	c.appTokens[key] = token
+	c.saveAppTokenCache()
+	return token.Token, nil
+}
+
+// mintInstallationToken exchanges an app JWT for a new installation token
+func (c *DipaChecker) mintInstallationToken(target Target) (appToken, error) {
+	jwt, err := appJWT(target.GitHubAppID, target.GitHubAppPrivateKeyPath)
+	if err != nil {
+		return appToken{}, err
+	}
+
+	url := fmt.Sprintf("%s/app/installations/%d/access_tokens", githubAPIURL, target.GitHubAppInstallationID)
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return appToken{}, fmt.Errorf("error creating request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return appToken{}, fmt.Errorf("error requesting installation token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return appToken{}, err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return appToken{}, fmt.Errorf("installation token request returned status %d: %s", resp.StatusCode, trimString(string(body), 200))
+	}
+
+	var token appToken
+	if err := json.Unmarshal(body, &token); err != nil {
+		return appToken{}, fmt.Errorf("error decoding installation token: %w", err)
+	}
+	return token, nil
+}
+
+// appJWT creates the short-lived RS256 JWT that authenticates as the GitHub App
+func appJWT(appID int64, keyPath string) (string, error) {
+	key, err := loadPrivateKey(keyPath)
+	if err != nil {
+		return "", err
+	}
+
+	// Backdate issuance to allow for clock drift, GitHub accepts at most 10 minutes of validity
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": strconv.FormatInt(appID, 10),
+	})
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("error signing app JWT: %w", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// loadPrivateKey reads a PEM encoded RSA private key in PKCS#1 or PKCS#8 form
+func loadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read github_app_private_key_path: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("github_app_private_key_path does not contain a PEM block")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse app private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("app private key is not an RSA key")
+	}
+	return key, nil
+}
+
+// loadAppTokenCache reads cached installation tokens from github_app_token_cache
+func (c *DipaChecker) loadAppTokenCache() map[string]appToken {
+	tokens := make(map[string]appToken)
+	if c.Config.GitHubAppTokenCache == "" {
+		return tokens
+	}
+
+	data, err := os.ReadFile(c.Config.GitHubAppTokenCache)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading app token cache, minting new tokens: %v", err)
+		}
+		return tokens
+	}
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		log.Printf("Error decoding app token cache, minting new tokens: %v", err)
+		return make(map[string]appToken)
+	}
+	return tokens
+}
+
+// saveAppTokenCache writes the installation tokens to github_app_token_cache, readable by the owner only
+func (c *DipaChecker) saveAppTokenCache() {
+	if c.Config.GitHubAppTokenCache == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(c.appTokens, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(c.Config.GitHubAppTokenCache, append(data, '\n'), 0600)
+	}
+	if err != nil {
+		log.Printf("Error saving app token cache: %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// appServer fakes the GitHub installation token endpoint, verifying the app JWT,
+// and serves a stable listing and dispatches
+type appServer struct {
+	mu      sync.Mutex
+	key     *rsa.PublicKey
+	minted  int
+	expires time.Duration
+	// dispatchAuth is the Authorization header of the last other request
+	dispatchAuth string
+}
+
+func (s *appServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/app/installations/7/access_tokens":
+	case "/stable/":
+		w.Write(listingJSON("Discord_228.0.ipa"))
+		return
+	default:
+		s.dispatchAuth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), ".")
+	if len(parts) != 3 {
+		http.Error(w, "not a JWT", http.StatusUnauthorized)
+		return
+	}
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(s.key, crypto.SHA256, digest[:], signature); err != nil {
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+	claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	if !strings.Contains(string(claims), `"iss":"42"`) {
+		http.Error(w, "wrong issuer", http.StatusUnauthorized)
+		return
+	}
+
+	s.minted++
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(appToken{
+		Token:     fmt.Sprintf("installation-%d", s.minted),
+		ExpiresAt: time.Now().Add(s.expires),
+	})
+}
+
+func (s *appServer) mintCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.minted
+}
+
+// newAppChecker creates a checker authenticating as a GitHub App with a fresh key
+func newAppChecker(t *testing.T) (*DipaChecker, *appServer) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "app.pem")
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(keyPath, pemData, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	checker := newTestChecker(t, `
+github_app_token_cache = `+strconvQuote(filepath.Join(dir, "tokens.json"))+`
+
+[[targets]]
+github_repo = "example/app"
+github_app_id = 42
+github_app_installation_id = 7
+github_app_private_key_path = `+strconvQuote(keyPath)+`
+`)
+	server := &appServer{key: &key.PublicKey, expires: time.Hour}
+	serveAll(t, checker, server)
+	return checker, server
+}
+
+func TestAppTokenCached(t *testing.T) {
+	checker, server := newAppChecker(t)
+	target := checker.Config.Targets[0]
+
+	for i := 0; i < 3; i++ {
+		token, err := checker.githubToken(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "installation-1" {
+			t.Errorf("token %q, want the first minted token", token)
+		}
+	}
+	if server.mintCount() != 1 {
+		t.Errorf("minted %d tokens, want 1 reused from the cache", server.mintCount())
+	}
+
+	// The cache file survives a restart and is only readable by the owner
+	info, err := os.Stat(checker.Config.GitHubAppTokenCache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("token cache mode %v, want 0600", info.Mode().Perm())
+	}
+	restarted := reopen(t, checker)
+	restarted.Client = checker.Client
+	if token, err := restarted.githubToken(target); err != nil || token != "installation-1" {
+		t.Errorf("token %q (%v) after a restart, want the cached one", token, err)
+	}
+	if server.mintCount() != 1 {
+		t.Errorf("minted %d tokens, want the cache file reused after a restart", server.mintCount())
+	}
+}
+
+func TestAppTokenRefreshedBeforeExpiry(t *testing.T) {
+	checker, server := newAppChecker(t)
+	target := checker.Config.Targets[0]
+
+	// Tokens expiring within the margin are replaced before they are used
+	server.mu.Lock()
+	server.expires = defaultAppTokenMargin - time.Minute
+	server.mu.Unlock()
+
+	for i := 1; i <= 2; i++ {
+		token, err := checker.githubToken(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("installation-%d", i); token != want {
+			t.Errorf("token %q, want %s", token, want)
+		}
+	}
+}
+
+func TestAppTokenUsedForDispatch(t *testing.T) {
+	checker, server := newAppChecker(t)
+	checker.Config.Branches = []string{"stable"}
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.dispatchAuth != "Bearer installation-1" {
+		t.Errorf("dispatch authorized with %q, want the installation token", server.dispatchAuth)
+	}
+}
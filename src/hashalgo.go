@@ -0,0 +1,28 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+)
+
+// defaultHashAlgorithm is used when hash_algorithm is not set
+const defaultHashAlgorithm = "sha256"
+
+// hashAlgorithms maps hash_algorithm values to their constructors
+var hashAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// newListingHasher returns the hasher of the configured hash_algorithm
+func (c *DipaChecker) newListingHasher() hash.Hash {
+	if newHash, ok := hashAlgorithms[c.Config.HashAlgorithm]; ok {
+		return newHash()
+	}
+	return sha256.New()
+}
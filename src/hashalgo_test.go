@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHashAlgorithmDigest(t *testing.T) {
+	listing := listingJSON("Discord_229.0.ipa", "Discord_228.0.ipa")
+
+	digests := map[string]func(data []byte) []byte{
+		"md5":    func(data []byte) []byte { sum := md5.Sum(data); return sum[:] },
+		"sha1":   func(data []byte) []byte { sum := sha1.Sum(data); return sum[:] },
+		"sha256": func(data []byte) []byte { sum := sha256.Sum256(data); return sum[:] },
+		"sha512": func(data []byte) []byte { sum := sha512.Sum512(data); return sum[:] },
+		// sha256 is the default
+		"": func(data []byte) []byte { sum := sha256.Sum256(data); return sum[:] },
+	}
+
+	for algorithm, digest := range digests {
+		checker := newTestChecker(t, "hash_algorithm = "+strconvQuote(algorithm))
+		serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(listing)
+		}))
+
+		files, hash, err := checker.FetchIPAList("stable")
+		if err != nil {
+			t.Fatalf("%q: FetchIPAList: %v", algorithm, err)
+		}
+		sorted, err := sortAndMarshal(files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := hex.EncodeToString(digest(sorted)); hash != want {
+			t.Errorf("%q: hash %s, want %s", algorithm, hash, want)
+		}
+	}
+}
+
+func TestHashAlgorithmChangeDetectedOnce(t *testing.T) {
+	checker := newTestChecker(t, `branches = ["stable"]`)
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stable/":
+			w.Write(listingJSON("Discord_228.0.ipa"))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+
+	changed := func() bool {
+		if err := checker.CheckBranch("stable"); err != nil {
+			t.Fatal(err)
+		}
+		checker.statusMu.Lock()
+		defer checker.statusMu.Unlock()
+		return checker.BranchStatus["stable"].Changed
+	}
+
+	if !changed() || changed() {
+		t.Fatal("expected the first check to detect a change and the second not to")
+	}
+
+	// Hashes of another algorithm never match, so switching counts as one change
+	checker.Config.HashAlgorithm = "sha1"
+	if !changed() {
+		t.Error("switching hash_algorithm was not treated as a change")
+	}
+	if changed() {
+		t.Error("the listing kept changing after switching hash_algorithm")
+	}
+}
+
+func TestValidateHashAlgorithm(t *testing.T) {
+	tests := []struct {
+		config  string
+		wantErr string
+	}{
+		{`hash_algorithm = "md5"`, ""},
+		{`hash_algorithm = "sha512"`, ""},
+		{`hash_algorithm = "crc32"`, "hash_algorithm must be"},
+		{`hash_algorithm = "SHA256"`, "hash_algorithm must be"},
+		{"hash_algorithm = \"sha1\"\nincremental_hash = true", "cannot be combined with incremental_hash"},
+		{"hash_algorithm = \"sha256\"\nincremental_hash = true", ""},
+	}
+
+	for _, tt := range tests {
+		_, err := loadTestConfig(t, tt.config)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.config, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error %v, want %q", tt.config, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// checksumPath returns the path of the checksum sidecar for a file
+func checksumPath(path string) string {
+	return path + ".sha256"
+}
+
+// backupPath returns the path of the backup copy of a file
+func backupPath(path string) string {
+	return path + ".bak"
+}
+
+// checksumHex returns the hex encoded SHA256 of data
+func checksumHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over path
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	return os.Rename(tmpName, path)
+}
+
+// copyFile copies src to dst atomically
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(dst, data, 0644)
+}
+
+// verifyChecksum checks data against the checksum sidecar of path.
+// A missing sidecar is treated as valid so existing installs can enable verification.
+func verifyChecksum(path string, data []byte) error {
+	expected, err := os.ReadFile(checksumPath(path))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	actual := checksumHex(data)
+	if strings.TrimSpace(string(expected)) != actual {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s",
+			path, strings.TrimSpace(string(expected)), actual)
+	}
+
+	return nil
+}
+
+// readVerifiedHashFile reads the hash file, restoring it from the backup if its checksum does not match
+func readVerifiedHashFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	verifyErr := verifyChecksum(path, data)
+	if verifyErr == nil {
+		return data, nil
+	}
+
+	log.Printf("!!! HASH FILE CORRUPTED: %v", verifyErr)
+
+	backup := backupPath(path)
+	backupData, err := os.ReadFile(backup)
+	if err != nil {
+		return nil, fmt.Errorf("%v; no usable backup at %s: %w", verifyErr, backup, err)
+	}
+	if err := verifyChecksum(backup, backupData); err != nil {
+		return nil, fmt.Errorf("%v; backup is corrupted too: %w", verifyErr, err)
+	}
+
+	log.Printf("!!! Restoring hash file from backup %s", backup)
+	if err := writeFileAtomic(path, backupData, 0644); err != nil {
+		return nil, fmt.Errorf("failed to restore hash file from backup: %w", err)
+	}
+	if err := writeFileAtomic(checksumPath(path), []byte(checksumHex(backupData)+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("failed to restore hash file checksum: %w", err)
+	}
+
+	return backupData, nil
+}
+
+// writeVerifiedHashFile backs up the current hash file and writes data along with its checksum
+func writeVerifiedHashFile(path string, data []byte) error {
+	if _, err := os.Stat(path); err == nil {
+		if err := copyFile(path, backupPath(path)); err != nil {
+			return fmt.Errorf("failed to back up hash file: %w", err)
+		}
+		if _, err := os.Stat(checksumPath(path)); err == nil {
+			if err := copyFile(checksumPath(path), checksumPath(backupPath(path))); err != nil {
+				return fmt.Errorf("failed to back up hash file checksum: %w", err)
+			}
+		}
+	}
+
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(checksumPath(path), []byte(checksumHex(data)+"\n"), 0644)
+}
+
+// compactBranchData is the on-disk form of BranchData with interned repo names
+type compactBranchData struct {
+	BranchData
+	Dispatches map[string][]int `json:"dispatches"`
+}
+
+// compactBranchHashes is the on-disk form of BranchHashes with interned repo names
+type compactBranchHashes struct {
+	BranchHashes
+	Repos    []string                     `json:"repos"`
+	Branches map[string]compactBranchData `json:"branches"`
+}
+
+// compactBranchFile is the on-disk form of a split_state_files branch file with interned repo names
+type compactBranchFile struct {
+	compactBranchData
+	Repos []string `json:"repos"`
+}
+
+// encodeHashes marshals the branch hashes, interning repo names when compact is set
+func encodeHashes(hashes *BranchHashes, compact bool) ([]byte, error) {
+	var data []byte
+	var err error
+	if compact {
+		data, err = json.MarshalIndent(internRepos(hashes), "", "  ")
+	} else {
+		data, err = json.MarshalIndent(hashes, "", "  ")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return append(data, '\n'), nil
+}
+
+// decodeHashes unmarshals branch hashes stored in either the plain or the compact format
+func decodeHashes(data []byte, hashes *BranchHashes) error {
+	var probe struct {
+		Repos []string `json:"repos"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return err
+	}
+
+	if probe.Repos == nil {
+		return json.Unmarshal(data, hashes)
+	}
+
+	var compact compactBranchHashes
+	if err := json.Unmarshal(data, &compact); err != nil {
+		return err
+	}
+
+	return expandRepos(&compact, hashes)
+}
+
+// encodeBranchData marshals the state of a single branch, interning repo names when compact is set
+func encodeBranchData(branchData BranchData, compact bool) ([]byte, error) {
+	var data []byte
+	var err error
+	if compact {
+		interned := internRepos(&BranchHashes{Branches: map[string]BranchData{"": branchData}})
+		data, err = json.MarshalIndent(compactBranchFile{
+			compactBranchData: interned.Branches[""],
+			Repos:             interned.Repos,
+		}, "", "  ")
+	} else {
+		data, err = json.MarshalIndent(branchData, "", "  ")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return append(data, '\n'), nil
+}
+
+// decodeBranchData unmarshals the state of a single branch stored in either format
+func decodeBranchData(data []byte, branchData *BranchData) error {
+	var probe struct {
+		Repos []string `json:"repos"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return err
+	}
+
+	if probe.Repos == nil {
+		return json.Unmarshal(data, branchData)
+	}
+
+	var file compactBranchFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+
+	var hashes BranchHashes
+	err := expandRepos(&compactBranchHashes{
+		Repos:    file.Repos,
+		Branches: map[string]compactBranchData{"": file.compactBranchData},
+	}, &hashes)
+	if err != nil {
+		return err
+	}
+
+	*branchData = hashes.Branches[""]
+	return nil
+}
+
+// internRepos replaces repo names in the dispatch history with indexes into a shared table
+func internRepos(hashes *BranchHashes) *compactBranchHashes {
+	compact := &compactBranchHashes{
+		BranchHashes: *hashes,
+		Repos:        []string{},
+		Branches:     make(map[string]compactBranchData, len(hashes.Branches)),
+	}
+	indexes := make(map[string]int)
+
+	// Intern in sorted order so the output is stable between saves
+	branches := make([]string, 0, len(hashes.Branches))
+	for branch := range hashes.Branches {
+		branches = append(branches, branch)
+	}
+	sort.Strings(branches)
+
+	for _, branch := range branches {
+		branchData := hashes.Branches[branch]
+		compactData := compactBranchData{
+			BranchData: branchData,
+			Dispatches: make(map[string][]int, len(branchData.Dispatches)),
+		}
+
+		hashKeys := make([]string, 0, len(branchData.Dispatches))
+		for hash := range branchData.Dispatches {
+			hashKeys = append(hashKeys, hash)
+		}
+		sort.Strings(hashKeys)
+
+		for _, hash := range hashKeys {
+			refs := make([]int, 0, len(branchData.Dispatches[hash]))
+			for _, repo := range branchData.Dispatches[hash] {
+				index, ok := indexes[repo]
+				if !ok {
+					index = len(compact.Repos)
+					indexes[repo] = index
+					compact.Repos = append(compact.Repos, repo)
+				}
+				refs = append(refs, index)
+			}
+			compactData.Dispatches[hash] = refs
+		}
+
+		compact.Branches[branch] = compactData
+	}
+
+	return compact
+}
+
+// expandRepos resolves interned repo indexes back into repo names
+func expandRepos(compact *compactBranchHashes, hashes *BranchHashes) error {
+	*hashes = compact.BranchHashes
+	hashes.Branches = make(map[string]BranchData, len(compact.Branches))
+
+	for branch, compactData := range compact.Branches {
+		branchData := compactData.BranchData
+		branchData.Dispatches = make(map[string][]string, len(compactData.Dispatches))
+
+		for hash, refs := range compactData.Dispatches {
+			repos := make([]string, 0, len(refs))
+			for _, index := range refs {
+				if index < 0 || index >= len(compact.Repos) {
+					return fmt.Errorf("invalid repo reference %d in %s dispatches", index, branch)
+				}
+				repos = append(repos, compact.Repos[index])
+			}
+			branchData.Dispatches[hash] = repos
+		}
+
+		hashes.Branches[branch] = branchData
+	}
+
+	return nil
+}
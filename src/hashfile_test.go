@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadVerifiedHashFileRestoresBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "branch_hashes.json")
+	if err := writeVerifiedHashFile(path, []byte(`{"version":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeVerifiedHashFile(path, []byte(`{"version":2}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A torn write leaves the file out of step with its checksum
+	if err := os.WriteFile(path, []byte(`{"vers`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := readVerifiedHashFile(path)
+	if err != nil {
+		t.Fatalf("readVerifiedHashFile: %v", err)
+	}
+	if string(data) != `{"version":1}` {
+		t.Errorf("restored %s, want the backup", data)
+	}
+
+	// The restored file and its checksum are written back
+	onDisk, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(onDisk) != `{"version":1}` {
+		t.Errorf("hash file holds %s after the restore, want the backup", onDisk)
+	}
+	if err := verifyChecksum(path, onDisk); err != nil {
+		t.Errorf("restored hash file does not verify: %v", err)
+	}
+}
+
+func TestReadVerifiedHashFileCorruptedBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "branch_hashes.json")
+	if err := writeVerifiedHashFile(path, []byte(`{"version":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeVerifiedHashFile(path, []byte(`{"version":2}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(backupPath(path), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := readVerifiedHashFile(path)
+	if err == nil || !strings.Contains(err.Error(), "backup is corrupted too") {
+		t.Errorf("expected an error for a corrupted backup, got %v", err)
+	}
+}
+
+func TestReadVerifiedHashFileWithoutChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "branch_hashes.json")
+	if err := os.WriteFile(path, []byte(`{"version":1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hash files written before checksums existed are read as they are
+	data, err := readVerifiedHashFile(path)
+	if err != nil {
+		t.Fatalf("readVerifiedHashFile: %v", err)
+	}
+	if string(data) != `{"version":1}` {
+		t.Errorf("read %s, want the hash file", data)
+	}
+}
+
+func TestCorruptedHashFileRestoredOnStart(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+verify_hash_checksum = true
+`)
+	for _, hash := range []string{"first", "second"} {
+		hash := hash
+		if err := checker.updateBranch("stable", func(branchData *BranchData) {
+			branchData.Hash = hash
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.WriteFile(checker.HashFile, []byte(`{"branches":`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := reopen(t, checker)
+	if got := restarted.BranchData.Branches["stable"].Hash; got != "first" {
+		t.Errorf("stable hash is %q after restoring the backup, want %q", got, "first")
+	}
+}
+
+func TestCompactHashesRoundTrip(t *testing.T) {
+	changed := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	hashes := BranchHashes{
+		Branches: map[string]BranchData{
+			"stable": {
+				Hash:        "h2",
+				LastChanged: changed,
+				Dispatches: map[string][]string{
+					"h1": {"example/app", "example/other"},
+					"h2": {"example/other", "example/app"},
+				},
+			},
+			"testflight": {
+				Hash:       "t1",
+				Dispatches: map[string][]string{"t1": {"example/app"}},
+			},
+		},
+		LastPayloads:    map[string]string{"example/app": `{"ipa_url":"x"}`},
+		BlockedVersions: []string{"Discord_227.0.ipa"},
+	}
+
+	compact, err := encodeHashes(&hashes, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := encodeHashes(&hashes, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each repo name is stored once, however many hashes reference it
+	if n := strings.Count(string(compact), `"example/app"`); n != 2 {
+		t.Errorf("example/app appears %d times in the compact file, want once in repos and once in last_payloads", n)
+	}
+	if len(compact) >= len(plain) {
+		t.Errorf("compact file is %d bytes, plain %d", len(compact), len(plain))
+	}
+
+	for name, data := range map[string][]byte{"compact": compact, "plain": plain} {
+		var decoded BranchHashes
+		if err := decodeHashes(data, &decoded); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(decoded, hashes) {
+			t.Errorf("%s round trip gave %+v, want %+v", name, decoded, hashes)
+		}
+	}
+
+	// Saves are stable, so an unchanged state encodes identically
+	again, _ := encodeHashes(&hashes, true)
+	if string(again) != string(compact) {
+		t.Error("encoding the same state twice gave different files")
+	}
+}
+
+func TestCompactHashesInvalidReference(t *testing.T) {
+	data := []byte(`{"repos": ["example/app"], "branches": {"stable": {"hash": "h1", "dispatches": {"h1": [0, 3]}}}}`)
+
+	var decoded BranchHashes
+	if err := decodeHashes(data, &decoded); err == nil || !strings.Contains(err.Error(), "invalid repo reference 3") {
+		t.Errorf("expected an invalid reference error, got %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// TargetHealth represents the last known reachability of a dispatch target
+type TargetHealth struct {
+	Healthy   bool      `json:"healthy"`
+	Status    string    `json:"status"`
+	CheckedAt time.Time `json:"checked_at"`
+	// CooldownUntil is set while dispatches to the target are suspended
+	CooldownUntil time.Time `json:"cooldown_until"`
+}
+
+// defaultActionsDisabledCooldown is used when actions_disabled_cooldown is not set
+const defaultActionsDisabledCooldown = 24 * time.Hour
+
+// actionsDisabledPattern matches GitHub's error message for repos with Actions disabled
+var actionsDisabledPattern = regexp.MustCompile(`(?i)actions (has been|is|are) disabled`)
+
+// CheckTargets verifies that every configured target still exists and is reachable
+func (c *DipaChecker) CheckTargets() {
+	log.Println("Checking target health...")
+
+	checked, unhealthy := 0, 0
+	for _, target := range c.Config.Targets {
+		// Only enabled GitHub repositories can be looked up
+		if target.Disabled || target.Provider != providerGitHub {
+			continue
+		}
+
+		health := c.checkTarget(target)
+		checked++
+
+		c.healthMu.Lock()
+		// A reachable repo can still have Actions disabled, keep that state until its cooldown ends
+		if previous, ok := c.TargetHealth[target.Name()]; ok && time.Now().Before(previous.CooldownUntil) {
+			health = previous
+		}
+		c.TargetHealth[target.Name()] = health
+		c.healthMu.Unlock()
+
+		if !health.Healthy {
+			unhealthy++
+			log.Printf("Target %s is unhealthy: %s", target.Name(), health.Status)
+		}
+	}
+
+	log.Printf("Target health check complete: %d/%d healthy",
+		checked-unhealthy, checked)
+}
+
+// checkTarget performs a lightweight repository lookup for a target
+func (c *DipaChecker) checkTarget(target Target) TargetHealth {
+	health := TargetHealth{CheckedAt: time.Now()}
+
+	url := fmt.Sprintf("%s/repos/%s", githubAPIURL, target.GitHubRepo)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		health.Status = err.Error()
+		return health
+	}
+
+	token, err := c.githubToken(target)
+	if err != nil {
+		health.Status = fmt.Sprintf("no token: %v", err)
+		return health
+	}
+
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		health.Status = fmt.Sprintf("unreachable: %v", err)
+		return health
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		health.Healthy = true
+		health.Status = "ok"
+	case http.StatusNotFound:
+		health.Status = "repository not found"
+	case http.StatusUnauthorized, http.StatusForbidden:
+		health.Status = fmt.Sprintf("unauthorized (status %d)", resp.StatusCode)
+	default:
+		health.Status = fmt.Sprintf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return health
+}
+
+// TargetHealthSnapshot returns a copy of the current target health state
+func (c *DipaChecker) TargetHealthSnapshot() map[string]TargetHealth {
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+
+	snapshot := make(map[string]TargetHealth, len(c.TargetHealth))
+	for repo, health := range c.TargetHealth {
+		snapshot[repo] = health
+	}
+	return snapshot
+}
+
+// isActionsDisabledError reports whether a dispatch failed because the repo has Actions disabled
+func isActionsDisabledError(err error) bool {
+	var dispatchErr *DispatchError
+	if !errors.As(err, &dispatchErr) {
+		return false
+	}
+
+	if dispatchErr.StatusCode != http.StatusForbidden && dispatchErr.StatusCode != http.StatusUnprocessableEntity {
+		return false
+	}
+
+	return actionsDisabledPattern.MatchString(dispatchErr.Body)
+}
+
+// markActionsDisabled marks a target unhealthy by its Name and suspends dispatches to it
+// for the cooldown
+func (c *DipaChecker) markActionsDisabled(name string) {
+	now := time.Now()
+	until := now.Add(c.actionsDisabledCooldown)
+	log.Printf("GitHub Actions is disabled for %s, suspending dispatches until %s", name, until.Format(time.RFC1123))
+
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+
+	c.TargetHealth[name] = TargetHealth{
+		Healthy:       false,
+		Status:        "actions disabled",
+		CheckedAt:     now,
+		CooldownUntil: until,
+	}
+}
+
+// actionsDisabledUntil returns the end of the Actions-disabled cooldown of a target by its
+// Name, if one is active
+func (c *DipaChecker) actionsDisabledUntil(name string) (time.Time, bool) {
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+
+	health, ok := c.TargetHealth[name]
+	if !ok || !time.Now().Before(health.CooldownUntil) {
+		return time.Time{}, false
+	}
+	return health.CooldownUntil, true
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const healthConfig = `
+target_health_interval = "1h"
+
+[[targets]]
+github_repo = "example/app"
+github_token = "token"
+
+[[targets]]
+github_repo = "example/gone"
+github_token = "token"
+
+[[targets]]
+github_repo = "example/private"
+github_token = "token"
+`
+
+// newHealthChecker checks a target that exists, one that was deleted and one the token cannot see
+func newHealthChecker(t *testing.T, config string) *DipaChecker {
+	checker := newTestChecker(t, config)
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/example/app":
+			w.Write([]byte(`{"full_name":"example/app"}`))
+		case "/repos/example/private":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	checker.CheckTargets()
+	return checker
+}
+
+func TestCheckTargets(t *testing.T) {
+	checker := newHealthChecker(t, healthConfig)
+
+	health := checker.TargetHealthSnapshot()
+	want := map[string]struct {
+		healthy bool
+		status  string
+	}{
+		"example/app":     {true, "ok"},
+		"example/gone":    {false, "repository not found"},
+		"example/private": {false, "unauthorized (status 403)"},
+	}
+	for repo, expected := range want {
+		got, ok := health[repo]
+		if !ok {
+			t.Errorf("%s was not checked", repo)
+			continue
+		}
+		if got.Healthy != expected.healthy || got.Status != expected.status {
+			t.Errorf("%s: healthy %v (%s), want %v (%s)", repo, got.Healthy, got.Status, expected.healthy, expected.status)
+		}
+	}
+}
+
+func TestStatusFileTargetHealth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.json")
+	checker := newHealthChecker(t, "status_file = "+strconvQuote(path)+"\n"+healthConfig)
+
+	if err := checker.writeStatusFile(true); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var status statusFile
+	if err := json.Unmarshal(data, &status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Targets) != 3 {
+		t.Fatalf("status file lists %d targets, want 3", len(status.Targets))
+	}
+	if status.Targets["example/gone"].Healthy || !status.Targets["example/app"].Healthy {
+		t.Errorf("status file target health is wrong: %+v", status.Targets)
+	}
+}
+
+func TestHealthzTargetHealth(t *testing.T) {
+	checker := newHealthChecker(t, healthConfig)
+
+	recorder := httptest.NewRecorder()
+	checker.handleHealthz(recorder, httptest.NewRequest("GET", "/healthz", nil))
+
+	// An unhealthy target is reported without failing the liveness check
+	if recorder.Code != http.StatusOK {
+		t.Errorf("status code %d, want 200", recorder.Code)
+	}
+	var response healthResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Status != "ok" {
+		t.Errorf("status %q, want ok", response.Status)
+	}
+	if health, ok := response.Targets["example/gone"]; !ok || health.Healthy {
+		t.Errorf("/healthz does not report example/gone as unhealthy: %+v", response.Targets)
+	}
+}
+
+func TestPrintTargetHealth(t *testing.T) {
+	var out bytes.Buffer
+	printTargetHealth(&out, map[string]TargetHealth{
+		"example/gone": {Status: "repository not found"},
+		"example/app":  {Healthy: true, Status: "ok"},
+	})
+
+	want := strings.Join([]string{
+		"targets:",
+		"  example/app: healthy (ok)",
+		"  example/gone: UNHEALTHY (repository not found)",
+		"",
+	}, "\n")
+	if out.String() != want {
+		t.Errorf("printed\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestIsActionsDisabledError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&DispatchError{StatusCode: 403, Body: `{"message":"Actions has been disabled for this repository."}`}, true},
+		{&DispatchError{StatusCode: 422, Body: `{"message":"Actions are disabled for this repo"}`}, true},
+		{&DispatchError{StatusCode: 403, Body: `{"message":"Resource not accessible by integration"}`}, false},
+		{&DispatchError{StatusCode: 500, Body: `{"message":"Actions has been disabled"}`}, false},
+		{fmt.Errorf("wrapped: %w", &DispatchError{StatusCode: 403, Body: "actions is disabled"}), true},
+		{errors.New("actions has been disabled"), false},
+	}
+
+	for _, tt := range tests {
+		if got := isActionsDisabledError(tt.err); got != tt.want {
+			t.Errorf("isActionsDisabledError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestActionsDisabledCooldown(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+actions_disabled_cooldown = "1h"
+`)
+
+	var mu sync.Mutex
+	listing := listingJSON("Discord_228.0.ipa")
+	dispatches := 0
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.URL.Path == "/stable/" {
+			w.Write(listing)
+			return
+		}
+		dispatches++
+		http.Error(w, `{"message":"Actions has been disabled for this repository."}`, http.StatusForbidden)
+	}))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	health := checker.TargetHealthSnapshot()["example/app"]
+	if health.Healthy || health.Status != "actions disabled" || time.Until(health.CooldownUntil) < 59*time.Minute {
+		t.Fatalf("example/app health after an Actions-disabled response: %+v", health)
+	}
+
+	// During the cooldown the target is not dispatched to at all
+	mu.Lock()
+	listing = listingJSON("Discord_228.0.ipa", "Discord_229.0.ipa")
+	mu.Unlock()
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if dispatches != 1 {
+		t.Errorf("got %d dispatch attempts, want none during the cooldown", dispatches)
+	}
+}
+
+// TestActionsDisabledWorkflowTarget keeps the cooldown of a workflow target through a
+// target health check, which tracks it under the same name
+func TestActionsDisabledWorkflowTarget(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+actions_disabled_cooldown = "1h"
+
+[[targets]]
+github_repo = "example/app"
+github_token = "token"
+dispatch_mode = "workflow"
+workflow_file = "build.yml"
+ref = "main"
+`)
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stable/":
+			w.Write(listingJSON("Discord_228.0.ipa"))
+		case "/repos/example/app":
+			w.Write([]byte(`{"full_name":"example/app"}`))
+		default:
+			http.Error(w, `{"message":"Actions has been disabled for this repository."}`, http.StatusForbidden)
+		}
+	}))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	checker.CheckTargets()
+
+	snapshot := checker.TargetHealthSnapshot()
+	if _, ok := snapshot["example/app"]; ok {
+		t.Errorf("health tracked under the repo as well as the target name: %v", snapshot)
+	}
+	health := snapshot["example/app:build.yml"]
+	if health.Status != "actions disabled" || time.Until(health.CooldownUntil) < 59*time.Minute {
+		t.Errorf("example/app:build.yml health after a health check: %+v", health)
+	}
+	if _, ok := checker.actionsDisabledUntil("example/app:build.yml"); !ok {
+		t.Error("cooldown ended with the health check")
+	}
+}
+
+// TestHealthzETag polls /healthz with the returned ETag and expects 304 until a check changes the state
+func TestHealthzETag(t *testing.T) {
+	checker := newTestChecker(t, "branches = [\"stable\"]\n")
+	serveDispatches(t, checker, listingJSON("Discord_228.0.ipa"))
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("GET", "/healthz", nil)
+		if ifNoneMatch != "" {
+			request.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		recorder := httptest.NewRecorder()
+		checker.handleHealthz(recorder, request)
+		return recorder
+	}
+
+	first := get("")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("status %d with ETag %q, want 200 with an ETag", first.Code, etag)
+	}
+
+	for _, header := range []string{etag, `"other", ` + etag, "W/" + etag, "*"} {
+		if unchanged := get(header); unchanged.Code != http.StatusNotModified || unchanged.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: status %d with %d bytes, want an empty 304", header, unchanged.Code, unchanged.Body.Len())
+		}
+	}
+	if stale := get(`"other"`); stale.Code != http.StatusOK {
+		t.Errorf("status %d for a different ETag, want 200", stale.Code)
+	}
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	changed := get(etag)
+	if changed.Code != http.StatusOK || changed.Header().Get("ETag") == etag {
+		t.Errorf("status %d with ETag %q after a check, want 200 with a new ETag", changed.Code, changed.Header().Get("ETag"))
+	}
+}
+
+func TestStatusFileReflectsLatestCycle(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "status.json")
+	checker := newTestChecker(t, "branches = [\"stable\"]\nstatus_file = "+strconvQuote(path)+"\n")
+	recorder := serveDispatches(t, checker, listingJSON("Discord_228.0.ipa"))
+
+	readStatus := func() statusFile {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var status statusFile
+		if err := json.Unmarshal(data, &status); err != nil {
+			t.Fatal(err)
+		}
+		return status
+	}
+
+	if !checker.CheckAll([]string{"stable"}) {
+		t.Fatal("first cycle failed")
+	}
+	first := readStatus()
+	stable := first.Branches["stable"]
+	if !first.Healthy || !stable.Changed || stable.Hash == "" {
+		t.Fatalf("first cycle status %+v, want a healthy change with a hash", first)
+	}
+	if len(stable.Dispatched) != 1 || stable.Dispatched[0] != "example/app" {
+		t.Errorf("first cycle dispatched %v, want [example/app]", stable.Dispatched)
+	}
+
+	// An unchanged listing is recorded as checked again without a change
+	if !checker.CheckAll([]string{"stable"}) {
+		t.Fatal("second cycle failed")
+	}
+	second := readStatus()
+	if second.Branches["stable"].Changed || second.Branches["stable"].Hash != stable.Hash {
+		t.Errorf("second cycle status %+v, want the same hash unchanged", second.Branches["stable"])
+	}
+	if !second.Branches["stable"].LastCheck.After(stable.LastCheck) || second.UpdatedAt.Before(first.UpdatedAt) {
+		t.Error("status file was not rewritten by the second cycle")
+	}
+
+	recorder.setListing(listingJSON("Discord_228.0.ipa", "Discord_229.0.ipa"))
+	checker.CheckAll([]string{"stable"})
+	third := readStatus().Branches["stable"]
+	if !third.Changed || third.Hash == stable.Hash || third.Version != "Discord_229.0.ipa" {
+		t.Errorf("third cycle status %+v, want the new version's change", third)
+	}
+
+	// The file is replaced atomically, so no temporary files are left beside it
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("status directory holds %d files, want only the status file", len(entries))
+	}
+}
+
+func TestHealthzReportsChecks(t *testing.T) {
+	checker := newTestChecker(t, "branches = [\"stable\"]\n")
+	serveDispatches(t, checker, listingJSON("Discord_228.0.ipa"))
+	server := httptest.NewServer(checker.NewHealthServer(0).Handler)
+	defer server.Close()
+
+	start := time.Now()
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get(server.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("status %d with content type %q, want 200 JSON", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	var response healthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Status != "ok" || response.TrackedHashes != 1 {
+		t.Errorf("status %q tracking %d hashes, want ok tracking 1", response.Status, response.TrackedHashes)
+	}
+	if last := response.Branches["stable"]; last.Before(start.Truncate(time.Second)) {
+		t.Errorf("last success of stable is %s, want the check just run", last)
+	}
+}
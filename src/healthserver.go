@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// healthResponse is the body of /healthz
+type healthResponse struct {
+	Status        string               `json:"status"`
+	Branches      map[string]time.Time `json:"last_success"`
+	TrackedHashes int                  `json:"tracked_hashes"`
+	// Targets is only reported with target_health_interval set, an unhealthy target
+	// does not fail the liveness check
+	Targets map[string]TargetHealth `json:"targets,omitempty"`
+}
+
+// NewHealthServer creates the HTTP server exposing /healthz on port
+func (c *DipaChecker) NewHealthServer(port int) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", c.handleHealthz)
+
+	return &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// handleHealthz reports the last successful check per branch, the number of tracked hashes
+// and the health of the targets, answering 304 when If-None-Match has the current ETag
+func (c *DipaChecker) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	response := healthResponse{
+		Status:   "ok",
+		Branches: make(map[string]time.Time),
+		Targets:  c.TargetHealthSnapshot(),
+	}
+	for branch, status := range c.BranchStatusSnapshot() {
+		response.Branches[branch] = status.LastSuccess
+		response.TrackedHashes += status.TrackedHashes
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Pollers can send the ETag back to skip the body while nothing changed
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// etagMatches reports whether an If-None-Match header lists etag
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// SendHeartbeat pings the heartbeat URL after a check cycle.
+// Failed cycles ping heartbeat_failure_url instead when it is set.
+func (c *DipaChecker) SendHeartbeat(failed bool) {
+	url := c.Config.HeartbeatURL
+	if failed {
+		url = c.Config.HeartbeatFailureURL
+	}
+	if url == "" {
+		return
+	}
+
+	if err := c.ping(url); err != nil {
+		log.Printf("Error sending heartbeat: %v", err)
+	}
+}
+
+// ping sends a GET request to url and expects a 2xx response
+func (c *DipaChecker) ping(url string) error {
+	resp, err := c.Client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
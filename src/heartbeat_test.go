@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestHeartbeatEachCycle(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+heartbeat_url = "https://hc.example.com/ping"
+heartbeat_failure_url = "https://hc.example.com/fail"
+`)
+
+	var mu sync.Mutex
+	listingUp := true
+	pings := map[string]int{}
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.URL.Path {
+		case "/stable/":
+			if !listingUp {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Write(listingJSON("Discord_228.0.ipa"))
+		case "/ping", "/fail":
+			pings[r.URL.Path]++
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+
+	// The first cycle dispatches, the next two see nothing new; each still pings
+	for i := 0; i < 3; i++ {
+		if !checker.CheckAll(checker.Config.Branches) {
+			t.Fatalf("cycle %d failed", i)
+		}
+	}
+	mu.Lock()
+	if pings["/ping"] != 3 || pings["/fail"] != 0 {
+		t.Errorf("pings %v after three successful cycles, want 3 heartbeats", pings)
+	}
+	listingUp = false
+	mu.Unlock()
+
+	if checker.CheckAll(checker.Config.Branches) {
+		t.Fatal("cycle succeeded with the listing down")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if pings["/ping"] != 3 || pings["/fail"] != 1 {
+		t.Errorf("pings %v after a failed cycle, want one failure ping", pings)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http"
+)
+
+// newHTTP3Transport creates the QUIC transport used for listing fetches when listing_http3
+// is set. It is nil unless dipa-auto is built with -tags http3, see http3_quic.go.
+var newHTTP3Transport func(tlsConfig *tls.Config) http.RoundTripper
+
+// fallbackTransport sends requests over primary and retries those that fail on fallback
+type fallbackTransport struct {
+	primary  http.RoundTripper
+	fallback http.RoundTripper
+}
+
+func (t fallbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.primary.RoundTrip(req)
+	// A request body may have been consumed already, and a canceled request stays canceled
+	if err == nil || req.Body != nil || req.Context().Err() != nil {
+		return resp, err
+	}
+
+	log.Printf("HTTP/3 request to %s failed, falling back to HTTP/2: %v", req.URL.Host, err)
+	return t.fallback.RoundTrip(req)
+}
+
+// listingClient returns the client for listing fetches, which tries HTTP/3 first when
+// listing_http3 is set and falls back to the regular transport
+func (c *DipaChecker) listingClient() *http.Client {
+	if c.http3 == nil {
+		return c.Client
+	}
+
+	fallback := c.Client.Transport
+	if fallback == nil {
+		fallback = http.DefaultTransport
+	}
+	client := *c.Client
+	client.Transport = fallbackTransport{primary: c.http3, fallback: fallback}
+	return &client
+}
@@ -0,0 +1,18 @@
+//go:build http3
+
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// HTTP/3 support pulls in quic-go, so it is only built with -tags http3
+func init() {
+	newHTTP3Transport = func(tlsConfig *tls.Config) http.RoundTripper {
+		// The minimum TLS version and the IPA host pin carry over, QUIC itself requires TLS 1.3
+		return &http3.Transport{TLSClientConfig: tlsConfig.Clone()}
+	}
+}
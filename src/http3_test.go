@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// quicStub stands in for the QUIC transport, answering listing requests or failing them
+type quicStub struct {
+	mu       sync.Mutex
+	hosts    []string
+	fail     bool
+	fallback http.RoundTripper
+}
+
+func (s *quicStub) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.mu.Lock()
+	s.hosts = append(s.hosts, req.URL.Host)
+	fail := s.fail
+	s.mu.Unlock()
+
+	if fail {
+		return nil, errors.New("no QUIC route to host")
+	}
+	return s.fallback.RoundTrip(req)
+}
+
+func (s *quicStub) requested() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.hosts...)
+}
+
+// withHTTP3 builds checkers with stub as their HTTP/3 transport
+func withHTTP3(t *testing.T, stub *quicStub) {
+	t.Helper()
+
+	previous := newHTTP3Transport
+	newHTTP3Transport = func(*tls.Config) http.RoundTripper { return stub }
+	t.Cleanup(func() { newHTTP3Transport = previous })
+}
+
+func TestListingHTTP3(t *testing.T) {
+	for _, fail := range []bool{false, true} {
+		stub := &quicStub{fail: fail}
+		withHTTP3(t, stub)
+		checker := newTestChecker(t, "listing_http3 = true\nbranches = [\"stable\"]\n")
+		recorder := serveDispatches(t, checker, listingJSON("Discord_228.0.ipa"))
+		stub.fallback = checker.Client.Transport
+
+		if err := checker.CheckBranch("stable"); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(stub.requested(), ","); got != "ipa.example.com" {
+			t.Errorf("HTTP/3 transport requested %q (failing %v), want only the listing", got, fail)
+		}
+		// Listings fetched over HTTP/3 and through the fallback both lead to the dispatch
+		if got := recorder.dispatched(); len(got) != 1 {
+			t.Errorf("dispatched to %v (HTTP/3 failing %v), want example/app", got, fail)
+		}
+	}
+}
+
+func TestListingHTTP3NotBuilt(t *testing.T) {
+	previous := newHTTP3Transport
+	newHTTP3Transport = nil
+	t.Cleanup(func() { newHTTP3Transport = previous })
+
+	checker := newTestChecker(t, "listing_http3 = true\n")
+	if checker.http3 != nil || checker.listingClient() != checker.Client {
+		t.Error("listings don't use the regular client without HTTP/3 support")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// jenkinsCrumb is the CSRF crumb returned by the Jenkins crumb issuer
+type jenkinsCrumb struct {
+	CrumbRequestField string `json:"crumbRequestField"`
+	Crumb             string `json:"crumb"`
+	// cookies hold the web session the crumb was issued for
+	cookies []*http.Cookie
+}
+
+// jenkinsRoot returns the Jenkins root URL of a job URL, e.g. https://ci.example.com
+// for https://ci.example.com/job/folder/job/app-build
+func jenkinsRoot(jobURL string) string {
+	if i := strings.Index(jobURL, "/job/"); i >= 0 {
+		return jobURL[:i]
+	}
+	return strings.TrimRight(jobURL, "/")
+}
+
+// buildJenkinsParameters encodes the client payload as buildWithParameters form values
+func buildJenkinsParameters(clientPayload map[string]interface{}) ([]byte, error) {
+	// Build parameters are strings, so non-string values are sent as JSON
+	parameters, err := stringParameters(clientPayload)
+	if err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+	for key, value := range parameters {
+		values.Set(key, value)
+	}
+	return []byte(values.Encode()), nil
+}
+
+// fetchJenkinsCrumb requests a CSRF crumb, returning nil when CSRF protection is disabled
+func (c *DipaChecker) fetchJenkinsCrumb(target Target) (*jenkinsCrumb, error) {
+	req, err := http.NewRequest("GET", jenkinsRoot(target.JenkinsJobURL)+"/crumbIssuer/api/json", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating crumb request: %w", err)
+	}
+	req.SetBasicAuth(target.JenkinsUser, target.JenkinsToken)
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching crumb: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Without CSRF protection there is no crumb issuer
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &DispatchError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var crumb jenkinsCrumb
+	if err := json.NewDecoder(resp.Body).Decode(&crumb); err != nil {
+		return nil, fmt.Errorf("error decoding crumb: %w", err)
+	}
+	crumb.cookies = resp.Cookies()
+	return &crumb, nil
+}
+
+// postJenkinsBuild triggers a parameterized Jenkins build through buildWithParameters
+func (c *DipaChecker) postJenkinsBuild(target Target, body []byte) error {
+	crumb, err := c.fetchJenkinsCrumb(target)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimRight(target.JenkinsJobURL, "/")+"/buildWithParameters", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+
+	req.SetBasicAuth(target.JenkinsUser, target.JenkinsToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if target.ContentType != "" {
+		req.Header.Set("Content-Type", target.ContentType)
+	}
+	// Jenkins only accepts a crumb from the session it was issued in
+	if crumb != nil {
+		req.Header.Set(crumb.CrumbRequestField, crumb.Crumb)
+		for _, cookie := range crumb.cookies {
+			req.AddCookie(cookie)
+		}
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Jenkins answers 201 with the queue item, older versions 200
+	accepted := target.acceptsStatus(resp.StatusCode, http.StatusCreated) ||
+		(len(target.SuccessStatuses) == 0 && resp.StatusCode == http.StatusOK)
+	if !accepted {
+		respBody, _ := io.ReadAll(resp.Body)
+		return &DispatchError{StatusCode: resp.StatusCode, Body: string(respBody), RetryAfter: rateLimitDelay(resp.Header)}
+	}
+
+	return nil
+}
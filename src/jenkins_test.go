@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const jenkinsConfig = `
+branches = ["stable"]
+dispatch_retries = 0
+
+[[targets]]
+provider = "jenkins"
+jenkins_job_url = "https://ci.example.com/job/ios/job/app-build/"
+jenkins_user = "dipa"
+jenkins_token = "api-token"
+`
+
+// jenkinsServer is a fake Jenkins answering builds with status, with CSRF protection
+// unless csrfDisabled is set
+type jenkinsServer struct {
+	t            *testing.T
+	csrfDisabled bool
+	status       int
+
+	mu     sync.Mutex
+	builds []*http.Request
+	params []map[string]string
+}
+
+func (s *jenkinsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, pass, ok := r.BasicAuth(); r.URL.Path != "/stable/" && (!ok || user != "dipa" || pass != "api-token") {
+		s.t.Errorf("%s %s: basic auth %q/%q, want the user and API token", r.Method, r.URL.Path, user, pass)
+	}
+
+	switch r.URL.Path {
+	case "/stable/":
+		w.Write(listingJSON("Discord_228.0.ipa"))
+	case "/crumbIssuer/api/json":
+		if s.csrfDisabled {
+			http.NotFound(w, r)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "JSESSIONID.abc", Value: "session"})
+		w.Write([]byte(`{"crumbRequestField":"Jenkins-Crumb","crumb":"c0ffee"}`))
+	case "/job/ios/job/app-build/buildWithParameters":
+		if err := r.ParseForm(); err != nil {
+			s.t.Error(err)
+		}
+		params := map[string]string{}
+		for key := range r.PostForm {
+			params[key] = r.PostForm.Get(key)
+		}
+		s.builds = append(s.builds, r)
+		s.params = append(s.params, params)
+		w.WriteHeader(s.status)
+	default:
+		s.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		http.NotFound(w, r)
+	}
+}
+
+func TestJenkinsBuildWithCrumb(t *testing.T) {
+	checker := newTestChecker(t, jenkinsConfig)
+	server := &jenkinsServer{t: t, status: http.StatusCreated}
+	serveAll(t, checker, server)
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.builds) != 1 {
+		t.Fatalf("triggered %d builds, want 1", len(server.builds))
+	}
+	build := server.builds[0]
+	if crumb := build.Header.Get("Jenkins-Crumb"); crumb != "c0ffee" {
+		t.Errorf("crumb header %q, want the issued crumb", crumb)
+	}
+	if cookie, err := build.Cookie("JSESSIONID.abc"); err != nil || cookie.Value != "session" {
+		t.Errorf("session cookie %v (%v), want the session the crumb was issued in", cookie, err)
+	}
+	if url := server.params[0]["ipa_url"]; !strings.HasSuffix(url, "/stable/Discord_228.0.ipa") {
+		t.Errorf("ipa_url parameter %q, want the new IPA", url)
+	}
+	if server.params[0]["is_testflight"] != "false" {
+		t.Errorf("is_testflight parameter %q, want false", server.params[0]["is_testflight"])
+	}
+	if failed := checker.BranchStatusSnapshot()["stable"].Failed; len(failed) != 0 {
+		t.Errorf("failed %v, want the 201 counted as success", failed)
+	}
+}
+
+func TestJenkinsBuildStatuses(t *testing.T) {
+	checker := newTestChecker(t, jenkinsConfig)
+	server := &jenkinsServer{t: t, csrfDisabled: true}
+	serveAll(t, checker, server)
+	target := checker.Config.Targets[0]
+
+	for status, ok := range map[int]bool{
+		http.StatusCreated:   true,
+		http.StatusOK:        true,
+		http.StatusNoContent: false,
+		http.StatusForbidden: false,
+	} {
+		server.mu.Lock()
+		server.status = status
+		server.mu.Unlock()
+
+		err := checker.sendDispatch(target, []byte("ipa_url=x"))
+		if (err == nil) != ok {
+			t.Errorf("status %d: error %v, want success %v", status, err, ok)
+		}
+	}
+
+	// Without CSRF protection builds are triggered without a crumb
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for _, build := range server.builds {
+		if crumb := build.Header.Get("Jenkins-Crumb"); crumb != "" {
+			t.Errorf("sent crumb %q without a crumb issuer", crumb)
+		}
+	}
+}
+
+func TestJenkinsValidation(t *testing.T) {
+	base := "[[targets]]\nprovider = \"jenkins\"\n"
+	tests := []struct {
+		fields, wantErr string
+	}{
+		{"jenkins_job_url = \"ci.example.com/job/app\"\njenkins_user = \"u\"\njenkins_token = \"t\"\n", "must be a valid URL"},
+		{"jenkins_job_url = \"https://ci.example.com/\"\njenkins_user = \"u\"\njenkins_token = \"t\"\n", "must point to a job"},
+		{"jenkins_job_url = \"https://ci.example.com/job/app\"\njenkins_user = \"u\"\n", "jenkins_user and jenkins_token are required"},
+		{"jenkins_job_url = \"https://ci.example.com/job/app\"\njenkins_user = \"u\"\njenkins_token = \"t\"\n", ""},
+	}
+
+	for _, tt := range tests {
+		_, err := loadTestConfig(t, base+tt.fields)
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: %v", tt.fields, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: error %v, want %q", tt.fields, err, tt.wantErr)
+		}
+	}
+}
+
+func TestJenkinsRoot(t *testing.T) {
+	for jobURL, want := range map[string]string{
+		"https://ci.example.com/job/app-build":            "https://ci.example.com",
+		"https://ci.example.com/jenkins/job/ios/job/app/": "https://ci.example.com/jenkins",
+		"https://ci.example.com/":                         "https://ci.example.com",
+	} {
+		if got := jenkinsRoot(jobURL); got != want {
+			t.Errorf("jenkinsRoot(%q) = %q, want %q", jobURL, got, want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// kafkaBufferSize bounds the events kept while the broker is unavailable
+const kafkaBufferSize = 100
+
+// KafkaProducer publishes a keyed message to a Kafka topic
+type KafkaProducer interface {
+	Produce(topic, key string, value []byte) error
+}
+
+// KafkaEvent is the message published for every dispatch
+type KafkaEvent struct {
+	Branch     string    `json:"branch"`
+	Version    string    `json:"version"`
+	URL        string    `json:"url"`
+	Successful []string  `json:"successful"`
+	Failed     []string  `json:"failed"`
+	Instance   string    `json:"instance,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// kafkaMessage is an event waiting to be published
+type kafkaMessage struct {
+	key   string
+	value []byte
+}
+
+// KafkaNotifier publishes dispatch events to a Kafka topic keyed by branch.
+// Events that fail to publish are buffered and retried with the next event.
+type KafkaNotifier struct {
+	Topic    string
+	Producer KafkaProducer
+
+	mu      sync.Mutex
+	pending []kafkaMessage
+}
+
+func (k *KafkaNotifier) exportsEvents() {}
+
+// Notify publishes the dispatch event of an update notification, ignoring alerts
+func (k *KafkaNotifier) Notify(n Notification) error {
+	if n.Version == "" {
+		return nil
+	}
+
+	value, err := json.Marshal(KafkaEvent{
+		Branch:     n.Branch,
+		Version:    n.Version,
+		URL:        n.URL,
+		Successful: n.Successful,
+		Failed:     n.Failed,
+		Instance:   n.Instance,
+		Time:       time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.pending = append(k.pending, kafkaMessage{key: n.Branch, value: value})
+	if dropped := len(k.pending) - kafkaBufferSize; dropped > 0 {
+		log.Printf("Kafka buffer full, dropping %d oldest events", dropped)
+		k.pending = k.pending[dropped:]
+	}
+
+	for len(k.pending) > 0 {
+		message := k.pending[0]
+		if err := k.Producer.Produce(k.Topic, message.key, message.value); err != nil {
+			return fmt.Errorf("kafka unavailable, %d events buffered: %w", len(k.pending), err)
+		}
+		k.pending = k.pending[1:]
+	}
+
+	return nil
+}
+
+// KafkaRESTProducer publishes messages through a Confluent-compatible Kafka REST proxy
+type KafkaRESTProducer struct {
+	URL    string
+	Client *http.Client
+}
+
+// Produce posts a single JSON record to the topic
+func (p *KafkaRESTProducer) Produce(topic, key string, value []byte) error {
+	payloadBytes, err := json.Marshal(map[string]interface{}{
+		"records": []map[string]interface{}{
+			{"key": key, "value": json.RawMessage(value)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	endpoint := strings.TrimSuffix(p.URL, "/") + "/topics/" + url.PathEscape(topic)
+	resp, err := p.Client.Post(endpoint, "application/vnd.kafka.json.v2+json", bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("kafka REST proxy returned status %d: %s", resp.StatusCode, trimString(string(body), 200))
+	}
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestKafkaDispatchEvent dispatches to one accepting and one rejecting target and
+// expects a single message keyed by branch describing the outcome
+func TestKafkaDispatchEvent(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+dispatch_retries = 0
+
+[[targets]]
+github_repo = "example/ok"
+github_token = "token"
+
+[[targets]]
+github_repo = "example/broken"
+github_token = "token"
+`)
+	producer := &recordingProducer{}
+	checker.Notifiers = []Notifier{&KafkaNotifier{Topic: "dispatches", Producer: producer}}
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stable/":
+			w.Write(listingJSON("Discord_228.0.ipa"))
+		case "/repos/example/broken/dispatches":
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(producer.values) != 1 {
+		t.Fatalf("published %d messages, want 1", len(producer.values))
+	}
+	if producer.topics[0] != "dispatches" || producer.keys[0] != "stable" {
+		t.Errorf("published to %s with key %s, want dispatches keyed by stable", producer.topics[0], producer.keys[0])
+	}
+
+	var event KafkaEvent
+	if err := json.Unmarshal(producer.values[0], &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Branch != "stable" || event.Version != "Discord_228.0.ipa" || !strings.HasSuffix(event.URL, "/stable/Discord_228.0.ipa") {
+		t.Errorf("event describes %s %s at %s", event.Branch, event.Version, event.URL)
+	}
+	if strings.Join(event.Successful, ",") != "example/ok" || strings.Join(event.Failed, ",") != "example/broken" {
+		t.Errorf("event outcome %v succeeded, %v failed", event.Successful, event.Failed)
+	}
+	if event.Instance != "test" || event.Time.IsZero() {
+		t.Errorf("event instance %q at %s, want instance test with a time", event.Instance, event.Time)
+	}
+}
+
+// TestKafkaEveryTargetFailed publishes dispatches no target took, which never notify
+func TestKafkaEveryTargetFailed(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+dispatch_retries = 0
+
+[[targets]]
+github_repo = "example/one"
+github_token = "token"
+
+[[targets]]
+github_repo = "example/two"
+github_token = "token"
+`)
+	notifier := &recordingNotifier{}
+	producer := &recordingProducer{}
+	checker.Notifiers = []Notifier{notifier, &KafkaNotifier{Topic: "dispatches", Producer: producer}}
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stable/" {
+			w.Write(listingJSON("Discord_228.0.ipa"))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := notifier.titled(""); len(got) != 0 {
+		t.Errorf("got %d notifications for a failed dispatch, want none", len(got))
+	}
+	if len(producer.values) != 1 {
+		t.Fatalf("published %d messages, want 1", len(producer.values))
+	}
+	var event KafkaEvent
+	if err := json.Unmarshal(producer.values[0], &event); err != nil {
+		t.Fatal(err)
+	}
+	if len(event.Successful) != 0 || strings.Join(event.Failed, ",") != "example/one,example/two" {
+		t.Errorf("event outcome %v succeeded, %v failed, want both failed", event.Successful, event.Failed)
+	}
+	if event.Version != "Discord_228.0.ipa" {
+		t.Errorf("event version %q", event.Version)
+	}
+}
+
+func TestKafkaBuffersWhileUnavailable(t *testing.T) {
+	producer := &recordingProducer{unavailable: true}
+	notifier := &KafkaNotifier{Topic: "dispatches", Producer: producer}
+
+	// Alerts carry no version and are not dispatch events
+	if err := notifier.Notify(Notification{Title: "Listing unreachable", Branch: "stable"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < kafkaBufferSize+2; i++ {
+		if err := notifier.Notify(Notification{Branch: "stable", Version: fmt.Sprintf("Discord_%d.0.ipa", i)}); err == nil {
+			t.Fatal("no error while the broker is unavailable")
+		}
+	}
+	if len(producer.values) != 0 {
+		t.Fatalf("published %d messages while unavailable", len(producer.values))
+	}
+
+	producer.unavailable = false
+	if err := notifier.Notify(Notification{Branch: "testflight", Version: "Discord_999.0.ipa"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The oldest events past the buffer size are dropped, the rest go out in order
+	if len(producer.values) != kafkaBufferSize {
+		t.Fatalf("published %d messages after recovering, want the %d buffered", len(producer.values), kafkaBufferSize)
+	}
+	var first, last KafkaEvent
+	json.Unmarshal(producer.values[0], &first)
+	json.Unmarshal(producer.values[len(producer.values)-1], &last)
+	if first.Version != "Discord_3.0.ipa" || last.Version != "Discord_999.0.ipa" {
+		t.Errorf("published %s through %s, want Discord_3.0.ipa through Discord_999.0.ipa", first.Version, last.Version)
+	}
+}
+
+func TestKafkaRESTProducer(t *testing.T) {
+	checker := newTestChecker(t, "")
+	var got struct {
+		Records []struct {
+			Key   string          `json:"key"`
+			Value json.RawMessage `json:"value"`
+		} `json:"records"`
+	}
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/topics/dipa events" || r.Header.Get("Content-Type") != "application/vnd.kafka.json.v2+json" {
+			t.Errorf("unexpected request to %s as %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+
+	producer := &KafkaRESTProducer{URL: "https://kafka.example.com/", Client: checker.Client}
+	if err := producer.Produce("dipa events", "stable", []byte(`{"branch":"stable"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Records) != 1 || got.Records[0].Key != "stable" || string(got.Records[0].Value) != `{"branch":"stable"}` {
+		t.Errorf("records %+v", got.Records)
+	}
+}
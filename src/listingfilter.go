@@ -0,0 +1,39 @@
+package main
+
+import "regexp"
+
+// compileFilePatterns compiles include_pattern and exclude_pattern, either may be empty
+func compileFilePatterns(include, exclude string) (*regexp.Regexp, *regexp.Regexp, error) {
+	var includeRe, excludeRe *regexp.Regexp
+	var err error
+	if include != "" {
+		if includeRe, err = regexp.Compile(include); err != nil {
+			return nil, nil, err
+		}
+	}
+	if exclude != "" {
+		if excludeRe, err = regexp.Compile(exclude); err != nil {
+			return nil, nil, err
+		}
+	}
+	return includeRe, excludeRe, nil
+}
+
+// filterFiles keeps the files whose names match include_pattern and not exclude_pattern
+func (c *DipaChecker) filterFiles(files []IPAFile) []IPAFile {
+	if c.includePattern == nil && c.excludePattern == nil {
+		return files
+	}
+
+	filtered := make([]IPAFile, 0, len(files))
+	for _, file := range files {
+		if c.includePattern != nil && !c.includePattern.MatchString(file.Name) {
+			continue
+		}
+		if c.excludePattern != nil && c.excludePattern.MatchString(file.Name) {
+			continue
+		}
+		filtered = append(filtered, file)
+	}
+	return filtered
+}
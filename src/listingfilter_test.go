@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestFilterFiles(t *testing.T) {
+	files := []IPAFile{
+		{Name: "Discord_228.0.ipa"},
+		{Name: "Discord_228.0.ipa.sha256"},
+		{Name: ".DS_Store"},
+		{Name: "Discord_229.0.ipa"},
+		{Name: "Discord_229.0.ipa.sha256"},
+		{Name: "Discord_229.0-debug.ipa"},
+	}
+
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{"no patterns", "", []string{
+			"Discord_228.0.ipa", "Discord_228.0.ipa.sha256", ".DS_Store",
+			"Discord_229.0.ipa", "Discord_229.0.ipa.sha256", "Discord_229.0-debug.ipa",
+		}},
+		{"include", `include_pattern = '\.ipa$'`, []string{
+			"Discord_228.0.ipa", "Discord_229.0.ipa", "Discord_229.0-debug.ipa",
+		}},
+		{"exclude", `exclude_pattern = '\.sha256$'`, []string{
+			"Discord_228.0.ipa", ".DS_Store", "Discord_229.0.ipa", "Discord_229.0-debug.ipa",
+		}},
+		{"both", "include_pattern = '\\.ipa$'\nexclude_pattern = '-debug'", []string{
+			"Discord_228.0.ipa", "Discord_229.0.ipa",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := newTestChecker(t, tt.config)
+
+			got := []string{}
+			for _, file := range checker.filterFiles(files) {
+				got = append(got, file.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filterFiles = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilteredListingHash(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+include_pattern = '\.ipa$'
+`)
+
+	listing := listingJSON("Discord_228.0.ipa")
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(listing)
+	}))
+
+	_, plainHash, err := checker.FetchIPAList("stable")
+	if err != nil {
+		t.Fatalf("FetchIPAList: %v", err)
+	}
+
+	// Checksums next to the IPA, and newer than it, change neither the hash nor the latest file
+	listing = listingJSON("Discord_228.0.ipa", "Discord_228.0.ipa.sha256")
+	files, mixedHash, err := checker.FetchIPAList("stable")
+	if err != nil {
+		t.Fatalf("FetchIPAList: %v", err)
+	}
+	if mixedHash != plainHash {
+		t.Errorf("checksum files changed the listing hash")
+	}
+	if latest := checker.GetLatestVersion(files); latest == nil || latest.Name != "Discord_228.0.ipa" {
+		t.Errorf("expected the IPA as the latest file, got %+v", latest)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"syscall"
+	"time"
+)
+
+// lockInfo is stored in the lock file to identify the instance holding it
+type lockInfo struct {
+	PID       int       `json:"pid"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// lockPath returns the path of the lock file guarding the hash file
+func (c *DipaChecker) lockPath() string {
+	return c.HashFile + ".lock"
+}
+
+// AcquireLock takes the hash file lock so only one instance runs against it.
+// A lock left behind by a process that no longer exists is reclaimed.
+func (c *DipaChecker) AcquireLock() error {
+	path := c.lockPath()
+	data, err := json.Marshal(lockInfo{PID: os.Getpid(), StartedAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = file.Write(data)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			return err
+		}
+		if !os.IsExist(err) {
+			return fmt.Errorf("failed to create lock file: %w", err)
+		}
+
+		holder, err := readLock(path)
+		if err != nil {
+			return err
+		}
+		// In containers every instance may run as the same PID, which can't be holding the lock yet
+		if holder.PID != os.Getpid() && processAlive(holder.PID) {
+			return fmt.Errorf("hash file is locked by another instance (pid %d, started %s)",
+				holder.PID, holder.StartedAt.Format(time.RFC1123))
+		}
+
+		log.Printf("Reclaiming stale lock from pid %d started %s, the instance is no longer running",
+			holder.PID, holder.StartedAt.Format(time.RFC1123))
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove stale lock file: %w", err)
+		}
+	}
+
+	return errors.New("failed to acquire lock, another instance took it concurrently")
+}
+
+// ReleaseLock removes the hash file lock
+func (c *DipaChecker) ReleaseLock() {
+	if err := os.Remove(c.lockPath()); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing lock file: %v", err)
+	}
+}
+
+// readLock reads the lock file, treating an unreadable one as held by no process
+func readLock(path string) (lockInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return lockInfo{}, nil
+		}
+		return lockInfo{}, fmt.Errorf("failed to read lock file: %w", err)
+	}
+
+	var info lockInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		log.Printf("Lock file %s is corrupt, treating it as stale: %v", path, err)
+		return lockInfo{}, nil
+	}
+	return info, nil
+}
+
+// processAlive reports whether a process with the given PID exists
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	// Signal 0 only checks for existence, EPERM means it exists under another user
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
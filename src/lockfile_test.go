@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeLock leaves a lock file held by pid
+func writeLock(t *testing.T, checker *DipaChecker, pid int) {
+	t.Helper()
+
+	data, _ := json.Marshal(lockInfo{PID: pid, StartedAt: time.Now().Add(-time.Hour)})
+	if err := os.WriteFile(checker.lockPath(), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// exitedPID runs a process to completion and returns its now unused PID
+func exitedPID(t *testing.T) int {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestLockHeldByLiveInstance(t *testing.T) {
+	checker := newTestChecker(t, "")
+	writeLock(t, checker, os.Getppid())
+
+	err := checker.AcquireLock()
+	if err == nil || !strings.Contains(err.Error(), "locked by another instance") {
+		t.Errorf("error %v, want the live holder to keep the lock", err)
+	}
+}
+
+func TestStaleLockReclaimed(t *testing.T) {
+	checker := newTestChecker(t, "")
+
+	for name, write := range map[string]func(){
+		"exited process": func() { writeLock(t, checker, exitedPID(t)) },
+		// A restarted container can reuse our PID for its previous instance
+		"own pid": func() { writeLock(t, checker, os.Getpid()) },
+		"corrupt": func() { os.WriteFile(checker.lockPath(), []byte("{"), 0644) },
+	} {
+		write()
+		if err := checker.AcquireLock(); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+
+		holder, err := readLock(checker.lockPath())
+		if err != nil || holder.PID != os.Getpid() {
+			t.Errorf("%s: lock held by %d (%v) after reclaiming, want this process", name, holder.PID, err)
+		}
+		checker.ReleaseLock()
+	}
+
+	if _, err := os.Stat(checker.lockPath()); !os.IsNotExist(err) {
+		t.Errorf("lock file left after ReleaseLock: %v", err)
+	}
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -11,6 +15,34 @@ import (
 )
 
 func main() {
+	// --dry-run and --strict are equivalent to DRY_RUN=true and STRICT_CONFIG=true.
+	// They go before a subcommand, e.g. dipa-auto --dry-run once.
+	flags := flag.NewFlagSet("dipa-auto", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "log dispatches instead of sending them and never write the hash file")
+	strict := flags.Bool("strict", false, "verify the config against the environment at startup")
+	flags.Parse(os.Args[1:])
+	if *dryRun {
+		os.Setenv("DRY_RUN", "true")
+	}
+	if *strict {
+		os.Setenv("STRICT_CONFIG", "true")
+	}
+
+	// Run a subcommand instead of the service if one was given
+	if handled, err := runCommand(flags.Args()); handled {
+		var exitErr *ExitCodeError
+		if errors.As(err, &exitErr) {
+			if exitErr.Message != "" {
+				log.Println(exitErr.Message)
+			}
+			os.Exit(exitErr.Code)
+		}
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
+
 	log.Println("Starting dipa-auto...")
 
 	// Load configuration
@@ -19,45 +51,72 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	if cfg.DryRun {
+		log.Println("Dry run, dispatches are only logged and the hash file is not written")
+	}
+
+	// Tag log lines so dispatches can be attributed in multi-instance setups
+	if cfg.InstanceID != "" {
+		log.SetPrefix("[" + cfg.InstanceID + "] ")
+	}
+
 	// Create checker
 	dipaChecker, err := NewChecker(cfg)
 	if err != nil {
 		log.Fatalf("Failed to create checker: %v", err)
 	}
 
+	// Read Vault tokens up front so missing secrets fail at startup
+	if err := dipaChecker.LoadVaultTokens(); err != nil {
+		log.Fatalf("Failed to load Vault tokens: %v", err)
+	}
+
+	// Make sure no other instance runs against the same hash file
+	if cfg.LockHashFile {
+		if err := dipaChecker.AcquireLock(); err != nil {
+			log.Fatalf("Failed to lock hash file: %v", err)
+		}
+		defer dipaChecker.ReleaseLock()
+	}
+
+	// Verify tokens and network path with a real dispatch if configured
+	if cfg.SelfTestRepo != "" {
+		if err := dipaChecker.SelfTest(); err != nil {
+			if cfg.SelfTestExitOnFailure {
+				log.Fatalf("Startup self-test failed: %v", err)
+			}
+			log.Printf("Startup self-test failed, continuing: %v", err)
+		}
+	}
+
 	// Set up cron scheduler
-	c := cron.New()
+	c := cron.New(cron.WithParser(cronParser(cfg)))
 	
-	// Define the check function without referencing entryID yet
+	// Declared before the check function so it can look up its own next run
+	var entryID cron.EntryID
 	checkFunc := func() {
 		log.Println("Starting scheduled check...")
-		
-		// Check both branches
-		if err := dipaChecker.CheckBranch("stable"); err != nil {
-			log.Printf("Error checking stable branch: %v", err)
-		}
-		
-		// Add a small delay between checks
-		time.Sleep(5 * time.Second)
-		
-		if err := dipaChecker.CheckBranch("testflight"); err != nil {
-			log.Printf("Error checking testflight branch: %v", err)
-		}
+		dipaChecker.CheckAll(cfg.Branches)
 		
 		// Log next scheduled run
-		entries := c.Entries()
-		if len(entries) > 0 {
-			nextRun := entries[0].Next
-			log.Printf("Check complete. Next run scheduled at: %s", nextRun.Format(time.RFC1123))
-		}
+		nextRun := c.Entry(entryID).Next
+		log.Printf("Check complete. Next run scheduled at: %s", nextRun.Format(time.RFC1123))
 	}
 	
 	// Add the function to the scheduler
-	entryID, err := c.AddFunc(cfg.RefreshSchedule, checkFunc)
+	entryID, err = c.AddFunc(cfg.RefreshSchedule, checkFunc)
 	if err != nil {
 		log.Fatalf("Failed to schedule cron job: %v", err)
 	}
 	
+	// Schedule periodic target health checks if configured
+	if cfg.TargetHealthInterval != "" {
+		if _, err := c.AddFunc("@every "+cfg.TargetHealthInterval, dipaChecker.CheckTargets); err != nil {
+			log.Fatalf("Failed to schedule target health checks: %v", err)
+		}
+		go dipaChecker.CheckTargets()
+	}
+	
 	// Start the scheduler
 	c.Start()
 	
@@ -67,6 +126,38 @@ func main() {
 	log.Printf("Scheduler started with cron expression: %s", cfg.RefreshSchedule)
 	log.Printf("Next check scheduled at: %s", nextRun.Format(time.RFC1123))
 
+	// Establish a baseline without waiting for the first scheduled run
+	go dipaChecker.RunInitialCheck(cfg.Branches)
+
+	// Serve Prometheus metrics if configured
+	var metricsServer *http.Server
+	if cfg.MetricsPort > 0 {
+		metricsServer = dipaChecker.Metrics.NewMetricsServer(cfg.MetricsPort)
+		go func() {
+			log.Printf("Serving /metrics on port %d", cfg.MetricsPort)
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("Metrics server failed: %v", err)
+			}
+		}()
+	}
+
+	// Serve liveness checks if configured
+	var healthServer *http.Server
+	if cfg.HealthPort > 0 {
+		healthServer = dipaChecker.NewHealthServer(cfg.HealthPort)
+		go func() {
+			log.Printf("Serving /healthz on port %d", cfg.HealthPort)
+			if err := healthServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("Health server failed: %v", err)
+			}
+		}()
+	}
+
+	// Check every branch right away on SIGUSR1, serialized against scheduled checks
+	checkCh := make(chan os.Signal, 1)
+	signal.Notify(checkCh, syscall.SIGUSR1)
+	go dipaChecker.CheckOnSignal(checkCh, cfg.Branches)
+
 	// Set up signal handling for graceful shutdown
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -74,6 +165,20 @@ func main() {
 	// Wait for termination signal
 	<-sigCh
 	log.Println("Shutdown signal received, stopping scheduler...")
-	c.Stop()
+	<-c.Stop().Done()
+	for _, server := range []*http.Server{healthServer, metricsServer} {
+		if server == nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Error stopping HTTP server on %s: %v", server.Addr, err)
+		}
+		cancel()
+	}
+	if err := dipaChecker.Flush(); err != nil {
+		log.Printf("Error saving hashes on shutdown: %v", err)
+	}
+	dipaChecker.reportShutdown()
 	log.Println("dipa-auto stopped")
 }
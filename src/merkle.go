@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"time"
+)
+
+// fileLeafHashes returns the hash of every listing entry, keyed by file name
+func fileLeafHashes(files []IPAFile) map[string]string {
+	leaves := make(map[string]string, len(files))
+	for _, file := range files {
+		leaf := file.Name + "\x00" + file.ModTime.UTC().Format(time.RFC3339Nano)
+		if file.ContentHash != "" {
+			leaf = file.Name + "\x00" + file.ContentHash
+		}
+		sum := sha256.Sum256([]byte(leaf))
+		leaves[file.Name] = hex.EncodeToString(sum[:])
+	}
+	return leaves
+}
+
+// merkleRoot folds the leaf hashes, ordered by file name, into a single root hash
+func merkleRoot(leaves map[string]string) string {
+	names := make([]string, 0, len(leaves))
+	for name := range leaves {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	level := make([][]byte, 0, len(names))
+	for _, name := range names {
+		leaf, _ := hex.DecodeString(leaves[name])
+		level = append(level, leaf)
+	}
+
+	if len(level) == 0 {
+		sum := sha256.Sum256(nil)
+		return hex.EncodeToString(sum[:])
+	}
+
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			// An odd node at the end of a level is paired with itself
+			right := level[i]
+			if i+1 < len(level) {
+				right = level[i+1]
+			}
+			sum := sha256.Sum256(append(append([]byte{}, level[i]...), right...))
+			next = append(next, sum[:])
+		}
+		level = next
+	}
+
+	return hex.EncodeToString(level[0])
+}
+
+// diffLeafHashes localizes the entries that changed between two sets of leaf hashes
+func diffLeafHashes(previous, current map[string]string) ListingDiff {
+	diff := ListingDiff{}
+	for name, leaf := range current {
+		old, ok := previous[name]
+		if !ok {
+			diff.Added = append(diff.Added, name)
+		} else if old != leaf {
+			diff.Changed = append(diff.Changed, name)
+		}
+	}
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
+
+// recordListing keeps the listing state needed to explain the next hash change, and the
+// latest file of each variant whose dispatch records stay current. Canary rollouts of
+// earlier listings are dropped.
+func (c *DipaChecker) recordListing(branchData *BranchData, files []IPAFile) {
+	if c.variantRegex != nil {
+		branchData.Variants = c.variantFiles(files)
+	}
+	dropStaleCanaries(branchData)
+	if c.Config.LogListingDiff || c.Config.NotifyChangelog {
+		branchData.Files = files
+	}
+	if c.Config.IncrementalHash {
+		branchData.FileHashes = fileLeafHashes(files)
+	}
+}
+
+// listingDiff explains what changed since the stored listing, when diff tracking is enabled
+func (c *DipaChecker) listingDiff(branchData BranchData, files []IPAFile) (ListingDiff, bool) {
+	switch {
+	case c.Config.IncrementalHash:
+		return diffLeafHashes(branchData.FileHashes, fileLeafHashes(files)), true
+	case c.Config.LogListingDiff || c.Config.NotifyChangelog:
+		return diffListings(branchData.Files, files), true
+	default:
+		return ListingDiff{}, false
+	}
+}
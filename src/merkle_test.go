@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMerkleRoot(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	files := []IPAFile{}
+	for i := 0; i < 5; i++ {
+		files = append(files, IPAFile{Name: fmt.Sprintf("Discord_%d.0.ipa", 220+i), ModTime: base.Add(time.Duration(i) * time.Minute)})
+	}
+	root := merkleRoot(fileLeafHashes(files))
+
+	reversed := []IPAFile{}
+	for i := len(files) - 1; i >= 0; i-- {
+		reversed = append(reversed, files[i])
+	}
+	if got := merkleRoot(fileLeafHashes(reversed)); got != root {
+		t.Error("root depends on listing order")
+	}
+
+	// Every entry, including the odd one at the end of a level, feeds the root
+	for i := range files {
+		changed := append([]IPAFile{}, files...)
+		changed[i].ModTime = changed[i].ModTime.Add(time.Second)
+		if merkleRoot(fileLeafHashes(changed)) == root {
+			t.Errorf("changing %s kept the root", files[i].Name)
+		}
+	}
+
+	if merkleRoot(fileLeafHashes(files[:4])) == root {
+		t.Error("removing a file kept the root")
+	}
+	if merkleRoot(nil) == "" {
+		t.Error("empty listing has no root")
+	}
+}
+
+func TestDiffLeafHashes(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	previous := fileLeafHashes([]IPAFile{
+		{Name: "Discord_227.0.ipa", ModTime: base},
+		{Name: "Discord_228.0.ipa", ModTime: base},
+		{Name: "Discord_old.ipa", ModTime: base},
+	})
+	current := fileLeafHashes([]IPAFile{
+		{Name: "Discord_227.0.ipa", ModTime: base},
+		{Name: "Discord_228.0.ipa", ContentHash: "abc"},
+		{Name: "Discord_229.0.ipa", ModTime: base},
+	})
+
+	diff := diffLeafHashes(previous, current)
+	if got, want := diff.String(), "+Discord_229.0.ipa, -Discord_old.ipa, ~Discord_228.0.ipa"; got != want {
+		t.Errorf("diff = %q, want %q", got, want)
+	}
+}
+
+// TestIncrementalHashLocalizesChange stores the per-file hashes and expects the next
+// change to be localized to the one replaced file
+func TestIncrementalHashLocalizesChange(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+incremental_hash = true
+`)
+	listing := `[{"name": "Discord_227.0.ipa", "mod_time": "2024-01-01T00:00:00Z"}, {"name": "Discord_228.0.ipa", "mod_time": "2024-01-01T00:01:00Z"}]`
+	recorder := serveDispatches(t, checker, []byte(listing))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	checker.stateMu.Lock()
+	stored := len(checker.BranchData.Branches["stable"].FileHashes)
+	checker.stateMu.Unlock()
+	if stored != 2 {
+		t.Fatalf("stored %d file hashes, want 2", stored)
+	}
+
+	logs := captureLog(t)
+	recorder.setListing([]byte(strings.Replace(listing, "00:01:00Z", "00:02:00Z", 1)))
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), ": ~Discord_228.0.ipa\n") {
+		t.Errorf("change not localized to Discord_228.0.ipa:\n%s", logs)
+	}
+	if got := len(recorder.dispatched()); got != 2 {
+		t.Errorf("%d dispatches, want one per change", got)
+	}
+}
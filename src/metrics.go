@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fetchDurationBuckets are the upper bounds of the fetch latency histogram in seconds
+var fetchDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// histogram is a cumulative Prometheus histogram
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Metrics collects Prometheus metrics for /metrics. A nil Metrics is a no-op.
+type Metrics struct {
+	// static holds the rendered metrics_labels attached to every sample
+	static string
+
+	mu            sync.Mutex
+	checks        map[string]uint64
+	lastCheck     map[string]time.Time
+	dispatches    map[[2]string]uint64
+	fetchDuration map[string]*histogram
+}
+
+// NewMetrics creates an empty metrics registry attaching labels to every sample
+func NewMetrics(labels map[string]string) *Metrics {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var static strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&static, "%s=\"%s\",", name, escapeLabel(labels[name]))
+	}
+
+	return &Metrics{
+		static:        static.String(),
+		checks:        make(map[string]uint64),
+		lastCheck:     make(map[string]time.Time),
+		dispatches:    make(map[[2]string]uint64),
+		fetchDuration: make(map[string]*histogram),
+	}
+}
+
+// ObserveCheck counts a branch check and records its time
+func (m *Metrics) ObserveCheck(branch string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checks[branch]++
+	m.lastCheck[branch] = time.Now()
+}
+
+// ObserveDispatch counts a dispatch to repo with result "success" or "failure"
+func (m *Metrics) ObserveDispatch(repo, result string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dispatches[[2]string{repo, result}]++
+}
+
+// ObserveFetch records the latency of a listing fetch
+func (m *Metrics) ObserveFetch(branch string, d time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.fetchDuration[branch]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(fetchDurationBuckets))}
+		m.fetchDuration[branch] = h
+	}
+
+	seconds := d.Seconds()
+	for i, bound := range fetchDurationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+
+	b.WriteString("# HELP dipa_auto_checks_total Branch checks performed.\n")
+	b.WriteString("# TYPE dipa_auto_checks_total counter\n")
+	for _, branch := range sortedKeys(m.checks) {
+		fmt.Fprintf(&b, "dipa_auto_checks_total{%sbranch=\"%s\"} %d\n", m.static, escapeLabel(branch), m.checks[branch])
+	}
+
+	b.WriteString("# HELP dipa_auto_last_check_timestamp_seconds Time of the last check per branch.\n")
+	b.WriteString("# TYPE dipa_auto_last_check_timestamp_seconds gauge\n")
+	for _, branch := range sortedKeys(m.checks) {
+		fmt.Fprintf(&b, "dipa_auto_last_check_timestamp_seconds{%sbranch=\"%s\"} %d\n", m.static,
+			escapeLabel(branch), m.lastCheck[branch].Unix())
+	}
+
+	b.WriteString("# HELP dipa_auto_dispatches_total Dispatches per target repo and result.\n")
+	b.WriteString("# TYPE dipa_auto_dispatches_total counter\n")
+	keys := make([][2]string, 0, len(m.dispatches))
+	for key := range m.dispatches {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	for _, key := range keys {
+		fmt.Fprintf(&b, "dipa_auto_dispatches_total{%srepo=\"%s\",result=\"%s\"} %d\n", m.static,
+			escapeLabel(key[0]), key[1], m.dispatches[key])
+	}
+
+	b.WriteString("# HELP dipa_auto_fetch_duration_seconds Latency of IPA listing fetches.\n")
+	b.WriteString("# TYPE dipa_auto_fetch_duration_seconds histogram\n")
+	fetched := make([]string, 0, len(m.fetchDuration))
+	for branch := range m.fetchDuration {
+		fetched = append(fetched, branch)
+	}
+	sort.Strings(fetched)
+	for _, branch := range fetched {
+		h, label := m.fetchDuration[branch], escapeLabel(branch)
+		for i, bound := range fetchDurationBuckets {
+			fmt.Fprintf(&b, "dipa_auto_fetch_duration_seconds_bucket{%sbranch=\"%s\",le=\"%g\"} %d\n", m.static, label, bound, h.counts[i])
+		}
+		fmt.Fprintf(&b, "dipa_auto_fetch_duration_seconds_bucket{%sbranch=\"%s\",le=\"+Inf\"} %d\n", m.static, label, h.count)
+		fmt.Fprintf(&b, "dipa_auto_fetch_duration_seconds_sum{%sbranch=\"%s\"} %g\n", m.static, label, h.sum)
+		fmt.Fprintf(&b, "dipa_auto_fetch_duration_seconds_count{%sbranch=\"%s\"} %d\n", m.static, label, h.count)
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// NewMetricsServer creates the HTTP server exposing /metrics on port, on its own mux
+func (m *Metrics) NewMetricsServer(port int) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.WriteTo(w)
+	})
+
+	return &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// metricLabelRegex matches valid Prometheus label names
+var metricLabelRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// metricLabels are the labels set by dipa-auto itself
+var metricLabels = []string{"branch", "repo", "result", "le"}
+
+// validateMetricLabels checks the names of metrics_labels
+func validateMetricLabels(labels map[string]string) error {
+	for name := range labels {
+		if !metricLabelRegex.MatchString(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("metrics_labels contains invalid label name %q", name)
+		}
+		if containsString(metricLabels, name) {
+			return fmt.Errorf("metrics_labels cannot set the %q label used by dipa-auto", name)
+		}
+	}
+	return nil
+}
+
+// escapeLabel escapes a Prometheus label value
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// sortedKeys returns the branches of a counter in order
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
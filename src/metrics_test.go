@@ -0,0 +1,176 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrape fetches /metrics from the metrics server of m
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+
+	server := httptest.NewServer(m.NewMetricsServer(0).Handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("content type %q, want the Prometheus text format", resp.Header.Get("Content-Type"))
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestMetricsCheckBranch(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+metrics_port = 9100
+dispatch_retries = 0
+
+[[targets]]
+github_repo = "example/app"
+github_token = "token"
+
+[[targets]]
+github_repo = "example/broken"
+github_token = "token"
+`)
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stable/":
+			w.Write(listingJSON("Discord_228.0.ipa"))
+		case "/repos/example/app/dispatches":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	start := time.Now().Unix()
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	body := scrape(t, checker.Metrics)
+
+	for _, line := range []string{
+		`dipa_auto_checks_total{branch="stable"} 1`,
+		`dipa_auto_dispatches_total{repo="example/app",result="success"} 1`,
+		`dipa_auto_dispatches_total{repo="example/broken",result="failure"} 1`,
+		`dipa_auto_fetch_duration_seconds_bucket{branch="stable",le="+Inf"} 1`,
+		`dipa_auto_fetch_duration_seconds_count{branch="stable"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics lack %s:\n%s", line, body)
+		}
+	}
+	prefix := `dipa_auto_last_check_timestamp_seconds{branch="stable"} `
+	var last int64
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, prefix) {
+			last, _ = strconv.ParseInt(strings.TrimPrefix(line, prefix), 10, 64)
+		}
+	}
+	if last < start || last > time.Now().Unix() {
+		t.Errorf("last check time of stable is %d, want the check just run:\n%s", last, body)
+	}
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	checker := newTestChecker(t, `branches = ["stable"]`)
+	if checker.Metrics != nil {
+		t.Fatal("metrics collected without metrics_port")
+	}
+
+	// The observers of a nil registry are no-ops
+	checker.Metrics.ObserveCheck("stable")
+	checker.Metrics.ObserveDispatch("example/app", "success")
+	checker.Metrics.ObserveFetch("stable", time.Second)
+}
+
+func TestFetchDurationBuckets(t *testing.T) {
+	m := NewMetrics(nil)
+	m.ObserveFetch("stable", 75*time.Millisecond)
+	m.ObserveFetch("stable", 3*time.Second)
+	body := scrape(t, m)
+
+	for _, line := range []string{
+		`dipa_auto_fetch_duration_seconds_bucket{branch="stable",le="0.05"} 0`,
+		`dipa_auto_fetch_duration_seconds_bucket{branch="stable",le="0.1"} 1`,
+		`dipa_auto_fetch_duration_seconds_bucket{branch="stable",le="2.5"} 1`,
+		`dipa_auto_fetch_duration_seconds_bucket{branch="stable",le="5"} 2`,
+		`dipa_auto_fetch_duration_seconds_bucket{branch="stable",le="+Inf"} 2`,
+		`dipa_auto_fetch_duration_seconds_sum{branch="stable"} 3.075`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("histogram lacks %s:\n%s", line, body)
+		}
+	}
+}
+
+func TestMetricsLabels(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+metrics_port = 9100
+
+[metrics_labels]
+region = "eu-west"
+environment = "prod \"blue\""
+`)
+	serveDispatches(t, checker, listingJSON("Discord_228.0.ipa"))
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	body := scrape(t, checker.Metrics)
+
+	// Static labels come first in name order on every sample
+	labels := `environment="prod \"blue\"",region="eu-west",`
+	for _, line := range []string{
+		`dipa_auto_checks_total{` + labels + `branch="stable"} 1`,
+		`dipa_auto_dispatches_total{` + labels + `repo="example/app",result="success"} 1`,
+		`dipa_auto_fetch_duration_seconds_count{` + labels + `branch="stable"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics lack %s:\n%s", line, body)
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		if !strings.HasPrefix(line, "#") && !strings.Contains(line, "{"+labels) {
+			t.Errorf("sample %s lacks the static labels", line)
+		}
+	}
+}
+
+func TestMetricsLabelsValidation(t *testing.T) {
+	tests := []struct {
+		label, wantErr string
+	}{
+		{"environment", ""},
+		{"_shard", ""},
+		{"9region", "invalid label name"},
+		{"data-center", "invalid label name"},
+		{"__name__", "invalid label name"},
+		{"branch", "used by dipa-auto"},
+		{"le", "used by dipa-auto"},
+	}
+
+	for _, tt := range tests {
+		_, err := loadTestConfig(t, "metrics_port = 9100\n[metrics_labels]\n"+strconvQuote(tt.label)+" = \"x\"\n")
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: %v", tt.label, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: error %v, want %q", tt.label, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Notification represents a message sent to the configured notifiers
+type Notification struct {
+	Title   string
+	Message string
+	Branch  string
+	// Instance is the instance_id of the sender
+	Instance string
+
+	// Update details, empty for alerts
+	Version    string
+	URL        string
+	Successful []string
+	Failed     []string
+}
+
+// Notifier delivers notifications to an external service
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// eventExporter is implemented by notifiers that export every dispatch event for other
+// systems rather than notifying people. They get each dispatch result through exportDispatch,
+// including those where every target failed, and never notify's messages or throttling.
+type eventExporter interface {
+	exportsEvents()
+}
+
+// newNotifiers creates the notifiers enabled in the configuration
+func newNotifiers(cfg *Config, client *http.Client) []Notifier {
+	notifiers := []Notifier{}
+
+	if cfg.SlackWebhookURL != "" {
+		notifiers = append(notifiers, &SlackNotifier{WebhookURL: cfg.SlackWebhookURL, Client: client})
+	}
+
+	if cfg.DiscordWebhookURL != "" {
+		notifiers = append(notifiers, &DiscordNotifier{WebhookURL: cfg.DiscordWebhookURL, Client: client})
+	}
+
+	if cfg.KafkaRESTURL != "" {
+		notifiers = append(notifiers, &KafkaNotifier{
+			Topic:    cfg.KafkaTopic,
+			Producer: &KafkaRESTProducer{URL: cfg.KafkaRESTURL, Client: client},
+		})
+	}
+
+	return notifiers
+}
+
+// notify sends a notification to every configured notifier, logging failures.
+// With notify_rate set, notifications beyond the rate limit are dropped and
+// optionally summarized in the next notification that gets through.
+func (c *DipaChecker) notify(n Notification) {
+	if len(c.Notifiers) == 0 {
+		return
+	}
+
+	if c.notifyLimiter != nil {
+		if !c.notifyLimiter.Allow() {
+			c.notifyMu.Lock()
+			c.droppedNotifications++
+			c.notifyMu.Unlock()
+			log.Printf("Notification %q throttled", n.Title)
+			return
+		}
+
+		c.notifyMu.Lock()
+		dropped := c.droppedNotifications
+		c.droppedNotifications = 0
+		c.notifyMu.Unlock()
+
+		if dropped > 0 && c.Config.NotifySummarizeDropped {
+			c.send(Notification{
+				Title:   fmt.Sprintf("%d more updates", dropped),
+				Message: fmt.Sprintf("%d notifications were throttled, check the logs for details", dropped),
+			})
+		}
+	}
+
+	c.send(n)
+}
+
+// send delivers a notification to every configured notifier except the event exporters,
+// logging failures
+func (c *DipaChecker) send(n Notification) {
+	c.deliver(n, false)
+}
+
+// exportDispatch passes the outcome of a dispatch to the event exporters, whether or not
+// a target took it
+func (c *DipaChecker) exportDispatch(event DispatchEvent, result DispatchResult) {
+	if len(result.Successful) == 0 && len(result.Failed) == 0 {
+		return
+	}
+	c.deliver(Notification{
+		Title:      fmt.Sprintf("%s dispatch", event.Branch),
+		Branch:     event.Branch,
+		Version:    event.Version,
+		URL:        event.IPAURL,
+		Successful: result.Successful,
+		Failed:     result.Failed,
+	}, true)
+}
+
+// deliver sends a notification to the event exporters when exports is set, or to the
+// other notifiers otherwise, logging failures
+func (c *DipaChecker) deliver(n Notification, exports bool) {
+	if c.Config.DryRun {
+		log.Printf("Dry run: would notify %q", n.Title)
+		return
+	}
+	n.Instance = c.Config.InstanceID
+	for _, notifier := range c.Notifiers {
+		if _, export := notifier.(eventExporter); export != exports {
+			continue
+		}
+		if err := notifier.Notify(n); err != nil {
+			log.Printf("Error sending notification %q: %v", n.Title, err)
+		}
+	}
+}
+
+// alert logs an alert and forwards it to the configured notifiers
+func (c *DipaChecker) alert(branch, title, message string) {
+	log.Printf("ALERT [%s] %s: %s", branch, title, message)
+	c.notify(Notification{
+		Title:   title,
+		Message: message,
+		Branch:  branch,
+	})
+}
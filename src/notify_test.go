@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestNotifyOnlyAcceptedUpdates expects no update notification while every target
+// rejects the update, and one once a target takes it
+func TestNotifyOnlyAcceptedUpdates(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+dispatch_retries = 0
+`)
+	notifier := &recordingNotifier{}
+	checker.Notifiers = []Notifier{notifier}
+
+	var accept int32
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stable/":
+			w.Write(listingJSON("Discord_228.0.ipa"))
+		case "/repos/example/app/dispatches":
+			if atomic.LoadInt32(&accept) == 0 {
+				http.Error(w, `{"message":"Unprocessable"}`, http.StatusUnprocessableEntity)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	for i := 0; i < 2; i++ {
+		if err := checker.CheckBranch("stable"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := notifier.titled("New stable version"); len(got) != 0 {
+		t.Fatalf("got %d notifications for a rejected update, want none", len(got))
+	}
+
+	atomic.StoreInt32(&accept, 1)
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	got := notifier.titled("New stable version")
+	if len(got) != 1 {
+		t.Fatalf("got %d notifications for an accepted update, want 1", len(got))
+	}
+	if len(got[0].Successful) != 1 || got[0].Successful[0] != "example/app" {
+		t.Errorf("notification lists %v as successful, want [example/app]", got[0].Successful)
+	}
+}
+
+// recordingProducer keeps every published Kafka message, failing while unavailable is set
+type recordingProducer struct {
+	mu          sync.Mutex
+	topics      []string
+	keys        []string
+	values      [][]byte
+	unavailable bool
+}
+
+func (p *recordingProducer) Produce(topic, key string, value []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.unavailable {
+		return errors.New("broker unavailable")
+	}
+	p.topics = append(p.topics, topic)
+	p.keys = append(p.keys, key)
+	p.values = append(p.values, value)
+	return nil
+}
+
+// TestNotifyRateSkipsKafka exports every dispatch while notify_rate throttles the webhooks
+func TestNotifyRateSkipsKafka(t *testing.T) {
+	checker := newTestChecker(t, "branches = [\"stable\"]\nnotify_rate = 1.0\nnotify_burst = 1\n")
+	notifier := &recordingNotifier{}
+	producer := &recordingProducer{}
+	checker.Notifiers = []Notifier{notifier, &KafkaNotifier{Topic: "dispatches", Producer: producer}}
+	recorder := serveDispatches(t, checker, nil)
+
+	for i := 0; i < 3; i++ {
+		recorder.setListing(listingJSON(fmt.Sprintf("Discord_%d.0.ipa", 228+i)))
+		if err := checker.CheckBranch("stable"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := notifier.titled(""); len(got) != 1 {
+		t.Errorf("webhook notifier got %d notifications, want 1 within notify_rate", len(got))
+	}
+	if len(producer.keys) != 3 {
+		t.Errorf("kafka got %d events, want all 3", len(producer.keys))
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import "fmt"
+
+// defaultEventType is the repository_dispatch event type when event_type is not set
+const defaultEventType = "ipa-update"
+
+// reservedPayloadFields are set by dipa-auto and cannot be replaced by payload_fields.
+// The informational branch and mod_time fields may be replaced.
+var reservedPayloadFields = []string{"ipa_url", "is_testflight", "payload_version", "files", "instance_id", "variant", "dispatch_id"}
+
+// BranchOverride changes a target's dispatch contract for a single branch
+type BranchOverride struct {
+	// EventType replaces the repository_dispatch event type
+	EventType string `toml:"event_type"`
+	// SuccessStatuses are the response status codes treated as a successful dispatch
+	SuccessStatuses []int `toml:"success_statuses"`
+	// PayloadFields are added to the client_payload
+	PayloadFields map[string]interface{} `toml:"payload_fields"`
+}
+
+// resolveTarget returns the target with its dispatch settings for branch filled in.
+// Settings are taken from the branch override, then the target, then the global config;
+// payload fields are merged with the same precedence for each key.
+func (c *DipaChecker) resolveTarget(target Target, branch string) Target {
+	override := target.BranchOverrides[branch]
+
+	resolved := target
+	resolved.EventType = firstNonEmpty(override.EventType, target.EventType, c.Config.EventType, defaultEventType)
+
+	resolved.SuccessStatuses = c.Config.SuccessStatuses
+	if len(target.SuccessStatuses) > 0 {
+		resolved.SuccessStatuses = target.SuccessStatuses
+	}
+	if len(override.SuccessStatuses) > 0 {
+		resolved.SuccessStatuses = override.SuccessStatuses
+	}
+
+	resolved.PayloadFields = make(map[string]interface{})
+	for _, fields := range []map[string]interface{}{c.Config.PayloadFields, target.PayloadFields, override.PayloadFields} {
+		for key, value := range fields {
+			resolved.PayloadFields[key] = value
+		}
+	}
+
+	return resolved
+}
+
+// acceptsStatus reports whether a response status counts as a successful dispatch,
+// using the provider's default status when success_statuses is not set
+func (t Target) acceptsStatus(status, defaultStatus int) bool {
+	if len(t.SuccessStatuses) == 0 {
+		return status == defaultStatus
+	}
+	for _, accepted := range t.SuccessStatuses {
+		if status == accepted {
+			return true
+		}
+	}
+	return false
+}
+
+// firstNonEmpty returns the first non-empty string
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// maxEventTypeLength is the longest repository_dispatch event type GitHub accepts
+const maxEventTypeLength = 100
+
+// validateDispatchSettings checks the event type, status codes and payload fields of one level of overrides
+func validateDispatchSettings(scope, eventType string, statuses []int, fields map[string]interface{}) error {
+	if len(eventType) > maxEventTypeLength {
+		return fmt.Errorf("event_type for %s must be at most %d characters", scope, maxEventTypeLength)
+	}
+	for _, status := range statuses {
+		if status < 200 || status > 299 {
+			return fmt.Errorf("success_statuses for %s must be 2xx status codes, got %d", scope, status)
+		}
+	}
+	for key := range fields {
+		if containsString(reservedPayloadFields, key) {
+			return fmt.Errorf("payload_fields for %s cannot replace the reserved field %q", scope, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const overridesConfig = `
+branches = ["stable", "testflight"]
+dispatch_retries = 0
+event_type = "global-update"
+success_statuses = [204]
+
+[payload_fields]
+channel = "global"
+owner = "global"
+team = "global"
+
+[[targets]]
+github_repo = "example/app"
+github_token = "token"
+event_type = "target-update"
+success_statuses = [202]
+
+[targets.payload_fields]
+owner = "target"
+team = "target"
+
+[targets.branch_overrides.testflight]
+event_type = "beta-update"
+success_statuses = [200]
+
+[targets.branch_overrides.testflight.payload_fields]
+team = "beta"
+`
+
+func TestResolveTargetPrecedence(t *testing.T) {
+	checker := newTestChecker(t, overridesConfig)
+	target := checker.Config.Targets[0]
+
+	tests := []struct {
+		branch, eventType string
+		status            int
+		fields            map[string]interface{}
+	}{
+		{"stable", "target-update", 202, map[string]interface{}{"channel": "global", "owner": "target", "team": "target"}},
+		{"testflight", "beta-update", 200, map[string]interface{}{"channel": "global", "owner": "target", "team": "beta"}},
+	}
+
+	for _, tt := range tests {
+		resolved := checker.resolveTarget(target, tt.branch)
+		if resolved.EventType != tt.eventType {
+			t.Errorf("%s event type %q, want %q", tt.branch, resolved.EventType, tt.eventType)
+		}
+		if len(resolved.SuccessStatuses) != 1 || resolved.SuccessStatuses[0] != tt.status {
+			t.Errorf("%s success statuses %v, want [%d]", tt.branch, resolved.SuccessStatuses, tt.status)
+		}
+		for key, want := range tt.fields {
+			if resolved.PayloadFields[key] != want {
+				t.Errorf("%s payload field %s = %v, want %v", tt.branch, key, resolved.PayloadFields[key], want)
+			}
+		}
+	}
+
+	// Resolving doesn't leak one branch's override into the configured target
+	if target.EventType != "target-update" || target.PayloadFields["team"] != "target" {
+		t.Errorf("resolving modified the target: %+v", target)
+	}
+}
+
+// TestBranchOverrideDispatch answers every dispatch with 200, which only the
+// testflight override accepts, and checks the event type each branch sends
+func TestBranchOverrideDispatch(t *testing.T) {
+	checker := newTestChecker(t, overridesConfig)
+
+	var mu sync.Mutex
+	eventTypes := map[string]string{}
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stable/":
+			w.Write(listingJSON("Discord_228.0.ipa"))
+		case "/testflight/":
+			w.Write(listingJSON("Discord_229.0.ipa"))
+		default:
+			var body struct {
+				EventType     string                 `json:"event_type"`
+				ClientPayload map[string]interface{} `json:"client_payload"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			branch, _ := body.ClientPayload["branch"].(string)
+
+			mu.Lock()
+			eventTypes[branch] = body.EventType + "/" + body.ClientPayload["team"].(string)
+			mu.Unlock()
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+
+	checker.CheckAll(checker.Config.Branches)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if eventTypes["stable"] != "target-update/target" || eventTypes["testflight"] != "beta-update/beta" {
+		t.Errorf("dispatched event types %v", eventTypes)
+	}
+
+	status := checker.BranchStatusSnapshot()
+	if failed := strings.Join(status["stable"].Failed, ","); failed != "example/app" {
+		t.Errorf("stable failed %q, want the 200 rejected by the target's success_statuses", failed)
+	}
+	if failed := status["testflight"].Failed; len(failed) != 0 {
+		t.Errorf("testflight failed %v, want the 200 accepted by the branch override", failed)
+	}
+}
+
+func TestEventTypeDefault(t *testing.T) {
+	checker := newTestChecker(t, `
+[[targets]]
+github_repo = "example/app"
+github_token = "token"
+
+[[targets]]
+github_repo = "example/custom"
+github_token = "token"
+event_type = "custom-build"
+`)
+
+	want := map[string]string{"example/app": "ipa-update", "example/custom": "custom-build"}
+	for _, target := range checker.Config.Targets {
+		if got := checker.resolveTarget(target, "stable").EventType; got != want[target.Name()] {
+			t.Errorf("%s event type %q, want %q", target.Name(), got, want[target.Name()])
+		}
+	}
+}
+
+func TestEventTypeLength(t *testing.T) {
+	long := strings.Repeat("e", maxEventTypeLength+1)
+	tests := []struct {
+		name, config, wantErr string
+	}{
+		{"global", "event_type = \"" + long + "\"\n", "event_type for the config"},
+		{"target", "[[targets]]\ngithub_repo = \"example/app\"\ngithub_token = \"token\"\nevent_type = \"" + long + "\"\n", "event_type for example/app"},
+		{"branch", "[[targets]]\ngithub_repo = \"example/app\"\ngithub_token = \"token\"\n[targets.branch_overrides.stable]\nevent_type = \"" + long + "\"\n", "event_type for example/app on stable"},
+		{"limit", "event_type = \"" + long[1:] + "\"\n", ""},
+	}
+
+	for _, tt := range tests {
+		_, err := loadTestConfig(t, tt.config)
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log"
+)
+
+// applyOperatorState takes the paused flag and blocklists from the stored state
+func (c *DipaChecker) applyOperatorState(stored BranchHashes) {
+	c.BranchData.Paused = stored.Paused
+	c.blockMu.Lock()
+	c.BranchData.BlockedVersions = stored.BlockedVersions
+	c.BranchData.BlockedHashes = stored.BlockedHashes
+	c.blockMu.Unlock()
+}
+
+// refreshPaused re-reads the paused flag and blocklist from the hash file so the pause/resume
+// and block subcommands take effect in a running instance, and reports whether dispatching is paused
+func (c *DipaChecker) refreshPaused() bool {
+	stored, err := c.readStoredState()
+	if err != nil {
+		log.Printf("Error reading paused state, keeping current state: %v", err)
+		return c.BranchData.Paused
+	}
+
+	c.applyOperatorState(stored)
+	return c.BranchData.Paused
+}
+
+// SetPaused persists the paused flag to the hash file
+func (c *DipaChecker) SetPaused(paused bool) error {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	c.mergeStoredState()
+	c.BranchData.Paused = paused
+	if c.Config.SplitStateFiles {
+		return c.writeSplitState()
+	}
+	return c.writeHashes()
+}
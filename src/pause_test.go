@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestPauseSurvivesDaemonSave pauses through a second checker, like the pause subcommand,
+// then saves from the running one and expects the pause to survive a reload
+func TestPauseSurvivesDaemonSave(t *testing.T) {
+	daemon := newTestChecker(t, "resume_schedule = true\n")
+
+	if err := reopen(t, daemon).SetPaused(true); err != nil {
+		t.Fatal(err)
+	}
+	daemon.recordLastRun(time.Now())
+
+	if !reopen(t, daemon).BranchData.Paused {
+		t.Fatal("pause was undone by the running instance's save")
+	}
+
+	if err := reopen(t, daemon).SetPaused(false); err != nil {
+		t.Fatal(err)
+	}
+	daemon.recordLastRun(time.Now())
+
+	if reopen(t, daemon).BranchData.Paused {
+		t.Fatal("resume was undone by the running instance's save")
+	}
+}
+
+// TestOperatorStateSurvivesThrottledFlush changes the operator state while a throttled
+// save is pending and expects the flush to keep it
+func TestOperatorStateSurvivesThrottledFlush(t *testing.T) {
+	daemon := newTestChecker(t, "save_interval = \"1h\"\n")
+
+	record := func(hash string) {
+		err := daemon.updateBranch("stable", func(branchData *BranchData) {
+			branchData.Hash = hash
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	record("first")
+	record("second")
+
+	cli := reopen(t, daemon)
+	if err := cli.SetPaused(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.SetBlocked("Discord_228.0.ipa", "", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := daemon.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := reopen(t, daemon)
+	if !reloaded.BranchData.Paused {
+		t.Error("pause was undone by the throttled flush")
+	}
+	if !reloaded.isBlockedVersion("Discord_228.0.ipa") {
+		t.Error("block was undone by the throttled flush")
+	}
+	if got := reloaded.BranchData.Branches["stable"].Hash; got != "second" {
+		t.Errorf("stable hash = %q, want the flushed %q", got, "second")
+	}
+}
+
+func TestPauseBlocksDispatch(t *testing.T) {
+	daemon := newTestChecker(t, `branches = ["stable"]`)
+	recorder := serveDispatches(t, daemon, listingJSON("Discord_228.0.ipa"))
+
+	if err := reopen(t, daemon).SetPaused(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := daemon.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if got := recorder.dispatched(); len(got) != 0 {
+		t.Fatalf("dispatched to %v while paused", got)
+	}
+
+	// The new hash is tracked, and the pause is still in place after a restart
+	restarted := reopen(t, daemon)
+	if !restarted.BranchData.Paused {
+		t.Error("pause did not survive a reload")
+	}
+	if restarted.BranchData.Branches["stable"].Hash == "" {
+		t.Error("the hash seen while paused was not recorded")
+	}
+
+	if err := reopen(t, daemon).SetPaused(false); err != nil {
+		t.Fatal(err)
+	}
+	recorder.setListing(listingJSON("Discord_228.0.ipa", "Discord_229.0.ipa"))
+	if err := daemon.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if got := recorder.dispatched(); len(got) != 1 {
+		t.Errorf("dispatched to %v after resuming, want example/app", got)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// maxFileListBytes caps the encoded size of the files array in a dispatch payload
+const maxFileListBytes = 48 * 1024
+
+// PayloadFile represents an IPA file in the dispatch payload
+type PayloadFile struct {
+	Name    string    `json:"name"`
+	URL     string    `json:"url"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// buildPayloadFiles converts the listing into payload entries, keeping the listing order.
+// The oldest files are dropped until the encoded list fits within maxFileListBytes.
+func buildPayloadFiles(baseURL, branch string, files []IPAFile) []PayloadFile {
+	entries := make([]PayloadFile, 0, len(files))
+	for _, file := range files {
+		entries = append(entries, PayloadFile{
+			Name:    file.Name,
+			URL:     ipaURL(baseURL, branch, file.Name),
+			ModTime: file.ModTime,
+		})
+	}
+
+	encoded, err := json.Marshal(entries)
+	if err != nil || len(encoded) <= maxFileListBytes {
+		return entries
+	}
+
+	// Drop the oldest entries until the list fits
+	byAge := make([]int, len(entries))
+	for i := range byAge {
+		byAge[i] = i
+	}
+	sort.Slice(byAge, func(i, j int) bool {
+		return entries[byAge[i]].ModTime.Before(entries[byAge[j]].ModTime)
+	})
+
+	dropped := make(map[int]bool)
+	size := len(encoded)
+	for _, index := range byAge {
+		if size <= maxFileListBytes {
+			break
+		}
+		entry, _ := json.Marshal(entries[index])
+		size -= len(entry) + 1
+		dropped[index] = true
+	}
+
+	trimmed := make([]PayloadFile, 0, len(entries)-len(dropped))
+	for i, entry := range entries {
+		if !dropped[i] {
+			trimmed = append(trimmed, entry)
+		}
+	}
+
+	log.Printf("Trimmed %s file list from %d to %d entries to fit the payload size limit",
+		branch, len(entries), len(trimmed))
+	return trimmed
+}
+
+// recordPayload stores the payload last dispatched to a target and when it was sent
+func (c *DipaChecker) recordPayload(repo string, payload []byte) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	if c.BranchData.LastPayloads == nil {
+		c.BranchData.LastPayloads = make(map[string]string)
+	}
+	c.BranchData.LastPayloads[repo] = string(payload)
+
+	if c.BranchData.LastDispatched == nil {
+		c.BranchData.LastDispatched = make(map[string]time.Time)
+	}
+	c.BranchData.LastDispatched[repo] = time.Now()
+}
+
+// Replay re-sends the last payload dispatched to a target exactly as it was sent
+func (c *DipaChecker) Replay(repo string) error {
+	c.stateMu.Lock()
+	payload, ok := c.BranchData.LastPayloads[repo]
+	c.stateMu.Unlock()
+	if !ok {
+		return fmt.Errorf("no dispatched payload stored for %s", repo)
+	}
+
+	for _, target := range c.Config.Targets {
+		if target.Name() != repo {
+			continue
+		}
+
+		if c.Config.DryRun {
+			log.Printf("Dry run: would replay last dispatch to %s: %s", repo, payload)
+			return nil
+		}
+
+		log.Printf("Replaying last dispatch to %s", repo)
+		if err := c.sendDispatch(target, []byte(payload)); err != nil {
+			return fmt.Errorf("failed to replay dispatch to %s: %w", repo, err)
+		}
+
+		log.Printf("Successfully replayed dispatch to %s", repo)
+		return nil
+	}
+
+	return fmt.Errorf("%s is not a configured target", repo)
+}
+
+// fileModTime returns the modification time of the named file in a listing,
+// which is unknown in content_addressed mode
+func fileModTime(files []IPAFile, name string) (time.Time, bool) {
+	for _, file := range files {
+		if file.Name == name && !file.ModTime.IsZero() {
+			return file.ModTime, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// findFile returns the file of a listing with the given name, or a file with only
+// that name when the listing does not contain it
+func findFile(files []IPAFile, name string) IPAFile {
+	for _, file := range files {
+		if file.Name == name {
+			return file
+		}
+	}
+	return IPAFile{Name: name}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPayloadVersion(t *testing.T) {
+	checker := newTestChecker(t, `branches = ["stable"]`)
+	recorder := serveDispatches(t, checker, listingJSON("Discord_228.0.ipa"))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := recorder.payload("example/app")
+	if payload == nil {
+		t.Fatal("example/app was not dispatched")
+	}
+	// JSON numbers decode as float64
+	if version, ok := payload["payload_version"].(float64); !ok || int(version) != PayloadVersion {
+		t.Errorf("payload_version is %v, want %d", payload["payload_version"], PayloadVersion)
+	}
+	if payload["ipa_url"] != "https://ipa.example.com/stable/Discord_228.0.ipa" {
+		t.Errorf("ipa_url is %v", payload["ipa_url"])
+	}
+}
+
+func TestPayloadFiles(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+
+[[targets]]
+github_repo = "example/app"
+github_token = "token"
+include_files = true
+
+[[targets]]
+github_repo = "example/other"
+github_token = "token"
+`)
+	recorder := serveDispatches(t, checker, listingJSON("Discord_229.0.ipa", "Discord 228.0.ipa", "Discord_230.0.ipa"))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	files, ok := recorder.payload("example/app")["files"].([]interface{})
+	if !ok {
+		t.Fatalf("example/app payload has no files array: %v", recorder.payload("example/app"))
+	}
+	want := []struct{ name, url string }{
+		{"Discord 228.0.ipa", "https://ipa.example.com/stable/Discord%20228.0.ipa"},
+		{"Discord_229.0.ipa", "https://ipa.example.com/stable/Discord_229.0.ipa"},
+		{"Discord_230.0.ipa", "https://ipa.example.com/stable/Discord_230.0.ipa"},
+	}
+	if len(files) != len(want) {
+		t.Fatalf("files array has %d entries, want %d", len(files), len(want))
+	}
+	for i, expected := range want {
+		file := files[i].(map[string]interface{})
+		if file["name"] != expected.name || file["url"] != expected.url {
+			t.Errorf("files[%d] = %v, want %s at %s", i, file, expected.name, expected.url)
+		}
+	}
+
+	if _, ok := recorder.payload("example/other")["files"]; ok {
+		t.Error("files array sent to a target without include_files")
+	}
+}
+
+func TestPayloadFilesTrimmed(t *testing.T) {
+	files := []IPAFile{}
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 1000; i++ {
+		files = append(files, IPAFile{
+			Name:    fmt.Sprintf("Discord_%s_%04d.ipa", strings.Repeat("x", 40), i),
+			ModTime: base.Add(time.Duration(i) * time.Minute),
+		})
+	}
+
+	trimmed := buildPayloadFiles("https://ipa.example.com", "stable", files)
+	encoded, _ := json.Marshal(trimmed)
+	if len(encoded) > maxFileListBytes {
+		t.Errorf("trimmed list is %d bytes, over the %d byte limit", len(encoded), maxFileListBytes)
+	}
+	if len(trimmed) == 0 || len(trimmed) == len(files) {
+		t.Fatalf("trimmed to %d of %d entries", len(trimmed), len(files))
+	}
+
+	// The newest files are kept, in listing order
+	if last := trimmed[len(trimmed)-1].Name; last != files[len(files)-1].Name {
+		t.Errorf("newest file %s was dropped, last kept is %s", files[len(files)-1].Name, last)
+	}
+	for i := 1; i < len(trimmed); i++ {
+		if !trimmed[i-1].ModTime.Before(trimmed[i].ModTime) {
+			t.Fatalf("trimmed list is out of order at %d", i)
+		}
+	}
+}
+
+func TestReplayByteIdentical(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+payload_fields = { channel = "prod", app = { name = "Discord" } }
+`)
+
+	var mu sync.Mutex
+	bodies := []string{}
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stable/" {
+			w.Write(listingJSON("Discord_228.0.ipa"))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replaying from a fresh process uses the payload stored in the hash file
+	cli := reopen(t, checker)
+	cli.Client = checker.Client
+	if err := cli.Replay("example/app"); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 2 {
+		t.Fatalf("got %d dispatches, want the original and the replay", len(bodies))
+	}
+	if bodies[1] != bodies[0] {
+		t.Errorf("replay sent\n%s\nwant the original\n%s", bodies[1], bodies[0])
+	}
+	if stored := cli.BranchData.LastPayloads["example/app"]; stored != bodies[0] {
+		t.Errorf("stored payload\n%s\ndiffers from the one sent\n%s", stored, bodies[0])
+	}
+
+	if err := cli.Replay("example/unknown"); err == nil {
+		t.Error("expected an error replaying to a target without a stored payload")
+	}
+}
+
+func TestPayloadInstanceID(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+instance_id = "dipa-eu-1"
+`)
+	notifier := &recordingNotifier{}
+	checker.Notifiers = []Notifier{notifier}
+	recorder := serveDispatches(t, checker, listingJSON("Discord_228.0.ipa"))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	if id := recorder.payload("example/app")["instance_id"]; id != "dipa-eu-1" {
+		t.Errorf("payload instance_id %v, want dipa-eu-1", id)
+	}
+	if got := notifier.titled("New stable version"); len(got) != 1 || got[0].Instance != "dipa-eu-1" {
+		t.Errorf("notifications %+v, want one from dipa-eu-1", got)
+	}
+}
+
+func TestInstanceIDDefaultsToHostname(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	cfg, err := loadTestConfig(t, `instance_id = ""`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.InstanceID != hostname {
+		t.Errorf("instance_id %q, want the hostname %q", cfg.InstanceID, hostname)
+	}
+}
+
+func TestPayloadFields(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+
+[[targets]]
+github_repo = "example/app"
+github_token = "token"
+
+[targets.payload_fields]
+app_name = "Discord"
+build = 42
+
+[targets.payload_fields.signing]
+team = "ABCDE12345"
+
+[[targets]]
+github_repo = "example/renamed"
+github_token = "token"
+
+[targets.payload_fields]
+branch = "release"
+`)
+	recorder := serveDispatches(t, checker, listingJSON("Discord_227.0.ipa", "Discord_228.0.ipa"))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := recorder.payload("example/app")
+	if payload["branch"] != "stable" || payload["mod_time"] != "2024-01-01T00:01:00Z" {
+		t.Errorf("branch %v and mod_time %v, want stable and the latest file's time", payload["branch"], payload["mod_time"])
+	}
+	if payload["app_name"] != "Discord" || payload["build"] != float64(42) {
+		t.Errorf("app_name %v and build %v, want the static payload_fields", payload["app_name"], payload["build"])
+	}
+	if signing, _ := payload["signing"].(map[string]interface{}); signing["team"] != "ABCDE12345" {
+		t.Errorf("signing is %v, want the nested table", payload["signing"])
+	}
+
+	// payload_fields may replace branch, unlike the reserved fields
+	if branch := recorder.payload("example/renamed")["branch"]; branch != "release" {
+		t.Errorf("branch is %v, want the payload_fields override", branch)
+	}
+}
+
+func TestPayloadFieldsReserved(t *testing.T) {
+	for _, field := range reservedPayloadFields {
+		_, err := loadTestConfig(t, "[payload_fields]\n"+field+" = \"x\"\n")
+		if err == nil || !strings.Contains(err.Error(), "cannot replace the reserved field") {
+			t.Errorf("%s: error %v, want the reserved field rejected", field, err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// checkReadiness calls a target's readiness URL, which must answer 200
+func (c *DipaChecker) checkReadiness(target Target) error {
+	resp, err := c.Client.Get(target.ReadinessURL)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("readiness check returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// successCooldownUntil reports until when a target is cooling down after its last successful dispatch
+func (c *DipaChecker) successCooldownUntil(target Target) (time.Time, bool) {
+	if target.SuccessCooldown == "" {
+		return time.Time{}, false
+	}
+
+	cooldown, err := time.ParseDuration(target.SuccessCooldown)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	c.stateMu.Lock()
+	last, ok := c.BranchData.LastDispatched[target.Name()]
+	c.stateMu.Unlock()
+	if !ok {
+		return time.Time{}, false
+	}
+
+	until := last.Add(cooldown)
+	return until, time.Now().Before(until)
+}
+
+// dispatchedTo returns the targets already dispatched for a tracking key of a branch
+func (c *DipaChecker) dispatchedTo(branch, key string) []string {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	return append([]string{}, c.BranchData.Branches[branch].Dispatches[key]...)
+}
+
+// accepted reports whether any target took the stored hash or the current variant files
+func (b BranchData) accepted() bool {
+	for key, repos := range b.Dispatches {
+		if len(repos) > 0 && b.tracksKey(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordDispatches adds successful repos to the dispatch history of a hash
+func recordDispatches(branchData *BranchData, hash string, successful []string) {
+	// Initialize dispatches map if needed
+	if branchData.Dispatches == nil {
+		branchData.Dispatches = make(map[string][]string)
+	}
+
+	existingDispatches := branchData.Dispatches[hash]
+	if existingDispatches == nil {
+		existingDispatches = []string{}
+	}
+
+	for _, repo := range successful {
+		if !containsString(existingDispatches, repo) {
+			existingDispatches = append(existingDispatches, repo)
+		}
+	}
+
+	branchData.Dispatches[hash] = existingDispatches
+}
+
+// dispatchPending retries targets that were deferred for the stored hash
+func (c *DipaChecker) dispatchPending(branch string, branchData BranchData, files []IPAFile) error {
+	latestVersion := c.selectVersion(files)
+	if latestVersion == nil {
+		return nil
+	}
+
+	log.Printf("Retrying %d pending %s dispatches: %v", len(branchData.Pending), branch, branchData.Pending)
+
+	finalURL := ipaURL(c.Config.IPABaseURL, branch, latestVersion.Name)
+	result, err := c.dispatchVariants(DispatchEvent{
+		Branch:      branch,
+		Hash:        branchData.Hash,
+		IPAURL:      finalURL,
+		Files:       files,
+		Version:     latestVersion.Name,
+		OnlyTargets: branchData.Pending,
+	}, c.dispatchWithCanary)
+	if err != nil {
+		return fmt.Errorf("error dispatching pending workflows: %w", err)
+	}
+
+	// Targets that failed outright are not retried, matching regular dispatches
+	accepted := branchData.accepted()
+	err = c.updateBranch(branch, func(branchData *BranchData) {
+		branchData.Pending = result.Pending
+		if result.Winner != "" {
+			branchData.Winner = result.Winner
+		}
+		recordResult(branchData, branchData.Hash, result)
+	})
+	if err != nil {
+		return fmt.Errorf("error saving hashes: %w", err)
+	}
+
+	if len(result.Failed) > 0 {
+		log.Printf("Failed to dispatch pending %s to %d repositories: %v", branch, len(result.Failed), result.Failed)
+	}
+
+	// A change every target deferred was not notified when it was detected
+	if !accepted && len(result.Successful) > 0 {
+		c.notify(Notification{
+			Title:      fmt.Sprintf("New %s version", branch),
+			Branch:     branch,
+			Version:    latestVersion.Name,
+			URL:        finalURL,
+			Successful: result.Successful,
+			Failed:     result.Failed,
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadinessDefersDispatch(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+
+[[targets]]
+github_repo = "example/ready"
+github_token = "token"
+readiness_url = "https://ci.example.com/ready"
+
+[[targets]]
+github_repo = "example/busy"
+github_token = "token"
+readiness_url = "https://ci.example.com/busy"
+`)
+
+	var mu sync.Mutex
+	busyReady := false
+	dispatched := []string{}
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/stable/":
+			w.Write(listingJSON("Discord_228.0.ipa"))
+		case r.URL.Path == "/ready":
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/busy":
+			if !busyReady {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		case strings.HasSuffix(r.URL.Path, "/dispatches"):
+			dispatched = append(dispatched, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/repos/"), "/dispatches"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if len(dispatched) != 1 || dispatched[0] != "example/ready" {
+		t.Errorf("dispatched %v, want only the ready target", dispatched)
+	}
+	mu.Unlock()
+	if pending := checker.BranchStatusSnapshot()["stable"].Pending; len(pending) != 1 || pending[0] != "example/busy" {
+		t.Errorf("pending %v, want example/busy", pending)
+	}
+
+	// Still busy, so it stays pending
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if len(dispatched) != 1 {
+		t.Errorf("dispatched %v while example/busy was not ready", dispatched)
+	}
+	busyReady = true
+	mu.Unlock()
+
+	// Once ready, the deferred target gets the same update without a new change
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dispatched) != 2 || dispatched[1] != "example/busy" {
+		t.Errorf("dispatched %v, want example/busy once it was ready", dispatched)
+	}
+
+	checker.stateMu.Lock()
+	defer checker.stateMu.Unlock()
+	if pending := checker.BranchData.Branches["stable"].Pending; len(pending) != 0 {
+		t.Errorf("example/busy is still pending: %v", pending)
+	}
+}
+
+// TestSuccessCooldown dispatches a second version within the target's cooldown and
+// expects it to be queued until the cooldown has elapsed
+func TestSuccessCooldown(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+
+[[targets]]
+github_repo = "example/slow"
+github_token = "token"
+success_cooldown = "1h"
+
+[[targets]]
+github_repo = "example/fast"
+github_token = "token"
+`)
+	recorder := serveDispatches(t, checker, listingJSON("Discord_228.0.ipa"))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	recorder.setListing(listingJSON("Discord_228.0.ipa", "Discord_229.0.ipa"))
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(recorder.dispatched(), ","); got != "example/slow,example/fast,example/fast" {
+		t.Fatalf("dispatched to %s, want example/slow skipped within its cooldown", got)
+	}
+	if pending := checker.BranchStatusSnapshot()["stable"].Pending; len(pending) != 1 || pending[0] != "example/slow" {
+		t.Errorf("pending %v, want example/slow queued", pending)
+	}
+
+	// The last success is persisted, so a restart doesn't end the cooldown
+	restarted := reopen(t, checker)
+	if _, ok := restarted.successCooldownUntil(restarted.Config.Targets[0]); !ok {
+		t.Error("cooldown not restored after a restart")
+	}
+
+	checker.stateMu.Lock()
+	checker.BranchData.LastDispatched["example/slow"] = time.Now().Add(-2 * time.Hour)
+	checker.stateMu.Unlock()
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	dispatched := recorder.dispatched()
+	if len(dispatched) != 4 || dispatched[3] != "example/slow" {
+		t.Fatalf("dispatched to %v, want example/slow once its cooldown elapsed", dispatched)
+	}
+	if url, _ := recorder.payload("example/slow")["ipa_url"].(string); !strings.HasSuffix(url, "Discord_229.0.ipa") {
+		t.Errorf("example/slow got %q after its cooldown, want Discord_229.0.ipa", url)
+	}
+}
+
+// TestEveryTargetInCooldown detects a version while every target is cooling down and
+// expects it to be queued once instead of being handled as a new change every check
+func TestEveryTargetInCooldown(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+
+[[targets]]
+github_repo = "example/slow"
+github_token = "token"
+success_cooldown = "1h"
+`)
+	notifier := &recordingNotifier{}
+	checker.Notifiers = []Notifier{notifier}
+	recorder := serveDispatches(t, checker, listingJSON("Discord_228.0.ipa"))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	first := storedHash(t, checker, "stable")
+	recorder.setListing(listingJSON("Discord_228.0.ipa", "Discord_229.0.ipa"))
+	for i := 0; i < 2; i++ {
+		if err := checker.CheckBranch("stable"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := recorder.dispatched(); len(got) != 1 {
+		t.Fatalf("dispatched to %v within the cooldown, want only the first version", got)
+	}
+	if storedHash(t, checker, "stable") == first {
+		t.Error("hash of the deferred version was not stored")
+	}
+	if pending := reopen(t, checker).BranchData.Branches["stable"].Pending; len(pending) != 1 || pending[0] != "example/slow" {
+		t.Errorf("persisted pending %v, want example/slow queued", pending)
+	}
+	if got := notifier.titled("New stable version"); len(got) != 1 {
+		t.Errorf("got %d notifications before the deferred version was dispatched, want 1", len(got))
+	}
+
+	checker.stateMu.Lock()
+	checker.BranchData.LastDispatched["example/slow"] = time.Now().Add(-2 * time.Hour)
+	checker.stateMu.Unlock()
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	dispatched := recorder.dispatched()
+	if len(dispatched) != 2 {
+		t.Fatalf("dispatched to %v, want example/slow once its cooldown elapsed", dispatched)
+	}
+	if url, _ := recorder.payload("example/slow")["ipa_url"].(string); !strings.HasSuffix(url, "Discord_229.0.ipa") {
+		t.Errorf("example/slow got %q after its cooldown, want Discord_229.0.ipa", url)
+	}
+	if got := notifier.titled("New stable version"); len(got) != 2 || got[1].Version != "Discord_229.0.ipa" {
+		t.Errorf("notifications %+v, want the deferred version notified once dispatched", got)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// normalizeFingerprint lowercases a hex fingerprint and strips colon separators
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+}
+
+// verifyPin returns a connection check that rejects TLS connections to host
+// unless the leaf certificate's SHA-256 fingerprint matches fingerprint
+func verifyPin(host, fingerprint string) func(tls.ConnectionState) error {
+	expected := normalizeFingerprint(fingerprint)
+
+	return func(state tls.ConnectionState) error {
+		if state.ServerName != host || len(state.PeerCertificates) == 0 {
+			return nil
+		}
+
+		sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+		if actual := hex.EncodeToString(sum[:]); actual != expected {
+			return fmt.Errorf("certificate pin mismatch for %s: got sha256 %s", host, actual)
+		}
+		return nil
+	}
+}
+
+// ipaHost returns the host name of the IPA base URL
+func ipaHost(baseURL string) (string, error) {
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+	return parsed.Hostname(), nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// servePinnedTLS serves the listing over TLS for the IPA host and the GitHub API,
+// keeping the checker's own transport so its pin is checked on every connection
+func servePinnedTLS(t *testing.T, checker *DipaChecker) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(listingJSON("Discord_228.0.ipa"))
+	}))
+	t.Cleanup(server.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	transport := checker.Client.Transport.(*http.Transport)
+	transport.TLSClientConfig.RootCAs = roots
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+	return server
+}
+
+// pinConfig returns a config pinning the IPA host, example.com, to fingerprint
+func pinConfig(fingerprint string) string {
+	return "ipa_base_url = \"https://example.com\"\nmax_retries = 0\nipa_host_pinned_sha256 = " + strconvQuote(fingerprint) + "\n"
+}
+
+func TestPinnedCertificate(t *testing.T) {
+	// The fingerprint is only known once the server runs, so pin it on a second checker
+	probe := newTestChecker(t, pinConfig(strings.Repeat("0", 64)))
+	server := servePinnedTLS(t, probe)
+	sum := sha256.Sum256(server.Certificate().Raw)
+	fingerprint := hex.EncodeToString(sum[:])
+
+	if _, _, err := probe.FetchIPAList("stable"); err == nil || !strings.Contains(err.Error(), "certificate pin mismatch") {
+		t.Errorf("error %v with a non-matching pin, want a pin mismatch", err)
+	}
+
+	// Fingerprints are accepted in upper case with colon separators too
+	colons := []string{}
+	for i := 0; i < len(fingerprint); i += 2 {
+		colons = append(colons, strings.ToUpper(fingerprint[i:i+2]))
+	}
+	for _, pin := range []string{fingerprint, strings.Join(colons, ":")} {
+		checker := newTestChecker(t, pinConfig(pin))
+		servePinnedTLS(t, checker)
+		if _, _, err := checker.FetchIPAList("stable"); err != nil {
+			t.Errorf("pin %s: %v", pin, err)
+		}
+	}
+}
+
+func TestPinOnlyAppliesToIPAHost(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	verify := verifyPin("example.com", strings.Repeat("0", 64))
+	certs := []*x509.Certificate{server.Certificate()}
+
+	if err := verify(tls.ConnectionState{ServerName: "api.github.com", PeerCertificates: certs}); err != nil {
+		t.Errorf("pin checked for another host: %v", err)
+	}
+	if err := verify(tls.ConnectionState{ServerName: "example.com", PeerCertificates: certs}); err == nil {
+		t.Error("non-matching certificate accepted for the pinned host")
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+)
+
+// Supported dispatch providers
+const (
+	providerGitHub      = "github"
+	providerAzureDevOps = "azuredevops"
+	providerCommand     = "command"
+	providerJenkins     = "jenkins"
+)
+
+// Dispatch modes of GitHub targets
+const (
+	dispatchModeRepository = "repository"
+	dispatchModeWorkflow   = "workflow"
+)
+
+// azureDevOpsAPIURL is the base URL of the Azure DevOps REST API
+const azureDevOpsAPIURL = "https://dev.azure.com"
+
+// Name returns the identifier used to track dispatches to the target
+func (t Target) Name() string {
+	switch t.Provider {
+	case providerAzureDevOps:
+		return fmt.Sprintf("azuredevops:%s/%s/%d", t.AzureOrganization, t.AzureProject, t.AzurePipelineID)
+	case providerCommand:
+		return "command:" + strings.Join(t.Command, " ")
+	case providerJenkins:
+		return "jenkins:" + t.JenkinsJobURL
+	default:
+		if t.DispatchMode == dispatchModeWorkflow {
+			return t.GitHubRepo + ":" + t.WorkflowFile
+		}
+		return t.GitHubRepo
+	}
+}
+
+// dispatchURL returns the GitHub endpoint dispatches to the target are posted to
+func (t Target) dispatchURL() string {
+	if t.DispatchMode == dispatchModeWorkflow {
+		return fmt.Sprintf("%s/repos/%s/actions/workflows/%s/dispatches", githubAPIURL, t.GitHubRepo, url.PathEscape(t.WorkflowFile))
+	}
+	return fmt.Sprintf("%s/repos/%s/dispatches", githubAPIURL, t.GitHubRepo)
+}
+
+// workflowInputs are the payload fields sent as workflow_dispatch inputs besides
+// payload_fields, since workflows reject inputs they don't declare. variant is only
+// sent with variant_regex set and dispatch_id only to canaries.
+var workflowInputs = []string{"ipa_url", "is_testflight", "variant", "dispatch_id"}
+
+// stringParameters converts payload values to strings, encoding non-string values as JSON
+func stringParameters(payload map[string]interface{}) (map[string]string, error) {
+	parameters := make(map[string]string, len(payload))
+	for key, value := range payload {
+		if str, ok := value.(string); ok {
+			parameters[key] = str
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		parameters[key] = string(encoded)
+	}
+	return parameters, nil
+}
+
+// receivesBranch reports whether the target is dispatched updates of a branch
+func (t Target) receivesBranch(branch string) bool {
+	if len(t.Branches) > 0 && !containsString(t.Branches, branch) {
+		return false
+	}
+	return !containsString(t.ExcludeBranches, branch)
+}
+
+// contentType returns the media type dispatch bodies are sent with
+func (t Target) contentType() string {
+	if t.ContentType != "" {
+		return t.ContentType
+	}
+	return "application/json"
+}
+
+// buildDispatchBody builds the request body sent to a target's provider
+func buildDispatchBody(target Target, eventType string, clientPayload map[string]interface{}) ([]byte, error) {
+	switch target.Provider {
+	case providerAzureDevOps:
+		// Pipeline template parameters are strings, so non-string values are sent as JSON
+		parameters, err := stringParameters(clientPayload)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(map[string]interface{}{
+			"templateParameters": parameters,
+		})
+	case providerCommand:
+		return json.Marshal(clientPayload)
+	case providerJenkins:
+		return buildJenkinsParameters(clientPayload)
+	default:
+		if target.DispatchMode == dispatchModeWorkflow {
+			// Workflow inputs are strings too, and limited to what the workflow declares
+			inputs := map[string]interface{}{}
+			for key, value := range clientPayload {
+				_, custom := target.PayloadFields[key]
+				if custom || containsString(workflowInputs, key) {
+					inputs[key] = value
+				}
+			}
+			parameters, err := stringParameters(inputs)
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(map[string]interface{}{
+				"ref":    target.Ref,
+				"inputs": parameters,
+			})
+		}
+		return json.Marshal(map[string]interface{}{
+			"event_type":     eventType,
+			"client_payload": clientPayload,
+		})
+	}
+}
+
+// sendDispatch sends a prepared request body to a target through its provider
+func (c *DipaChecker) sendDispatch(target Target, body []byte) error {
+	switch target.Provider {
+	case providerAzureDevOps:
+		return c.postAzurePipelineRun(target, body)
+	case providerCommand:
+		return c.runDispatchCommand(target, body)
+	case providerJenkins:
+		return c.postJenkinsBuild(target, body)
+	default:
+		return c.postDispatch(target, body)
+	}
+}
+
+// postAzurePipelineRun queues an Azure DevOps pipeline run
+func (c *DipaChecker) postAzurePipelineRun(target Target, body []byte) error {
+	url := fmt.Sprintf("%s/%s/%s/_apis/pipelines/%d/runs?api-version=7.1",
+		azureDevOpsAPIURL, target.AzureOrganization, target.AzureProject, target.AzurePipelineID)
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+
+	// Personal access tokens are sent as the basic auth password
+	req.SetBasicAuth("", target.AzureToken)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", target.contentType())
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if !target.acceptsStatus(resp.StatusCode, http.StatusOK) {
+		respBody, _ := io.ReadAll(resp.Body)
+		return &DispatchError{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+
+	return nil
+}
+
+// runDispatchCommand runs a target's command without a shell, passing the IPA URL
+// as the last argument and the client payload as JSON on stdin. A zero exit code is success.
+func (c *DipaChecker) runDispatchCommand(target Target, body []byte) error {
+	var payload struct {
+		IPAURL string `json:"ipa_url"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return fmt.Errorf("error decoding payload: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Client.Timeout)
+	defer cancel()
+
+	args := append(append([]string{}, target.Command[1:]...), payload.IPAURL)
+	cmd := exec.CommandContext(ctx, target.Command[0], args...)
+	cmd.Stdin = bytes.NewReader(body)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("command failed: %w, output: %s", err, trimString(string(output), 200))
+	}
+
+	return nil
+}
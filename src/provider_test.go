@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestExcludeBranches(t *testing.T) {
+	target := Target{GitHubRepo: "example/app", ExcludeBranches: []string{"testflight"}}
+
+	if !target.receivesBranch("stable") {
+		t.Error("target does not receive a branch it doesn't exclude")
+	}
+	if target.receivesBranch("testflight") {
+		t.Error("target receives an excluded branch")
+	}
+}
+
+func TestExcludeBranchesInGroupOverride(t *testing.T) {
+	_, err := loadTestConfig(t, `
+[[targets]]
+github_repo = "example/app"
+github_token = "token"
+
+[[target_groups]]
+repos = ["example/one", "example/two"]
+github_token = "token"
+branches = ["stable"]
+
+[[target_groups.overrides]]
+github_repo = "example/two"
+exclude_branches = ["testflight"]
+`)
+	if err == nil || !strings.Contains(err.Error(), "cannot set both") {
+		t.Errorf("error %v, want a target with both branch lists rejected", err)
+	}
+}
+
+// TestExcludedBranchNotDispatched checks both branches and expects the excluding
+// targets, including expanded repo templates, to only receive stable
+func TestExcludedBranchNotDispatched(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable", "testflight"]
+dispatch_retries = 0
+
+[[targets]]
+github_repo = "example/all"
+github_token = "token"
+
+[[targets]]
+github_repo = "example/stable-only"
+github_token = "token"
+exclude_branches = ["testflight"]
+
+[[targets]]
+repo_template = "example/app-{{.Branch}}"
+github_token = "token"
+exclude_branches = ["testflight"]
+`)
+
+	var mu sync.Mutex
+	received := map[string][]string{}
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/stable/":
+			w.Write(listingJSON("Discord_228.0.ipa"))
+		case r.URL.Path == "/testflight/":
+			w.Write(listingJSON("Discord_229.0.ipa"))
+		case strings.HasSuffix(r.URL.Path, "/dispatches"):
+			repo := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/repos/"), "/dispatches")
+			body, _ := io.ReadAll(r.Body)
+			branch := "stable"
+			if strings.Contains(string(body), `"branch":"testflight"`) {
+				branch = "testflight"
+			}
+			mu.Lock()
+			received[repo] = append(received[repo], branch)
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	if !checker.CheckAll(checker.Config.Branches) {
+		t.Fatal("CheckAll reported a failed check")
+	}
+
+	want := map[string]int{"example/all": 2, "example/stable-only": 1, "example/app-stable": 1}
+	for repo, count := range want {
+		if len(received[repo]) != count {
+			t.Errorf("%s received %v, want %d dispatches", repo, received[repo], count)
+		}
+	}
+	if branches := received["example/stable-only"]; len(branches) > 0 && branches[0] != "stable" {
+		t.Errorf("example/stable-only received %v, want only stable", branches)
+	}
+	if _, ok := received["example/app-testflight"]; ok {
+		t.Error("repo_template expanded a repo for an excluded branch")
+	}
+}
+
+func TestAzureDevOpsDispatch(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+dispatch_retries = 0
+
+[[targets]]
+provider = "azuredevops"
+azure_organization = "example"
+azure_project = "apps"
+azure_pipeline_id = 42
+azure_token = "pat"
+`)
+
+	var mu sync.Mutex
+	var runs []map[string]interface{}
+	status := http.StatusOK
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.URL.Path == "/stable/" {
+			w.Write(listingJSON("Discord_228.0.ipa"))
+			return
+		}
+		if r.Method != "POST" || r.URL.Path != "/example/apps/_apis/pipelines/42/runs" || r.URL.Query().Get("api-version") == "" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		if user, pass, ok := r.BasicAuth(); !ok || user != "" || pass != "pat" {
+			t.Errorf("basic auth %q/%q, want the PAT as password", user, pass)
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		runs = append(runs, body)
+		w.WriteHeader(status)
+	}))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	if len(runs) != 1 {
+		t.Fatalf("queued %d runs, want 1", len(runs))
+	}
+	parameters, _ := runs[0]["templateParameters"].(map[string]interface{})
+	if url, _ := parameters["ipa_url"].(string); !strings.HasSuffix(url, "/stable/Discord_228.0.ipa") {
+		t.Errorf("ipa_url parameter %q, want the new IPA", parameters["ipa_url"])
+	}
+	if branch := parameters["branch"]; branch != "stable" {
+		t.Errorf("branch parameter %v, want stable", branch)
+	}
+	mu.Unlock()
+
+	// GitHub's 204 is not success for Azure DevOps, which answers 200
+	target := checker.Config.Targets[0]
+	for code, ok := range map[int]bool{http.StatusOK: true, http.StatusNoContent: false, http.StatusUnauthorized: false} {
+		mu.Lock()
+		status = code
+		mu.Unlock()
+
+		err := checker.sendDispatch(target, []byte(`{"templateParameters": {}}`))
+		if (err == nil) != ok {
+			t.Errorf("status %d: error %v, want success %v", code, err, ok)
+		}
+	}
+}
+
+func TestAzureDevOpsValidation(t *testing.T) {
+	base := "[[targets]]\nprovider = \"azuredevops\"\n"
+	tests := []struct {
+		fields, wantErr string
+	}{
+		{"azure_pipeline_id = 42\nazure_token = \"pat\"\n", "azure_organization and azure_project are required"},
+		{"azure_organization = \"example\"\nazure_project = \"apps\"\nazure_token = \"pat\"\n", "azure_pipeline_id is required"},
+		{"azure_organization = \"example\"\nazure_project = \"apps\"\nazure_pipeline_id = 42\n", "azure_token is required"},
+	}
+
+	for _, tt := range tests {
+		_, err := loadTestConfig(t, base+tt.fields)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("error %v, want %q", err, tt.wantErr)
+		}
+	}
+}
+
+func TestDispatchContentType(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+
+[[targets]]
+github_repo = "example/default"
+github_token = "token"
+
+[[targets]]
+github_repo = "example/custom"
+github_token = "token"
+content_type = "application/vnd.custom+json"
+`)
+
+	var mu sync.Mutex
+	contentTypes := map[string]string{}
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stable/" {
+			w.Write(listingJSON("Discord_228.0.ipa"))
+			return
+		}
+		mu.Lock()
+		contentTypes[r.URL.Path] = r.Header.Get("Content-Type")
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := contentTypes["/repos/example/default/dispatches"]; got != "application/json" {
+		t.Errorf("default Content-Type %q, want application/json", got)
+	}
+	if got := contentTypes["/repos/example/custom/dispatches"]; got != "application/vnd.custom+json" {
+		t.Errorf("overridden Content-Type %q, want application/vnd.custom+json", got)
+	}
+}
+
+func TestContentTypeValidation(t *testing.T) {
+	for _, contentType := range []string{"json", "application/json; charset", ""} {
+		_, err := loadTestConfig(t, "[[targets]]\ngithub_repo = \"example/app\"\ngithub_token = \"token\"\ncontent_type = "+strconvQuote(contentType)+"\n")
+		if contentType == "" {
+			if err != nil {
+				t.Errorf("empty content_type rejected: %v", err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), "must be a media type") {
+			t.Errorf("content_type %q: error %v, want it rejected", contentType, err)
+		}
+	}
+}
+
+func TestWorkflowDispatch(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["testflight"]
+
+[[targets]]
+github_repo = "example/app"
+github_token = "token"
+dispatch_mode = "workflow"
+workflow_file = "build.yml"
+ref = "main"
+
+[targets.payload_fields]
+channel = "beta"
+`)
+
+	var mu sync.Mutex
+	var bodies []map[string]interface{}
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/testflight/" {
+			w.Write(listingJSON("Discord_228.0.ipa"))
+			return
+		}
+		if r.Method != "POST" || r.URL.Path != "/repos/example/app/actions/workflows/build.yml/dispatches" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		bodies = append(bodies, body)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	if err := checker.CheckBranch("testflight"); err != nil {
+		t.Fatal(err)
+	}
+	if failed := checker.BranchStatusSnapshot()["testflight"].Failed; len(failed) != 0 {
+		t.Errorf("failed %v, want the 204 counted as success", failed)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 1 {
+		t.Fatalf("sent %d workflow dispatches, want 1", len(bodies))
+	}
+	if ref := bodies[0]["ref"]; ref != "main" {
+		t.Errorf("ref %v, want main", ref)
+	}
+	if _, ok := bodies[0]["event_type"]; ok {
+		t.Error("workflow dispatch carries a repository_dispatch event_type")
+	}
+
+	// Inputs are strings limited to the IPA fields and payload_fields, which the workflow declares
+	inputs, _ := bodies[0]["inputs"].(map[string]interface{})
+	if url, _ := inputs["ipa_url"].(string); !strings.HasSuffix(url, "/testflight/Discord_228.0.ipa") {
+		t.Errorf("ipa_url input %v, want the new IPA", inputs["ipa_url"])
+	}
+	if inputs["is_testflight"] != "true" || inputs["channel"] != "beta" {
+		t.Errorf("inputs %v, want is_testflight \"true\" and channel beta", inputs)
+	}
+	if _, ok := inputs["branch"]; ok {
+		t.Errorf("inputs %v include the undeclared branch field", inputs)
+	}
+}
+
+func TestDispatchModeValidation(t *testing.T) {
+	base := "[[targets]]\ngithub_repo = \"example/app\"\ngithub_token = \"token\"\n"
+	tests := []struct {
+		fields, wantErr string
+	}{
+		{"dispatch_mode = \"workflow\"\nref = \"main\"\n", "workflow_file and ref are required"},
+		{"dispatch_mode = \"workflow\"\nworkflow_file = \"build.yml\"\n", "workflow_file and ref are required"},
+		{"dispatch_mode = \"actions\"\n", "must be 'repository' or 'workflow'"},
+		{"dispatch_mode = \"repository\"\n", ""},
+	}
+
+	for _, tt := range tests {
+		_, err := loadTestConfig(t, base+tt.fields)
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: %v", tt.fields, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: error %v, want %q", tt.fields, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// PruneSet lists the hash file entries pruning removes
+type PruneSet struct {
+	// Hashes are the dispatch records of superseded hashes per branch
+	Hashes map[string][]string
+	// Repos are the last payloads and dispatch times of targets no longer configured
+	Repos []string
+}
+
+// Empty reports whether there is nothing to prune
+func (p PruneSet) Empty() bool {
+	return len(p.Hashes) == 0 && len(p.Repos) == 0
+}
+
+// prunable finds the dispatch records of hashes other than each branch's current one,
+// keeping the records of each variant's current file
+// and the stored payloads of removed targets
+func (c *DipaChecker) prunable() PruneSet {
+	set := PruneSet{Hashes: make(map[string][]string)}
+
+	for _, branch := range c.branchNames() {
+		branchData := c.BranchData.Branches[branch]
+		for hash := range branchData.Dispatches {
+			if !branchData.tracksKey(hash) {
+				set.Hashes[branch] = append(set.Hashes[branch], hash)
+			}
+		}
+		sort.Strings(set.Hashes[branch])
+	}
+	for branch, hashes := range set.Hashes {
+		if len(hashes) == 0 {
+			delete(set.Hashes, branch)
+		}
+	}
+
+	configured := make(map[string]bool, len(c.Config.Targets))
+	for _, target := range c.Config.Targets {
+		configured[target.Name()] = true
+	}
+	for repo := range c.BranchData.LastPayloads {
+		if !configured[repo] {
+			set.Repos = append(set.Repos, repo)
+		}
+	}
+	for repo := range c.BranchData.LastDispatched {
+		if !configured[repo] && !containsString(set.Repos, repo) {
+			set.Repos = append(set.Repos, repo)
+		}
+	}
+	sort.Strings(set.Repos)
+
+	return set
+}
+
+// pruned returns a copy of the hash data without the entries in set
+func (c *DipaChecker) pruned(set PruneSet) BranchHashes {
+	data := c.BranchData
+
+	data.Branches = make(map[string]BranchData, len(c.BranchData.Branches))
+	for branch, branchData := range c.BranchData.Branches {
+		dispatches := make(map[string][]string, len(branchData.Dispatches))
+		for hash, repos := range branchData.Dispatches {
+			if !containsString(set.Hashes[branch], hash) {
+				dispatches[hash] = repos
+			}
+		}
+		branchData.Dispatches = dispatches
+		data.Branches[branch] = branchData
+	}
+
+	data.LastPayloads = make(map[string]string, len(c.BranchData.LastPayloads))
+	for repo, payload := range c.BranchData.LastPayloads {
+		if !containsString(set.Repos, repo) {
+			data.LastPayloads[repo] = payload
+		}
+	}
+	data.LastDispatched = make(map[string]time.Time, len(c.BranchData.LastDispatched))
+	for repo, at := range c.BranchData.LastDispatched {
+		if !containsString(set.Repos, repo) {
+			data.LastDispatched[repo] = at
+		}
+	}
+
+	return data
+}
+
+// Prune removes superseded entries from the hash file and reports what was removed
+// along with the encoded size before and after. With dryRun nothing is saved.
+func (c *DipaChecker) Prune(dryRun bool) (PruneSet, int, int, error) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	// Merge first and write the result as it is, since merging after pruning would
+	// bring back the payloads of removed targets from the hash file
+	c.mergeStoredState()
+	set := c.prunable()
+	after := c.pruned(set)
+
+	before, err := encodeHashes(&c.BranchData, c.Config.CompactHashFile)
+	if err != nil {
+		return set, 0, 0, err
+	}
+	shrunk, err := encodeHashes(&after, c.Config.CompactHashFile)
+	if err != nil {
+		return set, 0, 0, err
+	}
+
+	if dryRun || set.Empty() {
+		return set, len(before), len(shrunk), nil
+	}
+
+	c.blockMu.Lock()
+	c.BranchData = after
+	c.blockMu.Unlock()
+	return set, len(before), len(shrunk), c.writeHashes()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPruneDryRunMatchesPrune(t *testing.T) {
+	checker := newTestChecker(t, `branches = ["stable", "testflight"]`)
+
+	now := time.Now().Truncate(time.Second)
+	checker.stateMu.Lock()
+	checker.BranchData.Branches["stable"] = BranchData{
+		Hash:     "h2",
+		Variants: map[string]string{"arm64": "Discord_229_arm64.ipa"},
+		Dispatches: map[string][]string{
+			"h1": {"example/app"},
+			"h2": {"example/app"},
+			variantKey("arm64", "Discord_228_arm64.ipa"):   {"example/app"},
+			variantKey("arm64", "Discord_229_arm64.ipa"):   {"example/app"},
+			variantKey("x86_64", "Discord_227_x86_64.ipa"): {"gone/repo"},
+		},
+	}
+	checker.BranchData.Branches["testflight"] = BranchData{
+		Hash:       "t1",
+		Dispatches: map[string][]string{"t1": {"example/app"}},
+	}
+	checker.BranchData.LastPayloads = map[string]string{"example/app": "{}", "gone/repo": "{}"}
+	checker.BranchData.LastDispatched = map[string]time.Time{"example/app": now, "gone/repo": now, "old/repo": now}
+	err := checker.writeHashes()
+	checker.stateMu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preview, before, after, err := checker.Prune(true)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	want := PruneSet{
+		Hashes: map[string][]string{"stable": {"arm64#Discord_228_arm64.ipa", "h1", "x86_64#Discord_227_x86_64.ipa"}},
+		Repos:  []string{"gone/repo", "old/repo"},
+	}
+	if !reflect.DeepEqual(preview, want) {
+		t.Errorf("dry run would prune %+v, want %+v", preview, want)
+	}
+	if after >= before {
+		t.Errorf("dry run reports %d bytes after pruning, want fewer than %d", after, before)
+	}
+
+	// The dry run changes nothing, in memory or on disk
+	if got := reopen(t, checker).prunable(); !reflect.DeepEqual(got, preview) {
+		t.Errorf("dry run changed the hash file, it would now prune %+v", got)
+	}
+
+	checker.stateMu.Lock()
+	expected := checker.pruned(preview)
+	checker.stateMu.Unlock()
+
+	set, prunedBefore, prunedAfter, err := checker.Prune(false)
+	if err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	if !reflect.DeepEqual(set, preview) {
+		t.Errorf("pruned %+v, but the dry run showed %+v", set, preview)
+	}
+	if prunedBefore != before || prunedAfter != after {
+		t.Errorf("prune reports %d -> %d bytes, the dry run %d -> %d", prunedBefore, prunedAfter, before, after)
+	}
+
+	// What is left on disk is exactly what the dry run previewed
+	stored := reopen(t, checker)
+	for _, branch := range []string{"stable", "testflight"} {
+		got := stored.BranchData.Branches[branch].Dispatches
+		if !reflect.DeepEqual(got, expected.Branches[branch].Dispatches) {
+			t.Errorf("%s dispatches after pruning are %v, want %v", branch, got, expected.Branches[branch].Dispatches)
+		}
+	}
+	if !reflect.DeepEqual(stored.BranchData.LastPayloads, expected.LastPayloads) {
+		t.Errorf("payloads after pruning are %v, want %v", stored.BranchData.LastPayloads, expected.LastPayloads)
+	}
+	if len(stored.BranchData.LastDispatched) != 1 {
+		t.Errorf("dispatch times after pruning are %v, want only example/app", stored.BranchData.LastDispatched)
+	}
+	if !stored.prunable().Empty() {
+		t.Errorf("nothing should be left to prune, got %+v", stored.prunable())
+	}
+}
+
+// TestPruneCommandDryRun previews with the subcommand's own -dry-run flag, without DRY_RUN
+func TestPruneCommandDryRun(t *testing.T) {
+	checker := newTestChecker(t, `branches = ["stable"]`)
+	t.Setenv("CONFIG_PATH", filepath.Join(checker.Config.HashDir, "config.toml"))
+	t.Setenv("DRY_RUN", "")
+
+	checker.stateMu.Lock()
+	checker.BranchData.Branches["stable"] = BranchData{
+		Hash:       "h2",
+		Dispatches: map[string][]string{"h1": {"example/app"}, "h2": {"example/app"}},
+	}
+	err := checker.writeHashes()
+	checker.stateMu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runPrune([]string{"-dry-run"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := reopen(t, checker).prunable(); len(got.Hashes["stable"]) != 1 {
+		t.Fatalf("prune -dry-run changed the hash file, it would now prune %+v", got)
+	}
+
+	if err := runPrune(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := reopen(t, checker).prunable(); !got.Empty() {
+		t.Errorf("prune left %+v", got)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// fetchListingQuorum fetches the listing from each resolved backend of the IPA host
+// and keeps the entries that at least listing_quorum backends agree on, so that
+// eventually consistent mirrors behind DNS round-robin do not make the hash flap
+func (c *DipaChecker) fetchListingQuorum(branch string) ([]IPAFile, error) {
+	backends, err := c.listingBackends()
+	if err != nil {
+		return nil, err
+	}
+	if len(backends) < c.Config.ListingQuorum {
+		return nil, fmt.Errorf("only %d backends resolved, listing_quorum is %d", len(backends), c.Config.ListingQuorum)
+	}
+
+	listings := [][]IPAFile{}
+	var lastErr error
+	for _, backend := range backends {
+		files, err := c.fetchListingFromBackend(branch, backend)
+		if err != nil {
+			log.Printf("Error fetching %s listing from backend %s: %v", branch, backend, err)
+			lastErr = err
+			continue
+		}
+		listings = append(listings, files)
+	}
+
+	if len(listings) < c.Config.ListingQuorum {
+		return nil, fmt.Errorf("only %d of %d backends answered, listing_quorum is %d: %w",
+			len(listings), len(backends), c.Config.ListingQuorum, lastErr)
+	}
+
+	return quorumFiles(listings, c.Config.ListingQuorum), nil
+}
+
+// quorumFiles returns the files listed identically in at least quorum listings,
+// in the order they first appear
+func quorumFiles(listings [][]IPAFile, quorum int) []IPAFile {
+	counts := make(map[IPAFile]int)
+	order := []IPAFile{}
+	for _, files := range listings {
+		seen := make(map[IPAFile]bool)
+		for _, file := range files {
+			key := file
+			key.ModTime = file.ModTime.UTC()
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if counts[key] == 0 {
+				order = append(order, file)
+			}
+			counts[key]++
+		}
+	}
+
+	agreed := make([]IPAFile, 0, len(order))
+	for _, file := range order {
+		key := file
+		key.ModTime = file.ModTime.UTC()
+		if counts[key] >= quorum {
+			agreed = append(agreed, file)
+		}
+	}
+	return agreed
+}
+
+// listingBackends resolves the IPA host to the backend addresses to fetch from
+func (c *DipaChecker) listingBackends() ([]string, error) {
+	parsed, err := url.Parse(c.Config.IPABaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ipa_base_url: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, parsed.Hostname())
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", parsed.Hostname(), err)
+	}
+
+	backends := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		backends = append(backends, addr.IP.String())
+	}
+
+	if samples := c.Config.ListingBackendSamples; samples > 0 && len(backends) > samples {
+		c.rngMu.Lock()
+		c.rng.Shuffle(len(backends), func(i, j int) { backends[i], backends[j] = backends[j], backends[i] })
+		c.rngMu.Unlock()
+		backends = backends[:samples]
+	}
+	return backends, nil
+}
+
+// fetchListingFromBackend fetches the listing with connections pinned to one backend IP.
+// The request still carries the original host, so TLS and virtual hosting are unaffected.
+func (c *DipaChecker) fetchListingFromBackend(branch, ip string) ([]IPAFile, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if base, ok := c.Client.Transport.(*http.Transport); ok {
+		transport = base.Clone()
+	}
+	defer transport.CloseIdleConnections()
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		return dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+	}
+
+	client := &http.Client{Transport: transport, Timeout: c.Client.Timeout}
+	return c.fetchListingWith(client, branch)
+}
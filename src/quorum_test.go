@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQuorumFiles(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	older := IPAFile{Name: "Discord_228.0.ipa", ModTime: base}
+	newer := IPAFile{Name: "Discord_229.0.ipa", ModTime: base.Add(time.Hour)}
+	// The same file, mid-sync on one backend with a different mod_time
+	resynced := IPAFile{Name: "Discord_229.0.ipa", ModTime: base.Add(2 * time.Hour)}
+	// The same instant as newer, reported in another timezone
+	offset := IPAFile{Name: "Discord_229.0.ipa", ModTime: newer.ModTime.In(time.FixedZone("CET", 3600))}
+
+	tests := []struct {
+		name     string
+		listings [][]IPAFile
+		quorum   int
+		want     []string
+	}{
+		{
+			name:     "backends agree",
+			listings: [][]IPAFile{{older, newer}, {older, newer}},
+			quorum:   2,
+			want:     []string{"Discord_228.0.ipa", "Discord_229.0.ipa"},
+		},
+		{
+			name:     "one backend is behind",
+			listings: [][]IPAFile{{older, newer}, {older}},
+			quorum:   2,
+			want:     []string{"Discord_228.0.ipa"},
+		},
+		{
+			name:     "backends disagree on a mod_time",
+			listings: [][]IPAFile{{older, newer}, {older, resynced}},
+			quorum:   2,
+			want:     []string{"Discord_228.0.ipa"},
+		},
+		{
+			name:     "mod_times in different timezones",
+			listings: [][]IPAFile{{older, newer}, {older, offset}},
+			quorum:   2,
+			want:     []string{"Discord_228.0.ipa", "Discord_229.0.ipa"},
+		},
+		{
+			name:     "duplicates count once per backend",
+			listings: [][]IPAFile{{older, newer, newer}, {older}},
+			quorum:   2,
+			want:     []string{"Discord_228.0.ipa"},
+		},
+		{
+			name:     "quorum of one keeps every file",
+			listings: [][]IPAFile{{newer}, {older, resynced}},
+			quorum:   1,
+			want:     []string{"Discord_229.0.ipa", "Discord_228.0.ipa", "Discord_229.0.ipa"},
+		},
+		{
+			name:     "no agreement",
+			listings: [][]IPAFile{{older}, {newer}},
+			quorum:   2,
+			want:     []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, file := range quorumFiles(tt.listings, tt.quorum) {
+				got = append(got, file.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("quorumFiles = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Base delays of the retry backoff, retry_backoff replaces the fetch default
+const (
+	defaultFetchRetryBase    = time.Second
+	defaultDispatchRetryBase = time.Second
+)
+
+// isRetryableFetchError reports whether a listing fetch failed transiently: with a
+// network error, a 5xx or 429 response or a body that is not a JSON listing
+func isRetryableFetchError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var badBody *BadBodyError
+	var statusErr *StatusError
+	var urlErr *url.Error
+	var netErr net.Error
+	switch {
+	case errors.As(err, &badBody):
+		return true
+	case errors.As(err, &statusErr):
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	default:
+		return errors.As(err, &urlErr) || errors.As(err, &netErr)
+	}
+}
+
+// retryAfterDelay returns the delay requested by the Retry-After header of a 429 response,
+// given either in seconds or as an HTTP date
+func retryAfterDelay(err error) (time.Duration, bool) {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusTooManyRequests || statusErr.RetryAfter == "" {
+		return 0, false
+	}
+
+	return parseRetryAfter(statusErr.RetryAfter)
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(at); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// maxDispatchRetryAfter is the longest rate limit wait honored before a dispatch is
+// given up on instead, so a long reset does not stall the check cycle
+const maxDispatchRetryAfter = 5 * time.Minute
+
+// secondaryRateLimitPattern matches GitHub's secondary rate limit error message
+var secondaryRateLimitPattern = regexp.MustCompile(`(?i)secondary rate limit`)
+
+// rateLimitDelay returns the wait GitHub asks for through Retry-After, or through
+// X-RateLimit-Reset once the primary rate limit is used up
+func rateLimitDelay(header http.Header) time.Duration {
+	if delay, ok := parseRetryAfter(header.Get("Retry-After")); ok {
+		return delay
+	}
+
+	if header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			if delay := time.Until(time.Unix(reset, 0)); delay > 0 {
+				return delay
+			}
+		}
+	}
+	return 0
+}
+
+// isRateLimitError reports whether a dispatch was rejected by a rate limit: a 429,
+// or a 403 for the secondary rate limit or with rate limit headers asking to wait
+func isRateLimitError(err error) bool {
+	var dispatchErr *DispatchError
+	if !errors.As(err, &dispatchErr) {
+		return false
+	}
+
+	switch dispatchErr.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+		return dispatchErr.RetryAfter > 0 || secondaryRateLimitPattern.MatchString(dispatchErr.Body)
+	default:
+		return false
+	}
+}
+
+// isServerError reports whether a dispatch failed with a 5xx response
+func isServerError(err error) bool {
+	var dispatchErr *DispatchError
+	return errors.As(err, &dispatchErr) && dispatchErr.StatusCode >= 500
+}
+
+// Jitter strategies for retry backoff
+const (
+	jitterNone  = "none"
+	jitterFull  = "full"
+	jitterEqual = "equal"
+)
+
+// backoff returns the delay before retry attempt (0-based), growing as base*2^attempt
+// and randomized according to jitter_strategy:
+//   - none: exactly base*2^attempt
+//   - full: uniformly between 0 and base*2^attempt
+//   - equal (default): half of base*2^attempt plus up to the other half at random
+func (c *DipaChecker) backoff(base time.Duration, attempt int) time.Duration {
+	delay := base << uint(attempt)
+	if delay <= 0 {
+		return 0
+	}
+
+	switch c.Config.JitterStrategy {
+	case jitterNone:
+		return delay
+	case jitterFull:
+		return c.randDuration(delay)
+	default:
+		return delay/2 + c.randDuration(delay-delay/2)
+	}
+}
+
+// randDuration returns a random duration in [0, n)
+func (c *DipaChecker) randDuration(n time.Duration) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+
+	c.rngMu.Lock()
+	defer c.rngMu.Unlock()
+	return time.Duration(c.rng.Int63n(int64(n)))
+}
+
+// resetRetryBudget refills the retry budget at the start of a check cycle
+func (c *DipaChecker) resetRetryBudget() {
+	c.retryMu.Lock()
+	c.retriesLeft = c.Config.RetryBudget
+	c.retryMu.Unlock()
+}
+
+// takeRetry consumes one retry from the cycle's budget and reports whether one was left.
+// Retries are unlimited when retry_budget is not set.
+func (c *DipaChecker) takeRetry() bool {
+	if c.Config.RetryBudget <= 0 {
+		return true
+	}
+
+	c.retryMu.Lock()
+	defer c.retryMu.Unlock()
+
+	if c.retriesLeft <= 0 {
+		return false
+	}
+	c.retriesLeft--
+	return true
+}
+
+// sendDispatchWithRetry sends a dispatch, retrying transient 5xx responses with jittered backoff
+// and rate limited ones after the wait GitHub asks for
+func (c *DipaChecker) sendDispatchWithRetry(target Target, body []byte) error {
+	err := c.sendDispatch(target, body)
+	for attempt := 0; attempt < c.Config.DispatchRetries && (isServerError(err) || isRateLimitError(err)); attempt++ {
+		delay := c.backoff(c.dispatchRetryBase, attempt)
+		reason := "a server error"
+
+		var dispatchErr *DispatchError
+		if isRateLimitError(err) && errors.As(err, &dispatchErr) {
+			reason = "a rate limit"
+			if dispatchErr.RetryAfter > maxDispatchRetryAfter {
+				log.Printf("Rate limit of %s resets in %s, not retrying", target.Name(), dispatchErr.RetryAfter.Round(time.Second))
+				break
+			}
+			if dispatchErr.RetryAfter > delay {
+				delay = dispatchErr.RetryAfter
+			}
+		}
+
+		if !c.takeRetry() {
+			log.Printf("Retry budget exhausted, not retrying dispatch to %s", target.Name())
+			break
+		}
+
+		log.Printf("Dispatch to %s failed with %s, retrying in %s (attempt %d/%d): %v",
+			target.Name(), reason, delay.Round(time.Millisecond), attempt+1, c.Config.DispatchRetries, err)
+		time.Sleep(delay)
+
+		err = c.sendDispatch(target, body)
+	}
+	return err
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoffJitterBounds(t *testing.T) {
+	base := 100 * time.Millisecond
+
+	tests := []struct {
+		strategy string
+		min, max func(delay time.Duration) time.Duration
+	}{
+		{jitterNone,
+			func(delay time.Duration) time.Duration { return delay },
+			func(delay time.Duration) time.Duration { return delay }},
+		{jitterFull,
+			func(delay time.Duration) time.Duration { return 0 },
+			func(delay time.Duration) time.Duration { return delay - 1 }},
+		{jitterEqual,
+			func(delay time.Duration) time.Duration { return delay / 2 },
+			func(delay time.Duration) time.Duration { return delay - 1 }},
+		// equal is the default
+		{"",
+			func(delay time.Duration) time.Duration { return delay / 2 },
+			func(delay time.Duration) time.Duration { return delay - 1 }},
+	}
+
+	for _, tt := range tests {
+		checker := newTestChecker(t, "")
+		checker.Config.JitterStrategy = tt.strategy
+		checker.rng = rand.New(rand.NewSource(1))
+
+		for attempt := 0; attempt < 5; attempt++ {
+			delay := base << uint(attempt)
+			distinct := map[time.Duration]bool{}
+			for i := 0; i < 200; i++ {
+				got := checker.backoff(base, attempt)
+				if got < tt.min(delay) || got > tt.max(delay) {
+					t.Fatalf("%q attempt %d: backoff %s outside [%s, %s]",
+						tt.strategy, attempt, got, tt.min(delay), tt.max(delay))
+				}
+				distinct[got] = true
+			}
+			if tt.strategy != jitterNone && len(distinct) < 2 {
+				t.Errorf("%q attempt %d: backoff is not randomized", tt.strategy, attempt)
+			}
+		}
+	}
+}
+
+func TestBackoffSeeded(t *testing.T) {
+	first := newTestChecker(t, `jitter_strategy = "full"`)
+	second := newTestChecker(t, `jitter_strategy = "full"`)
+	first.rng = rand.New(rand.NewSource(42))
+	second.rng = rand.New(rand.NewSource(42))
+
+	// The same seed gives the same delays, so the jitter comes from c.rng alone
+	for attempt := 0; attempt < 5; attempt++ {
+		a := first.backoff(time.Second, attempt)
+		b := second.backoff(time.Second, attempt)
+		if a != b {
+			t.Errorf("attempt %d: %s and %s from the same seed", attempt, a, b)
+		}
+	}
+}
+
+func TestBackoffOverflow(t *testing.T) {
+	checker := newTestChecker(t, "")
+
+	// Shifting far enough overflows into a non-positive delay, which must not panic
+	if got := checker.backoff(time.Second, 70); got != 0 {
+		t.Errorf("backoff after overflow = %s, want 0", got)
+	}
+}
+
+// TestDispatchRetriesServerErrors answers each dispatch with the next status in the
+// sequence and expects only 5xx responses to be retried, up to dispatch_retries
+func TestDispatchRetriesServerErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		wantErr  bool
+		attempts int
+	}{
+		{"502 then 204", []int{http.StatusBadGateway, http.StatusNoContent}, false, 2},
+		{"400 is not retried", []int{http.StatusBadRequest, http.StatusNoContent}, true, 1},
+		{"retries exhausted", []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable}, true, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := newTestChecker(t, "dispatch_retries = 2\n")
+
+			var attempts int32
+			serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&attempts, 1)
+				w.WriteHeader(tt.statuses[n-1])
+			}))
+
+			err := checker.sendDispatchWithRetry(checker.Config.Targets[0], []byte(`{"event_type":"ipa-update"}`))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error %v, want error %v", err, tt.wantErr)
+			}
+			if got := atomic.LoadInt32(&attempts); int(got) != tt.attempts {
+				t.Errorf("%d attempts, want %d", got, tt.attempts)
+			}
+		})
+	}
+}
+
+// rateLimited is a dispatch response of TestDispatchRetriesRateLimits
+type rateLimited struct {
+	status  int
+	headers map[string]string
+	body    string
+}
+
+// TestDispatchRetriesRateLimits answers each dispatch with the next response in the
+// sequence and expects rate limits to be retried after the wait their headers ask for
+func TestDispatchRetriesRateLimits(t *testing.T) {
+	reset := time.Now().Add(3 * time.Second).Truncate(time.Second)
+	secondary := `{"message":"You have exceeded a secondary rate limit. Please wait a few minutes before you try again."}`
+	accepted := rateLimited{status: http.StatusNoContent}
+
+	tests := []struct {
+		name      string
+		responses []rateLimited
+		wantErr   bool
+		attempts  int
+		// wait is the least time between the first and second attempt, or with notBefore
+		// set the second attempt waits for that time
+		wait      time.Duration
+		notBefore time.Time
+	}{
+		{"429 with Retry-After",
+			[]rateLimited{{status: http.StatusTooManyRequests, headers: map[string]string{"Retry-After": "1"}}, accepted},
+			false, 2, time.Second, time.Time{}},
+		{"403 with X-RateLimit-Reset",
+			[]rateLimited{{status: http.StatusForbidden, headers: map[string]string{
+				"X-RateLimit-Remaining": "0",
+				"X-RateLimit-Reset":     strconv.FormatInt(reset.Unix(), 10),
+			}}, accepted},
+			false, 2, 0, reset},
+		{"secondary rate limit 403",
+			[]rateLimited{{status: http.StatusForbidden, body: secondary}, accepted},
+			false, 2, 0, time.Time{}},
+		{"secondary rate limit with Retry-After",
+			[]rateLimited{{status: http.StatusForbidden, headers: map[string]string{"Retry-After": "1"}, body: secondary}, accepted},
+			false, 2, time.Second, time.Time{}},
+		{"secondary rate limit retries exhausted",
+			[]rateLimited{{status: http.StatusForbidden, body: secondary}, {status: http.StatusForbidden, body: secondary}, {status: http.StatusForbidden, body: secondary}},
+			true, 3, 0, time.Time{}},
+		{"reset beyond the longest wait is not retried",
+			[]rateLimited{{status: http.StatusTooManyRequests, headers: map[string]string{"Retry-After": "3600"}}, accepted},
+			true, 1, 0, time.Time{}},
+		{"403 without a rate limit is not retried",
+			[]rateLimited{{status: http.StatusForbidden, body: `{"message":"Resource not accessible by integration"}`}, accepted},
+			true, 1, 0, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := newTestChecker(t, "dispatch_retries = 2\n")
+
+			var mu sync.Mutex
+			var times []time.Time
+			serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				times = append(times, time.Now())
+				response := tt.responses[len(times)-1]
+				mu.Unlock()
+
+				for name, value := range response.headers {
+					w.Header().Set(name, value)
+				}
+				w.WriteHeader(response.status)
+				w.Write([]byte(response.body))
+			}))
+
+			err := checker.sendDispatchWithRetry(checker.Config.Targets[0], []byte(`{"event_type":"ipa-update"}`))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error %v, want error %v", err, tt.wantErr)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(times) != tt.attempts {
+				t.Fatalf("%d attempts, want %d", len(times), tt.attempts)
+			}
+			if tt.attempts > 1 && !tt.notBefore.IsZero() {
+				if times[1].Before(tt.notBefore) || times[1].After(tt.notBefore.Add(time.Second)) {
+					t.Errorf("retried at %s, want at the rate limit reset %s", times[1].Format(time.StampMilli), tt.notBefore.Format(time.StampMilli))
+				}
+			} else if tt.attempts > 1 {
+				if waited := times[1].Sub(times[0]); waited < tt.wait {
+					t.Errorf("retried after %s, want at least %s", waited, tt.wait)
+				} else if waited > tt.wait+time.Second {
+					t.Errorf("retried after %s, want about %s", waited, tt.wait)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitDelay(t *testing.T) {
+	reset := time.Now().Add(time.Minute)
+	tests := []struct {
+		name    string
+		headers map[string]string
+		min     time.Duration
+		max     time.Duration
+	}{
+		{"Retry-After seconds", map[string]string{"Retry-After": "30"}, 30 * time.Second, 30 * time.Second},
+		{"Retry-After wins over the reset", map[string]string{
+			"Retry-After":           "5",
+			"X-RateLimit-Remaining": "0",
+			"X-RateLimit-Reset":     strconv.FormatInt(reset.Unix(), 10),
+		}, 5 * time.Second, 5 * time.Second},
+		{"reset once the limit is used up", map[string]string{
+			"X-RateLimit-Remaining": "0",
+			"X-RateLimit-Reset":     strconv.FormatInt(reset.Unix(), 10),
+		}, 58 * time.Second, time.Minute},
+		{"reset with requests remaining", map[string]string{
+			"X-RateLimit-Remaining": "12",
+			"X-RateLimit-Reset":     strconv.FormatInt(reset.Unix(), 10),
+		}, 0, 0},
+		{"reset in the past", map[string]string{
+			"X-RateLimit-Remaining": "0",
+			"X-RateLimit-Reset":     strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10),
+		}, 0, 0},
+		{"no headers", nil, 0, 0},
+	}
+
+	for _, tt := range tests {
+		header := http.Header{}
+		for name, value := range tt.headers {
+			header.Set(name, value)
+		}
+		if got := rateLimitDelay(header); got < tt.min || got > tt.max {
+			t.Errorf("%s: rateLimitDelay = %s, want between %s and %s", tt.name, got, tt.min, tt.max)
+		}
+	}
+}
+
+// TestRetryBudgetCapsCycle fails every listing fetch and dispatch in a cycle and expects
+// the retries across both branches and all targets to stop at retry_budget
+func TestRetryBudgetCapsCycle(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable", "testflight"]
+max_retries = 5
+dispatch_retries = 5
+retry_budget = 4
+
+[[targets]]
+github_repo = "example/one"
+github_token = "token"
+
+[[targets]]
+github_repo = "example/two"
+github_token = "token"
+`)
+
+	var fetches, dispatches int32
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stable/" {
+			atomic.AddInt32(&fetches, 1)
+			w.Write(listingJSON("Discord_228.0.ipa"))
+			return
+		}
+		if r.URL.Path == "/testflight/" {
+			atomic.AddInt32(&fetches, 1)
+		} else {
+			atomic.AddInt32(&dispatches, 1)
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+
+	// One fetch per branch and one dispatch per target, plus four retries in total
+	for cycle := 1; cycle <= 2; cycle++ {
+		checker.CheckAll(checker.Config.Branches)
+
+		total := atomic.LoadInt32(&fetches) + atomic.LoadInt32(&dispatches)
+		if want := int32(cycle * (2 + 2 + 4)); total != want {
+			t.Errorf("%d requests after cycle %d, want %d with the budget refilled each cycle", total, cycle, want)
+		}
+	}
+}
+
+// TestFetchRetries answers each listing fetch with the next status in the sequence and
+// expects only network-level and server errors to be retried, up to max_retries
+func TestFetchRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		wantErr  string
+		attempts int
+	}{
+		{"503 then 200", []int{http.StatusServiceUnavailable, http.StatusOK}, "", 2},
+		{"429 then 200", []int{http.StatusTooManyRequests, http.StatusOK}, "", 2},
+		{"404 is not retried", []int{http.StatusNotFound, http.StatusOK}, "404", 1},
+		{"retries exhausted", []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusInternalServerError}, "500", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := newTestChecker(t, "branches = [\"stable\"]\nmax_retries = 2\n")
+
+			var attempts int32
+			serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tt.statuses[atomic.AddInt32(&attempts, 1)-1]
+				if status != http.StatusOK {
+					w.WriteHeader(status)
+					return
+				}
+				w.Write(listingJSON("Discord_228.0.ipa"))
+			}))
+
+			files, _, err := checker.FetchIPAList("stable")
+			if tt.wantErr == "" && (err != nil || len(files) != 1) {
+				t.Errorf("fetched %d files (%v), want the listing", len(files), err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("error %v, want the last status %s", err, tt.wantErr)
+			}
+			if got := atomic.LoadInt32(&attempts); int(got) != tt.attempts {
+				t.Errorf("%d attempts, want %d", got, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestFetchHonorsRetryAfter(t *testing.T) {
+	checker := newTestChecker(t, "branches = [\"stable\"]\nmax_retries = 1\n")
+
+	var attempts int32
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write(listingJSON("Discord_228.0.ipa"))
+	}))
+
+	start := time.Now()
+	if _, _, err := checker.FetchIPAList("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want the 1s Retry-After honored over the backoff", elapsed)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{"0", 0, true},
+		{"Mon, 01 Jan 2024 00:00:00 GMT", 0, true},
+		{"-5", 0, false},
+		{"soon", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseRetryAfter(%q) = %s, %v, want %s, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if got, ok := parseRetryAfter(future); !ok || got < 59*time.Minute || got > time.Hour {
+		t.Errorf("parseRetryAfter(%q) = %s, %v, want about an hour", future, got, ok)
+	}
+}
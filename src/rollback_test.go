@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRollbackOnlyToAcceptingTargets(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+
+[[targets]]
+github_repo = "example/rollbacks"
+github_token = "token"
+accept_rollbacks = true
+
+[[targets]]
+github_repo = "example/forward"
+github_token = "token"
+`)
+	recorder := serveDispatches(t, checker, listingJSON("Discord_228.0.ipa", "Discord_229.0.ipa"))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if got := recorder.dispatched(); len(got) != 2 {
+		t.Fatalf("dispatched %v for an update, want both targets", got)
+	}
+
+	// 229.0 is pulled, so the branch goes back to 228.0
+	recorder.setListing(listingJSON("Discord_228.0.ipa"))
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := recorder.dispatched()[2:]
+	if len(got) != 1 || got[0] != "example/rollbacks" {
+		t.Fatalf("dispatched %v for a rollback, want only example/rollbacks", got)
+	}
+	if url, _ := recorder.payload("example/rollbacks")["ipa_url"].(string); !strings.HasSuffix(url, "/Discord_228.0.ipa") {
+		t.Errorf("rollback dispatched %s, want Discord_228.0.ipa", url)
+	}
+}
+
+func TestIsRollback(t *testing.T) {
+	plain := newTestChecker(t, "")
+	withRegex := newTestChecker(t, `version_regex = '_(\d+\.\d+)\.ipa$'`)
+	older := listingFile("Discord_228.0.ipa", 0)
+	newer := listingFile("Discord_229.0.ipa", 1)
+
+	tests := []struct {
+		checker *DipaChecker
+		file    IPAFile
+		latest  IPAFile
+		want    bool
+	}{
+		{plain, newer, older, false},
+		{plain, older, newer, true},
+		// Without a recorded latest file there is nothing to roll back from
+		{plain, older, IPAFile{}, false},
+		// version_regex wins over mod_time
+		{withRegex, listingFile("Discord_229.0.ipa", 0), listingFile("Discord_228.0.ipa", 1), false},
+		{withRegex, listingFile("Discord_228.0.ipa", 1), listingFile("Discord_229.0.ipa", 0), true},
+	}
+
+	for _, tt := range tests {
+		var branchData BranchData
+		if tt.latest.Name != "" {
+			recordLatest(&branchData, tt.latest)
+		}
+		if got := tt.checker.isRollback(tt.file, branchData); got != tt.want {
+			t.Errorf("isRollback(%s after %s) = %v, want %v", tt.file.Name, tt.latest.Name, got, tt.want)
+		}
+	}
+}
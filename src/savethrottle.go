@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// queueSave marks a branch dirty and saves the pending branches once save_interval
+// has passed since the last save, scheduling a flush for the rest of the interval otherwise
+func (c *DipaChecker) queueSave(branch string) error {
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+
+	if !containsString(c.dirtyBranches, branch) {
+		c.dirtyBranches = append(c.dirtyBranches, branch)
+	}
+
+	wait := time.Until(c.lastSave.Add(c.saveInterval))
+	if wait <= 0 {
+		return c.flushLocked()
+	}
+
+	if c.saveTimer == nil {
+		c.saveTimer = time.AfterFunc(wait, func() {
+			if err := c.Flush(); err != nil {
+				log.Printf("Error saving hashes: %v", err)
+			}
+		})
+	}
+	return nil
+}
+
+// Flush saves branches with throttled changes right away. It is called on shutdown
+// so coalesced saves are never lost.
+func (c *DipaChecker) Flush() error {
+	c.cycleMu.Lock()
+	defer c.cycleMu.Unlock()
+
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+
+	return c.flushLocked()
+}
+
+// flushLocked saves the dirty branches, the caller must hold stateMu and saveMu
+func (c *DipaChecker) flushLocked() error {
+	if c.saveTimer != nil {
+		c.saveTimer.Stop()
+		c.saveTimer = nil
+	}
+	if len(c.dirtyBranches) == 0 {
+		return nil
+	}
+
+	var err error
+	if c.Config.SplitStateFiles {
+		err = c.saveSplitState(c.dirtyBranches...)
+	} else {
+		err = c.saveHashesLocked()
+	}
+	if err != nil {
+		return err
+	}
+
+	c.dirtyBranches = nil
+	c.lastSave = time.Now()
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// storedHash reads the hash of a branch from the hash file on disk
+func storedHash(t *testing.T, checker *DipaChecker, branch string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(checker.HashFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hashes BranchHashes
+	if err := decodeHashes(data, &hashes); err != nil {
+		t.Fatal(err)
+	}
+	return hashes.Branches[branch].Hash
+}
+
+// setHash updates the hash of a branch through the throttled save path
+func setHash(t *testing.T, checker *DipaChecker, branch, hash string) {
+	t.Helper()
+
+	if err := checker.updateBranch(branch, func(branchData *BranchData) {
+		branchData.Hash = hash
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSaveThrottleBurst(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+save_interval = "1h"
+`)
+
+	setHash(t, checker, "stable", "0")
+	if got := storedHash(t, checker, "stable"); got != "0" {
+		t.Fatalf("first save wrote %q, want it saved right away", got)
+	}
+
+	// Further saves within the interval must not touch the file
+	if err := os.Remove(checker.HashFile); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 4; i++ {
+		setHash(t, checker, "stable", strconv.Itoa(i))
+	}
+	if _, err := os.Stat(checker.HashFile); !os.IsNotExist(err) {
+		t.Fatalf("hash file was written during the interval: %v", err)
+	}
+
+	if err := checker.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := storedHash(t, checker, "stable"); got != "4" {
+		t.Errorf("flush wrote %q, want the last change", got)
+	}
+
+	// Nothing is left to flush
+	if err := os.Remove(checker.HashFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := checker.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(checker.HashFile); !os.IsNotExist(err) {
+		t.Errorf("second flush wrote the hash file again: %v", err)
+	}
+}
+
+func TestSaveThrottleTimerFlush(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+save_interval = "20ms"
+`)
+
+	for i := 0; i <= 4; i++ {
+		setHash(t, checker, "stable", strconv.Itoa(i))
+	}
+
+	// The scheduled flush saves the burst without an explicit Flush
+	deadline := time.Now().Add(2 * time.Second)
+	for storedHash(t, checker, "stable") != "4" {
+		if time.Now().After(deadline) {
+			t.Fatalf("throttled changes were not flushed, hash file has %q", storedHash(t, checker, "stable"))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// recordLastRun persists the time of the last fully successful check cycle
+func (c *DipaChecker) recordLastRun(at time.Time) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	c.BranchData.LastRun = &at
+
+	var err error
+	if c.Config.SplitStateFiles {
+		err = c.saveSplitState()
+	} else {
+		err = c.saveHashesLocked()
+	}
+	if err != nil {
+		log.Printf("Error saving last run time: %v", err)
+	}
+}
+
+// lastRun returns the persisted time of the last fully successful check cycle
+func (c *DipaChecker) lastRun() (time.Time, bool) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	if c.BranchData.LastRun == nil {
+		return time.Time{}, false
+	}
+	return *c.BranchData.LastRun, true
+}
+
+// lastRunCurrent reports whether the persisted last run is still current,
+// meaning refresh_schedule has not come due since it happened
+func (c *DipaChecker) lastRunCurrent(now time.Time) bool {
+	last, ok := c.lastRun()
+	if !ok {
+		return false
+	}
+
+	schedule, err := cronParser(c.Config).Parse(c.Config.RefreshSchedule)
+	if err != nil {
+		return false
+	}
+	return schedule.Next(last).After(now)
+}
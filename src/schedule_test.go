@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestResumeScheduleSkipsInitialCheck restarts after a check and expects the recent
+// persisted run to suppress the immediate initial check until the schedule comes due
+func TestResumeScheduleSkipsInitialCheck(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+refresh_schedule = "0 0 1 1 *"
+resume_schedule = true
+`)
+
+	var fetches int32
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stable/" {
+			atomic.AddInt32(&fetches, 1)
+			w.Write(listingJSON("Discord_228.0.ipa"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	checker.RunInitialCheck(checker.Config.Branches)
+	if got := atomic.LoadInt32(&fetches); got != 1 {
+		t.Fatalf("first start fetched %d times, want an initial check", got)
+	}
+
+	restarted := reopen(t, checker)
+	restarted.Client = checker.Client
+	if restarted.BranchData.LastRun == nil {
+		t.Fatal("last run was not persisted")
+	}
+	restarted.RunInitialCheck(restarted.Config.Branches)
+	if got := atomic.LoadInt32(&fetches); got != 1 {
+		t.Errorf("restart fetched again with a current last run")
+	}
+
+	// Once the schedule has come due since the last run, the initial check runs again
+	stale := time.Now().AddDate(-2, 0, 0)
+	restarted.BranchData.LastRun = &stale
+	restarted.RunInitialCheck(restarted.Config.Branches)
+	if got := atomic.LoadInt32(&fetches); got != 2 {
+		t.Errorf("restart skipped the initial check with a last run from %s", stale.Format(time.RFC3339))
+	}
+}
+
+func TestResumeScheduleDisabled(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+refresh_schedule = "0 0 1 1 *"
+`)
+	recent := time.Now()
+	checker.BranchData.LastRun = &recent
+	requests := countRequests(t, checker)
+
+	checker.RunInitialCheck(checker.Config.Branches)
+	if atomic.LoadInt32(requests) == 0 {
+		t.Error("initial check skipped without resume_schedule")
+	}
+}
+
+// TestLastRunConcurrentCycle reads the last run while a signal-triggered cycle records
+// it, which the race detector flags without stateMu
+func TestLastRunConcurrentCycle(t *testing.T) {
+	checker := newTestChecker(t, `
+refresh_schedule = "0 0 1 1 *"
+resume_schedule = true
+`)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			checker.recordLastRun(time.Now())
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		checker.lastRunCurrent(time.Now())
+	}
+	<-done
+
+	if !checker.lastRunCurrent(time.Now()) {
+		t.Error("last run recorded by the cycle is not current")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+)
+
+// schemaRequired lists the required keys per config struct
+var schemaRequired = map[reflect.Type][]string{
+	reflect.TypeOf(Config{}): {"ipa_base_url", "refresh_schedule", "targets"},
+}
+
+// schemaEnums lists the allowed values of keys with a fixed set of values
+var schemaEnums = map[string][]string{
+	"provider":              {providerGitHub, providerAzureDevOps, providerCommand, providerJenkins},
+	"zero_enabled_targets":  {"warn", "fail"},
+	"jitter_strategy":       {jitterNone, jitterFull, jitterEqual},
+	"min_tls_version":       {"1.2", "1.3"},
+	"dispatch_mode":         {dispatchModeRepository, dispatchModeWorkflow},
+	"default_branch_policy": {defaultBranchUpdate, defaultBranchAlert},
+	"hash_algorithm":        {"md5", "sha1", "sha256", "sha512"},
+}
+
+// ConfigSchema returns a JSON Schema for the config file, generated from the
+// toml tags of Config so it stays in sync with the code
+func ConfigSchema() map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(Config{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "dipa-auto config"
+	return schema
+}
+
+// typeSchema returns the JSON Schema of a Go type
+func typeSchema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		addStructProperties(t, properties)
+
+		schema := map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+		if required, ok := schemaRequired[t]; ok {
+			schema["required"] = required
+		}
+		return schema
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// addStructProperties adds the schema of every toml field of t, flattening embedded structs
+func addStructProperties(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			addStructProperties(field.Type, properties)
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("toml"), ",")[0]
+		if name == "" || name == "-" || !field.IsExported() {
+			continue
+		}
+
+		schema := typeSchema(field.Type)
+		if enum, ok := schemaEnums[name]; ok {
+			schema["enum"] = enum
+		}
+		properties[name] = schema
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestConfigSchema(t *testing.T) {
+	data, err := json.Marshal(ConfigSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var schema struct {
+		Schema     string                     `json:"$schema"`
+		Required   []string                   `json:"required"`
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+	if schema.Schema == "" {
+		t.Error("schema has no $schema")
+	}
+	for _, key := range []string{"ipa_base_url", "refresh_schedule", "targets"} {
+		if !containsString(schema.Required, key) {
+			t.Errorf("%s is not required", key)
+		}
+	}
+
+	property := func(properties map[string]json.RawMessage, key string) map[string]interface{} {
+		t.Helper()
+		var p map[string]interface{}
+		if err := json.Unmarshal(properties[key], &p); err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		return p
+	}
+
+	if p := property(schema.Properties, "ipa_base_url"); p["type"] != "string" {
+		t.Errorf("ipa_base_url schema %v", p)
+	}
+	if p := property(schema.Properties, "dispatch_retries"); p["type"] != "integer" {
+		t.Errorf("dispatch_retries schema %v", p)
+	}
+	if p := property(schema.Properties, "branches"); p["type"] != "array" || p["items"].(map[string]interface{})["type"] != "string" {
+		t.Errorf("branches schema %v", p)
+	}
+
+	var targets struct {
+		Items struct {
+			AdditionalProperties bool                       `json:"additionalProperties"`
+			Properties           map[string]json.RawMessage `json:"properties"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(schema.Properties["targets"], &targets); err != nil {
+		t.Fatal(err)
+	}
+	if targets.Items.AdditionalProperties {
+		t.Error("targets allow unknown keys")
+	}
+	for _, key := range []string{"github_repo", "github_token", "event_type", "success_statuses", "disabled"} {
+		if _, ok := targets.Items.Properties[key]; !ok {
+			t.Errorf("targets have no %s property", key)
+		}
+	}
+
+	provider := property(targets.Items.Properties, "provider")
+	enum, _ := provider["enum"].([]interface{})
+	if len(enum) != 4 || enum[0] != providerGitHub {
+		t.Errorf("provider enum %v", provider["enum"])
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// SelfTest performs a real repository_dispatch to the sandbox repo with every
+// configured GitHub token, confirming credentials and network path end to end.
+// A dry run only logs the dispatches.
+func (c *DipaChecker) SelfTest() error {
+	tokens := []string{}
+	if c.Config.SelfTestToken != "" {
+		tokens = append(tokens, c.Config.SelfTestToken)
+	} else {
+		for _, target := range c.Config.Targets {
+			if target.Provider != providerGitHub || target.Disabled {
+				continue
+			}
+			token, err := c.githubToken(target)
+			if err != nil {
+				return fmt.Errorf("failed to get token for %s: %w", target.Name(), err)
+			}
+			if !containsString(tokens, token) {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+
+	payloadBytes, err := json.Marshal(map[string]interface{}{
+		"event_type": "self-test",
+		"client_payload": map[string]interface{}{
+			"started_at":      time.Now().UTC().Format(time.RFC3339),
+			"payload_version": PayloadVersion,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for i, token := range tokens {
+		if c.Config.DryRun {
+			log.Printf("Dry run: would send self-test dispatch to %s with token %d/%d", c.Config.SelfTestRepo, i+1, len(tokens))
+			continue
+		}
+
+		target := Target{Provider: providerGitHub, GitHubRepo: c.Config.SelfTestRepo, GitHubToken: token}
+		if err := c.postDispatch(target, payloadBytes); err != nil {
+			log.Printf("Self-test dispatch to %s with token %d/%d failed: %v", c.Config.SelfTestRepo, i+1, len(tokens), err)
+			failed++
+			continue
+		}
+		log.Printf("Self-test dispatch to %s with token %d/%d succeeded", c.Config.SelfTestRepo, i+1, len(tokens))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d tokens failed the self-test", failed, len(tokens))
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// countRequests serves 204 to every request and counts them
+func countRequests(t *testing.T, checker *DipaChecker) *int32 {
+	var requests int32
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	return &requests
+}
+
+func TestSelfTestDryRun(t *testing.T) {
+	checker := newTestChecker(t, "self_test_repo = \"example/sandbox\"\n")
+	checker.Config.DryRun = true
+	requests := countRequests(t, checker)
+
+	if err := checker.SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(requests); n != 0 {
+		t.Errorf("dry run sent %d requests", n)
+	}
+
+	checker.Config.DryRun = false
+	if err := checker.SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("self-test sent %d requests, want 1", n)
+	}
+}
+
+func TestReplayDryRun(t *testing.T) {
+	checker := newTestChecker(t, "")
+	checker.Config.DryRun = true
+	requests := countRequests(t, checker)
+	checker.BranchData.LastPayloads = map[string]string{"example/app": `{"event_type":"ipa-update"}`}
+
+	if err := checker.Replay("example/app"); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(requests); n != 0 {
+		t.Errorf("dry run sent %d requests", n)
+	}
+
+	checker.Config.DryRun = false
+	if err := checker.Replay("example/app"); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("replay sent %d requests, want 1", n)
+	}
+}
+
+// TestSelfTestDispatch sends the self-test with every distinct token to a fake dispatch
+// endpoint that rejects one of them
+func TestSelfTestDispatch(t *testing.T) {
+	checker := newTestChecker(t, `
+self_test_repo = "example/sandbox"
+
+[[targets]]
+github_repo = "example/one"
+github_token = "good"
+
+[[targets]]
+github_repo = "example/two"
+github_token = "good"
+
+[[targets]]
+github_repo = "example/three"
+github_token = "revoked"
+`)
+
+	var mu sync.Mutex
+	tokens := []string{}
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.Method != "POST" || r.URL.Path != "/repos/example/sandbox/dispatches" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var body struct {
+			EventType     string                 `json:"event_type"`
+			ClientPayload map[string]interface{} `json:"client_payload"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		if body.EventType != "self-test" || body.ClientPayload["payload_version"] == nil {
+			t.Errorf("self-test body %+v", body)
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		tokens = append(tokens, token)
+		if token == "revoked" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	err := checker.SelfTest()
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 tokens failed") {
+		t.Errorf("error %v, want the revoked token to fail the self-test", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(tokens, ",") != "good,revoked" {
+		t.Errorf("dispatched with tokens %v, want each distinct token once", tokens)
+	}
+}
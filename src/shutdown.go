@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// ShutdownSummary lists the unresolved issues the instance is leaving behind: unhealthy
+// or failing targets, branches whose last check failed and deferred dispatches
+func (c *DipaChecker) ShutdownSummary() []string {
+	issues := []string{}
+
+	c.healthMu.Lock()
+	repos := make([]string, 0, len(c.TargetHealth))
+	for repo, health := range c.TargetHealth {
+		if !health.Healthy {
+			repos = append(repos, repo)
+		}
+	}
+	sort.Strings(repos)
+	for _, repo := range repos {
+		issues = append(issues, fmt.Sprintf("target %s is unhealthy: %s", repo, c.TargetHealth[repo].Status))
+	}
+	c.healthMu.Unlock()
+
+	c.statusMu.Lock()
+	for _, branch := range c.Config.Branches {
+		status, ok := c.BranchStatus[branch]
+		if !ok {
+			continue
+		}
+		if status.LastError != "" {
+			issues = append(issues, fmt.Sprintf("%s: last check failed: %s", branch, status.LastError))
+		}
+		if status.Flapping {
+			issues = append(issues, fmt.Sprintf("%s: dispatching suspended while the hash flaps", branch))
+		}
+		if len(status.Failed) > 0 {
+			issues = append(issues, fmt.Sprintf("%s: last dispatch of %s failed for %v", branch, status.Version, status.Failed))
+		}
+	}
+	c.statusMu.Unlock()
+
+	c.stateMu.Lock()
+	for _, branch := range c.Config.Branches {
+		if pending := c.BranchData.Branches[branch].Pending; len(pending) > 0 {
+			issues = append(issues, fmt.Sprintf("%s: dispatches pending for %v", branch, pending))
+		}
+	}
+	c.stateMu.Unlock()
+
+	return issues
+}
+
+// reportShutdown logs the shutdown summary and sends it to the notifiers when
+// notify_shutdown_summary is set, bypassing the notification rate limit
+func (c *DipaChecker) reportShutdown() {
+	issues := c.ShutdownSummary()
+	if len(issues) == 0 {
+		log.Println("Shutting down with no unresolved issues")
+		return
+	}
+
+	log.Printf("Shutting down with %d unresolved issues:", len(issues))
+	for _, issue := range issues {
+		log.Printf("  - %s", issue)
+	}
+
+	if c.Config.NotifyShutdownSummary {
+		c.send(Notification{
+			Title:   fmt.Sprintf("Stopped with %d unresolved issues", len(issues)),
+			Message: "- " + strings.Join(issues, "\n- "),
+		})
+	}
+}
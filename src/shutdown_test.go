@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const shutdownConfig = `
+branches = ["stable", "testflight"]
+dispatch_retries = 0
+max_retries = 0
+notify_shutdown_summary = true
+
+[[targets]]
+github_repo = "example/app"
+github_token = "token"
+
+[[targets]]
+github_repo = "example/stuck"
+github_token = "token"
+`
+
+// TestShutdownSummaryStuckTarget leaves one target rejecting every dispatch and the
+// testflight listing failing, and expects both in the summary logged and sent at shutdown
+func TestShutdownSummaryStuckTarget(t *testing.T) {
+	checker := newTestChecker(t, shutdownConfig)
+	notifier := &recordingNotifier{}
+	checker.Notifiers = []Notifier{notifier}
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stable/":
+			w.Write(listingJSON("Discord_228.0.ipa"))
+		case "/testflight/":
+			w.WriteHeader(http.StatusBadGateway)
+		case "/repos/example/app/dispatches":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, `{"message":"Unprocessable"}`, http.StatusUnprocessableEntity)
+		}
+	}))
+	checker.CheckAll(checker.Config.Branches)
+
+	want := []string{
+		"stable: last dispatch of Discord_228.0.ipa failed for [example/stuck]",
+		"testflight: last check failed: ",
+	}
+	issues := checker.ShutdownSummary()
+	if len(issues) != len(want) {
+		t.Fatalf("summary lists %v, want %d issues", issues, len(want))
+	}
+	for i, issue := range issues {
+		if !strings.HasPrefix(issue, want[i]) {
+			t.Errorf("issue %d is %q, want %q", i, issue, want[i])
+		}
+	}
+
+	logs := captureLog(t)
+	checker.reportShutdown()
+	if !strings.Contains(logs.String(), "Shutting down with 2 unresolved issues:") ||
+		!strings.Contains(logs.String(), "  - "+issues[0]) {
+		t.Errorf("shutdown summary was not logged:\n%s", logs)
+	}
+	sent := notifier.titled("Stopped with 2 unresolved issues")
+	if len(sent) != 1 || sent[0].Message != "- "+strings.Join(issues, "\n- ") {
+		t.Errorf("sent %+v, want the summary notification", sent)
+	}
+}
+
+func TestShutdownSummaryClean(t *testing.T) {
+	checker := newTestChecker(t, strings.Replace(shutdownConfig, "notify_shutdown_summary = true", "", 1))
+	notifier := &recordingNotifier{}
+	checker.Notifiers = []Notifier{notifier}
+	serveDispatches(t, checker, listingJSON("Discord_228.0.ipa"))
+	checker.CheckAll(checker.Config.Branches)
+
+	if issues := checker.ShutdownSummary(); !reflect.DeepEqual(issues, []string{}) {
+		t.Errorf("summary lists %v after clean checks", issues)
+	}
+
+	logs := captureLog(t)
+	checker.reportShutdown()
+	if !strings.Contains(logs.String(), "Shutting down with no unresolved issues") {
+		t.Errorf("clean shutdown was not logged:\n%s", logs)
+	}
+	if sent := notifier.titled("Stopped"); len(sent) != 0 {
+		t.Errorf("sent %+v without notify_shutdown_summary", sent)
+	}
+}
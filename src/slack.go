@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// SlackNotifier posts Block Kit messages to a Slack incoming webhook
+type SlackNotifier struct {
+	WebhookURL string
+	Client     *http.Client
+}
+
+// slackText represents a Block Kit text object
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// slackBlock represents a Block Kit layout block
+type slackBlock struct {
+	Type     string         `json:"type"`
+	Text     *slackText     `json:"text,omitempty"`
+	Fields   []slackText    `json:"fields,omitempty"`
+	Elements []slackElement `json:"elements,omitempty"`
+}
+
+// slackElement represents a Block Kit interactive element
+type slackElement struct {
+	Type string    `json:"type"`
+	Text slackText `json:"text"`
+	URL  string    `json:"url,omitempty"`
+}
+
+// slackMessage represents the webhook payload
+type slackMessage struct {
+	Text   string       `json:"text"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+// Notify sends the notification to Slack
+func (s *SlackNotifier) Notify(n Notification) error {
+	payloadBytes, err := json.Marshal(buildSlackMessage(n))
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.Client.Post(s.WebhookURL, "application/json", bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("slack webhook returned status %d: %s", resp.StatusCode, trimString(string(body), 200))
+	}
+
+	return nil
+}
+
+// buildSlackMessage builds the Block Kit message for a notification
+func buildSlackMessage(n Notification) slackMessage {
+	blocks := []slackBlock{
+		{Type: "header", Text: &slackText{Type: "plain_text", Text: n.Title}},
+	}
+
+	if n.Message != "" {
+		blocks = append(blocks, slackBlock{
+			Type: "section",
+			Text: &slackText{Type: "mrkdwn", Text: n.Message},
+		})
+	}
+
+	fields := []slackText{}
+	if n.Branch != "" {
+		fields = append(fields, slackText{Type: "mrkdwn", Text: fmt.Sprintf("*Branch*\n%s", n.Branch)})
+	}
+	if n.Version != "" {
+		fields = append(fields, slackText{Type: "mrkdwn", Text: fmt.Sprintf("*Version*\n%s", n.Version)})
+	}
+	if n.Instance != "" {
+		fields = append(fields, slackText{Type: "mrkdwn", Text: fmt.Sprintf("*Instance*\n%s", n.Instance)})
+	}
+	if len(fields) > 0 {
+		blocks = append(blocks, slackBlock{Type: "section", Fields: fields})
+	}
+
+	if len(n.Successful) > 0 || len(n.Failed) > 0 {
+		summary := fmt.Sprintf("*Dispatches:* %d succeeded, %d failed", len(n.Successful), len(n.Failed))
+		if len(n.Successful) > 0 {
+			summary += fmt.Sprintf("\n:white_check_mark: %s", strings.Join(n.Successful, ", "))
+		}
+		if len(n.Failed) > 0 {
+			summary += fmt.Sprintf("\n:x: %s", strings.Join(n.Failed, ", "))
+		}
+		blocks = append(blocks, slackBlock{
+			Type: "section",
+			Text: &slackText{Type: "mrkdwn", Text: summary},
+		})
+	}
+
+	if n.URL != "" {
+		blocks = append(blocks, slackBlock{
+			Type: "actions",
+			Elements: []slackElement{{
+				Type: "button",
+				Text: slackText{Type: "plain_text", Text: "Download IPA"},
+				URL:  n.URL,
+			}},
+		})
+	}
+
+	return slackMessage{Text: n.Title, Blocks: blocks}
+}
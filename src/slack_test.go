@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSlackBlockKitMessage(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type %q, want application/json", ct)
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	notifier := &SlackNotifier{WebhookURL: server.URL, Client: server.Client()}
+	err := notifier.Notify(Notification{
+		Title:      "New stable version",
+		Message:    "Discord_228.0.ipa",
+		Branch:     "stable",
+		Version:    "Discord_228.0.ipa",
+		URL:        "https://ipa.example.com/stable/Discord_228.0.ipa",
+		Successful: []string{"example/app"},
+		Failed:     []string{"example/broken"},
+	})
+	if err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+
+	var want map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"text": "New stable version",
+		"blocks": [
+			{"type": "header", "text": {"type": "plain_text", "text": "New stable version"}},
+			{"type": "section", "text": {"type": "mrkdwn", "text": "Discord_228.0.ipa"}},
+			{"type": "section", "fields": [
+				{"type": "mrkdwn", "text": "*Branch*\nstable"},
+				{"type": "mrkdwn", "text": "*Version*\nDiscord_228.0.ipa"}
+			]},
+			{"type": "section", "text": {"type": "mrkdwn",
+				"text": "*Dispatches:* 1 succeeded, 1 failed\n:white_check_mark: example/app\n:x: example/broken"}},
+			{"type": "actions", "elements": [
+				{"type": "button", "text": {"type": "plain_text", "text": "Download IPA"},
+				 "url": "https://ipa.example.com/stable/Discord_228.0.ipa"}
+			]}
+		]
+	}`), &want)
+	if !reflect.DeepEqual(received, want) {
+		got, _ := json.MarshalIndent(received, "", "  ")
+		t.Errorf("Slack received\n%s", got)
+	}
+}
+
+func TestSlackAlertHasNoButton(t *testing.T) {
+	message := buildSlackMessage(Notification{Title: "Branch stale", Branch: "stable"})
+
+	for _, block := range message.Blocks {
+		if block.Type == "actions" {
+			t.Errorf("alert without a URL has an actions block: %+v", block)
+		}
+	}
+	if len(message.Blocks) != 2 {
+		t.Errorf("got %d blocks, want the header and branch field", len(message.Blocks))
+	}
+}
+
+func TestSlackFailureDoesNotFailCheck(t *testing.T) {
+	checker := newTestChecker(t, `branches = ["stable"]`)
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stable/":
+			w.Write(listingJSON("Discord_228.0.ipa"))
+		case "/slack":
+			http.Error(w, "invalid_payload", http.StatusBadRequest)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	checker.Notifiers = []Notifier{&SlackNotifier{WebhookURL: "http://slack.example.com/slack", Client: checker.Client}}
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Errorf("a failing Slack webhook failed the check: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// defaultStateSnapshots is the number of state snapshots kept when state_snapshots is not set
+const defaultStateSnapshots = 5
+
+// snapshotTimeFormat names snapshots so they sort chronologically
+const snapshotTimeFormat = "20060102T150405.000000000Z"
+
+// snapshotDir returns the directory state snapshots are kept in
+func (c *DipaChecker) snapshotDir() string {
+	return filepath.Join(filepath.Dir(c.HashFile), "snapshots")
+}
+
+// snapshotState writes the full state as a timestamped copy of the hash file and
+// removes all but the newest state_snapshots copies. Snapshots always hold every
+// branch, so one can replace the hash file to roll back even with split_state_files.
+func (c *DipaChecker) snapshotState() error {
+	if c.Config.StateSnapshots <= 0 || c.Config.DryRun {
+		return nil
+	}
+
+	data, err := encodeHashes(&c.BranchData, c.Config.CompactHashFile)
+	if err != nil {
+		return err
+	}
+
+	dir := c.snapshotDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	name := fmt.Sprintf("branch_hashes-%s.json", time.Now().UTC().Format(snapshotTimeFormat))
+	if err := writeFileAtomic(filepath.Join(dir, name), data, 0644); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	return pruneSnapshots(dir, c.Config.StateSnapshots)
+}
+
+// pruneSnapshots removes the oldest snapshots in dir beyond keep
+func pruneSnapshots(dir string, keep int) error {
+	snapshots, err := filepath.Glob(filepath.Join(dir, "branch_hashes-*.json"))
+	if err != nil {
+		return err
+	}
+	if len(snapshots) <= keep {
+		return nil
+	}
+
+	sort.Strings(snapshots)
+	for _, snapshot := range snapshots[:len(snapshots)-keep] {
+		if err := os.Remove(snapshot); err != nil {
+			return fmt.Errorf("failed to remove old snapshot: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// saveHash stores hash as the stable hash and saves the state
+func saveHash(t *testing.T, checker *DipaChecker, hash string) {
+	t.Helper()
+
+	checker.stateMu.Lock()
+	defer checker.stateMu.Unlock()
+	checker.BranchData.Branches["stable"] = BranchData{Hash: hash}
+	if err := checker.writeHashes(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// snapshotHashes returns the stable hash of every snapshot, oldest first
+func snapshotHashes(t *testing.T, checker *DipaChecker) []string {
+	t.Helper()
+
+	snapshots, err := filepath.Glob(filepath.Join(checker.snapshotDir(), "branch_hashes-*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := []string{}
+	for _, snapshot := range snapshots {
+		data, err := os.ReadFile(snapshot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var state BranchHashes
+		if err := json.Unmarshal(data, &state); err != nil {
+			t.Fatalf("%s: %v", snapshot, err)
+		}
+		hashes = append(hashes, state.Branches["stable"].Hash)
+	}
+	return hashes
+}
+
+func TestSnapshotsRotate(t *testing.T) {
+	checker := newTestChecker(t, `branches = ["stable"]`)
+	checker.Config.StateSnapshots = 3
+
+	want := []string{}
+	for i := 0; i < 5; i++ {
+		hash := fmt.Sprintf("h%d", i)
+		saveHash(t, checker, hash)
+
+		// Snapshots accumulate up to state_snapshots, then the oldest is dropped
+		want = append(want, hash)
+		if len(want) > 3 {
+			want = want[1:]
+		}
+		if got := snapshotHashes(t, checker); !reflect.DeepEqual(got, want) {
+			t.Errorf("after save %d snapshots hold %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestSnapshotsDisabled(t *testing.T) {
+	checker := newTestChecker(t, `branches = ["stable"]`)
+	saveHash(t, checker, "h0")
+
+	checker.Config.StateSnapshots = 3
+	checker.Config.DryRun = true
+	saveHash(t, checker, "h1")
+
+	if _, err := os.Stat(checker.snapshotDir()); !os.IsNotExist(err) {
+		t.Errorf("snapshot directory exists with snapshots off or in a dry run: %v", err)
+	}
+}
+
+// TestSnapshotsConfig loads configs without the state_snapshots = 0 of loadTestConfig
+func TestSnapshotsConfig(t *testing.T) {
+	load := func(extra string) (*Config, error) {
+		path := filepath.Join(t.TempDir(), "config.toml")
+		config := "ipa_base_url = \"https://ipa.example.com\"\nrefresh_schedule = \"0 * * * *\"\n" + extra +
+			"[[targets]]\ngithub_repo = \"example/app\"\ngithub_token = \"token\"\n"
+		if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return LoadConfig(path)
+	}
+
+	cfg, err := load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.StateSnapshots != defaultStateSnapshots {
+		t.Errorf("state_snapshots defaults to %d, want %d", cfg.StateSnapshots, defaultStateSnapshots)
+	}
+
+	if _, err := load("state_snapshots = -1\n"); err == nil || !strings.Contains(err.Error(), "cannot be negative") {
+		t.Errorf("error %v, want negative state_snapshots rejected", err)
+	}
+}
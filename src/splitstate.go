@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// branchFile returns the state file of a branch when split_state_files is set.
+// The name is escaped so it always stays a single file in the hash directory.
+func (c *DipaChecker) branchFile(branch string) string {
+	return filepath.Join(filepath.Dir(c.HashFile), url.PathEscape(branch)+".json")
+}
+
+// branchNames returns the names of all tracked branches in a stable order
+func (c *DipaChecker) branchNames() []string {
+	names := make([]string, 0, len(c.BranchData.Branches))
+	for branch := range c.BranchData.Branches {
+		names = append(names, branch)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadBranchFiles loads per-branch state files over the shared state
+func (c *DipaChecker) loadBranchFiles() error {
+	if c.BranchData.Branches == nil {
+		c.BranchData.Branches = make(map[string]BranchData)
+	}
+
+	branches := append([]string{}, c.Config.Branches...)
+	for branch := range c.BranchData.Branches {
+		if !containsString(branches, branch) {
+			branches = append(branches, branch)
+		}
+	}
+
+	migrate := false
+	for _, branch := range branches {
+		path := c.branchFile(branch)
+		data, err := c.readStateFile(path)
+		if os.IsNotExist(err) {
+			_, inShared := c.BranchData.Branches[branch]
+			migrate = migrate || inShared
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		var branchData BranchData
+		if err := decodeBranchData(data, &branchData); err != nil {
+			return fmt.Errorf("failed to decode %s: %w", path, err)
+		}
+		c.BranchData.Branches[branch] = branchData
+	}
+
+	// Move branches from a single-file hash file into their own files right away,
+	// since later saves only rewrite the branch that changed
+	if migrate {
+		return c.saveSplitState(c.branchNames()...)
+	}
+	return nil
+}
+
+// saveSplitState saves the shared state and the given branches, merging in operator
+// changes first like saveHashesLocked. The caller must hold stateMu.
+func (c *DipaChecker) saveSplitState(branches ...string) error {
+	c.mergeStoredState()
+	return c.writeSplitState(branches...)
+}
+
+// writeSplitState writes the shared state without branches, then the given branches to their own files
+func (c *DipaChecker) writeSplitState(branches ...string) error {
+	shared := c.BranchData
+	shared.Branches = map[string]BranchData{}
+
+	data, err := encodeHashes(&shared, c.Config.CompactHashFile)
+	if err != nil {
+		return err
+	}
+	if err := c.writeStateFile(c.HashFile, data); err != nil {
+		return err
+	}
+
+	for _, branch := range branches {
+		branchData, ok := c.BranchData.Branches[branch]
+		if !ok {
+			continue
+		}
+
+		data, err := encodeBranchData(branchData, c.Config.CompactHashFile)
+		if err != nil {
+			return err
+		}
+		if err := c.writeStateFile(c.branchFile(branch), data); err != nil {
+			return fmt.Errorf("failed to save %s state: %w", branch, err)
+		}
+	}
+
+	// The save succeeded, so a failed snapshot is only logged
+	if err := c.snapshotState(); err != nil {
+		log.Printf("Failed to snapshot state: %v", err)
+	}
+	return nil
+}
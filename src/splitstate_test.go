@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBranchFileStaysInHashDir(t *testing.T) {
+	checker := &DipaChecker{HashFile: filepath.Join("state", "branch_hashes.json")}
+
+	for _, branch := range []string{"stable", "feature/new-ui", "../escape", ".."} {
+		path := checker.branchFile(branch)
+		if filepath.Dir(path) != "state" {
+			t.Errorf("branchFile(%q) = %s, want a file in state", branch, path)
+		}
+		if path == checker.HashFile {
+			t.Errorf("branchFile(%q) overwrites the hash file", branch)
+		}
+	}
+}
+
+func TestSplitStateCompactRoundTrip(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable", "testflight"]
+split_state_files = true
+compact_hash_file = true
+`)
+
+	checker.BranchData.Branches["stable"] = BranchData{
+		Hash:       "abc",
+		Dispatches: map[string][]string{"abc": {"example/one", "example/two"}},
+	}
+	if err := checker.SaveHashes(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(checker.branchFile("stable"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"repos"`) {
+		t.Errorf("branch file is not compact:\n%s", data)
+	}
+
+	checker.BranchData = BranchHashes{}
+	if err := checker.LoadHashes(); err != nil {
+		t.Fatal(err)
+	}
+	got := checker.BranchData.Branches["stable"].Dispatches
+	want := map[string][]string{"abc": {"example/one", "example/two"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("dispatches after reload = %v, want %v", got, want)
+	}
+}
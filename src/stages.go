@@ -0,0 +1,14 @@
+package main
+
+import (
+	"sort"
+)
+
+// stagedTargets returns the targets ordered by stage, keeping the config order within a stage
+func (c *DipaChecker) stagedTargets() []Target {
+	targets := append([]Target(nil), c.Config.Targets...)
+	sort.SliceStable(targets, func(i, j int) bool {
+		return targets[i].Stage < targets[j].Stage
+	})
+	return targets
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestStagedDispatch configures targets out of stage order and expects them to be
+// dispatched stage by stage, with stage_delay only between stages
+func TestStagedDispatch(t *testing.T) {
+	const delay = 100 * time.Millisecond
+
+	checker := newTestChecker(t, `
+branches = ["stable"]
+stage_delay = "100ms"
+
+[[targets]]
+github_repo = "example/late"
+github_token = "token"
+stage = 2
+
+[[targets]]
+github_repo = "example/canary"
+github_token = "token"
+stage = 1
+
+[[targets]]
+github_repo = "example/canary-two"
+github_token = "token"
+stage = 1
+
+[[targets]]
+github_repo = "example/everyone"
+github_token = "token"
+`)
+
+	var mu sync.Mutex
+	repos := []string{}
+	times := []time.Time{}
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stable/" {
+			w.Write(listingJSON("Discord_228.0.ipa"))
+			return
+		}
+		mu.Lock()
+		repos = append(repos, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/repos/"), "/dispatches"))
+		times = append(times, time.Now())
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := strings.Join(repos, ","); got != "example/everyone,example/canary,example/canary-two,example/late" {
+		t.Fatalf("dispatch order %s, want stage 0, then both stage 1 targets, then stage 2", got)
+	}
+
+	// Targets within a stage go out together, stages are separated by the delay
+	if gap := times[2].Sub(times[1]); gap >= delay {
+		t.Errorf("%s between targets of the same stage, want no delay", gap)
+	}
+	for _, i := range []int{1, 3} {
+		if gap := times[i].Sub(times[i-1]); gap < delay {
+			t.Errorf("%s before %s, want at least stage_delay", gap, repos[i])
+		}
+	}
+}
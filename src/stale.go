@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// checkStale alerts once when a branch has not changed within the configured staleness window
+func (c *DipaChecker) checkStale(branch string) {
+	if c.staleAfter == 0 {
+		return
+	}
+
+	c.stateMu.Lock()
+	lastChanged := c.branchState(branch).LastChanged
+	c.stateMu.Unlock()
+
+	// Start the staleness window now for branches without a recorded change
+	if lastChanged.IsZero() {
+		err := c.updateBranch(branch, func(branchData *BranchData) {
+			branchData.LastChanged = time.Now()
+		})
+		if err != nil {
+			log.Printf("Error saving hashes: %v", err)
+		}
+		return
+	}
+
+	unchangedFor := time.Since(lastChanged)
+	stale := unchangedFor > c.staleAfter
+
+	wasStale := false
+	c.updateBranchStatus(branch, func(status *BranchStatus) {
+		wasStale = status.Stale
+		status.Stale = stale
+	})
+
+	if stale && !wasStale {
+		c.alert(branch, "Branch looks stale",
+			fmt.Sprintf("%s has not changed since %s (%s ago)",
+				branch, lastChanged.Format(time.RFC1123), unchangedFor.Round(time.Minute)))
+	}
+}
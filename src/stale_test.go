@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStaleBranchAlert(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+stale_branch_after = "1h"
+`)
+	notifier := &recordingNotifier{}
+	checker.Notifiers = []Notifier{notifier}
+
+	setLastChanged := func(at time.Time) {
+		if err := checker.updateBranch("stable", func(branchData *BranchData) {
+			branchData.LastChanged = at
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Within the window nothing happens
+	setLastChanged(time.Now().Add(-30 * time.Minute))
+	checker.checkStale("stable")
+	if alerts := notifier.titled("Branch looks stale"); len(alerts) != 0 {
+		t.Fatalf("got %d stale alerts within the window, want none", len(alerts))
+	}
+
+	// Past the window the alert fires once, not on every check
+	setLastChanged(time.Now().Add(-2 * time.Hour))
+	checker.checkStale("stable")
+	checker.checkStale("stable")
+	if alerts := notifier.titled("Branch looks stale"); len(alerts) != 1 {
+		t.Fatalf("got %d stale alerts past the window, want 1", len(alerts))
+	}
+	if !checker.BranchStatusSnapshot()["stable"].Stale {
+		t.Error("stable is not marked stale in its status")
+	}
+
+	// A change clears the state, so a later stale period alerts again
+	setLastChanged(time.Now())
+	checker.checkStale("stable")
+	if checker.BranchStatusSnapshot()["stable"].Stale {
+		t.Error("stable is still marked stale after a change")
+	}
+	setLastChanged(time.Now().Add(-2 * time.Hour))
+	checker.checkStale("stable")
+	if alerts := notifier.titled("Branch looks stale"); len(alerts) != 2 {
+		t.Errorf("got %d stale alerts after a second stale period, want 2", len(alerts))
+	}
+}
+
+func TestStaleWindowStartsForNewBranch(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+stale_branch_after = "1h"
+`)
+	notifier := &recordingNotifier{}
+	checker.Notifiers = []Notifier{notifier}
+
+	checker.checkStale("stable")
+
+	checker.stateMu.Lock()
+	lastChanged := checker.BranchData.Branches["stable"].LastChanged
+	checker.stateMu.Unlock()
+	if time.Since(lastChanged) > time.Minute {
+		t.Errorf("the staleness window of a branch without changes starts at %s, want now", lastChanged)
+	}
+	if alerts := notifier.titled("Branch looks stale"); len(alerts) != 0 {
+		t.Errorf("got %d stale alerts for a new branch, want none", len(alerts))
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// statsdPrefix namespaces every metric sent to StatsD
+const statsdPrefix = "dipa_auto."
+
+// StatsdClient sends DogStatsD metrics over UDP. A nil client is a no-op.
+type StatsdClient struct {
+	conn net.Conn
+}
+
+// newStatsdClient creates a client for addr, or nil when addr is empty
+func newStatsdClient(addr string) (*StatsdClient, error) {
+	if addr == "" {
+		return nil, nil
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsdClient{conn: conn}, nil
+}
+
+// Count adds value to a counter
+func (s *StatsdClient) Count(name string, value int, tags ...string) {
+	s.send(name, fmt.Sprintf("%d|c", value), tags)
+}
+
+// Incr increments a counter by one
+func (s *StatsdClient) Incr(name string, tags ...string) {
+	s.Count(name, 1, tags...)
+}
+
+// Timing records a duration in milliseconds
+func (s *StatsdClient) Timing(name string, d time.Duration, tags ...string) {
+	s.send(name, fmt.Sprintf("%d|ms", d.Milliseconds()), tags)
+}
+
+// send writes a single metric packet, logging failures since metrics are best effort
+func (s *StatsdClient) send(name, value string, tags []string) {
+	if s == nil {
+		return
+	}
+
+	packet := statsdPrefix + name + ":" + value
+	if len(tags) > 0 {
+		packet += "|#" + strings.Join(tags, ",")
+	}
+
+	if _, err := s.conn.Write([]byte(packet)); err != nil {
+		log.Printf("Error sending metric %s: %v", name, err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenStatsd starts a fake StatsD server and returns its address and a function
+// collecting the packets received until it has been quiet for a moment
+func listenStatsd(t *testing.T) (string, func() []string) {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn.LocalAddr().String(), func() []string {
+		packets := []string{}
+		buf := make([]byte, 1024)
+		for {
+			conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return packets
+			}
+			packets = append(packets, string(buf[:n]))
+		}
+	}
+}
+
+func TestStatsdMetrics(t *testing.T) {
+	addr, packets := listenStatsd(t)
+	checker := newTestChecker(t, `
+branches = ["stable", "testflight"]
+dispatch_retries = 0
+statsd_addr = "`+addr+`"
+
+[[targets]]
+github_repo = "example/ok"
+github_token = "token"
+
+[[targets]]
+github_repo = "example/broken"
+github_token = "token"
+`)
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/stable/":
+			w.Write(listingJSON("Discord_228.0.ipa"))
+		case r.URL.Path == "/repos/example/broken/dispatches":
+			w.WriteHeader(http.StatusBadRequest)
+		case strings.HasPrefix(r.URL.Path, "/repos/"):
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	checker.CheckAll(checker.Config.Branches)
+	got := packets()
+
+	for _, want := range []string{
+		"dipa_auto.checks:1|c|#branch:stable",
+		"dipa_auto.checks:1|c|#branch:testflight",
+		"dipa_auto.check.failures:1|c|#branch:testflight",
+		"dipa_auto.changes:1|c|#branch:stable",
+		"dipa_auto.dispatches:1|c|#branch:stable,repo:example/ok",
+		"dipa_auto.dispatch.failures:1|c|#branch:stable,repo:example/broken",
+	} {
+		if !containsString(got, want) {
+			t.Errorf("missing packet %q in %q", want, got)
+		}
+	}
+
+	durations := 0
+	for _, packet := range got {
+		if strings.HasPrefix(packet, "dipa_auto.check.duration:") && strings.Contains(packet, "|ms|#branch:") {
+			durations++
+		}
+	}
+	if durations != 2 {
+		t.Errorf("%d check.duration timers, want one per branch", durations)
+	}
+	if containsString(got, "dipa_auto.check.failures:1|c|#branch:stable") {
+		t.Error("a failed dispatch counted as a failed check")
+	}
+}
+
+func TestStatsdUnconfigured(t *testing.T) {
+	client, err := newStatsdClient("")
+	if err != nil || client != nil {
+		t.Fatalf("newStatsdClient(\"\") = %v, %v, want a nil client", client, err)
+	}
+
+	// A nil client must be safe to use everywhere metrics are recorded
+	client.Incr("checks", "branch:stable")
+	client.Timing("check.duration", time.Second)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// BranchStatus represents the in-memory status of a branch
+type BranchStatus struct {
+	LastCheck   time.Time `json:"last_check"`
+	LastSuccess time.Time `json:"last_success"`
+	LastError   string    `json:"last_error,omitempty"`
+	Stale       bool      `json:"stale"`
+	Flapping    bool      `json:"flapping"`
+	// NonJSON is set while the IPA host answers with non-JSON bodies, which is alerted once
+	NonJSON bool `json:"non_json"`
+	// Hash is the listing hash seen by the last successful check
+	Hash string `json:"hash,omitempty"`
+	// Changed reports whether the last check detected a change
+	Changed bool `json:"changed"`
+	// TrackedHashes is the number of hashes with dispatch records
+	TrackedHashes int `json:"tracked_hashes"`
+	// Results of the last dispatch
+	Version    string   `json:"version,omitempty"`
+	Dispatched []string `json:"dispatched,omitempty"`
+	Failed     []string `json:"failed,omitempty"`
+	Pending    []string `json:"pending,omitempty"`
+}
+
+// updateBranchStatus applies fn to the status of a branch under the status lock
+func (c *DipaChecker) updateBranchStatus(branch string, fn func(status *BranchStatus)) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	status, ok := c.BranchStatus[branch]
+	if !ok {
+		status = &BranchStatus{}
+		c.BranchStatus[branch] = status
+	}
+	fn(status)
+}
+
+// BranchStatusSnapshot returns a copy of the current branch status
+func (c *DipaChecker) BranchStatusSnapshot() map[string]BranchStatus {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	snapshot := make(map[string]BranchStatus, len(c.BranchStatus))
+	for branch, status := range c.BranchStatus {
+		snapshot[branch] = *status
+	}
+	return snapshot
+}
+
+// statusFile is the summary written to status_file after every check cycle
+type statusFile struct {
+	UpdatedAt time.Time               `json:"updated_at"`
+	Instance  string                  `json:"instance,omitempty"`
+	Healthy   bool                    `json:"healthy"`
+	Paused    bool                    `json:"paused"`
+	Branches  map[string]BranchStatus `json:"branches"`
+	// Targets is the last reachability check of each target with target_health_interval set
+	Targets map[string]TargetHealth `json:"targets,omitempty"`
+}
+
+// writeStatusFile atomically writes the branch status summary to status_file
+func (c *DipaChecker) writeStatusFile(healthy bool) error {
+	if c.Config.StatusFile == "" {
+		return nil
+	}
+
+	c.stateMu.Lock()
+	paused := c.BranchData.Paused
+	c.stateMu.Unlock()
+
+	data, err := json.MarshalIndent(statusFile{
+		UpdatedAt: time.Now().UTC(),
+		Instance:  c.Config.InstanceID,
+		Healthy:   healthy,
+		Paused:    paused,
+		Branches:  c.BranchStatusSnapshot(),
+		Targets:   c.TargetHealthSnapshot(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(c.Config.StatusFile, data, 0644)
+}
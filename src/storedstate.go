@@ -0,0 +1,129 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// readStoredState reads the shared state last saved to the hash file, which the
+// subcommands may have changed since this instance loaded it
+func (c *DipaChecker) readStoredState() (BranchHashes, error) {
+	var stored BranchHashes
+
+	data, err := c.readStateFile(c.HashFile)
+	if err != nil {
+		return stored, err
+	}
+	err = decodeHashes(data, &stored)
+	return stored, err
+}
+
+// storedBranches returns the branches last saved, reading their own files with split_state_files
+func (c *DipaChecker) storedBranches(stored BranchHashes) map[string]BranchData {
+	if !c.Config.SplitStateFiles {
+		return stored.Branches
+	}
+
+	branches := make(map[string]BranchData)
+	for branch := range c.BranchData.Branches {
+		data, err := c.readStateFile(c.branchFile(branch))
+		if err != nil {
+			continue
+		}
+		var branchData BranchData
+		if err := decodeBranchData(data, &branchData); err == nil {
+			branches[branch] = branchData
+		}
+	}
+	return branches
+}
+
+// mergeStoredState merges the changes the subcommands saved to the hash file into the
+// in-memory state right before it is saved, so a running instance never undoes them:
+// the pause flag and blocklists, dispatches recorded by force and entries removed by prune.
+// The caller must hold stateMu.
+func (c *DipaChecker) mergeStoredState() {
+	stored, err := c.readStoredState()
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading stored state, saving current state as is: %v", err)
+		return
+	}
+
+	c.applyOperatorState(stored)
+
+	for branch, storedData := range c.storedBranches(stored) {
+		if branchData, ok := c.BranchData.Branches[branch]; ok {
+			c.BranchData.Branches[branch] = mergeStoredBranch(branchData, storedData)
+		}
+	}
+
+	c.mergeStoredPayloads(stored)
+}
+
+// mergeStoredBranch merges what force and prune saved for a branch. A newer stored hash
+// was recorded by force and wins. For the same hash, dispatches recorded by force are
+// added and superseded hashes removed by prune stay removed.
+func mergeStoredBranch(current, stored BranchData) BranchData {
+	if stored.Hash != current.Hash {
+		if stored.LastChanged.After(current.LastChanged) {
+			return stored
+		}
+		return current
+	}
+
+	dispatches := make(map[string][]string, len(current.Dispatches))
+	for key, repos := range current.Dispatches {
+		if _, kept := stored.Dispatches[key]; !current.tracksKey(key) && !kept {
+			continue
+		}
+		dispatches[key] = repos
+	}
+	for key, repos := range stored.Dispatches {
+		if current.tracksKey(key) {
+			dispatches[key] = appendMissing(append([]string{}, dispatches[key]...), repos...)
+		}
+	}
+
+	current.Dispatches = dispatches
+	return current
+}
+
+// mergeStoredPayloads takes the payloads force dispatched after this instance's own
+// dispatches, and drops the payloads of unconfigured targets that prune removed
+func (c *DipaChecker) mergeStoredPayloads(stored BranchHashes) {
+	for repo, at := range stored.LastDispatched {
+		if !at.After(c.BranchData.LastDispatched[repo]) {
+			continue
+		}
+		if c.BranchData.LastDispatched == nil {
+			c.BranchData.LastDispatched = make(map[string]time.Time)
+		}
+		c.BranchData.LastDispatched[repo] = at
+
+		if payload, ok := stored.LastPayloads[repo]; ok {
+			if c.BranchData.LastPayloads == nil {
+				c.BranchData.LastPayloads = make(map[string]string)
+			}
+			c.BranchData.LastPayloads[repo] = payload
+		}
+	}
+
+	configured := make(map[string]bool, len(c.Config.Targets))
+	for _, target := range c.Config.Targets {
+		configured[target.Name()] = true
+	}
+	for repo := range c.BranchData.LastPayloads {
+		if _, kept := stored.LastPayloads[repo]; !configured[repo] && !kept {
+			delete(c.BranchData.LastPayloads, repo)
+		}
+	}
+	for repo := range c.BranchData.LastDispatched {
+		if _, kept := stored.LastDispatched[repo]; !configured[repo] && !kept {
+			delete(c.BranchData.LastDispatched, repo)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMergeStoredBranch(t *testing.T) {
+	earlier := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	later := earlier.Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		current BranchData
+		stored  BranchData
+		want    BranchData
+	}{
+		{
+			name:    "force recorded another target",
+			current: BranchData{Hash: "h2", Dispatches: map[string][]string{"h2": {"a"}}},
+			stored:  BranchData{Hash: "h2", Dispatches: map[string][]string{"h2": {"a", "b"}}},
+			want:    BranchData{Hash: "h2", Dispatches: map[string][]string{"h2": {"a", "b"}}},
+		},
+		{
+			name:    "prune removed a superseded hash",
+			current: BranchData{Hash: "h2", Dispatches: map[string][]string{"h1": {"a"}, "h2": {"a"}}},
+			stored:  BranchData{Hash: "h2", Dispatches: map[string][]string{"h2": {"a"}}},
+			want:    BranchData{Hash: "h2", Dispatches: map[string][]string{"h2": {"a"}}},
+		},
+		{
+			name:    "current variant files are kept",
+			current: BranchData{Hash: "h2", Variants: map[string]string{"arm64": "b.ipa"}, Dispatches: map[string][]string{"arm64#a.ipa": {"a"}, "arm64#b.ipa": {"a"}}},
+			stored:  BranchData{Hash: "h2", Dispatches: map[string][]string{}},
+			want:    BranchData{Hash: "h2", Variants: map[string]string{"arm64": "b.ipa"}, Dispatches: map[string][]string{"arm64#b.ipa": {"a"}}},
+		},
+		{
+			name:    "force recorded a newer hash",
+			current: BranchData{Hash: "h1", LastChanged: earlier, Dispatches: map[string][]string{"h1": {"a"}}},
+			stored:  BranchData{Hash: "h2", LastChanged: later, Dispatches: map[string][]string{"h2": {"a"}}},
+			want:    BranchData{Hash: "h2", LastChanged: later, Dispatches: map[string][]string{"h2": {"a"}}},
+		},
+		{
+			name:    "this instance recorded a newer hash",
+			current: BranchData{Hash: "h2", LastChanged: later, Dispatches: map[string][]string{"h2": {"a"}}},
+			stored:  BranchData{Hash: "h1", LastChanged: earlier, Dispatches: map[string][]string{"h1": {"a"}}},
+			want:    BranchData{Hash: "h2", LastChanged: later, Dispatches: map[string][]string{"h2": {"a"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		got := mergeStoredBranch(tt.current, tt.stored)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestBlockSurvivesDaemonSave blocks a version through a second checker, like the block
+// subcommand, and expects a save of the running instance to keep it
+func TestBlockSurvivesDaemonSave(t *testing.T) {
+	daemon := newTestChecker(t, "")
+
+	if err := reopen(t, daemon).SetBlocked("Discord_228.0.ipa", "abc", true); err != nil {
+		t.Fatal(err)
+	}
+	daemon.recordLastRun(time.Now())
+
+	reloaded := reopen(t, daemon)
+	if !reloaded.isBlockedVersion("Discord_228.0.ipa") {
+		t.Error("blocked version was undone by the running instance's save")
+	}
+	if !containsString(reloaded.BranchData.BlockedHashes, "abc") {
+		t.Error("blocked hash was undone by the running instance's save")
+	}
+}
+
+// TestPruneSurvivesDaemonSave prunes through a second checker and expects a save of
+// the running instance not to bring the pruned entries back
+func TestPruneSurvivesDaemonSave(t *testing.T) {
+	daemon := newTestChecker(t, "")
+	daemon.BranchData.Branches["stable"] = BranchData{
+		Hash:       "h2",
+		Dispatches: map[string][]string{"h1": {"example/app"}, "h2": {"example/app"}},
+	}
+	daemon.BranchData.LastPayloads = map[string]string{"example/app": "{}", "example/removed": "{}"}
+	if err := daemon.SaveHashes(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, err := reopen(t, daemon).Prune(false); err != nil {
+		t.Fatal(err)
+	}
+	daemon.recordLastRun(time.Now())
+
+	reloaded := reopen(t, daemon)
+	if _, ok := reloaded.BranchData.Branches["stable"].Dispatches["h1"]; ok {
+		t.Error("pruned hash was restored by the running instance's save")
+	}
+	if _, ok := reloaded.BranchData.LastPayloads["example/removed"]; ok {
+		t.Error("pruned payload was restored by the running instance's save")
+	}
+	if _, ok := reloaded.BranchData.LastPayloads["example/app"]; !ok {
+		t.Error("payload of a configured target was lost")
+	}
+}
+
+// TestForcedDispatchSurvivesDaemonSave records a dispatch through a second checker, like
+// the force subcommand, and expects a save of the running instance to keep it
+func TestForcedDispatchSurvivesDaemonSave(t *testing.T) {
+	daemon := newTestChecker(t, "")
+	daemon.BranchData.Branches["stable"] = BranchData{Hash: "h1", Dispatches: map[string][]string{"h1": {}}}
+	daemon.BranchData.LastDispatched = map[string]time.Time{"example/app": time.Now().Add(-time.Hour)}
+	if err := daemon.SaveHashes(); err != nil {
+		t.Fatal(err)
+	}
+
+	cli := reopen(t, daemon)
+	forcedAt := time.Now().UTC().Truncate(time.Second)
+	err := cli.updateBranch("stable", func(branchData *BranchData) {
+		recordDispatches(branchData, "h1", []string{"example/app"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli.BranchData.LastPayloads = map[string]string{"example/app": `{"forced":true}`}
+	cli.BranchData.LastDispatched = map[string]time.Time{"example/app": forcedAt}
+	if err := cli.SaveHashes(); err != nil {
+		t.Fatal(err)
+	}
+
+	daemon.recordLastRun(time.Now())
+
+	reloaded := reopen(t, daemon)
+	if got := reloaded.BranchData.Branches["stable"].Dispatches["h1"]; !containsString(got, "example/app") {
+		t.Errorf("forced dispatch was undone by the running instance's save, dispatches = %v", got)
+	}
+	if got := reloaded.BranchData.LastPayloads["example/app"]; got != `{"forced":true}` {
+		t.Errorf("forced payload was undone by the running instance's save, payload = %s", got)
+	}
+	if got := reloaded.BranchData.LastDispatched["example/app"]; !got.Equal(forcedAt) {
+		t.Errorf("last dispatch time = %s, want the forced %s", got, forcedAt)
+	}
+}
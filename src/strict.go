@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// StrictError lists every problem found by strict config verification
+type StrictError struct {
+	Problems []string
+}
+
+func (e *StrictError) Error() string {
+	return fmt.Sprintf("strict config verification found %d problems:\n  - %s",
+		len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// environmentProblems checks the config against the environment it runs in, listing every
+// problem at once instead of leaving them to fail one by one at runtime
+func environmentProblems(config *Config) []string {
+	var problems []string
+
+	if err := checkWritableDir(config.HashDir); err != nil {
+		problems = append(problems, fmt.Sprintf("hash directory is not writable: %v", err))
+	}
+	if config.StatusFile != "" {
+		if err := checkWritableDir(filepath.Dir(config.StatusFile)); err != nil {
+			problems = append(problems, fmt.Sprintf("status_file directory is not writable: %v", err))
+		}
+	}
+	if config.GitHubAppTokenCache != "" {
+		if err := checkWritableDir(filepath.Dir(config.GitHubAppTokenCache)); err != nil {
+			problems = append(problems, fmt.Sprintf("github_app_token_cache directory is not writable: %v", err))
+		}
+	}
+
+	usesVault := false
+	for _, target := range config.Targets {
+		if target.GitHubAppPrivateKeyPath != "" {
+			if _, err := os.Stat(target.GitHubAppPrivateKeyPath); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: github_app_private_key_path: %v", target.Name(), err))
+			}
+		}
+		if target.Provider == providerCommand && len(target.Command) > 0 {
+			if _, err := exec.LookPath(target.Command[0]); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: command: %v", target.Name(), err))
+			}
+		}
+		if target.VaultPath != "" {
+			usesVault = true
+		}
+	}
+	if usesVault {
+		for _, name := range []string{"VAULT_ADDR", "VAULT_TOKEN"} {
+			if os.Getenv(name) == "" {
+				problems = append(problems, fmt.Sprintf("%s must be set for targets using vault_path", name))
+			}
+		}
+	}
+
+	return problems
+}
+
+// checkWritableDir confirms a file can be written in dir, or in its nearest existing
+// parent when dir would be created at runtime. Nothing is created besides a probe file.
+func checkWritableDir(dir string) error {
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", dir)
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return err
+		}
+		dir = parent
+	}
+	f, err := os.CreateTemp(dir, ".dipa-auto-strict-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
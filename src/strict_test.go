@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestStrictReportsEveryProblem loads a config with several problems at once and
+// expects all of them in one error
+func TestStrictReportsEveryProblem(t *testing.T) {
+	t.Setenv("VAULT_ADDR", "")
+	t.Setenv("VAULT_TOKEN", "")
+	dir := t.TempDir()
+	blocker := filepath.Join(dir, "file")
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := loadTestConfig(t, `
+strict = true
+min_tls_version = "1.0"
+status_file = `+strconvQuote(filepath.Join(blocker, "status.json"))+`
+github_app_token_cache = `+strconvQuote(filepath.Join(dir, "cache", "tokens", "cache.json"))+`
+
+[ipa_headers]
+Authorization = "Bearer ${DIPA_STRICT_UNSET}"
+X-Rotated-By = "${DIPA_STRICT_UNSET}"
+
+[[targets]]
+github_repo = "example/app"
+github_app_id = 42
+github_app_installation_id = 7
+github_app_private_key_path = `+strconvQuote(filepath.Join(dir, "missing.pem"))+`
+
+[[targets]]
+provider = "command"
+command = ["dipa-auto-no-such-command", "--build"]
+
+[[targets]]
+github_repo = "example/vault"
+vault_path = "secret/data/dipa"
+`)
+
+	var strictErr *StrictError
+	if !errors.As(err, &strictErr) {
+		t.Fatalf("error %v, want a StrictError", err)
+	}
+	want := []string{
+		"${DIPA_STRICT_UNSET} is referenced but not set",
+		"min_tls_version must be '1.2' or '1.3'",
+		"status_file directory is not writable",
+		"example/app: github_app_private_key_path",
+		"command:dipa-auto-no-such-command --build: command",
+		"VAULT_ADDR must be set",
+		"VAULT_TOKEN must be set",
+	}
+	if len(strictErr.Problems) != len(want) {
+		t.Errorf("found %d problems, want %d:\n%v", len(strictErr.Problems), len(want), err)
+	}
+	for _, problem := range want {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("error lacks %q:\n%v", problem, err)
+		}
+	}
+	// Probing the token cache directory must not create it
+	if _, err := os.Stat(filepath.Join(dir, "cache")); !os.IsNotExist(err) {
+		t.Errorf("strict verification created the token cache directory: %v", err)
+	}
+}
+
+func TestStrictPasses(t *testing.T) {
+	t.Setenv("DIPA_STRICT_SET", "value")
+	cfg, err := loadTestConfig(t, `
+strict = true
+
+[[targets]]
+github_repo = "example/app"
+github_token = "${DIPA_STRICT_SET}"
+`)
+	if err != nil {
+		t.Fatalf("strict verification of a sound config failed: %v", err)
+	}
+	if !cfg.Strict {
+		t.Error("strict mode was not enabled")
+	}
+	if got := cfg.Targets[0].GitHubToken; got != "value" {
+		t.Errorf("github_token = %q, want the expanded reference", got)
+	}
+}
+
+// TestStrictOnlyWhenEnabled leaves the same problems to runtime without strict mode
+func TestStrictOnlyWhenEnabled(t *testing.T) {
+	config := "ipa_headers = { X-Token = \"${DIPA_STRICT_UNSET}\" }\n"
+	if _, err := loadTestConfig(t, config); err != nil {
+		t.Errorf("non-strict load failed: %v", err)
+	}
+
+	t.Setenv("STRICT_CONFIG", "true")
+	if _, err := loadTestConfig(t, config); err == nil {
+		t.Error("STRICT_CONFIG did not enable strict verification")
+	}
+}
+
+// TestStrictIgnoresComments only verifies references in values
+func TestStrictIgnoresComments(t *testing.T) {
+	if _, err := loadTestConfig(t, "strict = true\n# token: ${DIPA_STRICT_UNSET}\n"); err != nil {
+		t.Errorf("a reference in a comment failed strict verification: %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestChecker loads a checker from a config snippet, keeping its state in a
+// temporary directory
+func newTestChecker(t *testing.T, config string) *DipaChecker {
+	t.Helper()
+
+	cfg, err := loadTestConfig(t, config)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	checker, err := NewChecker(cfg)
+	if err != nil {
+		t.Fatalf("NewChecker: %v", err)
+	}
+	checker.fetchRetryBase = time.Millisecond
+	checker.dispatchRetryBase = time.Millisecond
+	if err := checker.InitHashFile(); err != nil {
+		t.Fatalf("InitHashFile: %v", err)
+	}
+	return checker
+}
+
+// loadTestConfig loads a config snippet with hash_dir in a temporary directory.
+// ipa_base_url, refresh_schedule, instance_id and a target are filled in when missing.
+func loadTestConfig(t *testing.T, config string) (*Config, error) {
+	t.Helper()
+
+	dir := t.TempDir()
+	if !strings.Contains(config, "ipa_base_url") {
+		config = "ipa_base_url = \"https://ipa.example.com\"\n" + config
+	}
+	if !strings.Contains(config, "refresh_schedule") {
+		config = "refresh_schedule = \"0 * * * *\"\n" + config
+	}
+	if !strings.Contains(config, "instance_id") {
+		config = "instance_id = \"test\"\n" + config
+	}
+	if !strings.Contains(config, "[[targets]]") {
+		config += "\n[[targets]]\ngithub_repo = \"example/app\"\ngithub_token = \"token\"\n"
+	}
+	config = "hash_dir = " + strconvQuote(dir) + "\nstate_snapshots = 0\n" + config
+
+	path := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return LoadConfig(path)
+}
+
+// strconvQuote quotes a path as a TOML basic string
+func strconvQuote(s string) string {
+	encoded, _ := json.Marshal(s)
+	return string(encoded)
+}
+
+// redirectTransport sends every request to a test server, keeping the path and query
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// serveAll points the checker's client, for the IPA host and GitHub alike, at handler
+func serveAll(t *testing.T, checker *DipaChecker, handler http.Handler) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	target, _ := url.Parse(server.URL)
+	checker.Client.Transport = redirectTransport{target: target}
+	return server
+}
+
+// listingJSON encodes a listing of the named files, each a minute newer than the last
+func listingJSON(names ...string) []byte {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries := make([]map[string]string, 0, len(names))
+	for i, name := range names {
+		entries = append(entries, map[string]string{
+			"name":     name,
+			"mod_time": base.Add(time.Duration(i) * time.Minute).Format(time.RFC3339),
+		})
+	}
+	data, _ := json.Marshal(entries)
+	return data
+}
+
+// listingFile returns a file modified the given number of minutes after the listing base time
+func listingFile(name string, minutes int) IPAFile {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return IPAFile{Name: name, ModTime: base.Add(time.Duration(minutes) * time.Minute)}
+}
+
+// recordingNotifier keeps every notification it receives
+type recordingNotifier struct {
+	mu            sync.Mutex
+	notifications []Notification
+}
+
+func (r *recordingNotifier) Notify(n Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.notifications = append(r.notifications, n)
+	return nil
+}
+
+// titled returns the notifications whose title starts with prefix
+func (r *recordingNotifier) titled(prefix string) []Notification {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	matching := []Notification{}
+	for _, n := range r.notifications {
+		if strings.HasPrefix(n.Title, prefix) {
+			matching = append(matching, n)
+		}
+	}
+	return matching
+}
+
+// reopen loads a second checker on the same config and hash file, like a subcommand
+// running next to the daemon
+func reopen(t *testing.T, checker *DipaChecker) *DipaChecker {
+	t.Helper()
+
+	other, err := NewChecker(checker.Config)
+	if err != nil {
+		t.Fatalf("NewChecker: %v", err)
+	}
+	if err := other.InitHashFile(); err != nil {
+		t.Fatalf("InitHashFile: %v", err)
+	}
+	return other
+}
+
+// dispatchRecorder records the repository dispatches a checker sends
+type dispatchRecorder struct {
+	mu      sync.Mutex
+	listing []byte
+	repos   []string
+	bodies  []map[string]interface{}
+}
+
+// serveDispatches serves listing for every branch and accepts and records every
+// repository dispatch
+func serveDispatches(t *testing.T, checker *DipaChecker, listing []byte) *dispatchRecorder {
+	t.Helper()
+
+	recorder := &dispatchRecorder{listing: listing}
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/repos/") {
+			recorder.mu.Lock()
+			defer recorder.mu.Unlock()
+			w.Write(recorder.listing)
+			return
+		}
+		if !strings.HasSuffix(r.URL.Path, "/dispatches") {
+			http.NotFound(w, r)
+			return
+		}
+
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		repo := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/repos/"), "/dispatches")
+
+		recorder.mu.Lock()
+		recorder.repos = append(recorder.repos, repo)
+		recorder.bodies = append(recorder.bodies, body)
+		recorder.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	return recorder
+}
+
+// setListing changes the listing served from now on
+func (d *dispatchRecorder) setListing(listing []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.listing = listing
+}
+
+// dispatched returns the repos dispatched to, in order
+func (d *dispatchRecorder) dispatched() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]string{}, d.repos...)
+}
+
+// payload returns the client_payload of the last dispatch to repo, nil without one
+func (d *dispatchRecorder) payload(repo string) map[string]interface{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i := len(d.repos) - 1; i >= 0; i-- {
+		if d.repos[i] == repo {
+			payload, _ := d.bodies[i]["client_payload"].(map[string]interface{})
+			return payload
+		}
+	}
+	return nil
+}
+
+// captureLog collects the standard logger's output until the test ends
+func captureLog(t *testing.T) *syncBuffer {
+	t.Helper()
+
+	buf := &syncBuffer{}
+	log.SetOutput(buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return buf
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent writers
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
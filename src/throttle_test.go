@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	bucket := newTokenBucket(3, 3)
+	bucket.last = now
+	bucket.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if !bucket.Allow() {
+			t.Fatalf("call %d denied within the burst", i+1)
+		}
+	}
+	if bucket.Allow() {
+		t.Fatal("call past the burst allowed")
+	}
+
+	// Three per minute refills one token every 20 seconds
+	now = now.Add(19 * time.Second)
+	if bucket.Allow() {
+		t.Fatal("allowed before a token was refilled")
+	}
+	now = now.Add(time.Second)
+	if !bucket.Allow() {
+		t.Fatal("denied after a token was refilled")
+	}
+
+	// Idle time never fills the bucket past the burst
+	now = now.Add(time.Hour)
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if bucket.Allow() {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Errorf("allowed %d calls after an idle hour, want the burst of 3", allowed)
+	}
+}
+
+// TestNotifyThrottleSummary sends past the burst and expects the dropped
+// notifications to be summarized once the limiter lets one through
+func TestNotifyThrottleSummary(t *testing.T) {
+	checker := newTestChecker(t, "notify_rate = 1.0\nnotify_burst = 2\nnotify_summarize_dropped = true\n")
+	notifier := &recordingNotifier{}
+	checker.Notifiers = []Notifier{notifier}
+
+	now := time.Now()
+	checker.notifyLimiter.now = func() time.Time { return now }
+
+	for i := 0; i < 5; i++ {
+		checker.notify(Notification{Title: "New stable version"})
+	}
+	if got := len(notifier.titled("")); got != 2 {
+		t.Fatalf("delivered %d notifications, want the burst of 2", got)
+	}
+
+	now = now.Add(time.Minute)
+	checker.notify(Notification{Title: "New testflight version"})
+
+	got := notifier.titled("")
+	if len(got) != 4 || got[2].Title != "3 more updates" || got[3].Title != "New testflight version" {
+		t.Errorf("notifications after the refill %v, want a summary of 3 dropped and the new one", got[2:])
+	}
+}
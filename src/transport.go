@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+)
+
+// tlsVersions maps min_tls_version values to TLS versions
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTransport creates the transport for outbound requests, applying the
+// minimum TLS version and the IPA host certificate pin
+func newTransport(cfg *Config) (*http.Transport, error) {
+	minVersion := uint16(tls.VersionTLS12)
+	if cfg.MinTLSVersion != "" {
+		version, ok := tlsVersions[cfg.MinTLSVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported min_tls_version %q", cfg.MinTLSVersion)
+		}
+		minVersion = version
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{MinVersion: minVersion}
+
+	if cfg.IPAHostPinnedSHA256 != "" {
+		host, err := ipaHost(cfg.IPABaseURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ipa_base_url: %w", err)
+		}
+		transport.TLSClientConfig.VerifyConnection = verifyPin(host, cfg.IPAHostPinnedSHA256)
+	}
+
+	return transport, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// serveTLSUpTo starts a TLS server negotiating at most maxVersion
+func serveTLSUpTo(t *testing.T, maxVersion uint16) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{MinVersion: tls.VersionTLS10, MaxVersion: maxVersion}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestMinTLSVersion(t *testing.T) {
+	tests := []struct {
+		minVersion string
+		serverMax  uint16
+		wantErr    bool
+	}{
+		{"", tls.VersionTLS11, true},
+		{"", tls.VersionTLS12, false},
+		{"1.2", tls.VersionTLS11, true},
+		{"1.2", tls.VersionTLS13, false},
+		{"1.3", tls.VersionTLS12, true},
+		{"1.3", tls.VersionTLS13, false},
+	}
+
+	for _, tt := range tests {
+		server := serveTLSUpTo(t, tt.serverMax)
+		roots := x509.NewCertPool()
+		roots.AddCert(server.Certificate())
+
+		transport, err := newTransport(&Config{MinTLSVersion: tt.minVersion})
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport.TLSClientConfig.RootCAs = roots
+
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("min_tls_version %q against a server up to %#x: error %v, want refused %v",
+				tt.minVersion, tt.serverMax, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMinTLSVersionValidation(t *testing.T) {
+	if _, err := newTransport(&Config{MinTLSVersion: "1.1"}); err == nil {
+		t.Error("min_tls_version 1.1 accepted")
+	}
+}
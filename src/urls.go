@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// escapeSegment escapes a path segment. A literal "+" is valid in paths but read as a
+// space by some object stores, so it is escaped as well.
+func escapeSegment(segment string) string {
+	return strings.ReplaceAll(url.PathEscape(segment), "+", "%2B")
+}
+
+// listingURL returns the URL of a branch's IPA listing, escaping the branch name
+func listingURL(baseURL, branch string) string {
+	return strings.TrimSuffix(baseURL, "/") + "/" + escapeSegment(branch) + "/"
+}
+
+// ipaURL returns the download URL of a file on a branch, escaping both path segments
+func ipaURL(baseURL, branch, name string) string {
+	return strings.TrimSuffix(baseURL, "/") + "/" + escapeSegment(branch) + "/" + escapeSegment(name)
+}
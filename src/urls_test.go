@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestListingURL(t *testing.T) {
+	tests := []struct {
+		base, branch, want string
+	}{
+		{"https://ipa.example.com", "stable", "https://ipa.example.com/stable/"},
+		{"https://ipa.example.com/", "stable", "https://ipa.example.com/stable/"},
+		{"https://ipa.example.com", "beta 2", "https://ipa.example.com/beta%202/"},
+		{"https://ipa.example.com", "c++", "https://ipa.example.com/c%2B%2B/"},
+		{"https://ipa.example.com", "a?b#c", "https://ipa.example.com/a%3Fb%23c/"},
+	}
+
+	for _, tt := range tests {
+		if got := listingURL(tt.base, tt.branch); got != tt.want {
+			t.Errorf("listingURL(%q, %q) = %q, want %q", tt.base, tt.branch, got, tt.want)
+		}
+	}
+}
+
+func TestIPAURL(t *testing.T) {
+	tests := []struct {
+		branch, name, want string
+	}{
+		{"stable", "Discord_228.0.ipa", "https://ipa.example.com/stable/Discord_228.0.ipa"},
+		{"beta 2", "Discord 228.0.ipa", "https://ipa.example.com/beta%202/Discord%20228.0.ipa"},
+		{"stable", "Discord+Plugins_228.0.ipa", "https://ipa.example.com/stable/Discord%2BPlugins_228.0.ipa"},
+		{"stable", "100%.ipa", "https://ipa.example.com/stable/100%25.ipa"},
+	}
+
+	for _, tt := range tests {
+		got := ipaURL("https://ipa.example.com", tt.branch, tt.name)
+		if got != tt.want {
+			t.Errorf("ipaURL(%q, %q) = %q, want %q", tt.branch, tt.name, got, tt.want)
+			continue
+		}
+
+		// The server sees the original names again
+		parsed, err := url.Parse(got)
+		if err != nil {
+			t.Errorf("ipaURL(%q, %q) is not a valid URL: %v", tt.branch, tt.name, err)
+			continue
+		}
+		if want := "/" + tt.branch + "/" + tt.name; parsed.Path != want {
+			t.Errorf("ipaURL(%q, %q) decodes to %q, want %q", tt.branch, tt.name, parsed.Path, want)
+		}
+	}
+}
+
+func TestFetchEscapedBranch(t *testing.T) {
+	checker := newTestChecker(t, `branches = ["beta 2+1"]`)
+
+	var requested string
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Path
+		w.Write(listingJSON("Discord_228.0.ipa"))
+	}))
+
+	if _, _, err := checker.FetchIPAList("beta 2+1"); err != nil {
+		t.Fatalf("FetchIPAList: %v", err)
+	}
+	if requested != "/beta 2+1/" {
+		t.Errorf("server got %q, want the unescaped branch", requested)
+	}
+}
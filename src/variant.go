@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// variantSeparator joins a variant and its file name in dispatch tracking keys. Listing
+// hashes never contain it.
+const variantSeparator = "#"
+
+// variantKey returns the key dispatches of a variant's file are tracked under, so a
+// variant is only dispatched again once its own file changes
+func variantKey(variant, file string) string {
+	return variant + variantSeparator + file
+}
+
+// dispatchKey returns the key dispatches of an event are tracked under, the listing hash
+// when variant_regex is not set
+func dispatchKey(event DispatchEvent) string {
+	if event.Variant == "" {
+		return event.Hash
+	}
+	return variantKey(event.Variant, event.Version)
+}
+
+// tracksKey reports whether a dispatch tracking key belongs to the stored hash, either
+// the hash itself or the current file of a variant
+func (b BranchData) tracksKey(key string) bool {
+	variant, file, ok := strings.Cut(key, variantSeparator)
+	if !ok {
+		return key == b.Hash
+	}
+	current, ok := b.Variants[variant]
+	return ok && current == file
+}
+
+// compileVariantRegex compiles variant_regex, which must capture the variant key
+// in a group named "variant" or in its first group
+func compileVariantRegex(expr string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	if re.NumSubexp() == 0 {
+		return nil, errors.New("it must capture the variant in a group")
+	}
+	return re, nil
+}
+
+// fileVariant returns the variant key captured from a file name
+func (c *DipaChecker) fileVariant(name string) (string, bool) {
+	match := c.variantRegex.FindStringSubmatch(name)
+	if match == nil {
+		return "", false
+	}
+	if i := c.variantRegex.SubexpIndex("variant"); i > 0 {
+		return match[i], match[i] != ""
+	}
+	return match[1], match[1] != ""
+}
+
+// latestVariants groups files by variant and selects the latest of each, skipping
+// files without a variant. It returns the variants in sorted order.
+func (c *DipaChecker) latestVariants(files []IPAFile) ([]string, map[string]*IPAFile) {
+	groups := map[string][]IPAFile{}
+	for _, file := range files {
+		variant, ok := c.fileVariant(file.Name)
+		if !ok {
+			continue
+		}
+		groups[variant] = append(groups[variant], file)
+	}
+
+	variants := make([]string, 0, len(groups))
+	latest := make(map[string]*IPAFile, len(groups))
+	for variant, group := range groups {
+		variants = append(variants, variant)
+		latest[variant] = c.selectVersion(group)
+	}
+	sort.Strings(variants)
+	return variants, latest
+}
+
+// dispatchVariants dispatches an event through dispatch, or with variant_regex set one
+// event per variant for the latest file of each, merging the results
+func (c *DipaChecker) dispatchVariants(event DispatchEvent, dispatch func(DispatchEvent) (DispatchResult, error)) (DispatchResult, error) {
+	if c.variantRegex == nil {
+		return dispatch(event)
+	}
+
+	variants, latest := c.latestVariants(event.Files)
+	if len(variants) == 0 {
+		log.Printf("No %s file matches variant_regex, nothing to dispatch", event.Branch)
+	}
+
+	merged := DispatchResult{Variants: make(map[string]DispatchResult, len(variants))}
+	for _, variant := range variants {
+		variantEvent := event
+		variantEvent.Variant = variant
+		variantEvent.Version = latest[variant].Name
+		variantEvent.IPAURL = ipaURL(c.Config.IPABaseURL, event.Branch, latest[variant].Name)
+
+		result, err := dispatch(variantEvent)
+		if err != nil {
+			return merged, err
+		}
+
+		merged.Variants[dispatchKey(variantEvent)] = result
+		merged.Successful = appendMissing(merged.Successful, result.Successful...)
+		merged.Failed = appendMissing(merged.Failed, result.Failed...)
+		merged.Pending = appendMissing(merged.Pending, result.Pending...)
+		if merged.Winner == "" {
+			merged.Winner = result.Winner
+		}
+	}
+	return merged, nil
+}
+
+// recordResult adds the successful targets of a dispatch to the history of a hash,
+// or with variant_regex set to the history of each variant's file
+func recordResult(branchData *BranchData, hash string, result DispatchResult) {
+	if result.Variants == nil {
+		recordDispatches(branchData, hash, result.Successful)
+		return
+	}
+	for key, variantResult := range result.Variants {
+		recordDispatches(branchData, key, variantResult.Successful)
+	}
+}
+
+// variantFiles returns the latest file of each variant in a listing
+func (c *DipaChecker) variantFiles(files []IPAFile) map[string]string {
+	_, latest := c.latestVariants(files)
+	names := make(map[string]string, len(latest))
+	for variant, file := range latest {
+		names[variant] = file.Name
+	}
+	return names
+}
+
+// appendMissing appends the values not already in list
+func appendMissing(list []string, values ...string) []string {
+	for _, value := range values {
+		if !containsString(list, value) {
+			list = append(list, value)
+		}
+	}
+	return list
+}
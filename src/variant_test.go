@@ -0,0 +1,122 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const variantConfig = "branches = [\"stable\"]\nvariant_regex = '_(?P<variant>arm64|x86_64)\\.ipa$'\n"
+
+func TestVariantsDispatchLatestOfEach(t *testing.T) {
+	checker := newTestChecker(t, variantConfig)
+	recorder := serveDispatches(t, checker, listingJSON(
+		"Discord_228_arm64.ipa",
+		"Discord_228_x86_64.ipa",
+		"Discord_229_arm64.ipa",
+		"Discord_229.ipa",
+	))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder.mu.Lock()
+	sent := map[string]string{}
+	for _, body := range recorder.bodies {
+		payload, _ := body["client_payload"].(map[string]interface{})
+		variant, _ := payload["variant"].(string)
+		url, _ := payload["ipa_url"].(string)
+		sent[variant] = url[strings.LastIndex(url, "/")+1:]
+	}
+	recorder.mu.Unlock()
+
+	want := map[string]string{"arm64": "Discord_229_arm64.ipa", "x86_64": "Discord_228_x86_64.ipa"}
+	if !reflect.DeepEqual(sent, want) {
+		t.Errorf("dispatched %v, want the latest file of each variant %v", sent, want)
+	}
+
+	// Each variant is tracked under the key of its own file
+	keys := []string{}
+	for key := range reopen(t, checker).BranchData.Branches["stable"].Dispatches {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	wantKeys := []string{variantKey("arm64", "Discord_229_arm64.ipa"), variantKey("x86_64", "Discord_228_x86_64.ipa")}
+	if !reflect.DeepEqual(keys, wantKeys) {
+		t.Errorf("tracked dispatches under %v, want %v", keys, wantKeys)
+	}
+}
+
+// TestVariantsOnlyChangedDispatched expects an arm64 update to leave the unchanged x86_64
+// build alone, although the listing hash changed
+func TestVariantsOnlyChangedDispatched(t *testing.T) {
+	checker := newTestChecker(t, variantConfig)
+	recorder := serveDispatches(t, checker, listingJSON("Discord_228_arm64.ipa", "Discord_228_x86_64.ipa"))
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder.setListing(listingJSON("Discord_228_arm64.ipa", "Discord_228_x86_64.ipa", "Discord_229_arm64.ipa"))
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if len(recorder.bodies) != 3 {
+		t.Fatalf("sent %d dispatches, want 2 for the first listing and 1 for the arm64 update", len(recorder.bodies))
+	}
+	payload, _ := recorder.bodies[2]["client_payload"].(map[string]interface{})
+	if url, _ := payload["ipa_url"].(string); !strings.HasSuffix(url, "/Discord_229_arm64.ipa") {
+		t.Errorf("update dispatched %v, want only the new arm64 build", url)
+	}
+}
+
+func TestVariantsNoMatch(t *testing.T) {
+	checker := newTestChecker(t, variantConfig)
+	recorder := serveDispatches(t, checker, listingJSON("Discord_229.ipa"))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if got := recorder.dispatched(); len(got) != 0 {
+		t.Errorf("dispatched to %v without a file matching variant_regex", got)
+	}
+
+	// The listing is recorded, so the next check sees no change
+	hash := storedHash(t, checker, "stable")
+	if hash == "" {
+		t.Fatal("hash of a listing without variants was not stored")
+	}
+	var changes int
+	checker.OnEvent = func(e Event) {
+		if e.Type == EventChangeDetected {
+			changes++
+		}
+	}
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if changes != 0 {
+		t.Errorf("detected %d changes of an unchanged listing", changes)
+	}
+}
+
+func TestVariantRegexValidation(t *testing.T) {
+	for expr, wantErr := range map[string]string{
+		`-(arm64|x86)\.ipa$`:        "",
+		`-(?P<variant>arm64)\.ipa$`: "",
+		`-arm64\.ipa$`:              "must capture the variant in a group",
+		`-(arm64\.ipa$`:             "invalid variant_regex",
+	} {
+		_, err := loadTestConfig(t, "variant_regex = '"+expr+"'\n")
+		if wantErr == "" && err != nil {
+			t.Errorf("%s: %v", expr, err)
+		}
+		if wantErr != "" && (err == nil || !strings.Contains(err.Error(), wantErr)) {
+			t.Errorf("%s: error %v, want %q", expr, err, wantErr)
+		}
+	}
+}
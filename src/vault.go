@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultVaultCacheTTL is used when vault_cache_ttl is not set
+const defaultVaultCacheTTL = 5 * time.Minute
+
+// defaultVaultKey is the secret key read when vault_key is not set
+const defaultVaultKey = "token"
+
+// vaultSecret is a cached secret value and when it has to be re-read
+type vaultSecret struct {
+	value     string
+	refreshAt time.Time
+}
+
+// vaultResponse is the subset of a Vault read response used here. KV version 2
+// nests the secret in data.data, version 1 returns it in data directly.
+type vaultResponse struct {
+	LeaseDuration int                    `json:"lease_duration"`
+	Data          map[string]interface{} `json:"data"`
+}
+
+// vaultToken returns a target's token from Vault, re-reading it once the cache TTL
+// or the secret's lease runs out. A cached token is kept if Vault is unreachable.
+func (c *DipaChecker) vaultToken(target Target) (string, error) {
+	key := target.VaultKey
+	if key == "" {
+		key = defaultVaultKey
+	}
+	cacheKey := target.VaultPath + "#" + key
+
+	c.vaultMu.Lock()
+	defer c.vaultMu.Unlock()
+
+	cached, ok := c.vaultSecrets[cacheKey]
+	if ok && time.Now().Before(cached.refreshAt) {
+		return cached.value, nil
+	}
+
+	secret, err := c.readVaultSecret(target.VaultPath, key)
+	if err != nil {
+		if ok {
+			log.Printf("Error refreshing Vault secret %s, using cached value: %v", target.VaultPath, err)
+			return cached.value, nil
+		}
+		return "", err
+	}
+
+	if c.vaultSecrets == nil {
+		c.vaultSecrets = make(map[string]vaultSecret)
+	}
+	c.vaultSecrets[cacheKey] = secret
+	return secret.value, nil
+}
+
+// readVaultSecret reads key from the secret at path using VAULT_ADDR and VAULT_TOKEN
+func (c *DipaChecker) readVaultSecret(path, key string) (vaultSecret, error) {
+	addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return vaultSecret{}, errors.New("VAULT_ADDR and VAULT_TOKEN must be set to read tokens from Vault")
+	}
+
+	url := strings.TrimSuffix(addr, "/") + "/v1/" + strings.TrimPrefix(path, "/")
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return vaultSecret{}, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return vaultSecret{}, fmt.Errorf("error reading Vault secret %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return vaultSecret{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return vaultSecret{}, fmt.Errorf("vault returned status %d for %s: %s", resp.StatusCode, path, trimString(string(body), 200))
+	}
+
+	var parsed vaultResponse
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return vaultSecret{}, fmt.Errorf("error decoding Vault secret %s: %w", path, err)
+	}
+
+	data := parsed.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	value, ok := data[key].(string)
+	if !ok || value == "" {
+		return vaultSecret{}, fmt.Errorf("vault secret %s has no %q value", path, key)
+	}
+
+	ttl := c.vaultCacheTTL
+	if lease := time.Duration(parsed.LeaseDuration) * time.Second; lease > 0 && lease < ttl {
+		ttl = lease
+	}
+	return vaultSecret{value: value, refreshAt: time.Now().Add(ttl)}, nil
+}
+
+// LoadVaultTokens reads the Vault tokens of every enabled target, failing early on missing secrets
+func (c *DipaChecker) LoadVaultTokens() error {
+	for _, target := range c.Config.Targets {
+		if target.VaultPath == "" || target.Disabled {
+			continue
+		}
+		if _, err := c.vaultToken(target); err != nil {
+			return fmt.Errorf("failed to read token for %s: %w", target.Name(), err)
+		}
+	}
+	return nil
+}
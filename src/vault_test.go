@@ -0,0 +1,203 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// vaultServer fakes a Vault with a KV v2 secret at secret/data/dipa and a KV v1
+// secret at kv/app, answering 503 while sealed
+type vaultServer struct {
+	mu     sync.Mutex
+	reads  int
+	token  string
+	sealed bool
+	// dispatchAuth is the Authorization header of the last dispatch
+	dispatchAuth string
+}
+
+func (s *vaultServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !strings.HasPrefix(r.URL.Path, "/v1/") {
+		if r.URL.Path == "/stable/" {
+			w.Write(listingJSON("Discord_228.0.ipa"))
+			return
+		}
+		s.dispatchAuth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Header.Get("X-Vault-Token") != "vault-root" {
+		http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+		return
+	}
+	if s.sealed {
+		http.Error(w, `{"errors":["Vault is sealed"]}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	s.reads++
+	switch r.URL.Path {
+	case "/v1/secret/data/dipa":
+		w.Write([]byte(`{"data": {"data": {"token": "` + s.token + `"}, "metadata": {"version": 3}}}`))
+	case "/v1/kv/app":
+		w.Write([]byte(`{"lease_duration": 60, "data": {"pat": "kv1-token"}}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newVaultChecker(t *testing.T) (*DipaChecker, *vaultServer) {
+	t.Helper()
+
+	t.Setenv("VAULT_ADDR", "https://vault.example.com/")
+	t.Setenv("VAULT_TOKEN", "vault-root")
+	checker := newTestChecker(t, `
+branches = ["stable"]
+
+[[targets]]
+github_repo = "example/v2"
+vault_path = "secret/data/dipa"
+
+[[targets]]
+github_repo = "example/v1"
+vault_path = "/kv/app"
+vault_key = "pat"
+disabled = true
+`)
+	server := &vaultServer{token: "kv2-token"}
+	serveAll(t, checker, server)
+	return checker, server
+}
+
+func TestVaultTokens(t *testing.T) {
+	checker, server := newVaultChecker(t)
+	v2, v1 := checker.Config.Targets[0], checker.Config.Targets[1]
+
+	for _, tt := range []struct {
+		target Target
+		want   string
+	}{{v2, "kv2-token"}, {v1, "kv1-token"}, {v2, "kv2-token"}} {
+		token, err := checker.githubToken(tt.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != tt.want {
+			t.Errorf("%s token %q, want %q", tt.target.Name(), token, tt.want)
+		}
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.reads != 2 {
+		t.Errorf("read Vault %d times, want each secret once within the cache TTL", server.reads)
+	}
+}
+
+func TestVaultRefresh(t *testing.T) {
+	checker, server := newVaultChecker(t)
+	target := checker.Config.Targets[0]
+	expire := func() {
+		checker.vaultMu.Lock()
+		for key, secret := range checker.vaultSecrets {
+			secret.refreshAt = time.Now().Add(-time.Second)
+			checker.vaultSecrets[key] = secret
+		}
+		checker.vaultMu.Unlock()
+	}
+
+	if _, err := checker.githubToken(target); err != nil {
+		t.Fatal(err)
+	}
+
+	// A rotated secret is picked up once the cache expires
+	server.mu.Lock()
+	server.token = "rotated-token"
+	server.mu.Unlock()
+	expire()
+	if token, err := checker.githubToken(target); err != nil || token != "rotated-token" {
+		t.Errorf("token %q (%v) after the cache expired, want the rotated one", token, err)
+	}
+
+	// An unreachable Vault keeps the cached token rather than failing dispatches
+	server.mu.Lock()
+	server.sealed = true
+	server.mu.Unlock()
+	expire()
+	if token, err := checker.githubToken(target); err != nil || token != "rotated-token" {
+		t.Errorf("token %q (%v) while Vault is sealed, want the cached one", token, err)
+	}
+}
+
+func TestVaultLeaseShortensCache(t *testing.T) {
+	checker, _ := newVaultChecker(t)
+	checker.vaultCacheTTL = time.Hour
+
+	if _, err := checker.githubToken(checker.Config.Targets[1]); err != nil {
+		t.Fatal(err)
+	}
+	checker.vaultMu.Lock()
+	defer checker.vaultMu.Unlock()
+	if refresh := time.Until(checker.vaultSecrets["/kv/app#pat"].refreshAt); refresh > time.Minute {
+		t.Errorf("secret refreshed in %s, want within its 60s lease", refresh)
+	}
+}
+
+func TestVaultErrors(t *testing.T) {
+	checker, server := newVaultChecker(t)
+
+	server.mu.Lock()
+	server.sealed = true
+	server.mu.Unlock()
+	if err := checker.LoadVaultTokens(); err == nil || !strings.Contains(err.Error(), "status 503") {
+		t.Errorf("error %v, want the uncached secret to fail at startup", err)
+	}
+
+	missing := Target{GitHubRepo: "example/missing", VaultPath: "secret/data/dipa", VaultKey: "nope"}
+	server.mu.Lock()
+	server.sealed = false
+	server.mu.Unlock()
+	if _, err := checker.githubToken(missing); err == nil || !strings.Contains(err.Error(), `no "nope" value`) {
+		t.Errorf("error %v, want a missing key reported", err)
+	}
+
+	t.Setenv("VAULT_TOKEN", "")
+	if _, err := checker.githubToken(Target{VaultPath: "kv/other"}); err == nil || !strings.Contains(err.Error(), "VAULT_TOKEN") {
+		t.Errorf("error %v, want the missing environment reported", err)
+	}
+}
+
+func TestVaultTokenUsedForDispatch(t *testing.T) {
+	checker, server := newVaultChecker(t)
+
+	if err := checker.LoadVaultTokens(); err != nil {
+		t.Fatal(err)
+	}
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.dispatchAuth != "Bearer kv2-token" {
+		t.Errorf("dispatch authorized with %q, want the Vault token", server.dispatchAuth)
+	}
+}
+
+func TestVaultTokenSourceValidation(t *testing.T) {
+	_, err := loadTestConfig(t, `
+[[targets]]
+github_repo = "example/app"
+github_token = "token"
+vault_path = "secret/data/dipa"
+`)
+	if err == nil || !strings.Contains(err.Error(), "exactly one of") {
+		t.Errorf("error %v, want a target with two token sources rejected", err)
+	}
+}
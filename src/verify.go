@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// zipMagic is the signature every zip archive, and therefore every IPA, starts with
+var zipMagic = []byte("PK\x03\x04")
+
+// verifyIPA fetches the first bytes of an IPA with a ranged request and checks
+// that it is a zip archive rather than an error page served under the IPA URL
+func (c *DipaChecker) verifyIPA(url string) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", len(zipMagic)-1))
+	c.setIPAAuth(req)
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Hosts ignoring the range answer 200, only the first bytes are read either way
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	head := make([]byte, len(zipMagic))
+	n, err := io.ReadFull(resp.Body, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	if !bytes.Equal(head[:n], zipMagic) {
+		return fmt.Errorf("not a zip archive, starts with %q (Content-Type %s)", head[:n], resp.Header.Get("Content-Type"))
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestVerifyIPA serves an error page under the IPA URL and expects the dispatch to
+// be deferred with an alert, then sent once a real zip is served
+func TestVerifyIPA(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+verify_ipa = true
+`)
+	notifier := &recordingNotifier{}
+	checker.Notifiers = []Notifier{notifier}
+
+	var mu sync.Mutex
+	ipa := "<html>502 Bad Gateway</html>"
+	ranges := []string{}
+	dispatches := 0
+	serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/stable/":
+			w.Write(listingJSON("Discord_228.0.ipa"))
+		case r.URL.Path == "/stable/Discord_228.0.ipa":
+			ranges = append(ranges, r.Header.Get("Range"))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte(ipa)[:4])
+		case strings.HasSuffix(r.URL.Path, "/dispatches"):
+			dispatches++
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+
+	if err := checker.CheckBranch("stable"); err == nil {
+		t.Fatal("an error page passed verification")
+	}
+	mu.Lock()
+	if dispatches != 0 {
+		t.Errorf("dispatched %d times with an error page under the IPA URL", dispatches)
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=0-3" {
+		t.Errorf("IPA requested with ranges %q, want only the zip signature", ranges)
+	}
+	ipa = "PK\x03\x04rest of the archive"
+	mu.Unlock()
+
+	if got := notifier.titled("IPA failed verification"); len(got) != 1 {
+		t.Errorf("%d verification alerts, want 1", len(got))
+	}
+	if storedHash(t, checker, "stable") != "" {
+		t.Error("hash stored although the dispatch was deferred")
+	}
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if dispatches != 1 {
+		t.Errorf("dispatched %d times once the IPA was a zip, want 1", dispatches)
+	}
+}
+
+func TestVerifyIPAResponses(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		ok     bool
+	}{
+		{"ranged zip", http.StatusPartialContent, "PK\x03\x04", true},
+		{"range ignored", http.StatusOK, "PK\x03\x04 and the rest of the file", true},
+		{"html", http.StatusOK, "<!DOCTYPE html>", false},
+		{"empty", http.StatusOK, "", false},
+		{"short", http.StatusPartialContent, "PK", false},
+		{"not found", http.StatusNotFound, "PK\x03\x04", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := newTestChecker(t, "")
+			serveAll(t, checker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+
+			err := checker.verifyIPA("https://ipa.example.com/stable/Discord_228.0.ipa")
+			if (err == nil) != tt.ok {
+				t.Errorf("error %v, want valid %v", err, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// versionRegex matches the first dotted version number in a file name, e.g. 228.0 in Discord_228.0.ipa
+var versionRegex = regexp.MustCompile(`\d+(\.\d+)*`)
+
+// parseVersion extracts the numeric components of the first version number in s
+func parseVersion(s string) ([]int, bool) {
+	match := versionRegex.FindString(s)
+	if match == "" {
+		return nil, false
+	}
+
+	parts := strings.Split(match, ".")
+	version := make([]int, 0, len(parts))
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, false
+		}
+		version = append(version, n)
+	}
+	return version, true
+}
+
+// formatVersion joins version components with dots
+func formatVersion(version []int) string {
+	parts := make([]string, len(version))
+	for i, n := range version {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ".")
+}
+
+// compareVersions returns -1, 0 or 1, treating missing components as zero
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// parseVersionSetting parses a configured version such as min_version, which may carry a
+// pre-release, e.g. 1.2.3-beta.4
+func parseVersionSetting(s string) (semver, bool) {
+	if version, ok := parseSemver(s); ok {
+		return version, true
+	}
+	version, ok := parseVersion(s)
+	return semver{version: version}, ok
+}
+
+// fileSemver returns the version of a file: its content-addressed version when set,
+// otherwise the version_regex capture of its name, or the first number in the name
+// without version_regex
+func (c *DipaChecker) fileSemver(file IPAFile) (semver, bool) {
+	if file.Version != "" {
+		if version, ok := parseVersionSetting(file.Version); ok {
+			return version, true
+		}
+	}
+	if c.nameVersionRegex != nil {
+		return c.nameVersion(file.Name)
+	}
+	version, ok := parseVersion(file.Name)
+	return semver{version: version}, ok
+}
+
+// belowMinVersion reports whether a file is older than min_version.
+// Files without a recognizable version are treated as below the floor.
+func (c *DipaChecker) belowMinVersion(file *IPAFile) bool {
+	if c.minVersion == nil || file == nil {
+		return false
+	}
+
+	version, ok := c.fileSemver(*file)
+	return !ok || compareSemver(version, *c.minVersion) < 0
+}
+
+// versionClause is a single comparison of a version constraint, e.g. "<2.0.0"
+type versionClause struct {
+	op      string
+	version []int
+}
+
+// parseConstraint parses a version constraint of comma or space separated clauses.
+// Supported operators are =, !=, <, <=, >, >=, ~ (same minor) and ^ (same major).
+func parseConstraint(constraint string) ([]versionClause, error) {
+	fields := strings.FieldsFunc(constraint, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty version constraint")
+	}
+
+	clauses := []versionClause{}
+	for _, field := range fields {
+		op := strings.TrimRight(field, "0123456789.")
+		value := field[len(op):]
+		version, ok := parseVersion(value)
+		if !ok || versionRegex.FindString(value) != value {
+			return nil, fmt.Errorf("invalid version in constraint clause %q", field)
+		}
+
+		switch op {
+		case "", "=", "==", "!=", "<", "<=", ">", ">=":
+			clauses = append(clauses, versionClause{op: op, version: version})
+		case "~":
+			clauses = append(clauses,
+				versionClause{op: ">=", version: version},
+				versionClause{op: "<", version: tildeUpperBound(version)})
+		case "^":
+			clauses = append(clauses,
+				versionClause{op: ">=", version: version},
+				versionClause{op: "<", version: caretUpperBound(version)})
+		default:
+			return nil, fmt.Errorf("unknown operator %q in constraint clause %q", op, field)
+		}
+	}
+	return clauses, nil
+}
+
+// tildeUpperBound returns the exclusive bound for ~version: the next minor, or the next major without one
+func tildeUpperBound(version []int) []int {
+	if len(version) < 2 {
+		return []int{version[0] + 1}
+	}
+	return []int{version[0], version[1] + 1}
+}
+
+// caretUpperBound returns the exclusive bound for ^version: the next major, or the next minor for 0.x
+func caretUpperBound(version []int) []int {
+	if version[0] == 0 && len(version) > 1 {
+		return []int{0, version[1] + 1}
+	}
+	return []int{version[0] + 1}
+}
+
+// satisfiesConstraint reports whether the version of file matches every clause of constraint
+func (c *DipaChecker) satisfiesConstraint(file IPAFile, constraint string) (bool, error) {
+	clauses, err := parseConstraint(constraint)
+	if err != nil {
+		return false, err
+	}
+
+	version, ok := c.fileSemver(file)
+	if !ok {
+		return false, fmt.Errorf("no version found in %q", file.Name)
+	}
+
+	for _, clause := range clauses {
+		cmp := compareSemver(version, semver{version: clause.version})
+		var matched bool
+		switch clause.op {
+		case "", "=", "==":
+			matched = cmp == 0
+		case "!=":
+			matched = cmp != 0
+		case "<":
+			matched = cmp < 0
+		case "<=":
+			matched = cmp <= 0
+		case ">":
+			matched = cmp > 0
+		case ">=":
+			matched = cmp >= 0
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// semver is a version with optional pre-release identifiers, e.g. 1.2.3-beta.4
+type semver struct {
+	version    []int
+	prerelease []string
+}
+
+// semverRegex matches a version with an optional pre-release and build suffix
+var semverRegex = regexp.MustCompile(`^v?(\d+(?:\.\d+)*)(?:-([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// parseSemver parses a version such as 1.2.3, v1.2 or 1.2.3-beta.4+build.5, ignoring build metadata
+func parseSemver(s string) (semver, bool) {
+	match := semverRegex.FindStringSubmatch(s)
+	if match == nil {
+		return semver{}, false
+	}
+
+	version, ok := parseVersion(match[1])
+	if !ok {
+		return semver{}, false
+	}
+	parsed := semver{version: version}
+	if match[2] != "" {
+		parsed.prerelease = strings.Split(match[2], ".")
+	}
+	return parsed, true
+}
+
+// compareSemver returns -1, 0 or 1 following semver precedence: a pre-release sorts before
+// its release, numeric identifiers compare numerically and before alphanumeric ones
+func compareSemver(a, b semver) int {
+	if cmp := compareVersions(a.version, b.version); cmp != 0 {
+		return cmp
+	}
+
+	switch {
+	case len(a.prerelease) == 0 && len(b.prerelease) == 0:
+		return 0
+	case len(a.prerelease) == 0:
+		return 1
+	case len(b.prerelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(a.prerelease) && i < len(b.prerelease); i++ {
+		if cmp := compareIdentifiers(a.prerelease[i], b.prerelease[i]); cmp != 0 {
+			return cmp
+		}
+	}
+	switch {
+	case len(a.prerelease) < len(b.prerelease):
+		return -1
+	case len(a.prerelease) > len(b.prerelease):
+		return 1
+	}
+	return 0
+}
+
+// compareIdentifiers compares two pre-release identifiers
+func compareIdentifiers(a, b string) int {
+	x, errA := strconv.Atoi(a)
+	y, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+		return 0
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// compileVersionRegex compiles version_regex, which must capture the version in a group
+// named "version" or in its first group
+func compileVersionRegex(expr string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	if re.NumSubexp() == 0 {
+		return nil, fmt.Errorf("it must capture the version in a group")
+	}
+	return re, nil
+}
+
+// nameVersion extracts the semantic version of a file name with version_regex
+func (c *DipaChecker) nameVersion(name string) (semver, bool) {
+	if c.nameVersionRegex == nil {
+		return semver{}, false
+	}
+
+	match := c.nameVersionRegex.FindStringSubmatch(name)
+	if match == nil {
+		return semver{}, false
+	}
+	group := 1
+	if i := c.nameVersionRegex.SubexpIndex("version"); i > 0 {
+		group = i
+	}
+	return parseSemver(match[group])
+}
+
+// compareNameVersions compares the versions of two file names with version_regex,
+// reporting false when either name has no version to fall back to mod_time
+func (c *DipaChecker) compareNameVersions(a, b string) (int, bool) {
+	va, okA := c.nameVersion(a)
+	vb, okB := c.nameVersion(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	return compareSemver(va, vb), true
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompareSemverPrerelease(t *testing.T) {
+	ordered := []string{
+		"1.2.3-alpha",
+		"1.2.3-beta",
+		"1.2.3-beta.4",
+		"1.2.3-beta.10",
+		"1.2.3-rc.1",
+		"1.2.3",
+		"1.2.4-beta.1",
+	}
+
+	for i := 0; i < len(ordered); i++ {
+		for j := 0; j < len(ordered); j++ {
+			a, okA := parseSemver(ordered[i])
+			b, okB := parseSemver(ordered[j])
+			if !okA || !okB {
+				t.Fatalf("failed to parse %q or %q", ordered[i], ordered[j])
+			}
+
+			want := 0
+			if i < j {
+				want = -1
+			} else if i > j {
+				want = 1
+			}
+			if got := compareSemver(a, b); got != want {
+				t.Errorf("compareSemver(%s, %s) = %d, want %d", ordered[i], ordered[j], got, want)
+			}
+		}
+	}
+}
+
+func TestMinVersionUsesVersionRegex(t *testing.T) {
+	checker := newTestChecker(t, `
+min_version = "228.0"
+version_regex = '_(\d+\.\d+(?:-[\w.]+)?)\.ipa$'
+`)
+
+	tests := []struct {
+		file  IPAFile
+		below bool
+	}{
+		{IPAFile{Name: "App-v2_228.0.ipa"}, false},
+		{IPAFile{Name: "App-v2_229.1.ipa"}, false},
+		{IPAFile{Name: "App-v3_227.9.ipa"}, true},
+		{IPAFile{Name: "App-v2_228.0-beta.4.ipa"}, true},
+		{IPAFile{Name: "App.ipa"}, true},
+		// The content-addressed version wins over the name
+		{IPAFile{Name: "App-v2_100.0.ipa", Version: "228.1"}, false},
+		{IPAFile{Name: "App-v2_300.0.ipa", Version: "227.0"}, true},
+	}
+
+	for _, tt := range tests {
+		file := tt.file
+		if got := checker.belowMinVersion(&file); got != tt.below {
+			t.Errorf("belowMinVersion(%s, version %q) = %v, want %v", file.Name, file.Version, got, tt.below)
+		}
+	}
+}
+
+func TestMinVersionPrerelease(t *testing.T) {
+	checker := newTestChecker(t, `min_version = "1.2.3-beta.4"`)
+
+	tests := []struct {
+		version string
+		below   bool
+	}{
+		{"1.2.3-beta.3", true},
+		{"1.2.3-alpha.9", true},
+		{"1.2.3-beta.4", false},
+		{"1.2.3-rc.1", false},
+		{"1.2.3", false},
+	}
+
+	for _, tt := range tests {
+		file := IPAFile{Name: "App.ipa", Version: tt.version}
+		if got := checker.belowMinVersion(&file); got != tt.below {
+			t.Errorf("belowMinVersion(%s) = %v, want %v", tt.version, got, tt.below)
+		}
+	}
+}
+
+func TestSatisfiesConstraint(t *testing.T) {
+	plain := newTestChecker(t, "")
+	withRegex := newTestChecker(t, `version_regex = '_(\d+\.\d+(?:-[\w.]+)?)\.ipa$'`)
+
+	tests := []struct {
+		checker    *DipaChecker
+		file       IPAFile
+		constraint string
+		want       bool
+	}{
+		{plain, IPAFile{Name: "App_1.4.2.ipa"}, "~1.4", true},
+		{plain, IPAFile{Name: "App_1.5.0.ipa"}, "~1.4", false},
+		{plain, IPAFile{Name: "App_1.9.0.ipa"}, "^1.4", true},
+		{plain, IPAFile{Name: "App_2.0.0.ipa"}, "^1.4", false},
+		{plain, IPAFile{Name: "App_0.3.1.ipa"}, "^0.3", true},
+		{plain, IPAFile{Name: "App_0.4.0.ipa"}, "^0.3", false},
+		{plain, IPAFile{Name: "App_1.2.0.ipa"}, ">=1.0, <2.0", true},
+		{plain, IPAFile{Name: "App_1.2.0.ipa"}, "!=1.2", false},
+		// Without version_regex the first number in the name is read
+		{plain, IPAFile{Name: "App-v2_228.0.ipa"}, ">=200", false},
+		{withRegex, IPAFile{Name: "App-v2_228.0.ipa"}, ">=200", true},
+		{withRegex, IPAFile{Name: "App-v2_228.0.ipa"}, "<3", false},
+		// A pre-release sorts before its release
+		{withRegex, IPAFile{Name: "App-v2_2.0-beta.1.ipa"}, "<2.0", true},
+		{withRegex, IPAFile{Name: "App_1.0.ipa", Version: "2.1"}, "^2.0", true},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.checker.satisfiesConstraint(tt.file, tt.constraint)
+		if err != nil {
+			t.Errorf("satisfiesConstraint(%s, %q): %v", tt.file.Name, tt.constraint, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("satisfiesConstraint(%s, %q) = %v, want %v", tt.file.Name, tt.constraint, got, tt.want)
+		}
+	}
+
+	if _, err := plain.satisfiesConstraint(IPAFile{Name: "App.ipa"}, "<2.0"); err == nil {
+		t.Error("expected an error for a file without a version")
+	}
+}
+
+// TestMinVersionFloor serves a historical listing below the floor, then adds a build
+// at the floor, and expects only that one to be dispatched
+func TestMinVersionFloor(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+min_version = "228.0"
+`)
+	recorder := serveDispatches(t, checker, listingJSON("Discord_226.0.ipa", "Discord_227.5.ipa"))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if got := recorder.dispatched(); len(got) != 0 {
+		t.Fatalf("dispatched %v for builds below min_version", got)
+	}
+	if storedHash(t, checker, "stable") == "" {
+		t.Error("listing below the floor was not tracked")
+	}
+
+	recorder.setListing(listingJSON("Discord_226.0.ipa", "Discord_227.5.ipa", "Discord_228.0.ipa"))
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if got := recorder.dispatched(); len(got) != 1 {
+		t.Fatalf("dispatched %v, want the build at min_version once", got)
+	}
+	if url, _ := recorder.payload("example/app")["ipa_url"].(string); !strings.HasSuffix(url, "Discord_228.0.ipa") {
+		t.Errorf("dispatched %q, want Discord_228.0.ipa", url)
+	}
+}
+
+// TestVersionConstraintPerTarget dispatches two versions to targets with different
+// constraints and expects each target to only get the versions it accepts
+func TestVersionConstraintPerTarget(t *testing.T) {
+	checker := newTestChecker(t, `
+branches = ["stable"]
+
+[[targets]]
+github_repo = "example/any"
+github_token = "token"
+
+[[targets]]
+github_repo = "example/minor"
+github_token = "token"
+version_constraint = "~228.0"
+
+[[targets]]
+github_repo = "example/legacy"
+github_token = "token"
+version_constraint = "<228.0.0"
+`)
+	recorder := serveDispatches(t, checker, listingJSON("Discord_228.0.ipa"))
+
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(recorder.dispatched(), ","); got != "example/any,example/minor" {
+		t.Errorf("228.0 dispatched to %s, want example/any and example/minor", got)
+	}
+
+	recorder.setListing(listingJSON("Discord_228.0.ipa", "Discord_229.0.ipa"))
+	if err := checker.CheckBranch("stable"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(recorder.dispatched(), ","); got != "example/any,example/minor,example/any" {
+		t.Errorf("229.0 dispatched to %s, want only example/any", got)
+	}
+}
+
+// TestVersionRegexOrdering serves a listing whose newest file by mod_time is not the
+// newest version and expects version_regex to pick by version
+func TestVersionRegexOrdering(t *testing.T) {
+	listing := listingJSON("Discord_229.0-beta.1.ipa", "Discord_228.10.ipa", "Discord_228.9.ipa")
+
+	tests := []struct {
+		config, want string
+	}{
+		{"", "Discord_228.9.ipa"},
+		{"version_regex = '_(?P<version>[\\d.]+(?:-[\\w.]+)?)\\.ipa$'\n", "Discord_229.0-beta.1.ipa"},
+	}
+
+	for _, tt := range tests {
+		checker := newTestChecker(t, "branches = [\"stable\"]\n"+tt.config)
+		recorder := serveDispatches(t, checker, listing)
+
+		if err := checker.CheckBranch("stable"); err != nil {
+			t.Fatal(err)
+		}
+		if url := dispatchedURL(recorder); !strings.HasSuffix(url, "/"+tt.want) {
+			t.Errorf("with %q dispatched %q, want %s", tt.config, url, tt.want)
+		}
+	}
+}